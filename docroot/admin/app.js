@@ -7,7 +7,9 @@ var app = new Vue({
         message: { text: '', next: ''},
         quiz: {
             name: '',
+            category: '',
             questionDuration: 20,
+            scoringMode: 'linear',
             shuffleQuestions: false,
             shuffleAnswers: false,
             questions: [
@@ -195,7 +197,9 @@ var app = new Vue({
         newQuiz: function() {
             this.quiz = {
                 name: '',
+                category: '',
                 questionDuration: 20,
+                scoringMode: 'linear',
                 questions: [
                     {
                         question: '',
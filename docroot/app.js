@@ -5,16 +5,17 @@ var app = new Vue({
 
     data: {
         screen: 'start',
-        entrance: { data: {pin: 0, name: ''}, disabled: true },
-        answerquestion: { answercount: 0, disabled: true },
-        displayplayerresults: { data: {correct: false, score: 0}, disabled: true },
+        entrance: { data: {pin: 0, name: '', team: ''}, disabled: true },
+        answerquestion: { answercount: 0, answers: [], multiselect: false, ordering: false, selected: [], fiftyfifty: false, removed: [], disabled: true, context: { questionindex: 0, totalquestions: 0, timeleft: 0, paused: false, preload: false, answersin: 0 }, timer: null, recorded: '' },
+        displayplayerresults: { data: {correct: false, score: 0}, hoststatus: '', correctanswer: '', disabled: true },
         authenticateuser: { username: '', password: '', previousscreen: '' },
 
-        hostselectquiz: { quizzes: [], disabled: true },
-        hostgamelobby: { data: { pin: 0, players: [] }, textarea: '', link: '', disabled: true },
-        hostshowquestion: { data: { questionindex: 0, timeleft: 0, answered: 0, totalplayers:0, question: '', answers: [], votes: [], totalvotes: 0, totalquestions: 0, topscorers: [] }, timer: null },
+        hostselectquiz: { quizzes: [], disabled: true, label: '' },
+        submitquestion: { open: false, question: '', answers: ['', '', '', ''], correct: 0, status: '', disabled: false },
+        hostgamelobby: { data: { pin: 0, players: [], playercount: 0, shufflequestions: false, shuffleanswers: false }, submissions: [], textarea: '', link: '', kick: '', disabled: true },
+        hostshowquestion: { data: { questionindex: 0, timeleft: 0, answered: 0, totalplayers:0, question: '', answers: [], votes: [], totalvotes: 0, totalquestions: 0, topscorers: [], paused: false, preload: false, answersin: 0, answergrace: 0 }, timer: null, gracetimer: null },
         hostshowresults: { data: { questionindex: 0, question: '', answers: [], correct: 0, votes: [], totalvotes: 0, totalquestions: 0 }, disabled: true },
-        hostshowgameresults: { data: [], disabled: true },
+        hostshowgameresults: { data: [], teams: [], summary: null, disabled: true },
         error: { message: '', next: '', disabled: true },
         sessionid: '',
         conn: null,
@@ -48,6 +49,24 @@ var app = new Vue({
             return null
         },
 
+        // identifies this device when joining games - kept in local storage
+        // so that every tab on the device sends the same fingerprint
+        deviceFingerprint: function() {
+            try {
+                let fingerprint = window.localStorage.getItem('quizdevice')
+                if (fingerprint == null || fingerprint.length == 0) {
+                    let bytes = new Uint8Array(16)
+                    window.crypto.getRandomValues(bytes)
+                    fingerprint = Array.from(bytes, function(b) { return ('0' + b.toString(16)).slice(-2) }).join('')
+                    window.localStorage.setItem('quizdevice', fingerprint)
+                }
+                return fingerprint
+            } catch (err) {
+                console.log('err: ' + err)
+                return ''
+            }
+        },
+
         showScreen: function(target) {
             this.screen = target
 
@@ -156,12 +175,77 @@ var app = new Vue({
                 return
             }
             console.log('sending command to join game')
-            this.sendCommand('join-game ' + JSON.stringify({name: this.entrance.data.name, pin: parseInt(this.entrance.data.pin)}))
+            this.sendCommand('join-game ' + JSON.stringify({name: this.entrance.data.name, pin: parseInt(this.entrance.data.pin), team: this.entrance.data.team, fingerprint: this.deviceFingerprint()}))
         },
 
         sendAnswer: function(choice) {
+            if (this.answerquestion.multiselect || this.answerquestion.ordering) {
+                // toggle the choice - the selection is sent with submitSelection,
+                // in the order that the items were picked for ordering questions
+                let index = this.answerquestion.selected.indexOf(choice)
+                if (index >= 0) {
+                    this.answerquestion.selected.splice(index, 1)
+                } else {
+                    this.answerquestion.selected.push(choice)
+                }
+                return
+            }
             this.answerquestion.disabled = true
-            this.sendCommand('answer ' + choice)
+            this.submitAnswer(choice)
+        },
+
+        submitSelection: function() {
+            if (this.answerquestion.selected.length == 0) {
+                return
+            }
+            if (this.answerquestion.ordering && this.answerquestion.selected.length != this.answerquestion.answercount) {
+                return
+            }
+            this.answerquestion.disabled = true
+            this.submitAnswer(this.answerquestion.selected.join(','))
+        },
+
+        useFiftyFifty: function() {
+            this.answerquestion.fiftyfifty = false
+            this.sendCommand('fifty-fifty')
+        },
+
+        // the question index lets the server reject answers to a question
+        // that has already ended
+        submitAnswer: function(answer) {
+            let command = 'answer ' + answer
+            if (this.answerquestion.context.totalquestions > 0) {
+                command += ' ' + this.answerquestion.context.questionindex
+            }
+            this.sendCommand(command)
+        },
+
+        submitQuestion: function() {
+            // leave out blank answers
+            let question = { question: this.submitquestion.question, answers: [], correct: -1 }
+            this.submitquestion.answers.forEach((answer, index) => {
+                if (answer.trim().length == 0) {
+                    return
+                }
+                if (index == this.submitquestion.correct) {
+                    question.correct = question.answers.length
+                }
+                question.answers.push(answer)
+            })
+            this.submitquestion.disabled = true
+            this.sendCommand('submit-question ' + JSON.stringify(question))
+        },
+
+        moderateQuestion: function(id, approve) {
+            this.sendCommand((approve ? 'approve-question ' : 'reject-question ') + id)
+        },
+
+        // falls back to the single correct answer before results arrive
+        answerCorrect: function(index) {
+            if (this.hostshowresults.data.answercorrect) {
+                return this.hostshowresults.data.answercorrect[index] == true
+            }
+            return this.hostshowresults.data.correct == index
         },
 
         sendCommand: function(command) {
@@ -182,7 +266,12 @@ var app = new Vue({
 
         hostSelectQuiz: function(quizid) {
             this.hostselectquiz.disabled = true
-            this.sendCommand('host-game-lobby ' + quizid)
+            let command = 'host-game-lobby ' + quizid
+            let label = this.hostselectquiz.label.trim()
+            if (label.length > 0) {
+                command += ' label=' + label
+            }
+            this.sendCommand(command)
         },
 
         updateHostGameLobbyText: function() {
@@ -192,14 +281,43 @@ var app = new Vue({
                     if (index > 0) playerstext += '\n'
                     playerstext += player
                 })
+                // large games only send a sample of the names
+                let more = (this.hostgamelobby.data.playercount || 0) - this.hostgamelobby.data.players.length
+                if (more > 0) {
+                    playerstext += '\n... and ' + more + ' more'
+                }
                 this.hostgamelobby.textarea = playerstext
 
-                if (this.hostgamelobby.data.players.length > 0) {
-                    this.hostgamelobby.disabled = false
-                }
+                this.hostgamelobby.disabled = (this.hostgamelobby.data.players.length == 0)
             }
         },
 
+        skipQuestion: function() {
+            this.sendCommand('skip-question')
+        },
+
+        beginAnswers: function() {
+            this.sendCommand('begin-answers')
+        },
+
+        pauseGame: function() {
+            this.sendCommand('pause-game')
+        },
+
+        resumeGame: function() {
+            this.sendCommand('resume-game')
+        },
+
+        kickPlayer: function() {
+            if (this.hostgamelobby.kick == '') return
+            this.sendCommand('kick-player ' + this.hostgamelobby.kick)
+            this.hostgamelobby.kick = ''
+        },
+
+        setShuffle: function() {
+            this.sendCommand('set-shuffle ' + JSON.stringify({questions: this.hostgamelobby.data.shufflequestions, answers: this.hostgamelobby.data.shuffleanswers}))
+        },
+
         startGame: function() {
             this.hostgamelobby.disabled = true
             this.sendCommand('start-game')
@@ -210,9 +328,30 @@ var app = new Vue({
                 clearInterval(this.hostshowquestion.timer)
                 this.hostshowquestion.timer = null
             }
+            if (this.hostshowquestion.gracetimer != null) {
+                clearTimeout(this.hostshowquestion.gracetimer)
+                this.hostshowquestion.gracetimer = null
+            }
             this.sendCommand('show-results')
         },
 
+        // answers that arrive just after the deadline are still counted so
+        // the results are held back until the grace period is over
+        countdownExpired: function() {
+            if (this.hostshowquestion.timer != null) {
+                clearInterval(this.hostshowquestion.timer)
+                this.hostshowquestion.timer = null
+            }
+            if (this.hostshowquestion.gracetimer != null) {
+                return
+            }
+            let that = this
+            this.hostshowquestion.gracetimer = setTimeout(function() {
+                that.hostshowquestion.gracetimer = null
+                that.stopCountdown()
+            }, this.hostshowquestion.data.answergrace)
+        },
+
         hostNextQuestion: function() {
             this.hostshowresults.disabled = true
             this.sendCommand('next-question')
@@ -270,6 +409,8 @@ var app = new Vue({
                     if (this.screen == 'display-player-results') {
                         // set flag to disabled when we switch away from it
                         this.displayplayerresults.disabled = true
+                        this.displayplayerresults.hoststatus = ''
+                        this.displayplayerresults.correctanswer = ''
                     }
                     switch (arg) {
                         case 'entrance':
@@ -314,9 +455,73 @@ var app = new Vue({
         
                 case 'display-choices':
                     this.answerquestion.answercount = parseInt(arg)
+                    this.answerquestion.multiselect = false
+                    this.answerquestion.ordering = false
+                    this.answerquestion.selected = []
+                    this.answerquestion.fiftyfifty = false
+                    this.answerquestion.removed = []
+                    this.answerquestion.recorded = ''
+                    this.answerquestion.answers = []
+                    if (arg.indexOf(' ') >= 0) {
+                        try {
+                            let choices = JSON.parse(arg.substring(arg.indexOf(' ') + 1))
+                            this.answerquestion.multiselect = choices.multiselect == true
+                            this.answerquestion.ordering = choices.type == 'ordering'
+                            this.answerquestion.fiftyfifty = choices.fiftyfifty == true
+                            this.answerquestion.removed = choices.removed || []
+                            // sent when the answers are shuffled for each player
+                            // so the colours no longer match the host's screen
+                            this.answerquestion.answers = choices.answers || []
+                        } catch (err) {
+                            console.log('err: ' + err)
+                        }
+                    }
                     this.answerquestion.disabled = false
                     break
         
+                case 'fifty-fifty-removed':
+                    try {
+                        this.answerquestion.removed = JSON.parse(arg)
+                        this.answerquestion.fiftyfifty = false
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
+                case 'question-context':
+                    try {
+                        this.answerquestion.context = JSON.parse(arg)
+                        if (this.answerquestion.timer != null) {
+                            clearInterval(this.answerquestion.timer)
+                        }
+                        let that = this
+                        this.answerquestion.timer = setInterval(function() {
+                            if (that.answerquestion.context.paused) {
+                                // the host resends the context when the game resumes
+                                return
+                            }
+                            if (that.answerquestion.context.preload) {
+                                // the countdown only starts once answers begin
+                                if (that.answerquestion.context.answersin > 0) {
+                                    that.answerquestion.context.answersin--
+                                    if (that.answerquestion.context.answersin == 0) {
+                                        that.answerquestion.context.preload = false
+                                    }
+                                }
+                                return
+                            }
+                            if (that.answerquestion.context.timeleft > 0) {
+                                that.answerquestion.context.timeleft--
+                            } else {
+                                clearInterval(that.answerquestion.timer)
+                                that.answerquestion.timer = null
+                            }
+                        }, 1000)
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
                 case 'player-results':
                     try {
                         this.displayplayerresults.data = JSON.parse(arg)
@@ -326,6 +531,33 @@ var app = new Vue({
                     }
                     break
         
+                case 'host-status':
+                    try {
+                        this.displayplayerresults.hoststatus = JSON.parse(arg).message
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+        
+                case 'answer-recorded':
+                    try {
+                        data = JSON.parse(arg)
+                        let choices = data.choices ? data.choices : [data.choice]
+                        this.answerquestion.recorded = 'You picked ' + choices.map(function(choice) { return String.fromCharCode(65 + choice) }).join(', ') + (data.final ? '' : ' - try again')
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
+                case 'emphasize-answer':
+                    try {
+                        data = JSON.parse(arg)
+                        this.displayplayerresults.correctanswer = data.order ? data.order.join(', ') : data.answer
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+        
                 case 'all-quizzes':
                     try {
                         this.hostselectquiz.quizzes = JSON.parse(arg)
@@ -355,9 +587,49 @@ var app = new Vue({
                     }
                     break
         
+                case 'submissions-open':
+                    this.submitquestion.open = true
+                    break
+
+                case 'question-submitted':
+                    this.submitquestion.question = ''
+                    this.submitquestion.answers = ['', '', '', '']
+                    this.submitquestion.correct = 0
+                    this.submitquestion.status = 'Your question is waiting for approval'
+                    this.submitquestion.disabled = false
+                    break
+
+                case 'question-approved':
+                    this.submitquestion.status = 'Your question was added to the game'
+                    break
+
+                case 'question-rejected':
+                    this.submitquestion.status = 'Your question was not accepted'
+                    break
+
+                case 'submitted-questions':
+                    try {
+                        this.hostgamelobby.submissions = JSON.parse(arg).submissions
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
                 case 'participants-list':
                     try {
                         this.hostgamelobby.data.players = JSON.parse(arg)
+                        this.hostgamelobby.data.playercount = this.hostgamelobby.data.players.length
+                        this.updateHostGameLobbyText()
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
+                case 'participants-summary':
+                    try {
+                        let summary = JSON.parse(arg)
+                        this.hostgamelobby.data.players = summary.sample
+                        this.hostgamelobby.data.playercount = summary.count
                         this.updateHostGameLobbyText()
                     } catch (err) {
                         console.log('err: ' + err)
@@ -375,13 +647,29 @@ var app = new Vue({
                                 clearInterval(this.hostshowquestion.timer)
                                 this.hostshowquestion.timer = null
                             }
+                            if (this.hostshowquestion.gracetimer != null) {
+                                clearTimeout(this.hostshowquestion.gracetimer)
+                                this.hostshowquestion.gracetimer = null
+                            }
 
                             this.hostshowquestion.timer = setInterval(function() {
-                                if (that.hostshowquestion && that.hostshowquestion.data && that.hostshowquestion.data.timeleft > 0) {
-                                    that.hostshowquestion.data.timeleft--
-        
+                                if (that.hostshowquestion && that.hostshowquestion.data && that.hostshowquestion.data.preload) {
+                                    // the countdown only starts once answers begin
+                                    if (!that.hostshowquestion.data.paused && that.hostshowquestion.data.answersin > 0) {
+                                        that.hostshowquestion.data.answersin--
+                                        if (that.hostshowquestion.data.answersin == 0) {
+                                            that.hostshowquestion.data.preload = false
+                                        }
+                                    }
+                                    return
+                                }
+                                if (that.hostshowquestion && that.hostshowquestion.data && !that.hostshowquestion.data.paused) {
+                                    if (that.hostshowquestion.data.timeleft > 0) {
+                                        that.hostshowquestion.data.timeleft--
+                                    }
+                                    // the question may have been reloaded during the grace period
                                     if (that.hostshowquestion.data.timeleft == 0) {
-                                        that.stopCountdown()
+                                        that.countdownExpired()
                                     }
                                 }
                             }, 1000)
@@ -421,6 +709,23 @@ var app = new Vue({
                 case 'show-winners':
                     try {
                         this.hostshowgameresults.data = JSON.parse(arg)
+                        this.hostshowgameresults.teams = []
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
+                case 'game-summary':
+                    try {
+                        this.hostshowgameresults.summary = JSON.parse(arg)
+                    } catch (err) {
+                        console.log('err: ' + err)
+                    }
+                    break
+
+                case 'show-team-winners':
+                    try {
+                        this.hostshowgameresults.teams = JSON.parse(arg)
                     } catch (err) {
                         console.log('err: ' + err)
                     }
@@ -429,6 +734,7 @@ var app = new Vue({
                 case 'error':
                     try {
                         data = JSON.parse(arg)
+                        this.submitquestion.disabled = false
                         this.showError(data.message, data.nextscreen)
                     } catch (err) {
                         console.log('err: ' + err)
@@ -4,7 +4,9 @@ go 1.16
 
 require (
 	github.com/gomodule/redigo v1.8.5
-	github.com/google/uuid v1.2.0
+	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/kwkoo/configparser v0.1.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.18.2
 )
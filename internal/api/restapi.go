@@ -1,24 +1,33 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kwkoo/go-quiz/internal/common"
 	"github.com/kwkoo/go-quiz/internal/messaging"
 )
 
 type RestApi struct {
-	hub messaging.MessageHub
+	hub           messaging.MessageHub
+	maxImportSize int64 // maximum number of bytes in a quiz import request
 }
 
-func InitRestApi(hub messaging.MessageHub) *RestApi {
-	return &RestApi{hub: hub}
+func InitRestApi(hub messaging.MessageHub, maxImportSize int64) *RestApi {
+	return &RestApi{
+		hub:           hub,
+		maxImportSize: maxImportSize,
+	}
 }
 
 func (api *RestApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -39,20 +48,90 @@ func (api *RestApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		api.Game(w, r)
 		return
 	}
+	if strings.HasPrefix(path, "/api/admin/") {
+		api.Admin(w, r)
+		return
+	}
+	if path == "/api/metrics" {
+		api.Metrics(w, r)
+		return
+	}
+	if path == "/api/stats" {
+		api.Stats(w, r)
+		return
+	}
+	if path == "/api/flags" {
+		api.QuestionFlags(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "/api/archive/") {
+		api.Archive(w, r)
+		return
+	}
+	if path == "/api/backup" {
+		api.Backup(w, r)
+		return
+	}
+	if path == "/api/restore" {
+		api.Restore(w, r)
+		return
+	}
 
 	http.Error(w, "not found", http.StatusNotFound)
 }
 
+// A quiz along with the number of seconds a game of the quiz is expected to
+// take - the duration is left out of exports so that it is never imported
+type quizWithDuration struct {
+	common.Quiz
+	EstimatedDuration int `json:"estimatedDuration"`
+}
+
 func (api *RestApi) Quiz(w http.ResponseWriter, r *http.Request) {
 	// export
 	if r.Method == http.MethodGet {
 		last := lastPart(r.URL.Path)
+		if last == "export" {
+			api.exportQuizzes(w)
+			return
+		}
+		if strings.HasSuffix(last, ".yaml") {
+			api.exportQuizYAML(w, strings.TrimSuffix(last, ".yaml"))
+			return
+		}
 		id, err := strconv.Atoi(last)
 		if err != nil {
+			// get all quizzes - optionally only those in the given category
+			// and with all the given tags
 			allQuizzes := api.getQuizzes()
+			query := r.URL.Query()
+			category, tags := query.Get("category"), query["tag"]
+			if category != "" || len(tags) > 0 {
+				filtered := []common.Quiz{}
+				for _, quiz := range allQuizzes {
+					if category != "" && quiz.Category != category {
+						continue
+					}
+					tagged := true
+					for _, tag := range tags {
+						if !quiz.HasTag(tag) {
+							tagged = false
+							break
+						}
+					}
+					if tagged {
+						filtered = append(filtered, quiz)
+					}
+				}
+				allQuizzes = filtered
+			}
+			withDurations := make([]quizWithDuration, len(allQuizzes))
+			for i, quiz := range allQuizzes {
+				withDurations[i] = quizWithDuration{quiz, quiz.EstimatedDuration()}
+			}
 			w.Header().Add("Content-Type", "application/json")
 			enc := json.NewEncoder(w)
-			if err := enc.Encode(allQuizzes); err != nil {
+			if err := enc.Encode(withDurations); err != nil {
 				log.Printf("error encoding slice of quizzes to JSON: %v", err)
 				return
 			}
@@ -67,7 +146,7 @@ func (api *RestApi) Quiz(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Add("Content-Type", "application/json")
 		enc := json.NewEncoder(w)
-		if err := enc.Encode(quiz); err != nil {
+		if err := enc.Encode(quizWithDuration{quiz, quiz.EstimatedDuration()}); err != nil {
 			streamResponse(w, false, fmt.Sprintf("error encoding quiz to JSON: %v", err))
 			return
 		}
@@ -86,36 +165,43 @@ func (api *RestApi) Quiz(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/push") {
+		api.PushQuiz(w, strings.TrimSuffix(r.URL.Path, "/push"))
+		return
+	}
+
 	// import
+	author, _, _ := r.BasicAuth()
+	r.Body = http.MaxBytesReader(w, r.Body, api.maxImportSize)
 	defer r.Body.Close()
 
 	// check to see if it's bulk import
 	if strings.HasSuffix(r.URL.Path, "/bulk") {
-		toImport, err := common.UnmarshalQuizzes(r.Body)
-		if err != nil {
-			streamResponse(w, false, fmt.Sprintf("error parsing JSON: %v", err))
-			return
-		}
-		for _, q := range toImport {
-			if err := api.addQuiz(q); err != nil {
-				streamResponse(w, false, fmt.Sprintf("error adding quiz: %v", err))
-				continue
-			}
-		}
-		streamResponse(w, true, "")
+		api.bulkImport(w, r.Body, author)
 		return
 	}
 
-	// we're importing a single quiz
-	toImport, err := common.UnmarshalQuiz(r.Body)
+	// we're importing a single quiz - either in YAML or in JSON
+	format := "JSON"
+	unmarshal := common.UnmarshalQuiz
+	if strings.HasSuffix(r.URL.Path, ".yaml") {
+		format = "YAML"
+		unmarshal = common.UnmarshalQuizYAML
+	}
+	toImport, err := unmarshal(r.Body)
 	if err != nil {
-		streamResponse(w, false, fmt.Sprintf("error parsing JSON: %v", err))
+		if bodyTooLarge(err) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			streamResponse(w, false, fmt.Sprintf("import exceeds the maximum size of %d bytes", api.maxImportSize))
+			return
+		}
+		streamResponse(w, false, fmt.Sprintf("error parsing %s: %v", format, err))
 		return
 	}
 
 	if toImport.Id == 0 {
 		// no ID, so treat this as an add operation
-		if err := api.addQuiz(toImport); err != nil {
+		if err := api.addQuiz(toImport, author); err != nil {
 			streamResponse(w, false, fmt.Sprintf("error adding quiz: %v", err))
 			return
 		}
@@ -128,6 +214,211 @@ func (api *RestApi) Quiz(w http.ResponseWriter, r *http.Request) {
 	streamResponse(w, true, "")
 }
 
+// Imports each quiz in a JSON array - invalid quizzes are skipped and the
+// response lists the outcome for each quiz
+func (api *RestApi) bulkImport(w http.ResponseWriter, body io.Reader, author string) {
+	var toImport []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&toImport); err != nil {
+		if bodyTooLarge(err) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			streamResponse(w, false, fmt.Sprintf("import exceeds the maximum size of %d bytes", api.maxImportSize))
+			return
+		}
+		streamResponse(w, false, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+
+	type itemResult struct {
+		Index   int    `json:"index"`
+		Success bool   `json:"success"`
+		Error   string `json:"error,omitempty"`
+	}
+	results := make([]itemResult, len(toImport))
+	for i, raw := range toImport {
+		results[i].Index = i
+		q, err := common.UnmarshalQuiz(bytes.NewReader(raw))
+		if err != nil {
+			results[i].Error = fmt.Sprintf("error parsing JSON: %v", err)
+			continue
+		}
+		if err := api.addQuiz(q, author); err != nil {
+			results[i].Error = fmt.Sprintf("error adding quiz: %v", err)
+			continue
+		}
+		results[i].Success = true
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("error encoding bulk import results to JSON: %v", err)
+	}
+}
+
+// Pushes the stored quiz into the games that are using it
+func (api *RestApi) PushQuiz(w http.ResponseWriter, path string) {
+	last := lastPart(path)
+	id, err := strconv.Atoi(last)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid id %s: %v", last, err))
+		return
+	}
+	quiz, err := api.getQuiz(id)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("quiz %d does not exist", id))
+		return
+	}
+	resp := struct {
+		Success bool  `json:"success"`
+		Games   []int `json:"games"` // pins of the games that will use the update
+	}{
+		Success: true,
+		Games:   api.pushQuiz(quiz),
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		log.Printf("error encoding push response to JSON: %v", err)
+	}
+}
+
+func (api *RestApi) exportQuizYAML(w http.ResponseWriter, idString string) {
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid id %s: %v", idString, err))
+		return
+	}
+	quiz, err := api.getQuiz(id)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("quiz %d does not exist", id))
+		return
+	}
+	data, err := quiz.MarshalToYAML()
+	if err != nil {
+		streamResponse(w, false, err.Error())
+		return
+	}
+	w.Header().Add("Content-Type", "application/yaml")
+	w.Write(data)
+}
+
+// Returns every quiz in a zip - one JSON file per quiz along with a manifest
+// listing the file that holds each quiz
+func (api *RestApi) exportQuizzes(w http.ResponseWriter) {
+	var buf bytes.Buffer
+	if err := writeQuizzesArchive(&buf, api.getQuizzes()); err != nil {
+		streamResponse(w, false, fmt.Sprintf("error exporting quizzes: %v", err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/zip")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"quizzes.zip\"")
+	w.Write(buf.Bytes())
+}
+
+// An entry in the manifest of a quiz export
+type exportedQuiz struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// Writes each quiz to <id>-<sanitized name>.json and the list of quizzes to
+// manifest.json
+func writeQuizzesArchive(w io.Writer, quizzes []common.Quiz) error {
+	sort.Slice(quizzes, func(i, j int) bool { return quizzes[i].Id < quizzes[j].Id })
+	zw := zip.NewWriter(w)
+	manifest := []exportedQuiz{}
+	for i := range quizzes {
+		quiz := &quizzes[i]
+		name := fmt.Sprintf("%d.json", quiz.Id)
+		if sanitized := sanitizeFilename(quiz.Name); sanitized != "" {
+			name = fmt.Sprintf("%d-%s.json", quiz.Id, sanitized)
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(quiz); err != nil {
+			return fmt.Errorf("error converting quiz %d to JSON: %v", quiz.Id, err)
+		}
+		manifest = append(manifest, exportedQuiz{Id: quiz.Id, Name: quiz.Name, File: name})
+	}
+
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return fmt.Errorf("error converting manifest to JSON: %v", err)
+	}
+
+	return zw.Close()
+}
+
+// Lowercases s and replaces each run of characters other than letters and
+// digits with a single dash
+func sanitizeFilename(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			dash = false
+			b.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+func (api *RestApi) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(api.getStats()); err != nil {
+		log.Printf("error encoding quiz stats to JSON: %v", err)
+	}
+}
+
+// Lists the questions that players have flagged so that the quizzes can be
+// fixed
+func (api *RestApi) QuestionFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(api.getQuestionFlags()); err != nil {
+		log.Printf("error encoding question flags to JSON: %v", err)
+	}
+}
+
+// Reports the number of connected clients and their round-trip times - to
+// help hosts diagnose laggy venues
+func (api *RestApi) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(api.getConnectionStats()); err != nil {
+		log.Printf("error encoding connection stats to JSON: %v", err)
+	}
+}
+
 func (api *RestApi) ExtendSession(w http.ResponseWriter, r *http.Request) {
 	id := lastPart(r.URL.Path)
 	if len(id) == 0 {
@@ -151,6 +442,11 @@ func (api *RestApi) Session(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if parts := pathParts(r.URL.Path, "/api/session"); len(parts) == 2 && parts[1] == "games" {
+			api.SessionGames(w, parts[0])
+			return
+		}
+
 		id := lastPart(r.URL.Path)
 		if len(id) == 0 {
 			streamResponse(w, false, "invalid session id")
@@ -183,11 +479,74 @@ func (api *RestApi) Session(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "unsupported method", http.StatusNotImplemented)
 }
 
+// Lists the games the session is in or was recently in - oldest first
+func (api *RestApi) SessionGames(w http.ResponseWriter, id string) {
+	session := api.getSession(id)
+	if session == nil {
+		streamResponse(w, false, fmt.Sprintf("invalid session id %s", id))
+		return
+	}
+	games := session.Games
+	if games == nil {
+		games = []common.GameParticipation{}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(games); err != nil {
+		log.Printf("error encoding games of session %s: %v", id, err)
+	}
+}
+
 func (api *RestApi) Game(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		parts := pathParts(r.URL.Path, "/api/game")
+		if len(parts) == 2 && parts[1] == "extend" {
+			api.ExtendGameSessions(w, parts[0])
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
 	if r.Method == http.MethodGet {
+		parts := pathParts(r.URL.Path, "/api/game")
+		if len(parts) == 3 && parts[1] == "report" {
+			api.ReportCard(w, parts[0], parts[2])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "status" {
+			api.GameStatus(w, parts[0])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "results" {
+			api.QuestionResults(w, parts[0])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "history" {
+			api.AnswerHistory(w, parts[0])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "leaderboard.csv" {
+			api.Leaderboard(w, parts[0])
+			return
+		}
+		if len(parts) == 3 && parts[1] == "question" && parts[2] == "current" {
+			api.PrintableQuestion(w, parts[0])
+			return
+		}
+
 		if strings.HasSuffix(r.URL.Path, "/game") {
-			// get all games
+			// get all games - optionally only those with the given label
 			all := api.getGames()
+			if label := r.URL.Query().Get("label"); label != "" {
+				filtered := []common.Game{}
+				for _, game := range all {
+					if game.Label == label {
+						filtered = append(filtered, game)
+					}
+				}
+				all = filtered
+			}
 			w.Header().Add("Content-Type", "application/json")
 			enc := json.NewEncoder(w)
 			if err := enc.Encode(all); err != nil {
@@ -231,18 +590,11 @@ func (api *RestApi) Game(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		game, err := api.getGame(pin)
-		if err != nil {
-			streamResponse(w, false, fmt.Sprintf("could not get game with pin %d: %v", pin, err))
+		// the games handler removes the players and host from the game
+		if err := api.deleteGame(pin); err != nil {
+			streamResponse(w, false, fmt.Sprintf("could not delete game with pin %d: %v", pin, err))
 			return
 		}
-
-		// remove players and host from game
-		players := append(game.GetPlayers(), game.Host)
-		api.removeGameFromSessions(players)
-		api.sendClientsToScreen(players, "entrance")
-
-		api.deleteGame(pin)
 		streamResponse(w, true, "")
 		return
 	}
@@ -255,7 +607,21 @@ func (api *RestApi) Game(w http.ResponseWriter, r *http.Request) {
 			streamResponse(w, false, fmt.Sprintf("error decoding game JSON: %v", err))
 			return
 		}
-		api.updateGame(game)
+		if last := lastPart(r.URL.Path); last != "game" {
+			pin, err := strconv.Atoi(last)
+			if err != nil {
+				streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", last, err))
+				return
+			}
+			if pin != game.Pin {
+				streamResponse(w, false, fmt.Sprintf("game pin %d does not match pin %d in the URL", game.Pin, pin))
+				return
+			}
+		}
+		if err := api.updateGame(game); err != nil {
+			streamResponse(w, false, fmt.Sprintf("error updating game: %v", err))
+			return
+		}
 		streamResponse(w, true, "")
 		return
 	}
@@ -263,6 +629,429 @@ func (api *RestApi) Game(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "unsupported method", http.StatusNotImplemented)
 }
 
+func (api *RestApi) Admin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/admin/reap" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unsupported method", http.StatusNotImplemented)
+			return
+		}
+		resp := struct {
+			Success bool `json:"success"`
+			Reaped  int  `json:"reaped"`
+		}{
+			Success: true,
+			Reaped:  api.reapSessions(),
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&resp); err != nil {
+			log.Printf("error encoding reap response to JSON: %v", err)
+		}
+		return
+	}
+
+	if r.URL.Path == "/api/admin/clients" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "unsupported method", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(api.getClients()); err != nil {
+			log.Printf("error encoding clients to JSON: %v", err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/admin/clients/") {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "unsupported method", http.StatusNotImplemented)
+			return
+		}
+		last := lastPart(r.URL.Path)
+		id, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			streamResponse(w, false, fmt.Sprintf("invalid client id %s: %v", last, err))
+			return
+		}
+		if !api.disconnectClient(id) {
+			streamResponse(w, false, fmt.Sprintf("client %d is not connected", id))
+			return
+		}
+		streamResponse(w, true, "")
+		return
+	}
+
+	if r.URL.Path == "/api/admin/store" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "unsupported method", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(api.getStoreStatus()); err != nil {
+			log.Printf("error encoding store status to JSON: %v", err)
+		}
+		return
+	}
+
+	http.Error(w, "not found", http.StatusNotFound)
+}
+
+// Returns all quizzes in a backup bundle - sessions and games are included if
+// they are listed in the include query parameter (e.g. ?include=sessions,games)
+func (api *RestApi) Backup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+
+	backup := common.Backup{
+		Version:   common.BackupVersion,
+		CreatedAt: time.Now(),
+		Quizzes:   api.getQuizzes(),
+	}
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		switch strings.TrimSpace(include) {
+		case "sessions":
+			backup.Sessions = api.getSessions()
+		case "games":
+			backup.Games = api.getGames()
+		}
+	}
+
+	encoded, err := backup.Marshal()
+	if err != nil {
+		streamResponse(w, false, err.Error())
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"quiz-backup.json\"")
+	w.Write(encoded)
+}
+
+// Returns a zip of everything needed to archive a game after an event - the
+// quiz that was used, the final results and every player's answers
+func (api *RestApi) Archive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+	last := lastPart(r.URL.Path)
+	pin, err := strconv.Atoi(last)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", last, err))
+		return
+	}
+	game, err := api.getGame(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+
+	// assemble the archive before writing the headers so that errors can
+	// still be reported
+	var buf bytes.Buffer
+	if err := writeGameArchive(&buf, game); err != nil {
+		streamResponse(w, false, fmt.Sprintf("error creating archive for game %d: %v", pin, err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/zip")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"game-%d.zip\"", pin))
+	w.Write(buf.Bytes())
+}
+
+// Player names and teams are typed in by players - spreadsheets treat cells
+// starting with these characters as formulas so they are prefixed with a
+// quote to stop them from being evaluated
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Writes quiz.json, results.csv and transcript.json to a zip
+func writeGameArchive(w io.Writer, game common.Game) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create("quiz.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&game.Quiz); err != nil {
+		return fmt.Errorf("error converting quiz to JSON: %v", err)
+	}
+
+	f, err = zw.Create("results.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"rank", "name", "team", "score", "correct", "answered"})
+	for i, player := range game.GetGameResults().Players {
+		cw.Write([]string{
+			strconv.Itoa(i + 1),
+			csvSafe(player.Name),
+			csvSafe(player.Team),
+			strconv.Itoa(player.Score),
+			strconv.Itoa(player.Correct),
+			strconv.Itoa(player.Answered),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("error writing results CSV: %v", err)
+	}
+
+	// the transcript is the report card of every player, ordered by name
+	players := game.GetPlayers()
+	sort.Slice(players, func(i, j int) bool {
+		return game.PlayerNames[players[i]] < game.PlayerNames[players[j]]
+	})
+	transcript := []common.ReportCard{}
+	for _, player := range players {
+		report, err := game.GetReportCard(player)
+		if err != nil {
+			return err
+		}
+		transcript = append(transcript, report)
+	}
+	f, err = zw.Create("transcript.json")
+	if err != nil {
+		return err
+	}
+	enc = json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(transcript); err != nil {
+		return fmt.Errorf("error converting transcript to JSON: %v", err)
+	}
+
+	return zw.Close()
+}
+
+// Restores the quizzes in a backup bundle - the mode query parameter is either
+// merge (the default), which keeps quizzes that are not in the bundle, or
+// replace, which deletes them. Sessions and games in the bundle are not
+// restored because they are only valid while their clients are connected.
+func (api *RestApi) Restore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+
+	replace := false
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "merge":
+	case "replace":
+		replace = true
+	default:
+		streamResponse(w, false, fmt.Sprintf("invalid restore mode %s - expected merge or replace", mode))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, api.maxImportSize)
+	defer r.Body.Close()
+	backup, err := common.UnmarshalBackup(r.Body)
+	if err != nil {
+		if bodyTooLarge(err) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			streamResponse(w, false, fmt.Sprintf("backup exceeds the maximum size of %d bytes", api.maxImportSize))
+			return
+		}
+		streamResponse(w, false, fmt.Sprintf("error parsing backup: %v", err))
+		return
+	}
+
+	if err := api.restoreQuizzes(backup.Quizzes, replace); err != nil {
+		streamResponse(w, false, fmt.Sprintf("error restoring quizzes: %v", err))
+		return
+	}
+
+	resp := struct {
+		Success bool `json:"success"`
+		Quizzes int  `json:"quizzes"` // number of quizzes restored
+	}{
+		Success: true,
+		Quizzes: len(backup.Quizzes),
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		log.Printf("error encoding restore response to JSON: %v", err)
+	}
+}
+
+func (api *RestApi) GameStatus(w http.ResponseWriter, pinString string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	status, err := api.getGameStatus(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&status); err != nil {
+		log.Printf("error encoding game status to JSON: %v", err)
+	}
+}
+
+// Serves /public/game/{pin} without authentication so that a game can be
+// shown on a projector - the correct answer is withheld until the results
+// are shown
+func (api *RestApi) PublicGame(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+		return
+	}
+	parts := pathParts(r.URL.Path, "/public/game")
+	if len(parts) != 1 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	pin, err := strconv.Atoi(parts[0])
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", parts[0], err))
+		return
+	}
+	view, err := api.getPublicGameView(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&view); err != nil {
+		log.Printf("error encoding public game view to JSON: %v", err)
+	}
+}
+
+// Keeps the host and all players in the game from expiring during a long
+// break
+func (api *RestApi) ExtendGameSessions(w http.ResponseWriter, pinString string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	game, err := api.getGame(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+	for _, id := range append(game.GetPlayers(), game.Host) {
+		api.extendSessionExpiry(id)
+	}
+	streamResponse(w, true, "")
+}
+
+func (api *RestApi) PrintableQuestion(w http.ResponseWriter, pinString string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	question, err := api.getPrintableQuestion(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting current question for game %d: %v", pin, err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&question); err != nil {
+		log.Printf("error encoding current question to JSON: %v", err)
+	}
+}
+
+// Returns the results of the current question without affecting the game -
+// responds with 409 if the game has not started
+func (api *RestApi) QuestionResults(w http.ResponseWriter, pinString string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	results, err := api.getGameResults(pin)
+	if err != nil {
+		if errState, ok := err.(*common.UnexpectedStateError); ok && errState.CurrentState == common.GameNotStarted {
+			w.WriteHeader(http.StatusConflict)
+		}
+		streamResponse(w, false, fmt.Sprintf("error getting results for game %d: %v", pin, err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&results); err != nil {
+		log.Printf("error encoding question results to JSON: %v", err)
+	}
+}
+
+// Returns the answer each player chose for each question asked so far
+func (api *RestApi) AnswerHistory(w http.ResponseWriter, pinString string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	game, err := api.getGame(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+	history := game.GetGameHistory()
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&history); err != nil {
+		log.Printf("error encoding answer history to JSON: %v", err)
+	}
+}
+
+// Returns the final standings of a game as CSV so that they can be pasted
+// into a gradebook - players are listed from the highest score
+func (api *RestApi) Leaderboard(w http.ResponseWriter, pinString string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	game, err := api.getGame(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+	w.Header().Add("Content-Type", "text/csv")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"leaderboard-%d.csv\"", pin))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "score", "correct"})
+	for _, player := range game.GetGameResults().Players {
+		cw.Write([]string{csvSafe(player.Name), strconv.Itoa(player.Score), strconv.Itoa(player.Correct)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("error writing leaderboard CSV: %v", err)
+	}
+}
+
+func (api *RestApi) ReportCard(w http.ResponseWriter, pinString, player string) {
+	pin, err := strconv.Atoi(pinString)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("invalid game id %s: %v", pinString, err))
+		return
+	}
+	game, err := api.getGame(pin)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error getting game %d: %v", pin, err))
+		return
+	}
+	report, err := game.GetReportCard(player)
+	if err != nil {
+		streamResponse(w, false, fmt.Sprintf("error generating report card: %v", err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&report); err != nil {
+		log.Printf("error encoding report card to JSON: %v", err)
+	}
+}
+
 func (api *RestApi) getQuizzes() []common.Quiz {
 	c := make(chan []common.Quiz)
 	api.hub.Send(messaging.QuizzesTopic, &common.GetQuizzesMessage{
@@ -285,10 +1074,11 @@ func (api *RestApi) deleteQuiz(id int) {
 	api.hub.Send(messaging.QuizzesTopic, common.DeleteQuizMessage{Quizid: id})
 }
 
-func (api *RestApi) addQuiz(q common.Quiz) error {
+func (api *RestApi) addQuiz(q common.Quiz, author string) error {
 	c := make(chan error)
 	api.hub.Send(messaging.QuizzesTopic, &common.AddQuizMessage{
 		Quiz:   q,
+		Author: author,
 		Result: c,
 	})
 	return <-c
@@ -304,6 +1094,17 @@ func (api *RestApi) updateQuiz(q common.Quiz) error {
 	return <-c
 }
 
+// used by the REST API
+func (api *RestApi) restoreQuizzes(quizzes []common.Quiz, replace bool) error {
+	c := make(chan error)
+	api.hub.Send(messaging.QuizzesTopic, &common.RestoreQuizzesMessage{
+		Quizzes: quizzes,
+		Replace: replace,
+		Result:  c,
+	})
+	return <-c
+}
+
 // used by the REST API
 func (api *RestApi) extendSessionExpiry(id string) {
 	api.hub.Send(messaging.SessionsTopic, common.ExtendSessionExpiryMessage{
@@ -337,6 +1138,62 @@ func (api *RestApi) deleteSession(id string) {
 	})
 }
 
+// used by the REST API
+func (api *RestApi) reapSessions() int {
+	c := make(chan int)
+	api.hub.Send(messaging.SessionsTopic, &common.ReapSessionsMessage{
+		Result: c,
+	})
+	return <-c
+}
+
+// used by the REST API
+func (api *RestApi) pushQuiz(q common.Quiz) []int {
+	c := make(chan []int)
+	api.hub.Send(messaging.GamesTopic, &common.PushQuizToGamesMessage{
+		Quiz:   q,
+		Result: c,
+	})
+	return <-c
+}
+
+// used by the REST API
+func (api *RestApi) getClients() []common.ConnectedClient {
+	c := make(chan []common.ConnectedClient)
+	api.hub.Send(messaging.SessionsTopic, &common.GetClientsMessage{
+		Result: c,
+	})
+	return <-c
+}
+
+// used by the REST API
+func (api *RestApi) getConnectionStats() common.ConnectionStats {
+	c := make(chan common.ConnectionStats)
+	api.hub.Send(messaging.ClientHubTopic, &common.GetConnectionStatsMessage{
+		Result: c,
+	})
+	return <-c
+}
+
+// used by the REST API
+func (api *RestApi) disconnectClient(id uint64) bool {
+	c := make(chan bool)
+	api.hub.Send(messaging.SessionsTopic, &common.DisconnectClientMessage{
+		Clientid: id,
+		Result:   c,
+	})
+	return <-c
+}
+
+// used by the REST API
+func (api *RestApi) getStoreStatus() common.StoreStatus {
+	c := make(chan common.StoreStatus)
+	api.hub.Send(messaging.SessionsTopic, &common.GetStoreStatusMessage{
+		Result: c,
+	})
+	return <-c
+}
+
 // used by the REST API
 func (api *RestApi) getGames() []common.Game {
 	c := make(chan []common.Game)
@@ -346,6 +1203,24 @@ func (api *RestApi) getGames() []common.Game {
 	return <-c
 }
 
+// used by the REST API
+func (api *RestApi) getStats() []common.QuizStats {
+	c := make(chan []common.QuizStats)
+	api.hub.Send(messaging.GamesTopic, &common.GetStatsMessage{
+		Result: c,
+	})
+	return <-c
+}
+
+// used by the REST API
+func (api *RestApi) getQuestionFlags() []common.QuestionFlag {
+	c := make(chan []common.QuestionFlag)
+	api.hub.Send(messaging.GamesTopic, &common.GetQuestionFlagsMessage{
+		Result: c,
+	})
+	return <-c
+}
+
 // used by the REST API
 func (api *RestApi) getGame(id int) (common.Game, error) {
 	c := make(chan common.GetGameResult)
@@ -358,28 +1233,67 @@ func (api *RestApi) getGame(id int) (common.Game, error) {
 }
 
 // used by the REST API
-func (api *RestApi) deleteGame(id int) {
-	api.hub.Send(messaging.GamesTopic, common.DeleteGameByPin{Pin: id})
+func (api *RestApi) getGameStatus(pin int) (common.GameStatus, error) {
+	c := make(chan common.GetGameStatusResult)
+	api.hub.Send(messaging.GamesTopic, &common.GetGameStatusMessage{
+		Pin:    pin,
+		Result: c,
+	})
+	result := <-c
+	return result.Status, result.Error
+}
+
+// used by the REST API
+func (api *RestApi) getPublicGameView(pin int) (common.PublicGameView, error) {
+	c := make(chan common.GetPublicGameViewResult)
+	api.hub.Send(messaging.GamesTopic, &common.GetPublicGameViewMessage{
+		Pin:    pin,
+		Result: c,
+	})
+	result := <-c
+	return result.View, result.Error
+}
+
+// used by the REST API
+func (api *RestApi) getPrintableQuestion(pin int) (common.PrintableQuestion, error) {
+	c := make(chan common.GetPrintableQuestionResult)
+	api.hub.Send(messaging.GamesTopic, &common.GetPrintableQuestionMessage{
+		Pin:    pin,
+		Result: c,
+	})
+	result := <-c
+	return result.Question, result.Error
 }
 
 // used by the REST API
-func (api *RestApi) updateGame(g common.Game) {
-	api.hub.Send(messaging.GamesTopic, g)
+func (api *RestApi) getGameResults(pin int) (common.QuestionResults, error) {
+	c := make(chan common.GetGameResultsResult)
+	api.hub.Send(messaging.GamesTopic, &common.GetGameResultsMessage{
+		Pin:    pin,
+		Result: c,
+	})
+	result := <-c
+	return result.Results, result.Error
 }
 
-func (api *RestApi) removeGameFromSessions(sessionids []string) {
-	api.hub.Send(messaging.SessionsTopic, common.DeregisterGameFromSessionsMessage{
-		Sessions: sessionids,
+// used by the REST API
+func (api *RestApi) deleteGame(id int) error {
+	c := make(chan error)
+	api.hub.Send(messaging.GamesTopic, &common.DeleteGameByPin{
+		Pin:    id,
+		Result: c,
 	})
+	return <-c
 }
 
-func (api *RestApi) sendClientsToScreen(sessionids []string, screen string) {
-	for _, id := range sessionids {
-		api.hub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-			Sessionid:  id,
-			Nextscreen: screen,
-		})
-	}
+// used by the REST API
+func (api *RestApi) updateGame(g common.Game) error {
+	c := make(chan error)
+	api.hub.Send(messaging.GamesTopic, &common.UpdateGameMessage{
+		Game:   g,
+		Result: c,
+	})
+	return <-c
 }
 
 // returns the part beyond the last slash in the URL
@@ -391,6 +1305,22 @@ func lastPart(s string) string {
 	return s[last+1:]
 }
 
+// returns the non-empty path segments after prefix
+func pathParts(path, prefix string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(strings.TrimPrefix(path, prefix), "/") {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// returns true if the error was caused by http.MaxBytesReader
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
 func streamResponse(w io.Writer, success bool, errMsg string) {
 	resp := struct {
 		Success bool   `json:"success"`
@@ -0,0 +1,882 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+// responds to REST API queries with canned responses
+type fakeHub struct {
+	sent    []interface{}
+	respond func(msg interface{})
+}
+
+func (h *fakeHub) Send(topicname string, msg interface{}) {
+	h.sent = append(h.sent, msg)
+	if h.respond != nil {
+		h.respond(msg)
+	}
+}
+
+func (h *fakeHub) Close() {}
+
+func (h *fakeHub) GetTopic(name string) chan interface{} {
+	return make(chan interface{})
+}
+
+func TestReapEndpoint(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.ReapSessionsMessage); ok {
+				go func() {
+					m.Result <- 1
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/admin/reap", nil))
+
+	resp := struct {
+		Success bool `json:"success"`
+		Reaped  int  `json:"reaped"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if !resp.Success || resp.Reaped != 1 {
+		t.Errorf("expected 1 reaped session but got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/reap", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected GET to be rejected but got status %d", w.Code)
+	}
+}
+
+func TestUpdateGamePinMismatch(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.UpdateGameMessage); ok {
+				go func() {
+					m.Result <- nil
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	tests := []struct {
+		path            string
+		expectedSuccess bool
+	}{
+		{"/api/game/123", true},
+		{"/api/game", true},
+		{"/api/game/456", false},
+	}
+
+	for testIndex, test := range tests {
+		hub.sent = nil
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodPut, test.path, strings.NewReader(`{"pin":123,"host":"host"}`)))
+		resp := struct {
+			Success bool `json:"success"`
+		}{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("error decoding response for test index %d: %v", testIndex, err)
+		}
+		if resp.Success != test.expectedSuccess {
+			t.Errorf("expected success %v but got %v for test index %d", test.expectedSuccess, resp.Success, testIndex)
+		}
+		if !test.expectedSuccess && len(hub.sent) != 0 {
+			t.Errorf("expected mismatched update not to be sent for test index %d", testIndex)
+		}
+	}
+}
+
+func TestImportSizeLimit(t *testing.T) {
+	hub := &fakeHub{}
+	api := InitRestApi(hub, 1024)
+
+	oversized := `{"name":"` + strings.Repeat("x", 4096) + `","questions":[]}`
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/api/quiz", oversized},
+		{"/api/quiz.yaml", "name: " + strings.Repeat("x", 4096) + "\n"},
+		{"/api/quiz/bulk", "[" + oversized + "]"},
+	}
+
+	for testIndex, test := range tests {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status %d but got %d for test index %d", http.StatusRequestEntityTooLarge, w.Code, testIndex)
+		}
+	}
+	if len(hub.sent) != 0 {
+		t.Errorf("expected no quizzes to be imported but got %v", hub.sent)
+	}
+}
+
+func TestExtendGameSessions(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetGameMessage); ok {
+				go func() {
+					m.Result <- common.GetGameResult{
+						Game: common.Game{
+							Pin:  m.Pin,
+							Host: "host",
+							Players: map[string]int{
+								"player1": 0,
+								"player2": 0,
+							},
+						},
+					}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/game/1234/extend", nil))
+	if !strings.Contains(w.Body.String(), `"success":true`) {
+		t.Fatalf("expected extend to succeed but got %s", w.Body.String())
+	}
+
+	extended := map[string]bool{}
+	for _, msg := range hub.sent {
+		if m, ok := msg.(common.ExtendSessionExpiryMessage); ok {
+			extended[m.Sessionid] = true
+		}
+	}
+	for _, id := range []string{"host", "player1", "player2"} {
+		if !extended[id] {
+			t.Errorf("expected session %s to be extended", id)
+		}
+	}
+	if len(extended) != 3 {
+		t.Errorf("expected 3 sessions to be extended but got %d", len(extended))
+	}
+}
+
+func TestFilterGamesByLabel(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetGamesMessage); ok {
+				go func() {
+					m.Result <- []common.Game{
+						{Pin: 1, Label: "Room A"},
+						{Pin: 2, Label: "Room B"},
+						{Pin: 3},
+						{Pin: 4, Label: "Room A"},
+					}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	tests := []struct {
+		query    string
+		expected []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{"?label=Room+A", []int{1, 4}},
+		{"?label=Room%20B", []int{2}},
+		{"?label=Room+C", []int{}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/game"+test.query, nil))
+		var games []common.Game
+		if err := json.NewDecoder(w.Body).Decode(&games); err != nil {
+			t.Fatalf("error decoding games for query \"%s\": %v", test.query, err)
+		}
+		pins := []int{}
+		for _, game := range games {
+			pins = append(pins, game.Pin)
+		}
+		if fmt.Sprint(pins) != fmt.Sprint(test.expected) {
+			t.Errorf("expected games %v for query \"%s\" but got %v", test.expected, test.query, pins)
+		}
+	}
+}
+
+func TestFilterQuizzes(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetQuizzesMessage); ok {
+				go func() {
+					m.Result <- []common.Quiz{
+						{Id: 1, Category: "Science", Tags: []string{"physics", "easy"}},
+						{Id: 2, Category: "Science", Tags: []string{"biology"}},
+						{Id: 3, Category: "History", Tags: []string{"easy"}},
+						{Id: 4},
+					}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	tests := []struct {
+		query    string
+		expected []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{"?category=Science", []int{1, 2}},
+		{"?tag=easy", []int{1, 3}},
+		{"?category=Science&tag=easy", []int{1}},
+		{"?tag=easy&tag=physics", []int{1}},
+		{"?tag=easy&tag=biology", []int{}},
+		{"?category=History&tag=biology", []int{}},
+		{"?category=Geography", []int{}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/quiz"+test.query, nil))
+		var quizzes []common.Quiz
+		if err := json.NewDecoder(w.Body).Decode(&quizzes); err != nil {
+			t.Fatalf("error decoding quizzes for query \"%s\": %v", test.query, err)
+		}
+		ids := []int{}
+		for _, quiz := range quizzes {
+			ids = append(ids, quiz.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expected) {
+			t.Errorf("expected quizzes %v for query \"%s\" but got %v", test.expected, test.query, ids)
+		}
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	quizzes := []common.Quiz{
+		{Id: 3, Name: "first", Questions: []common.QuizQuestion{{Question: "q", Answers: []string{"a", "b"}, Correct: 1}}},
+		{Id: 7, Name: "second", Questions: []common.QuizQuestion{{Question: "q", Answers: []string{"a", "b", "c"}, Correct: 2}}},
+	}
+	source := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetQuizzesMessage); ok {
+				go func() {
+					m.Result <- quizzes
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	w := httptest.NewRecorder()
+	InitRestApi(source, 1<<20).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/backup", nil))
+	backup := w.Body.String()
+
+	var restored *common.RestoreQuizzesMessage
+	target := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.RestoreQuizzesMessage); ok {
+				restored = m
+				go func() {
+					m.Result <- nil
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(target, 1<<20)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/restore?mode=replace", strings.NewReader(backup)))
+
+	resp := struct {
+		Success bool `json:"success"`
+		Quizzes int  `json:"quizzes"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if !resp.Success || resp.Quizzes != 2 {
+		t.Fatalf("expected 2 quizzes to be restored but got %+v", resp)
+	}
+	if restored == nil || !restored.Replace {
+		t.Fatalf("expected quizzes to be restored in replace mode but got %+v", restored)
+	}
+	for i, quiz := range restored.Quizzes {
+		if quiz.Id != quizzes[i].Id || quiz.Name != quizzes[i].Name || quiz.Questions[0].Correct != quizzes[i].Questions[0].Correct {
+			t.Errorf("expected restored quiz %+v but got %+v", quizzes[i], quiz)
+		}
+	}
+
+	invalid := []string{
+		`{"version":99,"quizzes":[]}`,
+		`{"version":1,"quizzes":[{"id":0,"name":"no id"}]}`,
+		`{"version":1,"quizzes":[{"id":1,"name":"a"},{"id":1,"name":"b"}]}`,
+	}
+	for _, body := range invalid {
+		restored = nil
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/restore", strings.NewReader(body)))
+		if restored != nil || !strings.Contains(w.Body.String(), `"success":false`) {
+			t.Errorf("expected backup %s to be rejected but got %s", body, w.Body.String())
+		}
+	}
+}
+
+func TestBulkImportResults(t *testing.T) {
+	added := []string{}
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.AddQuizMessage); ok {
+				var err error
+				if m.Quiz.Name == "store failure" {
+					err = errors.New("store is down")
+				} else {
+					added = append(added, m.Quiz.Name)
+				}
+				go func() {
+					m.Result <- err
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	body := `[
+		{"name":"valid","questions":[{"question":"q","answers":["a","b"],"correct":0}]},
+		{"name":"single answer","questions":[{"question":"q","answers":["a"],"correct":0}]},
+		"not a quiz",
+		{"name":"store failure","questions":[]},
+		{"name":"also valid","questions":[]}
+	]`
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/quiz/bulk", strings.NewReader(body)))
+
+	var results []struct {
+		Index   int    `json:"index"`
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	dec := json.NewDecoder(w.Body)
+	if err := dec.Decode(&results); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if dec.More() {
+		t.Error("expected a single JSON value in the response")
+	}
+
+	expected := []bool{true, false, false, false, true}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results but got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.Index != i || result.Success != expected[i] || (result.Error == "") != expected[i] {
+			t.Errorf("expected result %d to have success %v but got %+v", i, expected[i], result)
+		}
+	}
+	if fmt.Sprint(added) != "[valid also valid]" {
+		t.Errorf("expected the valid quizzes to be added but got %v", added)
+	}
+}
+
+func TestSessionGamesEndpoint(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetSessionMessage); ok {
+				var session *common.Session
+				if m.Sessionid == "player" {
+					session = &common.Session{
+						Id:    "player",
+						Games: []common.GameParticipation{{Pin: 100, Name: "alice"}},
+					}
+				}
+				go func() {
+					m.Result <- session
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/session/player/games", nil))
+	var games []common.GameParticipation
+	if err := json.NewDecoder(w.Body).Decode(&games); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if len(games) != 1 || games[0].Pin != 100 || games[0].Name != "alice" {
+		t.Errorf("expected the session to have joined game 100 but got %+v", games)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/session/missing/games", nil))
+	if !strings.Contains(w.Body.String(), `"success":false`) {
+		t.Errorf("expected an error for a missing session but got %s", w.Body.String())
+	}
+}
+
+func TestQuestionResultsEndpoint(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			m, ok := msg.(*common.GetGameResultsMessage)
+			if !ok {
+				return
+			}
+			var result common.GetGameResultsResult
+			switch m.Pin {
+			case 100:
+				result.Results = common.QuestionResults{QuestionIndex: 1, Votes: []int{2, 1}, TotalVotes: 3}
+			case 200:
+				result.Error = common.NewUnexpectedStateError(common.GameNotStarted, "game 200 has not started")
+			default:
+				result.Error = common.NewNoSuchGameError(m.Pin)
+			}
+			go func() {
+				m.Result <- result
+				close(m.Result)
+			}()
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/game/100/results", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", w.Code)
+	}
+	var results common.QuestionResults
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if results.QuestionIndex != 1 || results.TotalVotes != 3 {
+		t.Errorf("expected the results of question 1 but got %+v", results)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/game/200/results", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a game that has not started but got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/game/300/results", nil))
+	if !strings.Contains(w.Body.String(), `"success":false`) {
+		t.Errorf("expected an error for a missing game but got %s", w.Body.String())
+	}
+}
+
+func TestArchiveEndpoint(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetGameMessage); ok {
+				go func() {
+					m.Result <- common.GetGameResult{
+						Game: common.Game{
+							Pin:       m.Pin,
+							GameState: common.GameEnded,
+							Quiz: common.Quiz{
+								Id:   1,
+								Name: "test quiz",
+								Questions: []common.QuizQuestion{
+									{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+								},
+							},
+							Players:     map[string]int{"player1": 100, "player2": 0},
+							PlayerNames: map[string]string{"player1": "alice", "player2": "bob"},
+							Teams:       map[string]string{"player1": "=HYPERLINK(\"http://example.com\")"},
+							AnswerLog: map[string][]common.AnswerRecord{
+								"player1": {{QuestionIndex: 0, Answer: 1, Correct: true, Score: 100}},
+							},
+						},
+					}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/archive/100", nil))
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Fatalf("expected a zip but got %s: %s", contentType, w.Body.String())
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, `filename="game-100.zip"`) {
+		t.Errorf("unexpected content disposition %s", disposition)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("error reading zip: %v", err)
+	}
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	for _, name := range []string{"quiz.json", "results.csv", "transcript.json"} {
+		if _, ok := entries[name]; !ok {
+			t.Fatalf("expected the archive to contain %s but got %v", name, entries)
+		}
+	}
+
+	rc, err := entries["results.csv"].Open()
+	if err != nil {
+		t.Fatalf("error opening results.csv: %v", err)
+	}
+	rows, err := csv.NewReader(rc).ReadAll()
+	rc.Close()
+	if err != nil {
+		t.Fatalf("error parsing results.csv: %v", err)
+	}
+	if len(rows) != 3 || rows[1][1] != "alice" || rows[1][3] != "100" || rows[2][1] != "bob" {
+		t.Errorf("unexpected results %v", rows)
+	}
+	// cells that a spreadsheet would evaluate as formulas are neutralised
+	if len(rows) > 1 && rows[1][2] != `'=HYPERLINK("http://example.com")` {
+		t.Errorf("expected the team to be neutralised but got %q", rows[1][2])
+	}
+
+	rc, err = entries["transcript.json"].Open()
+	if err != nil {
+		t.Fatalf("error opening transcript.json: %v", err)
+	}
+	var transcript []common.ReportCard
+	err = json.NewDecoder(rc).Decode(&transcript)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("error parsing transcript.json: %v", err)
+	}
+	if len(transcript) != 2 || transcript[0].Name != "alice" || !transcript[0].Questions[0].Correct {
+		t.Errorf("unexpected transcript %+v", transcript)
+	}
+}
+
+func TestExportQuizzes(t *testing.T) {
+	quizzes := []common.Quiz{
+		{
+			Id:        2,
+			Name:      "Capitals / Europe!",
+			Category:  "geography",
+			Questions: []common.QuizQuestion{{Question: "capital of France", Answers: []string{"Paris", "Rome"}, Correct: 0}},
+		},
+		{
+			Id:        1,
+			Name:      "test quiz",
+			Questions: []common.QuizQuestion{{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1}},
+		},
+	}
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetQuizzesMessage); ok {
+				go func() {
+					m.Result <- append([]common.Quiz{}, quizzes...)
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/quiz/export", nil))
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Fatalf("expected a zip but got %s: %s", contentType, w.Body.String())
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, `filename="quizzes.zip"`) {
+		t.Errorf("unexpected content disposition %s", disposition)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("error reading zip: %v", err)
+	}
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 2 quizzes and a manifest but got %v", entries)
+	}
+
+	f, ok := entries["manifest.json"]
+	if !ok {
+		t.Fatalf("expected the archive to contain manifest.json but got %v", entries)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatalf("error opening manifest.json: %v", err)
+	}
+	var manifest []exportedQuiz
+	err = json.NewDecoder(rc).Decode(&manifest)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("error parsing manifest.json: %v", err)
+	}
+	if len(manifest) != 2 || manifest[0].File != "1-test-quiz.json" || manifest[1].File != "2-capitals-europe.json" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	for _, original := range quizzes {
+		name := "1-test-quiz.json"
+		if original.Id == 2 {
+			name = "2-capitals-europe.json"
+		}
+		f, ok := entries[name]
+		if !ok {
+			t.Fatalf("expected the archive to contain %s but got %v", name, entries)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("error opening %s: %v", name, err)
+		}
+		exported, err := common.UnmarshalQuiz(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("error parsing %s: %v", name, err)
+		}
+		if exported.Id != original.Id || exported.Name != original.Name || exported.Category != original.Category ||
+			len(exported.Questions) != 1 || exported.Questions[0].Question != original.Questions[0].Question ||
+			exported.Questions[0].Correct != original.Questions[0].Correct {
+			t.Errorf("expected %+v but got %+v", original, exported)
+		}
+	}
+}
+
+func TestLeaderboardCSV(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetGameMessage); ok {
+				go func() {
+					m.Result <- common.GetGameResult{
+						Game: common.Game{
+							Pin:         m.Pin,
+							GameState:   common.GameEnded,
+							Players:     map[string]int{"player1": 100, "player2": 250, "player3": 100},
+							PlayerNames: map[string]string{"player1": "carol", "player2": "bob", "player3": "@alice"},
+							AnswerLog: map[string][]common.AnswerRecord{
+								"player1": {{QuestionIndex: 0, Correct: true, Score: 100}, {QuestionIndex: 1}},
+								"player2": {{QuestionIndex: 0, Correct: true, Score: 100}, {QuestionIndex: 1, Correct: true, Score: 150}},
+								"player3": {{QuestionIndex: 0}, {QuestionIndex: 1, Correct: true, Score: 100}},
+							},
+						},
+					}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/game/100/leaderboard.csv", nil))
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/csv" {
+		t.Fatalf("expected CSV but got %s: %s", contentType, w.Body.String())
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("error parsing CSV: %v", err)
+	}
+	expected := [][]string{
+		{"name", "score", "correct"},
+		{"bob", "250", "2"},
+		{"'@alice", "100", "1"},
+		{"carol", "100", "1"},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(expected) {
+		t.Errorf("expected %v but got %v", expected, rows)
+	}
+}
+
+func TestQuestionFlagsEndpoint(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetQuestionFlagsMessage); ok {
+				go func() {
+					m.Result <- []common.QuestionFlag{{Quizid: 1, Pin: 100, QuestionIndex: 2, Question: "question 2", Reason: "ambiguous"}}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/flags", nil))
+	var flags []common.QuestionFlag
+	if err := json.NewDecoder(w.Body).Decode(&flags); err != nil {
+		t.Fatalf("error parsing flags: %v", err)
+	}
+	if len(flags) != 1 || flags[0].Question != "question 2" || flags[0].Reason != "ambiguous" {
+		t.Errorf("unexpected flags %+v", flags)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/flags", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected status %d for unsupported method but got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+func TestAnswerHistoryEndpoint(t *testing.T) {
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetGameMessage); ok {
+				go func() {
+					m.Result <- common.GetGameResult{
+						Game: common.Game{
+							Pin:           m.Pin,
+							GameState:     common.ShowResults,
+							QuestionIndex: 1,
+							Quiz: common.Quiz{
+								Questions: []common.QuizQuestion{
+									{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+									{Question: "question 1", Answers: []string{"zero", "one"}, Correct: 0},
+								},
+							},
+							Players:       map[string]int{"player1": 100, "player2": 0},
+							PlayerNames:   map[string]string{"player1": "alice", "player2": "bob"},
+							AnswerHistory: map[string][]int{"player1": {1, 0}, "player2": {0}},
+						},
+					}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/game/100/history", nil))
+	var history common.GameHistory
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("error decoding history: %v", err)
+	}
+	expected := "{100 2 [{alice [1 0]} {bob [0 -1]}]}"
+	if fmt.Sprint(history) != expected {
+		t.Errorf("expected %s but got %v", expected, history)
+	}
+}
+
+func TestQuizEstimatedDuration(t *testing.T) {
+	quiz := common.Quiz{
+		Id:               1,
+		QuestionDuration: 20,
+		Questions: []common.QuizQuestion{
+			{Question: "question 0", Answers: []string{"zero", "one"}},
+			{Question: "question 1", Answers: []string{"zero", "one"}, Duration: 40},
+		},
+	}
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			switch m := msg.(type) {
+			case *common.GetQuizzesMessage:
+				go func() {
+					m.Result <- []common.Quiz{quiz}
+					close(m.Result)
+				}()
+			case *common.GetQuizMessage:
+				go func() {
+					m.Result <- common.GetQuizResult{Quiz: quiz}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/quiz", nil))
+	var list []struct {
+		Id                int `json:"id"`
+		EstimatedDuration int `json:"estimatedDuration"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("error decoding quizzes: %v", err)
+	}
+	if len(list) != 1 || list[0].Id != 1 || list[0].EstimatedDuration != 60 {
+		t.Errorf("expected quiz 1 to take 60 seconds but got %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/quiz/1", nil))
+	var single struct {
+		Id                int `json:"id"`
+		EstimatedDuration int `json:"estimatedDuration"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&single); err != nil {
+		t.Fatalf("error decoding quiz: %v", err)
+	}
+	if single.Id != 1 || single.EstimatedDuration != 60 {
+		t.Errorf("expected quiz 1 to take 60 seconds but got %+v", single)
+	}
+}
+
+func TestPublicGameView(t *testing.T) {
+	game := common.Game{
+		Pin:       100,
+		GameState: common.QuestionInProgress,
+		Players:   map[string]int{"player1": 0},
+		Votes:     []int{0, 1},
+		Quiz: common.Quiz{
+			Questions: []common.QuizQuestion{
+				{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+			},
+		},
+	}
+	hub := &fakeHub{
+		respond: func(msg interface{}) {
+			if m, ok := msg.(*common.GetPublicGameViewMessage); ok {
+				go func() {
+					m.Result <- common.GetPublicGameViewResult{View: game.PublicView()}
+					close(m.Result)
+				}()
+			}
+		},
+	}
+	api := InitRestApi(hub, 1<<20)
+
+	w := httptest.NewRecorder()
+	api.PublicGame(w, httptest.NewRequest(http.MethodGet, "/public/game/100", nil))
+	if strings.Contains(w.Body.String(), "correct") {
+		t.Errorf("expected the live question to withhold the answer but got %s", w.Body.String())
+	}
+	var view common.PublicGameView
+	if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+		t.Fatalf("error decoding view: %v", err)
+	}
+	if view.Question != "question 0" {
+		t.Errorf("expected the live question but got %+v", view)
+	}
+
+	game.GameState = common.ShowResults
+	w = httptest.NewRecorder()
+	api.PublicGame(w, httptest.NewRequest(http.MethodGet, "/public/game/100", nil))
+	view = common.PublicGameView{}
+	if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+		t.Fatalf("error decoding view: %v", err)
+	}
+	if fmt.Sprint(view.CorrectIndices) != "[1]" {
+		t.Errorf("expected the results to include the answer but got %+v", view)
+	}
+}
@@ -6,6 +6,10 @@ type ClientCommand struct {
 	client uint64
 	cmd    string
 	arg    string
+
+	// set when the command is resent after waiting for another client to
+	// release the session
+	retried bool
 }
 
 func NewClientCommand(client uint64, message []byte) *ClientCommand {
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version of the backup bundle format - bumped whenever the format changes
+// in a way that older servers cannot restore
+const BackupVersion = 1
+
+// All quizzes - and optionally sessions and games - in a single bundle for
+// disaster recovery
+type Backup struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Quizzes   []Quiz    `json:"quizzes"`
+	Sessions  []Session `json:"sessions,omitempty"`
+	Games     []Game    `json:"games,omitempty"`
+}
+
+func (b Backup) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(b); err != nil {
+		return nil, fmt.Errorf("error converting backup to JSON: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Ingests a backup bundle in JSON
+func UnmarshalBackup(r io.Reader) (Backup, error) {
+	dec := json.NewDecoder(r)
+	var backup Backup
+	if err := dec.Decode(&backup); err != nil {
+		return Backup{}, err
+	}
+	if backup.Version < 1 || backup.Version > BackupVersion {
+		return Backup{}, fmt.Errorf("unsupported backup version %d", backup.Version)
+	}
+	ids := make(map[int]struct{})
+	for _, quiz := range backup.Quizzes {
+		if quiz.Id <= 0 {
+			return Backup{}, fmt.Errorf("quiz \"%s\" has invalid id %d", quiz.Name, quiz.Id)
+		}
+		if _, ok := ids[quiz.Id]; ok {
+			return Backup{}, fmt.Errorf("quiz id %d appears more than once", quiz.Id)
+		}
+		ids[quiz.Id] = struct{}{}
+		if err := quiz.Validate(); err != nil {
+			return Backup{}, err
+		}
+	}
+	return backup, nil
+}
@@ -2,12 +2,17 @@ package common
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -31,7 +36,21 @@ const (
 	GameEnded          = iota
 )
 
-const winnerCount = 5
+// bonus points for each correct answer in a row before the current one
+const streakBonus = 50
+
+// number of wrong answers removed by the 50:50 lifeline
+const fiftyFiftyRemoved = 2
+
+// limits on the questions that players submit so that a single player
+// cannot flood the host or the stored game
+const (
+	maxPendingSubmissions = 3   // questions waiting for approval per player
+	maxSubmissionLength   = 280 // characters in the question and in each answer
+)
+
+// longer reasons for flagging a question are truncated
+const maxFlagReasonLength = 200
 
 type UnexpectedStateError struct {
 	CurrentState int
@@ -79,6 +98,73 @@ func NewNameExistsInGameError(name string, pin int) *NameExistsInGameError {
 	}
 }
 
+type AnswersNotOpenError struct {
+	Pin int
+}
+
+func (e *AnswersNotOpenError) Error() string {
+	return fmt.Sprintf("answers for the current question in game %d have not begun", e.Pin)
+}
+
+func NewAnswersNotOpenError(pin int) *AnswersNotOpenError {
+	return &AnswersNotOpenError{
+		Pin: pin,
+	}
+}
+
+// Returned when a player's answer is for a question that has already been
+// replaced by another question
+type StaleAnswerError struct {
+	Pin           int
+	QuestionIndex int // question the player answered
+}
+
+func (e *StaleAnswerError) Error() string {
+	return fmt.Sprintf("question %d in game %d has ended", e.QuestionIndex+1, e.Pin)
+}
+
+func NewStaleAnswerError(pin, questionIndex int) *StaleAnswerError {
+	return &StaleAnswerError{
+		Pin:           pin,
+		QuestionIndex: questionIndex,
+	}
+}
+
+// Returned in practice mode when a player answers incorrectly and may try
+// again
+type RetryAnswerError struct {
+	AttemptsLeft int // -1 if the number of attempts is unlimited
+}
+
+func (e *RetryAnswerError) Error() string {
+	if e.AttemptsLeft < 0 {
+		return "incorrect - try again"
+	}
+	return fmt.Sprintf("incorrect - try again (%d attempt(s) left)", e.AttemptsLeft)
+}
+
+func NewRetryAnswerError(attemptsLeft int) *RetryAnswerError {
+	return &RetryAnswerError{
+		AttemptsLeft: attemptsLeft,
+	}
+}
+
+// Returned in practice mode when a player answers after using up all their
+// attempts
+type AttemptsExhaustedError struct {
+	MaxAttempts int
+}
+
+func (e *AttemptsExhaustedError) Error() string {
+	return fmt.Sprintf("you have used all %d attempts at this question", e.MaxAttempts)
+}
+
+func NewAttemptsExhaustedError(maxAttempts int) *AttemptsExhaustedError {
+	return &AttemptsExhaustedError{
+		MaxAttempts: maxAttempts,
+	}
+}
+
 // Queried by the host - either when the host first displays the question or
 // when the host reconnects
 type GameCurrentQuestion struct {
@@ -91,6 +177,41 @@ type GameCurrentQuestion struct {
 	Votes          []int    `json:"votes"`
 	TotalVotes     int      `json:"totalvotes"`
 	TotalQuestions int      `json:"totalquestions"`
+	Preload        bool     `json:"preload"`     // true if answers have not begun
+	AnswersIn      int      `json:"answersin"`   // seconds until answers begin if the question is being preloaded
+	Type           string   `json:"type"`        // question type - blank for multiple choice questions
+	Paused         bool     `json:"paused"`      // the countdown is stopped until the host resumes the game
+	AnswerGrace    int      `json:"answergrace"` // milliseconds after the countdown ends that answers are still accepted
+}
+
+// Sent to players alongside the answer choices so that they can show their
+// progress through the quiz and a countdown synced to the host
+type PlayerQuestionContext struct {
+	QuestionIndex  int  `json:"questionindex"`
+	TotalQuestions int  `json:"totalquestions"`
+	TimeLeft       int  `json:"timeleft"`  // seconds
+	Paused         bool `json:"paused"`    // the countdown is stopped until the host resumes the game
+	Preload        bool `json:"preload"`   // the countdown only starts once answers begin
+	AnswersIn      int  `json:"answersin"` // seconds until answers begin if the question is being preloaded
+}
+
+// Sent to a player after they answer so that they can see the choice that
+// was recorded - indices are in the order the answers were displayed to the
+// player
+type AnswerAcknowledgement struct {
+	Choice  int   `json:"choice"`            // -1 for ordering and multi-select questions
+	Choices []int `json:"choices,omitempty"` // the player's ordering or selection
+	Final   bool  `json:"final"`             // false if the player may answer again
+}
+
+// The live question without votes or timing - for screen readers and
+// printing
+type PrintableQuestion struct {
+	Pin            int      `json:"pin"`
+	QuestionIndex  int      `json:"questionIndex"`
+	TotalQuestions int      `json:"totalQuestions"`
+	Question       string   `json:"question"`
+	Answers        []string `json:"answers"`
 }
 
 // To be sent to the host when a player answers a question
@@ -100,6 +221,7 @@ type AnswersUpdate struct {
 	TotalPlayers int   `json:"totalplayers"`
 	Votes        []int `json:"votes"`
 	TotalVotes   int   `json:"totalvotes"`
+	Correct      *int  `json:"correct,omitempty"` // number of players that answered correctly - only set if the quiz shows a live correct count
 }
 
 type QuestionResults struct {
@@ -107,11 +229,43 @@ type QuestionResults struct {
 	Question       string        `json:"question"`
 	Answers        []string      `json:"answers"`
 	Correct        int           `json:"correct"`
+	CorrectAnswers []int         `json:"correctanswers,omitempty"` // correct answers if this is a multi-select question
+	CorrectIndices []int         `json:"correctindices"`           // every correct answer - empty for ordering and informational questions
+	AnswerCorrect  []bool        `json:"answercorrect"`            // true for each answer that is correct - used to color the answers
 	Votes          []int         `json:"votes"`
 	TotalVotes     int           `json:"totalvotes"`
 	TotalQuestions int           `json:"totalquestions"`
 	TotalPlayers   int           `json:"totalplayers"`
 	TopScorers     []PlayerScore `json:"topscorers"`
+	Revealed       int           `json:"revealed"`         // number of answers with vote counts in Votes
+	Winner         string        `json:"winner,omitempty"` // name of the first player to answer a buzzer question correctly
+	MostPopular    int           `json:"mostpopular"`      // index of the answer with the most votes - ties go to the lowest index, -1 if there are no votes or not all votes are revealed
+}
+
+// Compact summary of a game for dashboards that poll
+type GameStatus struct {
+	Pin            int `json:"pin"`
+	State          int `json:"state"`
+	QuestionIndex  int `json:"questionIndex"`
+	TotalQuestions int `json:"totalQuestions"`
+	Players        int `json:"players"`
+	Answered       int `json:"answered"` // number of players that have answered the current question
+}
+
+// Game state that is safe to show on a public display such as a projector -
+// the correct answers and votes are only included once the results are shown
+type PublicGameView struct {
+	Pin            int           `json:"pin"`
+	State          int           `json:"state"`
+	QuestionIndex  int           `json:"questionIndex"`
+	TotalQuestions int           `json:"totalQuestions"`
+	Players        int           `json:"players"`
+	Answered       int           `json:"answered"`
+	Question       string        `json:"question,omitempty"`
+	Answers        []string      `json:"answers,omitempty"`
+	Votes          []int         `json:"votes,omitempty"`
+	CorrectIndices []int         `json:"correctindices,omitempty"`
+	TopScorers     []PlayerScore `json:"topscorers,omitempty"`
 }
 
 type PlayerScore struct {
@@ -127,22 +281,109 @@ func (p PlayerScoreList) Less(i, j int) bool { return p[i].Score < p[j].Score }
 func (p PlayerScoreList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 type Game struct {
-	Pin              int                 `json:"pin"`
-	Host             string              `json:"host"`    // session ID of game host
-	Players          map[string]int      `json:"players"` // scores of players
-	PlayerNames      map[string]string   `json:"playernames"`
-	Quiz             Quiz                `json:"quiz"`
-	QuestionIndex    int                 `json:"questionindex"`    // current question
-	QuestionDeadline time.Time           `json:"questiondeadline"` // answers must come in at this time or before
-	PlayersAnswered  map[string]struct{} `json:"playersanswered"`
-	CorrectPlayers   map[string]struct{} `json:"correctplayers"` // players that answered current question correctly
-	Votes            []int               `json:"votes"`          // number of players that answered each choice
-	GameState        int                 `json:"gamestate"`
+	Pin                int                         `json:"pin"`
+	Host               string                      `json:"host"`    // session ID of game host
+	Players            map[string]int              `json:"players"` // scores of players
+	PlayerNames        map[string]string           `json:"playernames"`
+	Quiz               Quiz                        `json:"quiz"`
+	QuestionIndex      int                         `json:"questionindex"`    // current question
+	QuestionDeadline   time.Time                   `json:"questiondeadline"` // answers must come in at this time or before
+	PlayersAnswered    map[string]struct{}         `json:"playersanswered"`
+	CorrectPlayers     map[string]struct{}         `json:"correctplayers"` // players that answered current question correctly
+	Votes              []int                       `json:"votes"`          // number of players that answered each choice
+	GameState          int                         `json:"gamestate"`
+	Preloading         bool                        `json:"preloading"`               // waiting for answers to begin so that clients can preload media
+	AnswersStart       time.Time                   `json:"answersstart"`             // answers begin at this time if the quiz has a preload delay
+	Flags              map[int]map[string]string   `json:"flags"`                    // question index to session ID to reason for players that flagged a question
+	AnswerLog          map[string][]AnswerRecord   `json:"answerlog"`                // answers submitted by each player
+	RevealedBars       int                         `json:"revealedbars"`             // number of vote bars revealed in the results if the quiz reveals them one at a time
+	QuestionDuration   int                         `json:"questionduration"`         // overrides the quiz's question duration for this game if set
+	Disconnected       map[string]struct{}         `json:"disconnected"`             // players whose clients are disconnected
+	Fingerprints       map[string]string           `json:"fingerprints"`             // device fingerprint to session ID of the player that joined from that device
+	AutoStarting       bool                        `json:"autostarting"`             // the auto-start countdown has begun
+	HostDisconnected   bool                        `json:"hostdisconnected"`         // the host's client is disconnected
+	HostDisconnectedAt time.Time                   `json:"hostdisconnectedat"`       // when the host's client disconnected
+	PendingQuiz        *Quiz                       `json:"pendingquiz,omitempty"`    // updated quiz to switch to at the next question
+	CaseSensitiveNames bool                        `json:"casesensitivenames"`       // "Bob" and "bob" are different players
+	Attempts           map[string]int              `json:"attempts"`                 // number of attempts each player made at the current question in practice mode
+	Label              string                      `json:"label"`                    // groups games for event organizers - e.g. "Room A"
+	Submissions        []SubmittedQuestion         `json:"submissions"`              // questions submitted by players that are waiting for the host's approval
+	SubmissionCount    int                         `json:"submissioncount"`          // number of questions submitted so far - used to number submissions
+	Teams              map[string]string           `json:"teams"`                    // session ID to the name of the player's team for players that joined a team
+	Paused             bool                        `json:"paused"`                   // the host paused the current question - the countdown is stopped
+	PausedAt           time.Time                   `json:"pausedat"`                 // when the host paused the current question
+	QuestionWinner     string                      `json:"questionwinner"`           // session ID of the first player to answer the current buzzer question correctly
+	Streaks            map[string]int              `json:"streaks"`                  // number of questions in a row each player has answered correctly
+	AnswerGrace        int                         `json:"answergrace"`              // milliseconds after the deadline that answers are still accepted
+	AnswerLogCap       int                         `json:"answerlogcap"`             // number of answers kept in each player's answer log - 0 keeps every answer
+	TruncatedAnswers   map[string]TruncatedAnswers `json:"truncatedanswers"`         // totals of the answers dropped from each player's answer log
+	ShuffleOverride    *ShuffleOptions             `json:"shuffleoverride"`          // the host's shuffle settings for this game - nil uses the quiz's settings
+	AnswerHistory      map[string][]int            `json:"answerhistory"`            // answer each player chose for each question asked - -1 if the player did not answer or the question takes more than one answer
+	FiftyFiftyUsed     map[string]int              `json:"fiftyfiftyused"`           // index of the question each player used their 50:50 lifeline on
+	ApprovedQuestions  []QuizQuestion              `json:"approvedquestions"`        // questions submitted by players that the host approved - asked after the quiz's questions
+	UnshuffledQuiz     *Quiz                       `json:"unshuffledquiz,omitempty"` // the game's quiz before it was shuffled - reshuffled when the host changes the shuffle settings in the lobby
 }
 
+// Shuffle settings chosen by the host in the lobby
+type ShuffleOptions struct {
+	Questions bool `json:"questions"`
+	Answers   bool `json:"answers"`
+}
+
+// Overrides the shuffle settings of quiz - a nil receiver leaves quiz alone
+func (o *ShuffleOptions) Apply(quiz *Quiz) {
+	if o == nil {
+		return
+	}
+	quiz.ShuffleQuestions = o.Questions
+	quiz.ShuffleAnswers = o.Answers
+}
+
+// Totals of the answers dropped from a player's answer log once it reached
+// the cap - kept so that the results stay accurate
+type TruncatedAnswers struct {
+	Count         int `json:"count"`
+	Correct       int `json:"correct"`
+	WeightedScore int `json:"weightedscore"` // score weighted by the difficulty of each question
+}
+
+// A question submitted by a player in the lobby
+type SubmittedQuestion struct {
+	Id        int          `json:"id"`
+	Sessionid string       `json:"sessionid"` // player that submitted the question
+	Name      string       `json:"name"`      // name of the player that submitted the question
+	Question  QuizQuestion `json:"question"`
+}
+
+// A single answer submitted by a player
+type AnswerRecord struct {
+	QuestionIndex int   `json:"questionindex"`
+	Answer        int   `json:"answer"`
+	Correct       bool  `json:"correct"`
+	Score         int   `json:"score"`               // points earned for this answer
+	PriorStreak   int   `json:"priorstreak"`         // the player's streak before this answer - restored if the player changes the answer
+	ResponseTime  int   `json:"responsetime"`        // milliseconds between the question starting and the answer
+	Order         []int `json:"order,omitempty"`     // the player's ordering of the items if this is an ordering question
+	Selection     []int `json:"selection,omitempty"` // the answers the player selected if this is a multi-select question
+}
+
+// Marks games that were gzipped before they were persisted - games without
+// the marker are plain JSON
+var compressedGameMarker = []byte("gzip:")
+
+// Ingests a game in JSON - the JSON may be compressed
 func UnmarshalGame(b []byte) (*Game, error) {
+	var r io.Reader = bytes.NewReader(b)
+	if bytes.HasPrefix(b, compressedGameMarker) {
+		zr, err := gzip.NewReader(bytes.NewReader(b[len(compressedGameMarker):]))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing game: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
 	var game Game
-	dec := json.NewDecoder(bytes.NewReader(b))
+	dec := json.NewDecoder(r)
 	if err := dec.Decode(&game); err != nil {
 		return nil, err
 	}
@@ -158,19 +399,99 @@ func (g *Game) Marshal() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// Like Marshal except that the JSON is gzipped
+func (g *Game) MarshalCompressed() ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(compressedGameMarker)
+	zw := gzip.NewWriter(&b)
+	if err := json.NewEncoder(zw).Encode(g); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
 func (g *Game) Copy() Game {
 	target := Game{
-		Pin:              g.Pin,
-		Host:             g.Host,
-		Players:          make(map[string]int),
-		PlayerNames:      make(map[string]string),
-		Quiz:             g.Quiz,
-		QuestionIndex:    g.QuestionIndex,
-		QuestionDeadline: g.QuestionDeadline,
-		PlayersAnswered:  make(map[string]struct{}),
-		CorrectPlayers:   make(map[string]struct{}),
-		Votes:            []int{},
-		GameState:        g.GameState,
+		Pin:                g.Pin,
+		Host:               g.Host,
+		Players:            make(map[string]int),
+		PlayerNames:        make(map[string]string),
+		Quiz:               g.Quiz,
+		QuestionIndex:      g.QuestionIndex,
+		QuestionDeadline:   g.QuestionDeadline,
+		PlayersAnswered:    make(map[string]struct{}),
+		CorrectPlayers:     make(map[string]struct{}),
+		Votes:              make([]int, len(g.Votes)),
+		GameState:          g.GameState,
+		Preloading:         g.Preloading,
+		AnswersStart:       g.AnswersStart,
+		Flags:              make(map[int]map[string]string),
+		AnswerLog:          make(map[string][]AnswerRecord),
+		RevealedBars:       g.RevealedBars,
+		QuestionDuration:   g.QuestionDuration,
+		Disconnected:       make(map[string]struct{}),
+		Fingerprints:       make(map[string]string),
+		Attempts:           make(map[string]int),
+		AutoStarting:       g.AutoStarting,
+		HostDisconnected:   g.HostDisconnected,
+		HostDisconnectedAt: g.HostDisconnectedAt,
+		CaseSensitiveNames: g.CaseSensitiveNames,
+		AnswerGrace:        g.AnswerGrace,
+		AnswerLogCap:       g.AnswerLogCap,
+		Label:              g.Label,
+		Submissions:        make([]SubmittedQuestion, len(g.Submissions)),
+		SubmissionCount:    g.SubmissionCount,
+		Paused:             g.Paused,
+		PausedAt:           g.PausedAt,
+		QuestionWinner:     g.QuestionWinner,
+	}
+	copy(target.Submissions, g.Submissions)
+	if g.ApprovedQuestions != nil {
+		target.ApprovedQuestions = append([]QuizQuestion{}, g.ApprovedQuestions...)
+	}
+	if g.TruncatedAnswers != nil {
+		target.TruncatedAnswers = make(map[string]TruncatedAnswers)
+		for k, v := range g.TruncatedAnswers {
+			target.TruncatedAnswers[k] = v
+		}
+	}
+	if g.Streaks != nil {
+		target.Streaks = make(map[string]int)
+		for k, v := range g.Streaks {
+			target.Streaks[k] = v
+		}
+	}
+
+	if g.FiftyFiftyUsed != nil {
+		target.FiftyFiftyUsed = make(map[string]int)
+		for k, v := range g.FiftyFiftyUsed {
+			target.FiftyFiftyUsed[k] = v
+		}
+	}
+
+	if g.Teams != nil {
+		target.Teams = make(map[string]string)
+		for k, v := range g.Teams {
+			target.Teams[k] = v
+		}
+	}
+
+	if g.PendingQuiz != nil {
+		pending := *g.PendingQuiz
+		target.PendingQuiz = &pending
+	}
+
+	if g.ShuffleOverride != nil {
+		override := *g.ShuffleOverride
+		target.ShuffleOverride = &override
+	}
+
+	if g.UnshuffledQuiz != nil {
+		unshuffled := *g.UnshuffledQuiz
+		target.UnshuffledQuiz = &unshuffled
 	}
 
 	for k, v := range g.Players {
@@ -191,9 +512,85 @@ func (g *Game) Copy() Game {
 
 	copy(target.Votes, g.Votes)
 
+	for k, v := range g.Flags {
+		flags := make(map[string]string)
+		for sessionid, reason := range v {
+			flags[sessionid] = reason
+		}
+		target.Flags[k] = flags
+	}
+
+	for k, v := range g.AnswerLog {
+		target.AnswerLog[k] = append([]AnswerRecord{}, v...)
+	}
+
+	if g.AnswerHistory != nil {
+		target.AnswerHistory = make(map[string][]int)
+		for k, v := range g.AnswerHistory {
+			target.AnswerHistory[k] = append([]int{}, v...)
+		}
+	}
+
+	for k := range g.Disconnected {
+		target.Disconnected[k] = struct{}{}
+	}
+
+	for k, v := range g.Fingerprints {
+		target.Fingerprints[k] = v
+	}
+
+	for k, v := range g.Attempts {
+		target.Attempts[k] = v
+	}
+
 	return target
 }
 
+func (g *Game) Status() GameStatus {
+	return GameStatus{
+		Pin:            g.Pin,
+		State:          g.GameState,
+		QuestionIndex:  g.QuestionIndex,
+		TotalQuestions: g.Quiz.NumQuestions(),
+		Players:        len(g.Players),
+		Answered:       len(g.PlayersAnswered),
+	}
+}
+
+// Returns a view of the game that does not give away the answer to the
+// current question while it is live
+func (g *Game) PublicView() PublicGameView {
+	view := PublicGameView{
+		Pin:            g.Pin,
+		State:          g.GameState,
+		QuestionIndex:  g.QuestionIndex,
+		TotalQuestions: g.Quiz.NumQuestions(),
+		Players:        len(g.Players),
+		Answered:       len(g.PlayersAnswered),
+	}
+
+	switch g.GameState {
+	case QuestionInProgress:
+		if question, err := g.Quiz.GetQuestion(g.QuestionIndex); err == nil {
+			view.Question = question.Question
+			view.Answers = question.Answers
+		}
+
+	case ShowResults:
+		if results, err := g.GetQuestionResults(); err == nil {
+			view.Question = results.Question
+			view.Answers = results.Answers
+			view.Votes = results.Votes
+			view.CorrectIndices = results.CorrectIndices
+			view.TopScorers = results.TopScorers
+		}
+
+	case GameEnded:
+		view.TopScorers = g.GetWinners()
+	}
+	return view
+}
+
 func (g *Game) setupQuestion(newIndex int) error {
 	g.QuestionIndex = newIndex
 	question, err := g.Quiz.GetQuestion(newIndex)
@@ -201,11 +598,73 @@ func (g *Game) setupQuestion(newIndex int) error {
 		return err
 	}
 
+	// players that did not answer the last question correctly lose their
+	// streak - wrong answers end the streak when they are registered but
+	// players that did not answer at all are only caught here. Nobody can
+	// answer an informational question correctly so it leaves the streaks
+	// alone.
+	if previous, err := g.Quiz.GetQuestion(newIndex - 1); err != nil || !previous.IsInformational() {
+		for sessionid := range g.Streaks {
+			if _, ok := g.CorrectPlayers[sessionid]; !ok {
+				delete(g.Streaks, sessionid)
+			}
+		}
+	}
+
+	// every player starts the question without an answer
+	for sessionid := range g.Players {
+		g.padAnswerHistory(sessionid, newIndex+1)
+	}
+
 	g.GameState = QuestionInProgress
 	g.PlayersAnswered = make(map[string]struct{})
 	g.CorrectPlayers = make(map[string]struct{})
 	g.Votes = make([]int, question.NumAnswers())
-	g.QuestionDeadline = time.Now().Add(time.Second * time.Duration(g.Quiz.QuestionDuration))
+	g.RevealedBars = 0
+	g.Attempts = make(map[string]int)
+	g.Paused = false
+	g.QuestionWinner = ""
+
+	// if the question needs to be preloaded, the timer only starts when the
+	// host begins answers or when the preload delay has elapsed - quizzes
+	// without a preload delay do not preload
+	duration := time.Second * time.Duration(g.EffectiveQuestionDuration())
+	g.Preloading = question.Preload && g.Quiz.PreloadDelay > 0
+	g.AnswersStart = time.Time{}
+	if g.Preloading {
+		g.AnswersStart = time.Now().Add(time.Second * time.Duration(g.Quiz.PreloadDelay))
+		g.QuestionDeadline = g.AnswersStart.Add(duration)
+		return nil
+	}
+	g.QuestionDeadline = time.Now().Add(duration)
+	return nil
+}
+
+// Returns true if the current question is still waiting for answers to
+// begin - answers begin automatically once the preload delay has elapsed.
+func (g *Game) preloading(now time.Time) bool {
+	if !g.Preloading {
+		return false
+	}
+	if !g.AnswersStart.IsZero() && !now.Before(g.AnswersStart) {
+		g.Preloading = false
+		return false
+	}
+	return true
+}
+
+// Starts the answer timer for a question that is being preloaded.
+func (g *Game) BeginAnswers() error {
+	if g.GameState != QuestionInProgress {
+		return NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing a live question", g.Pin))
+	}
+	if !g.preloading(time.Now()) {
+		// answers have already begun
+		return nil
+	}
+	g.Preloading = false
+	g.AnswersStart = time.Time{}
+	g.QuestionDeadline = time.Now().Add(time.Second * time.Duration(g.EffectiveQuestionDuration()))
 	return nil
 }
 
@@ -237,6 +696,44 @@ func (g *Game) GetPlayerNames() []string {
 	return names
 }
 
+// Returns up to n player names with first listed first if it is a player -
+// the rest are the first names in sorted order so that the sample stays the
+// same when the list is rebuilt. Used instead of GetPlayerNames when there
+// are too many players to list.
+func (g *Game) SamplePlayerNames(n int, first string) []string {
+	names := []string{}
+	if n <= 0 {
+		return names
+	}
+	others := []string{}
+	for sessionid, name := range g.PlayerNames {
+		if sessionid != first {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	if name, ok := g.PlayerNames[first]; ok {
+		names = append(names, name)
+	}
+	for _, name := range others {
+		if len(names) >= n {
+			break
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Returns the player names in a random order - used by the host to pick
+// players fairly
+func (g *Game) ShuffledPlayerNames() []string {
+	names := g.GetPlayerNames()
+	rand.Shuffle(len(names), func(i, j int) {
+		names[i], names[j] = names[j], names[i]
+	})
+	return names
+}
+
 // Returns true if the player was added - false if the player is already in
 // the game
 func (g *Game) AddPlayer(sessionid, name string) bool {
@@ -251,23 +748,438 @@ func (g *Game) AddPlayer(sessionid, name string) bool {
 	return true
 }
 
+// Returns the session ID of the player identified by target - target can
+// either be the player's session ID or name
+func (g *Game) FindPlayer(target string) (string, bool) {
+	if _, ok := g.Players[target]; ok {
+		return target, true
+	}
+	target = strings.TrimSpace(target)
+	for k, v := range g.PlayerNames {
+		if v == target || (!g.CaseSensitiveNames && strings.EqualFold(v, target)) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// Moves the player with the given name to a new session if the game has not
+// started - used when a player rejoins the lobby after losing their session.
+// Only players whose clients are disconnected can be taken over so that a
+// connected player cannot have their slot stolen by someone using the same
+// name. Returns the player's previous session ID and true if the player was
+// moved.
+func (g *Game) ReplacePlayer(name, sessionid string) (string, bool) {
+	if g.GameState != GameNotStarted {
+		return "", false
+	}
+	previous := ""
+	for k, v := range g.PlayerNames {
+		if k == sessionid {
+			continue
+		}
+		if v == name || (!g.CaseSensitiveNames && strings.EqualFold(v, name)) {
+			previous = k
+			break
+		}
+	}
+	if previous == "" {
+		return "", false
+	}
+	if _, ok := g.Disconnected[previous]; !ok {
+		return "", false
+	}
+
+	g.Players[sessionid] = g.Players[previous]
+	g.PlayerNames[sessionid] = g.PlayerNames[previous]
+	delete(g.Players, previous)
+	delete(g.PlayerNames, previous)
+	delete(g.Disconnected, previous)
+	if team, ok := g.Teams[previous]; ok {
+		g.Teams[sessionid] = team
+		delete(g.Teams, previous)
+	}
+	for fingerprint, player := range g.Fingerprints {
+		if player == previous {
+			delete(g.Fingerprints, fingerprint)
+		}
+	}
+	for i, submission := range g.Submissions {
+		if submission.Sessionid == previous {
+			g.Submissions[i].Sessionid = sessionid
+		}
+	}
+	return previous, true
+}
+
+// Puts the player on a team - team names that differ only in case are
+// treated as the same team
+func (g *Game) SetTeam(sessionid, team string) {
+	if team == "" {
+		return
+	}
+	if g.Teams == nil {
+		g.Teams = make(map[string]string)
+	}
+	for _, existing := range g.Teams {
+		if strings.EqualFold(existing, team) {
+			team = existing
+			break
+		}
+	}
+	g.Teams[sessionid] = team
+}
+
+// Returns true if enough players have joined for the game to auto-start -
+// only returns true once per game so that the countdown is only begun once
+func (g *Game) ClaimAutoStart() bool {
+	if g.GameState != GameNotStarted || g.AutoStarting || g.Quiz.AutoStartPlayers <= 0 || len(g.Players) < g.Quiz.AutoStartPlayers {
+		return false
+	}
+	g.AutoStarting = true
+	return true
+}
+
+// Returns true if another player in the game joined from the device with
+// the given fingerprint
+func (g *Game) DeviceJoined(sessionid, fingerprint string) bool {
+	existing, ok := g.Fingerprints[fingerprint]
+	if !ok || existing == sessionid {
+		return false
+	}
+	_, stillPlaying := g.Players[existing]
+	return stillPlaying
+}
+
+func (g *Game) RecordFingerprint(sessionid, fingerprint string) {
+	if fingerprint == "" {
+		return
+	}
+	if g.Fingerprints == nil {
+		g.Fingerprints = make(map[string]string)
+	}
+	g.Fingerprints[fingerprint] = sessionid
+}
+
 // name should be trimmed of leading and trailing spaces
+// Names are compared case-insensitively unless the game has case-sensitive
+// names
 func (g *Game) NameExistsInGame(name string) bool {
-	lowerName := strings.ToLower(name)
 	for _, v := range g.PlayerNames {
-		if lowerName == strings.ToLower(v) {
+		if g.CaseSensitiveNames {
+			if name == v {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(name, v) {
 			return true
 		}
 	}
 	return false
 }
 
+// Returns name with a numeric suffix appended if the name already exists in
+// the game - e.g. "Alex (2)". name should be trimmed of leading and trailing
+// spaces.
+func (g *Game) UniqueName(name string) string {
+	if !g.NameExistsInGame(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		if !g.NameExistsInGame(candidate) {
+			return candidate
+		}
+	}
+}
+
+// Keeps an unshuffled copy of the quiz and shuffles the game's quiz with the
+// game's shuffle settings - the questions the host approved are kept
 func (g *Game) SetQuiz(quiz Quiz) {
+	unshuffled := quiz
+	unshuffled.Questions = append([]QuizQuestion{}, quiz.Questions...)
+	unshuffled.Questions = append(unshuffled.Questions, g.ApprovedQuestions...)
+	g.UnshuffledQuiz = &unshuffled
+	g.ShuffleQuiz()
+}
+
+// Shuffles a fresh copy of the unshuffled quiz - called again when the host
+// changes the shuffle settings so that the stored quiz does not have to be
+// looked up
+func (g *Game) ShuffleQuiz() {
+	if g.UnshuffledQuiz == nil {
+		return
+	}
+	quiz := *g.UnshuffledQuiz
+	// the questions are shuffled in place below so they must not be shared
+	// with the unshuffled quiz
+	quiz.Questions = append([]QuizQuestion{}, quiz.Questions...)
+	g.ShuffleOverride.Apply(&quiz)
+
+	if quiz.ShuffleQuestions {
+		quiz.Shuffle()
+	}
+	for i, question := range quiz.Questions {
+		if quiz.ShufflesAnswers(question) {
+			quiz.Questions[i] = question.ShuffleAnswers()
+		}
+	}
 	g.Quiz = quiz
 }
 
+// Switches to the pending quiz update - the questions that have already been
+// asked are kept and the rest are taken from the update by position. The
+// questions approved by the host are not part of the update so the ones that
+// have not been asked are kept at the end.
+func (g *Game) applyPendingQuiz() {
+	if g.PendingQuiz == nil {
+		return
+	}
+	updated := *g.PendingQuiz
+	g.PendingQuiz = nil
+
+	asked := g.QuestionIndex + 1
+	if asked > g.Quiz.NumQuestions() {
+		asked = g.Quiz.NumQuestions()
+	}
+	// approved questions are appended after the quiz's own questions
+	approved := g.Quiz.NumQuestions() - len(g.ApprovedQuestions)
+	if approved < 0 {
+		approved = 0
+	}
+	next := asked
+	if next > approved {
+		next = approved
+	}
+	questions := append([]QuizQuestion{}, g.Quiz.Questions[:asked]...)
+	for i := next; i < updated.NumQuestions(); i++ {
+		question := updated.Questions[i]
+		if updated.ShufflesAnswers(question) {
+			question = question.ShuffleAnswers()
+		}
+		questions = append(questions, question)
+	}
+	for i := asked; i < g.Quiz.NumQuestions(); i++ {
+		if i >= approved {
+			questions = append(questions, g.Quiz.Questions[i])
+		}
+	}
+	updated.Questions = questions
+	g.Quiz = updated
+}
+
+// Returns the number of seconds players have to answer the current question -
+// the host may override the durations in the quiz when creating the game,
+// otherwise the question's own duration is used if it has one
+func (g *Game) EffectiveQuestionDuration() int {
+	if g.QuestionDuration > 0 {
+		return g.QuestionDuration
+	}
+	return g.Quiz.QuestionDurationAt(g.QuestionIndex)
+}
+
+// Returns the order in which the player sees the answers to the current
+// question - element i is the index of the answer shown at position i. The
+// order is seeded by the session ID so that it is stable across reconnects.
+// Returns nil if the quiz does not shuffle answers per player or if the
+// question is a true/false question.
+func (g *Game) PlayerAnswerOrder(sessionid string) []int {
+	if !g.Quiz.ShufflePerPlayer {
+		return nil
+	}
+	question, err := g.Quiz.GetQuestion(g.QuestionIndex)
+	if err != nil || question.IsTrueFalse() {
+		return nil
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%d", sessionid, g.QuestionIndex)
+	return rand.New(rand.NewSource(int64(h.Sum64()))).Perm(question.NumAnswers())
+}
+
+// Returns the answers to the current question in the order that the player
+// sees them
+func (g *Game) PlayerAnswers(sessionid string) ([]string, error) {
+	question, err := g.Quiz.GetQuestion(g.QuestionIndex)
+	if err != nil {
+		return nil, err
+	}
+	order := g.PlayerAnswerOrder(sessionid)
+	if order == nil {
+		return question.Answers, nil
+	}
+	answers := make([]string, len(order))
+	for i, index := range order {
+		answers[i] = question.Answers[index]
+	}
+	return answers, nil
+}
+
+// Returns true if the player can still use the 50:50 lifeline on the current
+// question - only single-answer questions with at least two wrong answers
+// qualify
+func (g *Game) FiftyFiftyAvailable(sessionid string) bool {
+	if !g.Quiz.FiftyFifty {
+		return false
+	}
+	if _, used := g.FiftyFiftyUsed[sessionid]; used {
+		return false
+	}
+	question, err := g.Quiz.GetQuestion(g.QuestionIndex)
+	if err != nil || question.IsOrdering() || question.IsMultiSelect() || question.IsInformational() {
+		return false
+	}
+	return question.NumAnswers()-1 >= fiftyFiftyRemoved
+}
+
+// Spends the player's 50:50 lifeline on the current question - returns the
+// positions of the removed answers as the player sees them. Answers are
+// still registered against the full set of answers.
+func (g *Game) UseFiftyFifty(sessionid string) ([]int, error) {
+	if _, ok := g.Players[sessionid]; !ok {
+		return nil, fmt.Errorf("player %s is not part of game %d", sessionid, g.Pin)
+	}
+	if g.GameState != QuestionInProgress {
+		return nil, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game %d is not showing a live question", g.Pin))
+	}
+	if !g.Quiz.FiftyFifty {
+		return nil, errors.New("lifelines are not enabled for this quiz")
+	}
+	if _, used := g.FiftyFiftyUsed[sessionid]; used {
+		return nil, errors.New("you have already used your 50:50 lifeline")
+	}
+	if !g.FiftyFiftyAvailable(sessionid) {
+		return nil, errors.New("the 50:50 lifeline cannot be used on this question")
+	}
+	if g.AnswerIsFinal(sessionid) {
+		return nil, errors.New("you have already answered this question")
+	}
+	if g.FiftyFiftyUsed == nil {
+		g.FiftyFiftyUsed = make(map[string]int)
+	}
+	g.FiftyFiftyUsed[sessionid] = g.QuestionIndex
+	return g.RemovedAnswers(sessionid), nil
+}
+
+// Returns the positions, as the player sees them, of the answers removed by
+// the player's 50:50 lifeline - nil if the lifeline was not used on the
+// current question. The answers are picked with a seed so that they stay
+// the same across reconnects.
+func (g *Game) RemovedAnswers(sessionid string) []int {
+	if index, used := g.FiftyFiftyUsed[sessionid]; !used || index != g.QuestionIndex {
+		return nil
+	}
+	question, err := g.Quiz.GetQuestion(g.QuestionIndex)
+	if err != nil {
+		return nil
+	}
+	wrong := []int{}
+	for i := 0; i < question.NumAnswers(); i++ {
+		if !question.IsCorrectAnswer(i) {
+			wrong = append(wrong, i)
+		}
+	}
+	if len(wrong) < fiftyFiftyRemoved {
+		return nil
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "fiftyfifty:%s:%d", sessionid, g.QuestionIndex)
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	r.Shuffle(len(wrong), func(i, j int) { wrong[i], wrong[j] = wrong[j], wrong[i] })
+	removed := wrong[:fiftyFiftyRemoved]
+
+	// convert the answers to the positions that the player sees them in
+	if order := g.PlayerAnswerOrder(sessionid); order != nil {
+		for i, index := range removed {
+			for position, shown := range order {
+				if shown == index {
+					removed[i] = position
+					break
+				}
+			}
+		}
+	}
+	sort.Ints(removed)
+	return removed
+}
+
+// Returns true if the host's client has been disconnected for at least the
+// grace period
+func (g *Game) HostAbandoned(grace time.Duration, now time.Time) bool {
+	return g.HostDisconnected && !g.HostDisconnectedAt.IsZero() && now.Sub(g.HostDisconnectedAt) >= grace
+}
+
+// Records whether the player's or host's client is connected - returns true
+// if the state was changed
+func (g *Game) SetConnected(sessionid string, connected bool) bool {
+	if sessionid == g.Host {
+		if g.HostDisconnected == !connected {
+			return false
+		}
+		g.HostDisconnected = !connected
+		if connected {
+			g.HostDisconnectedAt = time.Time{}
+		} else {
+			g.HostDisconnectedAt = time.Now()
+		}
+		return true
+	}
+	if _, ok := g.Players[sessionid]; !ok {
+		return false
+	}
+	_, disconnected := g.Disconnected[sessionid]
+	if connected {
+		if !disconnected {
+			return false
+		}
+		delete(g.Disconnected, sessionid)
+		return true
+	}
+	if disconnected {
+		return false
+	}
+	if g.Disconnected == nil {
+		g.Disconnected = make(map[string]struct{})
+	}
+	g.Disconnected[sessionid] = struct{}{}
+	return true
+}
+
+// Returns true if the player used up all their attempts at the current
+// question in practice mode without answering correctly
+func (g *Game) AttemptsExhausted(sessionid string) bool {
+	if !g.Quiz.PracticeMode || g.Quiz.MaxAttempts <= 0 || g.Attempts[sessionid] < g.Quiz.MaxAttempts {
+		return false
+	}
+	_, correct := g.CorrectPlayers[sessionid]
+	return !correct
+}
+
+// Returns true if the player has already answered the live question
+func (g *Game) HasAnswered(sessionid string) bool {
+	if g.GameState != QuestionInProgress {
+		return false
+	}
+	_, ok := g.PlayersAnswered[sessionid]
+	return ok
+}
+
+// Returns true if players may replace their answer to the live question
+func (g *Game) AnswerChangeAllowed() bool {
+	return g.Quiz.AllowAnswerChange && !g.Quiz.PracticeMode
+}
+
+// Returns true if the player has answered the live question and cannot
+// change the answer
+func (g *Game) AnswerIsFinal(sessionid string) bool {
+	return g.HasAnswered(sessionid) && !g.AnswerChangeAllowed()
+}
+
 func (g *Game) DeletePlayer(sessionid string) {
 	delete(g.Players, sessionid)
+	delete(g.PlayerNames, sessionid)
+	delete(g.Disconnected, sessionid)
+	delete(g.Teams, sessionid)
 	delete(g.PlayersAnswered, sessionid)
 	delete(g.CorrectPlayers, sessionid)
 }
@@ -284,6 +1196,8 @@ func (g *Game) NextState() (int, error) {
 			g.GameState = GameEnded
 			return g.GameState, fmt.Errorf("error trying to start game: %v", err)
 		}
+		// the shuffle settings are locked once the game starts
+		g.UnshuffledQuiz = nil
 		return g.GameState, nil
 
 	case QuestionInProgress:
@@ -291,6 +1205,7 @@ func (g *Game) NextState() (int, error) {
 		return g.GameState, nil
 
 	case ShowResults:
+		g.applyPendingQuiz()
 		if g.QuestionIndex < g.Quiz.NumQuestions() {
 			g.QuestionIndex++
 		}
@@ -316,18 +1231,92 @@ func (g *Game) ShowResults() error {
 		return NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not in the expected state", g.Pin))
 	}
 	g.GameState = ShowResults
+	g.Paused = false
+	return nil
+}
+
+// Ends the current question early - players that have already answered
+// keep their points but no further answers are accepted
+func (g *Game) SkipQuestion() error {
+	if g.GameState != QuestionInProgress {
+		return NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing a live question", g.Pin))
+	}
+	g.GameState = ShowResults
+	g.Paused = false
 	return nil
 }
 
+// Stops the countdown for the current question until the game is resumed
+func (g *Game) Pause() error {
+	return g.pauseAt(time.Now())
+}
+
+func (g *Game) pauseAt(now time.Time) error {
+	if g.GameState != QuestionInProgress {
+		return NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing a live question", g.Pin))
+	}
+	if g.Paused {
+		return nil
+	}
+	if !g.preloading(now) && !now.Before(g.QuestionDeadline) {
+		return fmt.Errorf("question %d in game %d has expired", g.QuestionIndex, g.Pin)
+	}
+	g.Paused = true
+	g.PausedAt = now
+	return nil
+}
+
+// Restarts the countdown - the deadline is pushed back by the time the game
+// was paused
+func (g *Game) Resume() error {
+	return g.resumeAt(time.Now())
+}
+
+func (g *Game) resumeAt(now time.Time) error {
+	if g.GameState != QuestionInProgress {
+		return NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing a live question", g.Pin))
+	}
+	if !g.Paused {
+		return nil
+	}
+	paused := now.Sub(g.PausedAt)
+	if paused < 0 {
+		paused = 0
+	}
+	g.QuestionDeadline = g.QuestionDeadline.Add(paused)
+	if !g.AnswersStart.IsZero() {
+		g.AnswersStart = g.AnswersStart.Add(paused)
+	}
+	g.Paused = false
+	g.PausedAt = time.Time{}
+	return nil
+}
+
+// Time stands still while the game is paused
+func (g *Game) clock(now time.Time) time.Time {
+	if g.Paused {
+		return g.PausedAt
+	}
+	return now
+}
+
 // Returns true if state was changed
 func (g *Game) GetCurrentQuestion() (bool, GameCurrentQuestion, error) {
 	if g.GameState != QuestionInProgress {
 		return false, GameCurrentQuestion{}, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing a live question", g.Pin))
 	}
 
-	now := time.Now()
-	timeLeft := int(g.QuestionDeadline.Unix() - now.Unix())
-	if timeLeft <= 0 || len(g.PlayersAnswered) >= len(g.Players) {
+	now := g.clock(time.Now())
+	preloading := g.preloading(now)
+	timeLeft := g.secondsLeft(now)
+	answersIn := 0
+	if preloading {
+		timeLeft = g.EffectiveQuestionDuration()
+		answersIn = int((g.AnswersStart.Sub(now) + time.Second - 1) / time.Second)
+	} else if timeLeft < 0 {
+		timeLeft = 0
+	}
+	if !preloading && !g.Paused && (g.answersClosed(now) || len(g.PlayersAnswered) >= len(g.Players)) {
 		g.GameState = ShowResults
 		return true, GameCurrentQuestion{}, NewUnexpectedStateError(ShowResults, fmt.Sprintf("game with pin %d should be showing results", g.Pin))
 	}
@@ -347,11 +1336,140 @@ func (g *Game) GetCurrentQuestion() (bool, GameCurrentQuestion, error) {
 		Votes:          g.Votes,
 		TotalVotes:     g.totalVotes(),
 		TotalQuestions: g.Quiz.NumQuestions(),
+		Preload:        preloading,
+		AnswersIn:      answersIn,
+		Type:           question.Type,
+		Paused:         g.Paused,
+		AnswerGrace:    g.AnswerGrace,
+	}, nil
+}
+
+// Unlike GetCurrentQuestion, this does not end a question whose time is up -
+// printing the question must not change the game
+func (g *Game) GetPrintableQuestion() (PrintableQuestion, error) {
+	if g.GameState != QuestionInProgress {
+		return PrintableQuestion{}, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing a live question", g.Pin))
+	}
+	question, err := g.Quiz.GetQuestion(g.QuestionIndex)
+	if err != nil {
+		return PrintableQuestion{}, err
+	}
+	return PrintableQuestion{
+		Pin:            g.Pin,
+		QuestionIndex:  g.QuestionIndex,
+		TotalQuestions: g.Quiz.NumQuestions(),
+		Question:       question.Question,
+		Answers:        question.Answers,
 	}, nil
 }
 
+// Answers are accepted until AnswerGrace after the deadline
+func (g *Game) answersClosed(now time.Time) bool {
+	return now.After(g.QuestionDeadline.Add(time.Duration(g.AnswerGrace) * time.Millisecond))
+}
+
+// Always derived from the deadline so that clients that reconnect
+// mid-question pick up the countdown where it is - rounded up so that the
+// question ends when the countdown reaches 0
+func (g *Game) secondsLeft(now time.Time) int {
+	return int((g.QuestionDeadline.Sub(now) + time.Second - 1) / time.Second)
+}
+
+func (g *Game) GetPlayerQuestionContext() PlayerQuestionContext {
+	now := g.clock(time.Now())
+	timeLeft := g.secondsLeft(now)
+	preloading := g.preloading(now)
+	answersIn := 0
+	if preloading {
+		timeLeft = g.EffectiveQuestionDuration()
+		answersIn = int((g.AnswersStart.Sub(now) + time.Second - 1) / time.Second)
+	} else if timeLeft < 0 {
+		timeLeft = 0
+	}
+	return PlayerQuestionContext{
+		QuestionIndex:  g.QuestionIndex,
+		TotalQuestions: g.Quiz.NumQuestions(),
+		TimeLeft:       timeLeft,
+		Paused:         g.Paused,
+		Preload:        preloading,
+		AnswersIn:      answersIn,
+	}
+}
+
+// Returns the answer recorded for the player for the current question -
+// false if the player has not answered
+func (g *Game) RecordedAnswer(sessionid string) (AnswerAcknowledgement, bool) {
+	for _, record := range g.AnswerLog[sessionid] {
+		if record.QuestionIndex != g.QuestionIndex {
+			continue
+		}
+		order := g.PlayerAnswerOrder(sessionid)
+		displayed := func(canonical int) int {
+			for i, index := range order {
+				if index == canonical {
+					return i
+				}
+			}
+			return canonical
+		}
+		ack := AnswerAcknowledgement{Choice: -1, Final: !g.AnswerChangeAllowed()}
+		if record.Answer >= 0 {
+			ack.Choice = displayed(record.Answer)
+		}
+		choices := record.Order
+		if choices == nil {
+			choices = record.Selection
+		}
+		for _, index := range choices {
+			ack.Choices = append(ack.Choices, displayed(index))
+		}
+		return ack, true
+	}
+	return AnswerAcknowledgement{}, false
+}
+
+// Returns an error if the player is answering a question other than the
+// current question - answers are matched to the current question when they
+// are registered so answers that were in flight when the game moved on must
+// be rejected
+func (g *Game) EnsureCurrentQuestion(questionIndex int) error {
+	if questionIndex != g.QuestionIndex {
+		return NewStaleAnswerError(g.Pin, questionIndex)
+	}
+	return nil
+}
+
 // Returns true if changed
 func (g *Game) RegisterAnswer(sessionid string, answerIndex int) (bool, AnswersUpdate, error) {
+	return g.registerResponse(sessionid, []int{answerIndex})
+}
+
+// Registers a player's ordering of the items in an ordering question - order
+// lists the indices of the items as they were displayed to the player, from
+// first to last
+func (g *Game) RegisterOrdering(sessionid string, order []int) (bool, AnswersUpdate, error) {
+	return g.registerResponse(sessionid, order)
+}
+
+// Registers the answers a player selected for a multi-select question -
+// selection lists the indices of the answers as they were displayed to the
+// player
+func (g *Game) RegisterSelection(sessionid string, selection []int) (bool, AnswersUpdate, error) {
+	return g.registerResponse(sessionid, selection)
+}
+
+// response holds the chosen answer for choice questions, the selected
+// answers for multi-select questions and the player's ordering of the items
+// for ordering questions
+func (g *Game) registerResponse(sessionid string, response []int) (bool, AnswersUpdate, error) {
+	return g.registerResponseAt(sessionid, response, time.Now())
+}
+
+// Answers that arrive up to AnswerGrace after the deadline - e.g. because
+// the player clicked just before the countdown ran out - are still counted
+// but earn the minimum score. Answers that arrive after that end the
+// question.
+func (g *Game) registerResponseAt(sessionid string, response []int, now time.Time) (bool, AnswersUpdate, error) {
 	if _, ok := g.Players[sessionid]; !ok {
 		return false, AnswersUpdate{}, fmt.Errorf("player %s is not part of game %d", sessionid, g.Pin)
 	}
@@ -359,8 +1477,10 @@ func (g *Game) RegisterAnswer(sessionid string, answerIndex int) (bool, AnswersU
 		return false, AnswersUpdate{}, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game %d is not showing a live question", g.Pin))
 	}
 
-	now := time.Now()
-	if now.After(g.QuestionDeadline) {
+	if g.Paused || g.preloading(now) {
+		return false, AnswersUpdate{}, NewAnswersNotOpenError(g.Pin)
+	}
+	if g.answersClosed(now) {
 		g.GameState = ShowResults
 		return true, AnswersUpdate{}, NewUnexpectedStateError(ShowResults, fmt.Sprintf("question %d in game %d has expired", g.QuestionIndex, g.Pin))
 	}
@@ -370,35 +1490,395 @@ func (g *Game) RegisterAnswer(sessionid string, answerIndex int) (bool, AnswersU
 		return false, AnswersUpdate{}, err
 	}
 
-	if answerIndex < 0 || answerIndex >= question.NumAnswers() {
+	if question.IsOrdering() {
+		if !isPermutation(response, question.NumAnswers()) {
+			return false, AnswersUpdate{}, errors.New("invalid ordering")
+		}
+	} else if question.IsMultiSelect() {
+		if !isSelection(response, question.NumAnswers()) {
+			return false, AnswersUpdate{}, errors.New("invalid selection")
+		}
+	} else if len(response) != 1 || response[0] < 0 || response[0] >= question.NumAnswers() {
 		return false, AnswersUpdate{}, errors.New("invalid answer")
 	}
+	canonical := make([]int, len(response))
+	copy(canonical, response)
+	if order := g.PlayerAnswerOrder(sessionid); order != nil {
+		// map the player's choices back to the canonical answers
+		for i, index := range canonical {
+			canonical[i] = order[index]
+		}
+	}
+
+	_, answered := g.PlayersAnswered[sessionid]
+	if answered && g.AnswerChangeAllowed() {
+		// take back the previous answer so that it is replaced
+		g.withdrawAnswer(sessionid, question)
+		answered = false
+	}
+	if answered && g.AttemptsExhausted(sessionid) {
+		return false, AnswersUpdate{}, NewAttemptsExhaustedError(g.Quiz.MaxAttempts)
+	}
+	if !answered && g.Quiz.PracticeMode && !question.IsOrdering() && !question.IsInformational() {
+		if g.Attempts == nil {
+			g.Attempts = make(map[string]int)
+		}
+		g.Attempts[sessionid]++
+		if !question.IsCorrectChoice(canonical) && (g.Quiz.MaxAttempts <= 0 || g.Attempts[sessionid] < g.Quiz.MaxAttempts) {
+			// the player may try again
+			attemptsLeft := -1
+			if g.Quiz.MaxAttempts > 0 {
+				attemptsLeft = g.Quiz.MaxAttempts - g.Attempts[sessionid]
+			}
+			return true, AnswersUpdate{}, NewRetryAnswerError(attemptsLeft)
+		}
+	}
 
-	if _, ok := g.PlayersAnswered[sessionid]; !ok {
+	if !answered {
 		// player hasn't answered yet
 		g.PlayersAnswered[sessionid] = struct{}{}
 
-		if answerIndex == question.Correct {
-			// calculate score, add to player score
-			g.Players[sessionid] += calculateScore(int(g.QuestionDeadline.Unix()-now.Unix()), g.Quiz.QuestionDuration)
+		record := AnswerRecord{
+			QuestionIndex: g.QuestionIndex,
+			Answer:        canonical[0],
+			ResponseTime:  g.EffectiveQuestionDuration()*1000 - int(g.QuestionDeadline.Sub(now)/time.Millisecond),
+		}
+		score := scoringFor(g.Quiz.ScoringMode)(g.QuestionDeadline.Sub(now), g.EffectiveQuestionDuration())
+
+		if question.IsOrdering() {
+			// each correctly placed item earns its share of the score - the
+			// vote for each item counts the players that placed it correctly
+			record.Answer = -1
+			record.Order = canonical
+			placed := 0
+			for position, index := range canonical {
+				if question.OriginalIndex(index) == position {
+					placed++
+					g.Votes[index]++
+				}
+			}
+			record.Correct = placed == question.NumAnswers()
+			record.Score = score * placed / question.NumAnswers()
+		} else if question.IsMultiSelect() {
+			// each correct pick earns its share of the score and each wrong
+			// pick takes a share away - the score never goes below 0
+			record.Answer = -1
+			record.Selection = canonical
+			picked := 0
+			for _, index := range canonical {
+				if question.IsCorrectAnswer(index) {
+					picked++
+				}
+				g.Votes[index]++
+			}
+			wrong := len(canonical) - picked
+			record.Correct = question.IsCorrectChoice(canonical)
+			if picked > wrong {
+				record.Score = score * (picked - wrong) / len(question.CorrectAnswers)
+			}
+		} else {
+			// informational questions are not scored
+			if canonical[0] == question.Correct && !question.IsInformational() {
+				record.Correct = true
+				if !question.IsBuzzer() || g.QuestionWinner == "" {
+					record.Score = score
+				}
+				if question.IsBuzzer() && g.QuestionWinner == "" {
+					g.QuestionWinner = sessionid
+				}
+			}
+			g.Votes[canonical[0]]++
+		}
+		record.PriorStreak = g.Streaks[sessionid]
+		if record.Correct && !question.IsInformational() {
+			record.Score += g.extendStreak(sessionid)
+		} else if !question.IsInformational() {
+			delete(g.Streaks, sessionid)
+			if record.Score == 0 {
+				// answers that earned partial credit are not penalized
+				record.Score = -g.penalty(sessionid)
+			}
+		}
+		g.Players[sessionid] += record.Score
+		if record.Correct {
 			g.CorrectPlayers[sessionid] = struct{}{}
 		}
-		g.Votes[answerIndex]++
+		g.logAnswer(sessionid, record)
+		g.padAnswerHistory(sessionid, g.QuestionIndex+1)
+		g.AnswerHistory[sessionid][g.QuestionIndex] = record.Answer
 	}
 
+	return true, g.UpdateAnswers(), nil
+}
+
+// Ends the live question if every player has answered - called when an
+// answer is registered and when a player leaves the game. Returns the
+// progress of the question for the host.
+func (g *Game) UpdateAnswers() AnswersUpdate {
 	answeredCount := len(g.PlayersAnswered)
 	totalPlayers := len(g.Players)
-	allAnswered := answeredCount >= totalPlayers
+	// buzzer questions end as soon as someone answers correctly
+	allAnswered := answeredCount >= totalPlayers || g.QuestionWinner != ""
 	if allAnswered {
 		g.GameState = ShowResults
 	}
-	return true, AnswersUpdate{
+	update := AnswersUpdate{
 		AllAnswered:  allAnswered,
 		Answered:     answeredCount,
 		TotalPlayers: totalPlayers,
 		Votes:        g.Votes,
 		TotalVotes:   g.totalVotes(),
-	}, nil
+	}
+	if g.Quiz.LiveCorrectCount {
+		correct := len(g.CorrectPlayers)
+		update.Correct = &correct
+	}
+	return update
+}
+
+// Reverses everything the player's answer to the live question did - the
+// score, the votes, the streak and the answer log
+func (g *Game) withdrawAnswer(sessionid string, question QuizQuestion) {
+	delete(g.PlayersAnswered, sessionid)
+	delete(g.CorrectPlayers, sessionid)
+	records := g.AnswerLog[sessionid]
+	if len(records) == 0 || records[len(records)-1].QuestionIndex != g.QuestionIndex {
+		return
+	}
+	record := records[len(records)-1]
+	g.AnswerLog[sessionid] = records[:len(records)-1]
+
+	g.Players[sessionid] -= record.Score
+	if question.IsOrdering() {
+		for position, index := range record.Order {
+			if question.OriginalIndex(index) == position {
+				g.Votes[index]--
+			}
+		}
+	} else if question.IsMultiSelect() {
+		for _, index := range record.Selection {
+			g.Votes[index]--
+		}
+	} else {
+		g.Votes[record.Answer]--
+	}
+
+	if record.PriorStreak > 0 {
+		if g.Streaks == nil {
+			g.Streaks = make(map[string]int)
+		}
+		g.Streaks[sessionid] = record.PriorStreak
+	} else {
+		delete(g.Streaks, sessionid)
+	}
+}
+
+// Returns the points to take away from the player for a wrong answer - the
+// penalty stops at 0 unless the quiz allows negative scores
+func (g *Game) penalty(sessionid string) int {
+	penalty := g.Quiz.Penalty()
+	if !g.Quiz.AllowNegative && penalty > g.Players[sessionid] {
+		penalty = g.Players[sessionid]
+	}
+	if penalty < 0 {
+		return 0
+	}
+	return penalty
+}
+
+// Adds a correct answer to the player's streak - returns the bonus for the
+// correct answers in a row before this one
+func (g *Game) extendStreak(sessionid string) int {
+	if g.Streaks == nil {
+		g.Streaks = make(map[string]int)
+	}
+	bonus := g.Streaks[sessionid] * streakBonus
+	g.Streaks[sessionid]++
+	return bonus
+}
+
+// Returns the number of questions in a row the player has answered
+// correctly up to the current question - 0 if the player did not answer the
+// current question correctly
+func (g *Game) CurrentStreak(sessionid string) int {
+	if _, ok := g.CorrectPlayers[sessionid]; !ok {
+		return 0
+	}
+	return g.Streaks[sessionid]
+}
+
+func (g *Game) logAnswer(sessionid string, record AnswerRecord) {
+	if g.AnswerLog == nil {
+		g.AnswerLog = make(map[string][]AnswerRecord)
+	}
+	records := append(g.AnswerLog[sessionid], record)
+	if g.AnswerLogCap > 0 && len(records) > g.AnswerLogCap {
+		// keep the most recent answers and fold the rest into the totals
+		if g.TruncatedAnswers == nil {
+			g.TruncatedAnswers = make(map[string]TruncatedAnswers)
+		}
+		truncated := g.TruncatedAnswers[sessionid]
+		excess := len(records) - g.AnswerLogCap
+		for _, earlier := range records[:excess] {
+			truncated.Count++
+			if earlier.Correct {
+				truncated.Correct++
+			}
+			if question, err := g.Quiz.GetQuestion(earlier.QuestionIndex); err == nil {
+				truncated.WeightedScore += earlier.Score * question.Weight()
+			}
+		}
+		g.TruncatedAnswers[sessionid] = truncated
+		records = append([]AnswerRecord{}, records[excess:]...)
+	}
+	g.AnswerLog[sessionid] = records
+}
+
+// Extends the player's answer history with -1 until it covers length
+// questions
+func (g *Game) padAnswerHistory(sessionid string, length int) {
+	if g.AnswerHistory == nil {
+		g.AnswerHistory = make(map[string][]int)
+	}
+	history := g.AnswerHistory[sessionid]
+	for len(history) < length {
+		history = append(history, -1)
+	}
+	g.AnswerHistory[sessionid] = history
+}
+
+// Returns the explanation for the wrong answer the player chose for the
+// current question - blank if the player answered correctly, did not answer
+// or the question has no explanation for that choice
+func (g *Game) PlayerChoiceExplanation(sessionid string) string {
+	if _, correct := g.CorrectPlayers[sessionid]; correct {
+		return ""
+	}
+	question, err := g.Quiz.GetQuestion(g.QuestionIndex)
+	if err != nil {
+		return ""
+	}
+	for _, record := range g.AnswerLog[sessionid] {
+		if record.QuestionIndex == g.QuestionIndex {
+			return question.ChoiceExplanation(record.Answer)
+		}
+	}
+	return ""
+}
+
+// Records a player's report that the current question is problematic.
+// Players may only flag each question once. Returns the number of flags
+// for the current question.
+func (g *Game) FlagQuestion(sessionid, reason string) (int, error) {
+	if _, ok := g.Players[sessionid]; !ok {
+		return 0, fmt.Errorf("player %s is not part of game %d", sessionid, g.Pin)
+	}
+	if g.GameState != QuestionInProgress && g.GameState != ShowResults {
+		return 0, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game %d is not showing a question", g.Pin))
+	}
+
+	if g.Flags == nil {
+		g.Flags = make(map[int]map[string]string)
+	}
+	flags, ok := g.Flags[g.QuestionIndex]
+	if !ok {
+		flags = make(map[string]string)
+		g.Flags[g.QuestionIndex] = flags
+	}
+	if _, ok := flags[sessionid]; ok {
+		return len(flags), errors.New("you have already flagged this question")
+	}
+	if runes := []rune(reason); len(runes) > maxFlagReasonLength {
+		reason = string(runes[:maxFlagReasonLength])
+	}
+	flags[sessionid] = reason
+	return len(flags), nil
+}
+
+// Adds a question submitted by a player to the submissions waiting for the
+// host's approval
+func (g *Game) SubmitQuestion(sessionid string, question QuizQuestion) (SubmittedQuestion, error) {
+	if _, ok := g.Players[sessionid]; !ok {
+		return SubmittedQuestion{}, fmt.Errorf("player %s is not part of game %d", sessionid, g.Pin)
+	}
+	if !g.Quiz.AcceptSubmissions {
+		return SubmittedQuestion{}, fmt.Errorf("game %d does not accept questions from players", g.Pin)
+	}
+	if g.GameState != GameNotStarted {
+		return SubmittedQuestion{}, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game %d has already started", g.Pin))
+	}
+
+	// only keep the fields that players are allowed to set
+	question = QuizQuestion{
+		Question: question.Question,
+		Answers:  question.Answers,
+		Correct:  question.Correct,
+		Type:     question.Type,
+	}
+	if strings.TrimSpace(question.Question) == "" {
+		return SubmittedQuestion{}, errors.New("the question is blank")
+	}
+	for _, text := range append([]string{question.Question}, question.Answers...) {
+		if utf8.RuneCountInString(text) > maxSubmissionLength {
+			return SubmittedQuestion{}, fmt.Errorf("questions and answers cannot be longer than %d characters", maxSubmissionLength)
+		}
+	}
+	if err := question.Validate(); err != nil {
+		return SubmittedQuestion{}, err
+	}
+	pending := 0
+	for _, submission := range g.Submissions {
+		if submission.Sessionid == sessionid {
+			pending++
+		}
+	}
+	if pending >= maxPendingSubmissions {
+		return SubmittedQuestion{}, fmt.Errorf("you already have %d questions waiting for approval", pending)
+	}
+
+	g.SubmissionCount++
+	submission := SubmittedQuestion{
+		Id:        g.SubmissionCount,
+		Sessionid: sessionid,
+		Name:      g.PlayerNames[sessionid],
+		Question:  question,
+	}
+	g.Submissions = append(g.Submissions, submission)
+	return submission, nil
+}
+
+// Removes the submission from the submissions waiting for approval - the
+// question is added to the end of the quiz if it is approved
+func (g *Game) ModerateSubmission(id int, approve bool) (SubmittedQuestion, error) {
+	if g.GameState != GameNotStarted {
+		return SubmittedQuestion{}, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game %d has already started", g.Pin))
+	}
+
+	for i, submission := range g.Submissions {
+		if submission.Id != id {
+			continue
+		}
+		g.Submissions = append(g.Submissions[:i], g.Submissions[i+1:]...)
+		if approve {
+			g.ApprovedQuestions = append(g.ApprovedQuestions, submission.Question)
+			question := submission.Question
+			if g.Quiz.ShufflesAnswers(question) {
+				question = question.ShuffleAnswers()
+			}
+			// the questions may be shared with the stored quiz
+			questions := make([]QuizQuestion, len(g.Quiz.Questions), len(g.Quiz.Questions)+1)
+			copy(questions, g.Quiz.Questions)
+			g.Quiz.Questions = append(questions, question)
+			if g.UnshuffledQuiz != nil {
+				// keep the question if the quiz is reshuffled
+				unshuffled := make([]QuizQuestion, len(g.UnshuffledQuiz.Questions), len(g.UnshuffledQuiz.Questions)+1)
+				copy(unshuffled, g.UnshuffledQuiz.Questions)
+				g.UnshuffledQuiz.Questions = append(unshuffled, submission.Question)
+			}
+		}
+		return submission, nil
+	}
+	return SubmittedQuestion{}, fmt.Errorf("there is no submitted question %d in game %d", id, g.Pin)
 }
 
 func (g *Game) GetQuestionResults() (QuestionResults, error) {
@@ -411,33 +1891,140 @@ func (g *Game) GetQuestionResults() (QuestionResults, error) {
 		Question:       question.Question,
 		Answers:        question.Answers,
 		Correct:        question.Correct,
-		Votes:          g.Votes,
+		CorrectAnswers: question.CorrectAnswers,
+		Votes:          append([]int{}, g.Votes...),
 		TotalVotes:     g.totalVotes(),
 		TotalQuestions: g.Quiz.NumQuestions(),
 		TotalPlayers:   len(g.Players),
 		TopScorers:     g.GetWinners(),
+		MostPopular:    mostPopular(g.Votes),
+		Revealed:       len(g.Votes),
+		Winner:         g.PlayerNames[g.QuestionWinner],
+		CorrectIndices: []int{},
+		AnswerCorrect:  make([]bool, question.NumAnswers()),
+	}
+	if !question.IsOrdering() && !question.IsInformational() {
+		for i := range results.AnswerCorrect {
+			if question.IsCorrectAnswer(i) {
+				results.CorrectIndices = append(results.CorrectIndices, i)
+				results.AnswerCorrect[i] = true
+			}
+		}
+	}
+
+	// only expose the vote counts for the bars that the host has revealed
+	if g.Quiz.RevealOneAtATime && g.RevealedBars < len(g.Votes) {
+		results.Revealed = g.RevealedBars
+		results.Votes = append([]int{}, g.Votes[:g.RevealedBars]...)
+		results.MostPopular = -1
 	}
 
 	return results, nil
 }
 
+// Votes are indexed by answer so ties always go to the answer that comes
+// first - returns -1 if there are no votes
+func mostPopular(votes []int) int {
+	popular := -1
+	for i, count := range votes {
+		if count > 0 && (popular < 0 || count > votes[popular]) {
+			popular = i
+		}
+	}
+	return popular
+}
+
+// Reveals the vote bar for the next answer in the results - returns true
+// if all bars have been revealed
+func (g *Game) RevealNextBar() (bool, error) {
+	if g.GameState != ShowResults {
+		return false, NewUnexpectedStateError(g.GameState, fmt.Sprintf("game with pin %d is not showing results", g.Pin))
+	}
+	if !g.Quiz.RevealOneAtATime {
+		return true, nil
+	}
+	if g.RevealedBars < len(g.Votes) {
+		g.RevealedBars++
+	}
+	return g.RevealedBars >= len(g.Votes), nil
+}
+
 func (g *Game) GetWinners() []PlayerScore {
+	return g.topScorers(g.Players)
+}
+
+// Like GetWinners except that the points for each correct answer are
+// multiplied by the question's difficulty so that hard questions count more
+func (g *Game) GetDifficultyWeightedWinners() []PlayerScore {
+	scores := make(map[string]int)
+	for sessionid := range g.Players {
+		total := g.TruncatedAnswers[sessionid].WeightedScore
+		for _, record := range g.AnswerLog[sessionid] {
+			question, err := g.Quiz.GetQuestion(record.QuestionIndex)
+			if err != nil {
+				continue
+			}
+			total += record.Score * question.Weight()
+		}
+		scores[sessionid] = total
+	}
+	return g.topScorers(scores)
+}
+
+// Sums the scores of the players on each team - players that did not join a
+// team are left out
+func (g *Game) TeamScores() map[string]int {
+	scores := make(map[string]int)
+	for sessionid, team := range g.Teams {
+		score, ok := g.Players[sessionid]
+		if !ok {
+			continue
+		}
+		scores[team] += score
+	}
+	return scores
+}
+
+// Like GetWinners except that the scores of the players on each team are
+// added up - teams with the same score are listed by name
+func (g *Game) GetTeamWinners() []PlayerScore {
+	teams := []PlayerScore{}
+	for team, score := range g.TeamScores() {
+		teams = append(teams, PlayerScore{
+			Name:  team,
+			Score: score,
+		})
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Score != teams[j].Score {
+			return teams[i].Score > teams[j].Score
+		}
+		return teams[i].Name < teams[j].Name
+	})
+	if max := g.Quiz.WinnerCount(); len(teams) > max {
+		teams = teams[:max]
+	}
+	return teams
+}
+
+func (g *Game) topScorers(scores map[string]int) []PlayerScore {
 	// copied from https://stackoverflow.com/a/18695740
-	pl := make(PlayerScoreList, len(g.Players))
-	i := 0
-	for k, v := range g.Players {
-		pl[i] = PlayerScore{
+	pl := make(PlayerScoreList, 0, len(scores))
+	for k, v := range scores {
+		if _, disconnected := g.Disconnected[k]; disconnected && g.Quiz.ExcludeDisconnected {
+			continue
+		}
+		pl = append(pl, PlayerScore{
 			id:    k,
 			Name:  g.PlayerNames[k],
 			Score: v,
-		}
-		i++
+		})
 	}
 	sort.Sort(sort.Reverse(pl))
 
 	max := len(pl)
-	if max > winnerCount {
-		max = winnerCount
+	if max > g.Quiz.WinnerCount() {
+		max = g.Quiz.WinnerCount()
 	}
 	return pl[:max]
 }
@@ -446,9 +2033,34 @@ func (g *Game) GetGameState() int {
 	return g.GameState
 }
 
-func calculateScore(timeLeft, questionDuration int) int {
+// Returns true if order contains each of 0 to n-1 exactly once
+func isPermutation(order []int, n int) bool {
+	if len(order) != n {
+		return false
+	}
+	seen := make([]bool, n)
+	for _, index := range order {
+		if index < 0 || index >= n || seen[index] {
+			return false
+		}
+		seen[index] = true
+	}
+	return true
+}
+
+// Scores range from 100 for answers at the deadline to 200 for instant
+// answers - questionDuration is in seconds, and questions without a duration
+// only earn the minimum score
+func calculateScore(timeLeft time.Duration, questionDuration int) int {
+	duration := time.Duration(questionDuration) * time.Second
+	if duration <= 0 {
+		return 100
+	}
 	if timeLeft < 0 {
 		timeLeft = 0
 	}
-	return 100 + (timeLeft * 100 / questionDuration)
+	if timeLeft > duration {
+		timeLeft = duration
+	}
+	return 100 + int(timeLeft.Milliseconds()*100/duration.Milliseconds())
 }
@@ -1,37 +1,111 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCalculateScore(t *testing.T) {
 	tests := []struct {
-		timeLeft         int
+		timeLeft         time.Duration
 		questionDuration int
 		expectedScore    int
 	}{
 		{0, 10, 100},
-		{5, 10, 150},
-		{10, 10, 200},
+		{5 * time.Second, 10, 150},
+		{10 * time.Second, 10, 200},
+		{900 * time.Millisecond, 10, 109},
+		{100 * time.Millisecond, 10, 101},
+		{4800 * time.Millisecond, 10, 148},
+		{5 * time.Second, 20, 125},
+		{-time.Second, 10, 100},
+		{11 * time.Second, 10, 200},
+		{0, 0, 100},
+		{5 * time.Second, 0, 100},
+		{5 * time.Second, -1, 100},
 	}
 
 	for _, test := range tests {
 		score := calculateScore(test.timeLeft, test.questionDuration)
 		if score != test.expectedScore {
-			t.Errorf("expected a score of %d but got %d", test.expectedScore, score)
+			t.Errorf("expected a score of %d for %v left of %d seconds but got %d", test.expectedScore, test.timeLeft, test.questionDuration, score)
 		}
 	}
 }
 
+func TestScoringModes(t *testing.T) {
+	tests := []struct {
+		mode          string
+		timeLeft      time.Duration
+		expectedScore int
+	}{
+		{"", 5 * time.Second, 150}, // defaults to linear
+		{ScoringLinear, 0, 100},
+		{ScoringLinear, 5 * time.Second, 150},
+		{ScoringLinear, 10 * time.Second, 200},
+		{ScoringFlat, 0, 100},
+		{ScoringFlat, 5 * time.Second, 100},
+		{ScoringFlat, 10 * time.Second, 100},
+		{ScoringExponential, 0, 100},
+		{ScoringExponential, 5 * time.Second, 120},
+		{ScoringExponential, 7500 * time.Millisecond, 146},
+		{ScoringExponential, 10 * time.Second, 200},
+		{ScoringExponential, -time.Second, 100},
+		{ScoringExponential, 11 * time.Second, 200},
+	}
+
+	for _, test := range tests {
+		score := scoringFor(test.mode)(test.timeLeft, 10)
+		if score != test.expectedScore {
+			t.Errorf("expected a %s score of %d for %v left but got %d", test.mode, test.expectedScore, test.timeLeft, score)
+		}
+	}
+	if score := exponentialScore(5*time.Second, 0); score != 100 {
+		t.Errorf("expected an exponential score of 100 for a question without a duration but got %d", score)
+	}
+
+	// the quiz's scoring mode is used when answers are registered
+	game := Game{
+		Pin:     1,
+		Players: map[string]int{"player1": 0, "player2": 0},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			ScoringMode:      ScoringFlat,
+			Questions:        []QuizQuestion{{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1}},
+		},
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	if _, _, err := game.RegisterAnswer("player1", 1); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	if game.Players["player1"] != 100 {
+		t.Errorf("expected a flat score of 100 but got %d", game.Players["player1"])
+	}
+
+	if err := (Quiz{ScoringMode: "random"}).Validate(); err == nil {
+		t.Error("expected an unknown scoring mode to be rejected")
+	}
+}
+
 func TestNameExistsInGame(t *testing.T) {
 	tests := []struct {
 		playerNames      []string
 		newPlayer        string
+		caseSensitive    bool
 		expectedResponse bool
 	}{
-		{[]string{"abc"}, "ABC", true}, // case-sensitivity
-		{[]string{"abc"}, "abc", true},
-		{[]string{"abc"}, "abcd", false},
+		{[]string{"abc"}, "ABC", false, true}, // case-sensitivity
+		{[]string{"abc"}, "abc", false, true},
+		{[]string{"abc"}, "abcd", false, false},
+		{[]string{"Bob"}, "bob", false, true},
+		{[]string{"Bob"}, "bob", true, false},
+		{[]string{"Bob"}, "Bob", true, true},
 	}
 
 	game := Game{}
@@ -42,6 +116,7 @@ func TestNameExistsInGame(t *testing.T) {
 			m[p] = p
 		}
 		game.PlayerNames = m
+		game.CaseSensitiveNames = test.caseSensitive
 
 		response := game.NameExistsInGame(test.newPlayer)
 		if response != test.expectedResponse {
@@ -50,3 +125,1933 @@ func TestNameExistsInGame(t *testing.T) {
 	}
 
 }
+
+func TestBeginAnswers(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0},
+		PlayerNames: map[string]string{"player1": "player1"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			PreloadDelay:     60,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one"},
+					Correct:  1,
+					Preload:  true,
+				},
+			},
+		},
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	_, _, err := game.RegisterAnswer("player1", 1)
+	if _, ok := err.(*AnswersNotOpenError); !ok {
+		t.Fatalf("expected answer to be rejected before answers begin but got %v", err)
+	}
+	if len(game.PlayersAnswered) != 0 {
+		t.Errorf("expected no players to have answered but got %d", len(game.PlayersAnswered))
+	}
+	if context := game.GetPlayerQuestionContext(); !context.Preload || context.AnswersIn != 60 {
+		t.Errorf("expected the player context to be preloading for 60 seconds but got %+v", context)
+	}
+
+	if err := game.BeginAnswers(); err != nil {
+		t.Fatalf("error beginning answers: %v", err)
+	}
+	if context := game.GetPlayerQuestionContext(); context.Preload || context.TimeLeft != 20 {
+		t.Errorf("expected the player countdown to have started but got %+v", context)
+	}
+
+	if _, _, err := game.RegisterAnswer("player1", 1); err != nil {
+		t.Fatalf("expected answer to be accepted after answers begin but got %v", err)
+	}
+	if game.Players["player1"] <= 0 {
+		t.Errorf("expected player to have scored but got %d", game.Players["player1"])
+	}
+}
+
+func TestPreloadDelay(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0},
+		PlayerNames: map[string]string{"player1": "player1"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			PreloadDelay:     5,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one"},
+					Correct:  1,
+					Preload:  true,
+				},
+			},
+		},
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	_, current, err := game.GetCurrentQuestion()
+	if err != nil {
+		t.Fatalf("error getting current question: %v", err)
+	}
+	if !current.Preload || current.AnswersIn != 5 {
+		t.Errorf("expected question to be preloading for 5 seconds but got %+v", current)
+	}
+	if _, _, err := game.RegisterAnswer("player1", 1); err == nil {
+		t.Error("expected answer to be rejected during the preload delay")
+	}
+
+	// simulate the preload delay elapsing
+	game.AnswersStart = time.Now().Add(-time.Second)
+	game.QuestionDeadline = game.AnswersStart.Add(20 * time.Second)
+
+	if _, _, err := game.RegisterAnswer("player1", 1); err != nil {
+		t.Fatalf("expected answer to be accepted after the preload delay but got %v", err)
+	}
+	if game.Preloading {
+		t.Error("expected game to have stopped preloading")
+	}
+
+	// quizzes without a preload delay do not preload
+	game.Quiz.PreloadDelay = 0
+	game.GameState = GameNotStarted
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error restarting game: %v", err)
+	}
+	if game.Preloading {
+		t.Error("expected the question not to preload without a preload delay")
+	}
+}
+
+func TestFlagQuestion(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0},
+		PlayerNames: map[string]string{"player1": "player1", "player2": "player2"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one"},
+					Correct:  1,
+				},
+			},
+		},
+	}
+
+	if _, err := game.FlagQuestion("player1", "ambiguous"); err == nil {
+		t.Error("expected flag to be rejected before the game has started")
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	tests := []struct {
+		sessionid     string
+		expectedCount int
+		expectError   bool
+	}{
+		{"player1", 1, false},
+		{"player1", 1, true}, // players may only flag a question once
+		{"player2", 2, false},
+		{"stranger", 0, true},
+	}
+
+	for testIndex, test := range tests {
+		count, err := game.FlagQuestion(test.sessionid, "wrong answer")
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error value %v for test index %d", err, testIndex)
+		}
+		if count != test.expectedCount {
+			t.Errorf("expected a flag count of %d but got %d for test index %d", test.expectedCount, count, testIndex)
+		}
+	}
+
+	copied := game.Copy()
+	if len(copied.Flags[0]) != 2 {
+		t.Errorf("expected copied game to have 2 flags but got %d", len(copied.Flags[0]))
+	}
+
+	// long reasons are truncated
+	game.Players["player3"] = 0
+	if _, err := game.FlagQuestion("player3", strings.Repeat("x", 500)); err != nil {
+		t.Fatalf("error flagging question: %v", err)
+	}
+	if reason := game.Flags[0]["player3"]; len(reason) != maxFlagReasonLength {
+		t.Errorf("expected the reason to be truncated to %d characters but got %d", maxFlagReasonLength, len(reason))
+	}
+}
+
+func TestLegacySingleAnswerQuestion(t *testing.T) {
+	// a single-answer question that was persisted before validation existed
+	data := []byte(`{"pin":1,"host":"host","players":{"player1":0},"playernames":{"player1":"player1"},"quiz":{"questionDuration":20,"questions":[{"question":"informational","answers":["only"],"correct":0}]},"gamestate":0}`)
+	game, err := UnmarshalGame(data)
+	if err != nil {
+		t.Fatalf("error unmarshaling game: %v", err)
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	_, update, err := game.RegisterAnswer("player1", 0)
+	if err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	if !update.AllAnswered {
+		t.Error("expected all players to have answered")
+	}
+	if game.Players["player1"] != 0 {
+		t.Errorf("expected informational question not to be scored but got %d", game.Players["player1"])
+	}
+	if len(game.CorrectPlayers) != 0 {
+		t.Errorf("expected no correct players but got %d", len(game.CorrectPlayers))
+	}
+}
+
+func TestGetReportCard(t *testing.T) {
+	game := Game{
+		Pin:             1,
+		Players:         map[string]int{"player1": 0, "player2": 0},
+		PlayerNames:     map[string]string{"player1": "alice", "player2": "bob"},
+		PlayersAnswered: map[string]struct{}{},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+				{Question: "question 1", Answers: []string{"zero", "one"}, Correct: 0},
+				{Question: "question 2", Answers: []string{"zero", "one"}, Correct: 0},
+			},
+		},
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	// question 0 - alice is correct, all players answered so results are
+	// shown
+	game.RegisterAnswer("player1", 1)
+	game.RegisterAnswer("player2", 0)
+	game.NextState()
+	// question 1 - alice is wrong
+	game.RegisterAnswer("player1", 1)
+	game.RegisterAnswer("player2", 0)
+	game.NextState()
+	// question 2 - alice does not answer
+	game.RegisterAnswer("player2", 0)
+	game.NextState()
+	game.NextState()
+
+	if game.GameState != GameEnded {
+		t.Fatalf("expected game to have ended but got state %d", game.GameState)
+	}
+
+	report, err := game.GetReportCard("alice")
+	if err != nil {
+		t.Fatalf("error getting report card: %v", err)
+	}
+	if len(report.Questions) != 3 {
+		t.Fatalf("expected 3 questions in report card but got %d", len(report.Questions))
+	}
+	if report.Score != game.Players["player1"] {
+		t.Errorf("expected report card score %d but got %d", game.Players["player1"], report.Score)
+	}
+
+	tests := []struct {
+		answered bool
+		chosen   int
+		correct  bool
+	}{
+		{true, 1, true},
+		{true, 1, false},
+		{false, -1, false},
+	}
+	for i, test := range tests {
+		q := report.Questions[i]
+		if q.Answered != test.answered || q.Chosen != test.chosen || q.Correct != test.correct {
+			t.Errorf("unexpected report card entry %+v for question %d", q, i)
+		}
+		if q.Correct && q.Score <= 0 {
+			t.Errorf("expected a score for question %d", i)
+		}
+		if !q.Correct && q.Score != 0 {
+			t.Errorf("expected no score for question %d but got %d", i, q.Score)
+		}
+	}
+
+	if _, err := game.GetReportCard("nobody"); err == nil {
+		t.Error("expected error for player not in game")
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	game := Game{
+		PlayerNames: map[string]string{"player1": "Alex", "player2": "Alex (2)"},
+	}
+
+	if name := game.UniqueName("Sam"); name != "Sam" {
+		t.Errorf("expected unique name to be unchanged but got %s", name)
+	}
+	if name := game.UniqueName("alex"); name != "alex (3)" {
+		t.Errorf("expected alex (3) but got %s", name)
+	}
+}
+
+func TestShuffledPlayerNames(t *testing.T) {
+	game := Game{
+		Players:     map[string]int{"p1": 100, "p2": 200, "p3": 300, "p4": 400},
+		PlayerNames: map[string]string{"p1": "alice", "p2": "bob", "p3": "carol", "p4": "dave"},
+	}
+
+	shuffled := game.ShuffledPlayerNames()
+	sorted := append([]string{}, shuffled...)
+	sort.Strings(sorted)
+	expected := game.GetPlayerNames()
+	if len(sorted) != len(expected) {
+		t.Fatalf("expected %d names but got %d", len(expected), len(sorted))
+	}
+	for i := range expected {
+		if sorted[i] != expected[i] {
+			t.Errorf("expected shuffled names to be a permutation of %v but got %v", expected, shuffled)
+			break
+		}
+	}
+
+	if game.Players["p3"] != 300 {
+		t.Error("expected shuffling not to affect scores")
+	}
+}
+
+func TestSamplePlayerNames(t *testing.T) {
+	game := Game{
+		Players:     map[string]int{"p1": 0, "p2": 0, "p3": 0, "p4": 0},
+		PlayerNames: map[string]string{"p1": "dave", "p2": "carol", "p3": "bob", "p4": "alice"},
+	}
+
+	tests := []struct {
+		n        int
+		first    string
+		expected []string
+	}{
+		{3, "", []string{"alice", "bob", "carol"}},
+		{3, "nobody", []string{"alice", "bob", "carol"}},
+		{3, "p1", []string{"dave", "alice", "bob"}},
+		{2, "p4", []string{"alice", "bob"}},
+		{0, "p1", []string{}},
+	}
+
+	for testIndex, test := range tests {
+		// repeated to make sure the sample does not vary with the map order
+		for i := 0; i < 10; i++ {
+			if names := game.SamplePlayerNames(test.n, test.first); fmt.Sprint(names) != fmt.Sprint(test.expected) {
+				t.Fatalf("expected %v but got %v for test index %d", test.expected, names, testIndex)
+			}
+		}
+	}
+}
+
+func TestDifficultyWeightedWinners(t *testing.T) {
+	game := Game{
+		Players:     map[string]int{"p1": 300, "p2": 250},
+		PlayerNames: map[string]string{"p1": "alice", "p2": "bob"},
+		Quiz: Quiz{
+			Questions: []QuizQuestion{
+				{Question: "easy", Answers: []string{"a", "b"}, Difficulty: 1},
+				{Question: "easy", Answers: []string{"a", "b"}},
+				{Question: "hard", Answers: []string{"a", "b"}, Difficulty: 3},
+			},
+		},
+		AnswerLog: map[string][]AnswerRecord{
+			// alice gets both easy questions right
+			"p1": {
+				{QuestionIndex: 0, Correct: true, Score: 150},
+				{QuestionIndex: 1, Correct: true, Score: 150},
+				{QuestionIndex: 2},
+			},
+			// bob only gets the hard question right
+			"p2": {
+				{QuestionIndex: 0},
+				{QuestionIndex: 1},
+				{QuestionIndex: 2, Correct: true, Score: 250},
+			},
+		},
+	}
+
+	standard := game.GetWinners()
+	if standard[0].Name != "alice" || standard[0].Score != 300 {
+		t.Errorf("expected alice to win the standard leaderboard but got %v", standard)
+	}
+
+	weighted := game.GetDifficultyWeightedWinners()
+	if weighted[0].Name != "bob" || weighted[0].Score != 750 {
+		t.Errorf("expected bob to win the difficulty-weighted leaderboard with 750 but got %v", weighted)
+	}
+	if weighted[1].Name != "alice" || weighted[1].Score != 300 {
+		t.Errorf("expected alice to be second with 300 but got %v", weighted)
+	}
+}
+
+func TestRevealNextBar(t *testing.T) {
+	for _, oneAtATime := range []bool{false, true} {
+		game := Game{
+			Pin:         1,
+			Players:     map[string]int{"player1": 0, "player2": 0},
+			PlayerNames: map[string]string{"player1": "player1", "player2": "player2"},
+			Quiz: Quiz{
+				QuestionDuration: 20,
+				RevealOneAtATime: oneAtATime,
+				Questions: []QuizQuestion{
+					{
+						Question: "question 0",
+						Answers:  []string{"zero", "one", "two"},
+						Correct:  1,
+					},
+				},
+			},
+		}
+
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		if _, err := game.RevealNextBar(); err == nil {
+			t.Error("expected an error revealing a bar while the question is in progress")
+		}
+		game.RegisterAnswer("player1", 1)
+		game.RegisterAnswer("player2", 2)
+		if err := game.ShowResults(); err != nil {
+			t.Fatalf("error showing results: %v", err)
+		}
+
+		expectedVotes := []int{0, 1, 1}
+		revealed := 0
+		if !oneAtATime {
+			revealed = len(expectedVotes)
+		}
+		for {
+			results, err := game.GetQuestionResults()
+			if err != nil {
+				t.Fatalf("error getting question results: %v", err)
+			}
+			if results.Revealed != revealed || len(results.Votes) != revealed {
+				t.Fatalf("expected %d revealed bars but got %d with votes %v", revealed, results.Revealed, results.Votes)
+			}
+			if revealed < len(expectedVotes) && results.MostPopular != -1 {
+				t.Errorf("expected the most popular answer to be hidden until all bars are revealed but got %d", results.MostPopular)
+			}
+			for i, v := range results.Votes {
+				if v != expectedVotes[i] {
+					t.Errorf("expected %d votes for answer %d but got %d", expectedVotes[i], i, v)
+				}
+			}
+
+			done, err := game.RevealNextBar()
+			if err != nil {
+				t.Fatalf("error revealing next bar: %v", err)
+			}
+			if revealed < len(expectedVotes) {
+				revealed++
+			}
+			if done != (revealed == len(expectedVotes)) {
+				t.Errorf("expected done to be %v after revealing %d bars", !done, revealed)
+			}
+			if done {
+				break
+			}
+		}
+
+		results, _ := game.GetQuestionResults()
+		if results.Revealed != len(expectedVotes) || len(results.Votes) != len(expectedVotes) {
+			t.Errorf("expected all bars to be revealed at the end but got %d", results.Revealed)
+		}
+		// answers 1 and 2 are tied
+		if results.MostPopular != 1 {
+			t.Errorf("expected the tie to go to answer 1 but got %d", results.MostPopular)
+		}
+	}
+}
+
+func TestShufflePerPlayer(t *testing.T) {
+	game := Game{
+		Pin:            1,
+		Players:        map[string]int{},
+		PlayerNames:    map[string]string{},
+		CorrectPlayers: map[string]struct{}{},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			ShufflePerPlayer: true,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one", "two", "three"},
+					Correct:  2,
+				},
+			},
+		},
+	}
+
+	// find two players that see the correct answer in different positions
+	positions := map[string]int{}
+	players := []string{}
+	for i := 0; len(players) < 2 && i < 100; i++ {
+		player := fmt.Sprintf("player%d", i)
+		answers, err := game.PlayerAnswers(player)
+		if err != nil {
+			t.Fatalf("error getting answers for %s: %v", player, err)
+		}
+		for position, answer := range answers {
+			if answer != "two" {
+				continue
+			}
+			if len(players) == 0 || positions[players[0]] != position {
+				positions[player] = position
+				players = append(players, player)
+			}
+		}
+	}
+	if len(players) < 2 {
+		t.Fatal("could not find two players with different answer orders")
+	}
+
+	for _, player := range players {
+		game.Players[player] = 0
+		game.PlayerNames[player] = player
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	for _, player := range players {
+		if _, _, err := game.RegisterAnswer(player, positions[player]); err != nil {
+			t.Fatalf("error registering answer for %s: %v", player, err)
+		}
+		if game.Players[player] <= 0 {
+			t.Errorf("expected %s to score by choosing position %d but got %d", player, positions[player], game.Players[player])
+		}
+	}
+	if game.Votes[2] != 2 {
+		t.Errorf("expected both votes to be counted against the canonical answer but got %v", game.Votes)
+	}
+}
+
+func TestDisconnectedTopScorer(t *testing.T) {
+	tests := []struct {
+		exclude  bool
+		expected []string
+	}{
+		{false, []string{"top", "second"}},
+		{true, []string{"second"}},
+	}
+
+	for testIndex, test := range tests {
+		game := Game{
+			Pin:         1,
+			Players:     map[string]int{"top": 500, "second": 300},
+			PlayerNames: map[string]string{"top": "top", "second": "second"},
+			Quiz:        Quiz{ExcludeDisconnected: test.exclude},
+		}
+		if !game.SetConnected("top", false) {
+			t.Fatalf("expected disconnecting a player to change the game for test index %d", testIndex)
+		}
+		if game.SetConnected("host", false) {
+			t.Errorf("expected disconnecting a non-player to be ignored for test index %d", testIndex)
+		}
+
+		winners := game.GetWinners()
+		if len(winners) != len(test.expected) {
+			t.Fatalf("expected %d winners but got %v for test index %d", len(test.expected), winners, testIndex)
+		}
+		for i, winner := range winners {
+			if winner.Name != test.expected[i] {
+				t.Errorf("expected winner %d to be %s but got %s for test index %d", i, test.expected[i], winner.Name, testIndex)
+			}
+		}
+
+		// reconnecting puts the player back in the winners
+		game.SetConnected("top", true)
+		if winners := game.GetWinners(); len(winners) != 2 || winners[0].Name != "top" {
+			t.Errorf("expected reconnected player to top the winners but got %v for test index %d", winners, testIndex)
+		}
+	}
+}
+
+func TestMixedAnswerCounts(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0},
+		PlayerNames: map[string]string{"player1": "player1"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{Question: "two", Answers: []string{"a", "b"}, Correct: 1},
+				{Question: "three", Answers: []string{"a", "b", "c"}, Correct: 2},
+				{Question: "four", Answers: []string{"a", "b", "c", "d"}, Correct: 3},
+				{Question: "two again", Answers: []string{"a", "b"}, Correct: 0},
+			},
+		},
+	}
+	if err := game.Quiz.Validate(); err != nil {
+		t.Fatalf("expected mixed quiz to be valid: %v", err)
+	}
+
+	state, err := game.NextState()
+	for i, question := range game.Quiz.Questions {
+		if err != nil || state != QuestionInProgress {
+			t.Fatalf("expected question %d to be in progress but got state %d: %v", i, state, err)
+		}
+		_, current, err := game.GetCurrentQuestion()
+		if err != nil {
+			t.Fatalf("error getting question %d: %v", i, err)
+		}
+		if len(current.Answers) != question.NumAnswers() || len(current.Votes) != question.NumAnswers() {
+			t.Errorf("expected %d answers and votes for question %d but got %d and %d", question.NumAnswers(), i, len(current.Answers), len(current.Votes))
+		}
+		if _, _, err := game.RegisterAnswer("player1", question.NumAnswers()); err == nil {
+			t.Errorf("expected an out of range answer to be rejected for question %d", i)
+		}
+		if _, _, err := game.RegisterAnswer("player1", question.NumAnswers()-1); err != nil {
+			t.Fatalf("error answering question %d: %v", i, err)
+		}
+
+		results, err := game.GetQuestionResults()
+		if err != nil {
+			t.Fatalf("error getting results for question %d: %v", i, err)
+		}
+		if len(results.Answers) != question.NumAnswers() || len(results.Votes) != question.NumAnswers() {
+			t.Errorf("expected %d answers and votes in results for question %d but got %d and %d", question.NumAnswers(), i, len(results.Answers), len(results.Votes))
+		}
+		state, err = game.NextState()
+	}
+	if state != GameEnded {
+		t.Errorf("expected game to end but got state %d", state)
+	}
+	if game.Players["player1"] <= 0 {
+		t.Errorf("expected player to have scored but got %d", game.Players["player1"])
+	}
+}
+
+func TestCompressedGameRoundTrip(t *testing.T) {
+	game := Game{
+		Pin:         1234,
+		Host:        "host",
+		Players:     make(map[string]int),
+		PlayerNames: make(map[string]string),
+		AnswerLog:   make(map[string][]AnswerRecord),
+		Quiz: Quiz{
+			Name:             "large quiz",
+			QuestionDuration: 20,
+		},
+	}
+	for i := 0; i < 50; i++ {
+		game.Quiz.Questions = append(game.Quiz.Questions, QuizQuestion{
+			Question: fmt.Sprintf("question %d", i),
+			Answers:  []string{"zero", "one", "two", "three"},
+			Correct:  i % 4,
+		})
+	}
+	for i := 0; i < 200; i++ {
+		player := fmt.Sprintf("player%d", i)
+		game.Players[player] = i * 10
+		game.PlayerNames[player] = fmt.Sprintf("Player %d", i)
+		for q := 0; q < 50; q++ {
+			game.AnswerLog[player] = append(game.AnswerLog[player], AnswerRecord{
+				QuestionIndex: q,
+				Answer:        (i + q) % 4,
+				Correct:       (i+q)%4 == q%4,
+				Score:         100,
+				ResponseTime:  i * q,
+			})
+		}
+	}
+
+	plain, err := game.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling game: %v", err)
+	}
+	compressed, err := game.MarshalCompressed()
+	if err != nil {
+		t.Fatalf("error compressing game: %v", err)
+	}
+	if len(compressed) >= len(plain) {
+		t.Errorf("expected compressed game to be smaller than %d bytes but got %d", len(plain), len(compressed))
+	}
+
+	// games persisted before compression was introduced must still load
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"compressed", compressed},
+		{"uncompressed", plain},
+	}
+	for _, test := range tests {
+		loaded, err := UnmarshalGame(test.data)
+		if err != nil {
+			t.Fatalf("error unmarshaling %s game: %v", test.name, err)
+		}
+		if loaded.Pin != game.Pin || len(loaded.Players) != 200 || loaded.Players["player199"] != 1990 {
+			t.Errorf("%s game did not round-trip: pin %d with %d players", test.name, loaded.Pin, len(loaded.Players))
+		}
+		if records := loaded.AnswerLog["player7"]; len(records) != 50 || records[49].ResponseTime != game.AnswerLog["player7"][49].ResponseTime {
+			t.Errorf("%s game did not round-trip the answer log", test.name)
+		}
+	}
+}
+
+func TestOrderingQuestion(t *testing.T) {
+	question := QuizQuestion{
+		Type:     QuestionTypeOrdering,
+		Question: "order these",
+		Answers:  []string{"first", "second", "third", "fourth"},
+	}.ShuffleAnswers()
+
+	// the displayed indices of the items in the correct order
+	correctOrder := make([]int, question.NumAnswers())
+	for i := range question.Answers {
+		correctOrder[question.OriginalIndex(i)] = i
+	}
+	partialOrder := append([]int{}, correctOrder...)
+	partialOrder[2], partialOrder[3] = partialOrder[3], partialOrder[2]
+
+	tests := []struct {
+		name          string
+		order         []int
+		expectError   bool
+		expectCorrect bool
+		expectPlaced  int
+	}{
+		{"fully correct", correctOrder, false, true, 4},
+		{"partially correct", partialOrder, false, false, 2},
+		{"repeated item", []int{0, 0, 1, 2}, true, false, 0},
+		{"missing item", []int{0, 1, 2}, true, false, 0},
+	}
+
+	for _, test := range tests {
+		game := Game{
+			Pin:            1,
+			Players:        map[string]int{"player1": 0},
+			PlayerNames:    map[string]string{"player1": "player1"},
+			CorrectPlayers: map[string]struct{}{},
+			Quiz: Quiz{
+				QuestionDuration: 20,
+				Questions:        []QuizQuestion{question},
+			},
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		_, _, err := game.RegisterOrdering("player1", test.order)
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error value %v for %s ordering", err, test.name)
+			continue
+		}
+		if test.expectError {
+			continue
+		}
+
+		record := game.AnswerLog["player1"][0]
+		if record.Correct != test.expectCorrect {
+			t.Errorf("expected %s ordering to have correct %v but got %v", test.name, test.expectCorrect, record.Correct)
+		}
+		_, correct := game.CorrectPlayers["player1"]
+		if correct != test.expectCorrect {
+			t.Errorf("expected %s ordering to have player in correct players %v but got %v", test.name, test.expectCorrect, correct)
+		}
+		// full credit is between 100 and 200 points depending on the time left
+		fullMin, fullMax := 100*test.expectPlaced/4, 200*test.expectPlaced/4
+		if score := game.Players["player1"]; score < fullMin || score > fullMax {
+			t.Errorf("expected %s ordering to score between %d and %d but got %d", test.name, fullMin, fullMax, score)
+		}
+		placed := 0
+		for _, votes := range game.Votes {
+			placed += votes
+		}
+		if placed != test.expectPlaced {
+			t.Errorf("expected %s ordering to place %d items correctly but got %d", test.name, test.expectPlaced, placed)
+		}
+	}
+}
+
+func TestCopyVotes(t *testing.T) {
+	game := Game{Votes: []int{3, 1, 0, 2}}
+	copied := game.Copy()
+	game.Votes[0] = 10
+
+	expected := []int{3, 1, 0, 2}
+	if len(copied.Votes) != len(expected) {
+		t.Fatalf("expected %d votes in the copy but got %d", len(expected), len(copied.Votes))
+	}
+	for i, v := range expected {
+		if copied.Votes[i] != v {
+			t.Errorf("expected %d votes for choice %d in the copy but got %d", v, i, copied.Votes[i])
+		}
+	}
+}
+
+func TestMultiSelect(t *testing.T) {
+	question := QuizQuestion{
+		Question:       "multi-select",
+		Answers:        []string{"zero", "one", "two", "three"},
+		CorrectAnswers: []int{0, 2},
+	}
+
+	tests := []struct {
+		name          string
+		selection     []int
+		expectError   bool
+		expectCorrect bool
+		expectShare   int // score in halves of full credit
+		expectVotes   []int
+	}{
+		{"exact match", []int{2, 0}, false, true, 2, []int{1, 0, 1, 0}},
+		{"partial match", []int{2}, false, false, 1, []int{0, 0, 1, 0}},
+		{"over-selection", []int{0, 1, 2}, false, false, 1, []int{1, 1, 1, 0}},
+		{"more wrong than right", []int{0, 1, 3}, false, false, 0, []int{1, 1, 0, 1}},
+		{"repeated answer", []int{0, 0}, true, false, 0, nil},
+		{"out of range", []int{0, 4}, true, false, 0, nil},
+	}
+
+	for _, test := range tests {
+		game := Game{
+			Pin:            1,
+			Players:        map[string]int{"player1": 0},
+			PlayerNames:    map[string]string{"player1": "player1"},
+			CorrectPlayers: map[string]struct{}{},
+			Quiz: Quiz{
+				QuestionDuration: 20,
+				Questions:        []QuizQuestion{question},
+			},
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		_, _, err := game.RegisterSelection("player1", test.selection)
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error value %v for %s", err, test.name)
+			continue
+		}
+		if test.expectError {
+			continue
+		}
+
+		record := game.AnswerLog["player1"][0]
+		if record.Correct != test.expectCorrect {
+			t.Errorf("expected %s to have correct %v but got %v", test.name, test.expectCorrect, record.Correct)
+		}
+		if _, correct := game.CorrectPlayers["player1"]; correct != test.expectCorrect {
+			t.Errorf("expected %s to have player in correct players %v but got %v", test.name, test.expectCorrect, correct)
+		}
+		// full credit is between 100 and 200 points depending on the time left
+		shareMin, shareMax := 100*test.expectShare/2, 200*test.expectShare/2
+		if score := game.Players["player1"]; score < shareMin || score > shareMax {
+			t.Errorf("expected %s to score between %d and %d but got %d", test.name, shareMin, shareMax, score)
+		}
+		for i, votes := range test.expectVotes {
+			if game.Votes[i] != votes {
+				t.Errorf("expected %s to leave votes %v but got %v", test.name, test.expectVotes, game.Votes)
+				break
+			}
+		}
+	}
+}
+
+func TestPerQuestionDuration(t *testing.T) {
+	game := Game{
+		Pin:            1,
+		Players:        map[string]int{"player1": 0},
+		PlayerNames:    map[string]string{"player1": "player1"},
+		CorrectPlayers: map[string]struct{}{},
+		Quiz: Quiz{
+			QuestionDuration: 10,
+			Questions: []QuizQuestion{
+				{Question: "hard", Answers: []string{"a", "b"}, Correct: 0, Duration: 60},
+				{Question: "easy", Answers: []string{"a", "b"}, Correct: 0},
+			},
+		},
+	}
+
+	expectDeadline := func(seconds int) {
+		t.Helper()
+		remaining := time.Until(game.QuestionDeadline)
+		if remaining <= time.Duration(seconds-2)*time.Second || remaining > time.Duration(seconds)*time.Second {
+			t.Errorf("expected deadline about %d seconds away but got %v", seconds, remaining)
+		}
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	expectDeadline(60)
+
+	// an immediate answer earns close to full credit for the question's own
+	// duration - scoring against the quiz's duration would exceed 200
+	if _, _, err := game.RegisterAnswer("player1", 0); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	if score := game.Players["player1"]; score < 190 || score > 200 {
+		t.Errorf("expected score between 190 and 200 but got %d", score)
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error moving to next question: %v", err)
+	}
+	expectDeadline(10)
+
+	// the host's override applies to every question
+	game.QuestionDuration = 30
+	game.QuestionIndex = 0
+	if game.EffectiveQuestionDuration() != 30 {
+		t.Errorf("expected the host's duration of 30 seconds but got %d", game.EffectiveQuestionDuration())
+	}
+}
+
+func TestTeamScores(t *testing.T) {
+	game := Game{
+		Players: map[string]int{
+			"alice": 300,
+			"bob":   100,
+			"carol": 250,
+			"dave":  150,
+			"erin":  400,
+			"frank": 500, // not on a team
+		},
+		PlayerNames: map[string]string{},
+	}
+	game.SetTeam("alice", "Reds")
+	game.SetTeam("bob", "reds") // same team as alice
+	game.SetTeam("carol", "Blues")
+	game.SetTeam("dave", "Blues")
+	game.SetTeam("erin", "Greens")
+	game.SetTeam("frank", "")
+
+	scores := game.TeamScores()
+	expected := map[string]int{"Reds": 400, "Blues": 400, "Greens": 400}
+	if len(scores) != len(expected) {
+		t.Fatalf("expected %d teams but got %v", len(expected), scores)
+	}
+	for team, score := range expected {
+		if scores[team] != score {
+			t.Errorf("expected team %s to score %d but got %d", team, score, scores[team])
+		}
+	}
+
+	// teams with the same score are listed by name
+	winners := game.GetTeamWinners()
+	names := []string{}
+	for _, winner := range winners {
+		names = append(names, winner.Name)
+	}
+	if strings.Join(names, ",") != "Blues,Greens,Reds" {
+		t.Errorf("expected tied teams in name order but got %v", names)
+	}
+
+	game.Players["bob"] += 50
+	if winners := game.GetTeamWinners(); winners[0].Name != "Reds" || winners[0].Score != 450 {
+		t.Errorf("expected Reds to lead with 450 but got %+v", winners[0])
+	}
+
+	// players that leave no longer count towards their team
+	game.DeletePlayer("erin")
+	if _, ok := game.TeamScores()["Greens"]; ok {
+		t.Error("expected Greens to have no score after its only player left")
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0},
+		PlayerNames: map[string]string{"player1": "player1", "player2": "player2"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one"},
+					Correct:  1,
+				},
+			},
+		},
+	}
+
+	if err := game.Pause(); err == nil {
+		t.Error("expected an error pausing a game that has not started")
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	// pause 5 seconds in with 15 seconds left
+	start := time.Now()
+	game.QuestionDeadline = start.Add(15 * time.Second)
+	pausedAt := start
+	if err := game.pauseAt(pausedAt); err != nil {
+		t.Fatalf("error pausing game: %v", err)
+	}
+	// pausing again doesn't move the time the game was paused
+	if err := game.pauseAt(pausedAt.Add(time.Second)); err != nil || !game.PausedAt.Equal(pausedAt) {
+		t.Errorf("expected pausing twice to keep the original pause time but got %v and %v", game.PausedAt, err)
+	}
+
+	if _, _, err := game.RegisterAnswer("player1", 1); err == nil {
+		t.Error("expected answers to be rejected while the game is paused")
+	}
+
+	// the deadline passing while paused doesn't end the question
+	game.QuestionDeadline = time.Now().Add(-time.Second)
+	game.PausedAt = game.QuestionDeadline.Add(-10 * time.Second)
+	changed, current, err := game.GetCurrentQuestion()
+	if changed || err != nil || game.GameState != QuestionInProgress {
+		t.Fatalf("expected the question to stay live while paused but got state %d and %v", game.GameState, err)
+	}
+	if !current.Paused || current.TimeLeft != 10 {
+		t.Errorf("expected 10 seconds left while paused but got %d", current.TimeLeft)
+	}
+
+	// resuming pushes the deadline back by the time the game was paused
+	deadline := game.QuestionDeadline
+	resumedAt := game.PausedAt.Add(30 * time.Second)
+	if err := game.resumeAt(resumedAt); err != nil {
+		t.Fatalf("error resuming game: %v", err)
+	}
+	if game.Paused {
+		t.Error("expected game not to be paused after resuming")
+	}
+	if !game.QuestionDeadline.Equal(deadline.Add(30 * time.Second)) {
+		t.Errorf("expected the deadline to move back by 30 seconds but got %v", game.QuestionDeadline.Sub(deadline))
+	}
+	if left := game.QuestionDeadline.Sub(resumedAt); left != 10*time.Second {
+		t.Errorf("expected 10 seconds left after resuming but got %v", left)
+	}
+
+	// resuming a game that isn't paused doesn't move the deadline
+	deadline = game.QuestionDeadline
+	if err := game.resumeAt(resumedAt.Add(time.Minute)); err != nil || !game.QuestionDeadline.Equal(deadline) {
+		t.Errorf("expected resuming twice to keep the deadline but got %v and %v", game.QuestionDeadline, err)
+	}
+
+	// a question can't be paused once it has expired
+	game.QuestionDeadline = time.Now().Add(-time.Second)
+	if err := game.Pause(); err == nil {
+		t.Error("expected an error pausing an expired question")
+	}
+}
+
+func TestPauseDuringPreload(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0},
+		PlayerNames: map[string]string{"player1": "player1"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			PreloadDelay:     5,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one"},
+					Correct:  1,
+					Preload:  true,
+				},
+			},
+		},
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	answersStart, deadline := game.AnswersStart, game.QuestionDeadline
+	pausedAt := time.Now()
+	if err := game.pauseAt(pausedAt); err != nil {
+		t.Fatalf("error pausing game: %v", err)
+	}
+	if err := game.resumeAt(pausedAt.Add(time.Minute)); err != nil {
+		t.Fatalf("error resuming game: %v", err)
+	}
+	if !game.AnswersStart.Equal(answersStart.Add(time.Minute)) || !game.QuestionDeadline.Equal(deadline.Add(time.Minute)) {
+		t.Errorf("expected the preload delay and deadline to move back by a minute but got %v and %v", game.AnswersStart.Sub(answersStart), game.QuestionDeadline.Sub(deadline))
+	}
+}
+
+func TestSkipQuestion(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0, "player3": 0},
+		PlayerNames: map[string]string{"player1": "player1", "player2": "player2", "player3": "player3"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one"},
+					Correct:  1,
+				},
+				{
+					Question: "question 1",
+					Answers:  []string{"zero", "one"},
+					Correct:  0,
+				},
+			},
+		},
+	}
+
+	if err := game.SkipQuestion(); err == nil {
+		t.Error("expected an error skipping a question before the game has started")
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	if _, _, err := game.RegisterAnswer("player1", 1); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	score := game.Players["player1"]
+	if score == 0 {
+		t.Fatal("expected player1 to score")
+	}
+
+	if err := game.SkipQuestion(); err != nil {
+		t.Fatalf("error skipping question: %v", err)
+	}
+	if game.GameState != ShowResults || game.QuestionIndex != 0 {
+		t.Fatalf("expected the results of question 0 to show but got state %d and question %d", game.GameState, game.QuestionIndex)
+	}
+
+	// no further answers are counted
+	if _, _, err := game.RegisterAnswer("player2", 1); err == nil {
+		t.Error("expected answers to be rejected after the question was skipped")
+	}
+	if game.Votes[1] != 1 || game.totalVotes() != 1 {
+		t.Errorf("expected only player1's vote to count but got %v", game.Votes)
+	}
+	if game.Players["player1"] != score || game.Players["player2"] != 0 {
+		t.Errorf("expected scores to stay the same but got %v", game.Players)
+	}
+
+	if err := game.SkipQuestion(); err == nil {
+		t.Error("expected an error skipping a question that is showing results")
+	}
+
+	// the game carries on with the next question
+	if state, err := game.NextState(); err != nil || state != QuestionInProgress || game.QuestionIndex != 1 {
+		t.Errorf("expected question 1 to be live but got state %d, question %d and %v", state, game.QuestionIndex, err)
+	}
+}
+
+func TestBuzzerQuestion(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0, "player3": 0, "player4": 0},
+		PlayerNames: map[string]string{"player1": "player1", "player2": "player2", "player3": "player3", "player4": "player4"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{
+					Question: "question 0",
+					Answers:  []string{"zero", "one", "two"},
+					Correct:  1,
+					Type:     QuestionTypeBuzzer,
+				},
+				{
+					Question: "question 1",
+					Answers:  []string{"zero", "one", "two"},
+					Correct:  2,
+					Type:     QuestionTypeBuzzer,
+				},
+			},
+		},
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	// wrong answers don't end the question
+	if _, update, err := game.RegisterAnswer("player1", 0); err != nil || update.AllAnswered {
+		t.Fatalf("expected a wrong answer to leave the question open but got %+v and %v", update, err)
+	}
+	_, update, err := game.RegisterAnswer("player2", 1)
+	if err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	if !update.AllAnswered || game.GameState != ShowResults {
+		t.Errorf("expected the first correct answer to end the question but got state %d", game.GameState)
+	}
+	if game.QuestionWinner != "player2" || game.Players["player2"] == 0 {
+		t.Errorf("expected player2 to win the question but got winner %s and score %d", game.QuestionWinner, game.Players["player2"])
+	}
+
+	// later correct answers get nothing
+	if _, _, err := game.RegisterAnswer("player3", 1); err == nil {
+		t.Error("expected a correct answer after the question was won to be rejected")
+	}
+	if game.Players["player1"] != 0 || game.Players["player3"] != 0 {
+		t.Errorf("expected only player2 to score but got %v", game.Players)
+	}
+	results, err := game.GetQuestionResults()
+	if err != nil || results.Winner != "player2" {
+		t.Errorf("expected player2 to be shown as the winner but got %q and %v", results.Winner, err)
+	}
+
+	// the next question can be won by someone else
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error moving to the next question: %v", err)
+	}
+	if game.QuestionWinner != "" {
+		t.Errorf("expected no winner for the new question but got %s", game.QuestionWinner)
+	}
+	if _, _, err := game.RegisterAnswer("player4", 2); err != nil || game.QuestionWinner != "player4" {
+		t.Errorf("expected player4 to win question 1 but got %s and %v", game.QuestionWinner, err)
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	questions := []QuizQuestion{}
+	for i := 0; i < 5; i++ {
+		questions = append(questions, QuizQuestion{
+			Question: fmt.Sprintf("question %d", i),
+			Answers:  []string{"zero", "one"},
+			Correct:  1,
+		})
+	}
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0, "player3": 0},
+		PlayerNames: map[string]string{"player1": "player1", "player2": "player2", "player3": "player3"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions:        questions,
+		},
+	}
+
+	// answers for each question - -1 if the player doesn't answer
+	answers := []map[string]int{
+		{"player1": 1, "player2": 1, "player3": 0},
+		{"player1": 1, "player2": -1, "player3": 1},
+		{"player1": 1, "player2": 1, "player3": 1},
+		{"player1": 0, "player2": 1, "player3": 1},
+		{"player1": 1, "player2": 1, "player3": 1},
+	}
+	expectedStreaks := []map[string]int{
+		{"player1": 1, "player2": 1, "player3": 0},
+		{"player1": 2, "player2": 0, "player3": 1},
+		{"player1": 3, "player2": 1, "player3": 2},
+		{"player1": 0, "player2": 2, "player3": 3},
+		{"player1": 1, "player2": 3, "player3": 4},
+	}
+
+	for i, questionAnswers := range answers {
+		if _, err := game.NextState(); err != nil || game.GameState != QuestionInProgress {
+			t.Fatalf("error showing question %d: %v", i, err)
+		}
+		// answering immediately scores close to 200 before the bonus
+		game.QuestionDeadline = time.Now().Add(20 * time.Second)
+		for _, player := range []string{"player1", "player2", "player3"} {
+			answer := questionAnswers[player]
+			if answer < 0 {
+				continue
+			}
+			if _, _, err := game.RegisterAnswer(player, answer); err != nil {
+				t.Fatalf("error registering answer for %s to question %d: %v", player, i, err)
+			}
+			log := game.AnswerLog[player]
+			record := log[len(log)-1]
+			expectedScore := 0
+			if record.Correct {
+				expectedScore = 200 + (expectedStreaks[i][player]-1)*streakBonus
+			}
+			if record.Score < expectedScore-1 || record.Score > expectedScore {
+				t.Errorf("expected %s to score about %d for question %d but got %d", player, expectedScore, i, record.Score)
+			}
+		}
+		if err := game.ShowResults(); err != nil {
+			t.Fatalf("error showing results of question %d: %v", i, err)
+		}
+		for player, expected := range expectedStreaks[i] {
+			if streak := game.CurrentStreak(player); streak != expected {
+				t.Errorf("expected %s to have a streak of %d after question %d but got %d", player, expected, i, streak)
+			}
+		}
+	}
+
+	// streaks are persisted with the game
+	data, err := game.Marshal()
+	if err != nil {
+		t.Fatalf("error marshalling game: %v", err)
+	}
+	restored, err := UnmarshalGame(data)
+	if err != nil {
+		t.Fatalf("error unmarshalling game: %v", err)
+	}
+	if restored.Streaks["player3"] != 4 {
+		t.Errorf("expected the restored game to keep player3's streak of 4 but got %v", restored.Streaks)
+	}
+	if copied := game.Copy(); copied.Streaks["player2"] != 3 {
+		t.Errorf("expected the copy to keep player2's streak of 3 but got %v", copied.Streaks)
+	}
+}
+
+func TestInformationalQuestionKeepsStreaks(t *testing.T) {
+	game := Game{
+		Pin:     1,
+		Players: map[string]int{"player1": 0, "player2": 0},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+				{Question: "an announcement"},
+				{Question: "question 2", Answers: []string{"zero", "one"}, Correct: 1},
+			},
+		},
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	game.RegisterAnswer("player1", 1)
+	game.RegisterAnswer("player2", 1)
+
+	// move through the informational question to the last question
+	for game.QuestionIndex < 2 {
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error moving to the next state: %v", err)
+		}
+	}
+	if game.GameState != QuestionInProgress {
+		t.Fatalf("expected the last question to be in progress but got state %d", game.GameState)
+	}
+	if game.Streaks["player1"] != 1 || game.Streaks["player2"] != 1 {
+		t.Errorf("expected the informational question to leave the streaks alone but got %v", game.Streaks)
+	}
+}
+
+func TestAnswerGrace(t *testing.T) {
+	tests := []struct {
+		offset        time.Duration // time of the answer relative to the deadline
+		expectAnswer  bool
+		expectMinimum bool // the answer earns the minimum score
+	}{
+		{-time.Second, true, false},
+		{0, true, true},
+		{500 * time.Millisecond, true, true},
+		{501 * time.Millisecond, false, false},
+	}
+
+	for testIndex, test := range tests {
+		game := Game{
+			Pin:         1,
+			Players:     map[string]int{"player1": 0, "player2": 0},
+			PlayerNames: map[string]string{"player1": "player1", "player2": "player2"},
+			Quiz: Quiz{
+				QuestionDuration: 20,
+				Questions: []QuizQuestion{
+					{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+				},
+			},
+			AnswerGrace: 500,
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game for test index %d: %v", testIndex, err)
+		}
+
+		_, _, err := game.registerResponseAt("player1", []int{1}, game.QuestionDeadline.Add(test.offset))
+		if !test.expectAnswer {
+			if _, ok := err.(*UnexpectedStateError); !ok {
+				t.Errorf("expected a late answer to be rejected but got %v for test index %d", err, testIndex)
+			}
+			if game.GameState != ShowResults {
+				t.Errorf("expected a late answer to end the question for test index %d", testIndex)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("error registering answer for test index %d: %v", testIndex, err)
+		}
+		score := game.Players["player1"]
+		if test.expectMinimum && score != 100 {
+			t.Errorf("expected the minimum score of 100 but got %d for test index %d", score, testIndex)
+		}
+		if !test.expectMinimum && score <= 100 {
+			t.Errorf("expected a time bonus but got %d for test index %d", score, testIndex)
+		}
+	}
+
+	// the question stays live for the host until the grace period is over
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0},
+		PlayerNames: map[string]string{"player1": "player1"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1},
+			},
+		},
+		AnswerGrace: 60000,
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	game.QuestionDeadline = time.Now().Add(-time.Second)
+	changed, current, err := game.GetCurrentQuestion()
+	if err != nil || changed {
+		t.Fatalf("expected the question to still be live during the grace period but got %v", err)
+	}
+	if current.TimeLeft != 0 || current.AnswerGrace != 60000 {
+		t.Errorf("expected no time left and the grace period to be sent to the host but got %+v", current)
+	}
+	game.AnswerGrace = 500
+	if _, _, err := game.GetCurrentQuestion(); err == nil || game.GameState != ShowResults {
+		t.Errorf("expected the question to end once the grace period is over but got %v", err)
+	}
+}
+
+func TestMostPopular(t *testing.T) {
+	tests := []struct {
+		votes    []int
+		expected int
+	}{
+		{[]int{}, -1},
+		{[]int{0, 0, 0}, -1},
+		{[]int{1, 3, 2}, 1},
+		{[]int{0, 2, 2, 1}, 1},
+		{[]int{2, 2, 2, 2}, 0},
+		{[]int{1, 0, 3, 3}, 2},
+	}
+
+	for testIndex, test := range tests {
+		// repeated to make sure the result does not vary
+		for i := 0; i < 10; i++ {
+			if popular := mostPopular(test.votes); popular != test.expected {
+				t.Fatalf("expected %d but got %d for test index %d", test.expected, popular, testIndex)
+			}
+		}
+	}
+}
+
+func TestAnswerLogCap(t *testing.T) {
+	play := func(logCap int) Game {
+		questions := []QuizQuestion{}
+		for i := 0; i < 5; i++ {
+			questions = append(questions, QuizQuestion{
+				Question:   fmt.Sprintf("question %d", i),
+				Answers:    []string{"zero", "one"},
+				Correct:    1,
+				Difficulty: 3,
+			})
+		}
+		game := Game{
+			Pin:          1,
+			Players:      map[string]int{"player1": 0, "player2": 0},
+			PlayerNames:  map[string]string{"player1": "alice", "player2": "bob"},
+			Quiz:         Quiz{QuestionDuration: 20, Questions: questions},
+			AnswerLogCap: logCap,
+		}
+		for i := 0; i < len(questions); i++ {
+			if _, err := game.NextState(); err != nil {
+				t.Fatalf("error starting question %d: %v", i, err)
+			}
+			// alice gets the odd questions wrong and bob gets them all right
+			game.RegisterAnswer("player1", 1-i%2)
+			// both players answering moves the game to the results
+			game.RegisterAnswer("player2", 1)
+		}
+		return game
+	}
+
+	uncapped := play(0)
+	capped := play(2)
+
+	for _, player := range []string{"player1", "player2"} {
+		if len(capped.AnswerLog[player]) != 2 {
+			t.Errorf("expected 2 answers to be kept for %s but got %d", player, len(capped.AnswerLog[player]))
+		}
+		if capped.TruncatedAnswers[player].Count != 3 {
+			t.Errorf("expected 3 answers to be truncated for %s but got %d", player, capped.TruncatedAnswers[player].Count)
+		}
+		// the most recent answers are kept
+		if last := capped.AnswerLog[player][1]; last.QuestionIndex != 4 {
+			t.Errorf("expected the last answer kept for %s to be for question 4 but got %d", player, last.QuestionIndex)
+		}
+		if capped.Players[player] != uncapped.Players[player] {
+			t.Errorf("expected %s to keep a score of %d but got %d", player, uncapped.Players[player], capped.Players[player])
+		}
+		report, err := capped.GetReportCard(player)
+		if err != nil {
+			t.Fatalf("error getting report card for %s: %v", player, err)
+		}
+		if report.Truncated != 3 || report.Score != uncapped.Players[player] {
+			t.Errorf("expected the report card for %s to show 3 truncated answers and the final score but got %+v", player, report)
+		}
+	}
+
+	if fmt.Sprint(capped.GetGameResults().Players) != fmt.Sprint(uncapped.GetGameResults().Players) {
+		t.Errorf("expected the results to match the uncapped game - expected %+v but got %+v", uncapped.GetGameResults().Players, capped.GetGameResults().Players)
+	}
+	if fmt.Sprint(capped.GetDifficultyWeightedWinners()) != fmt.Sprint(uncapped.GetDifficultyWeightedWinners()) {
+		t.Errorf("expected the weighted winners to match the uncapped game - expected %v but got %v", uncapped.GetDifficultyWeightedWinners(), capped.GetDifficultyWeightedWinners())
+	}
+
+	copied := capped.Copy()
+	if copied.TruncatedAnswers["player1"] != capped.TruncatedAnswers["player1"] {
+		t.Error("expected the truncated totals to be copied")
+	}
+}
+
+func TestAnswerHistory(t *testing.T) {
+	questions := []QuizQuestion{}
+	for i := 0; i < 3; i++ {
+		questions = append(questions, QuizQuestion{
+			Question: fmt.Sprintf("question %d", i),
+			Answers:  []string{"zero", "one", "two"},
+			Correct:  1,
+		})
+	}
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0},
+		PlayerNames: map[string]string{"player1": "alice", "player2": "bob"},
+		Quiz:        Quiz{QuestionDuration: 20, Questions: questions},
+	}
+
+	// bob does not answer the second question
+	choices := map[string][]int{
+		"player1": {0, 2, 1},
+		"player2": {1, -1, 2},
+	}
+	for i := range questions {
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting question %d: %v", i, err)
+		}
+		for _, player := range []string{"player1", "player2"} {
+			if choice := choices[player][i]; choice >= 0 {
+				if _, _, err := game.RegisterAnswer(player, choice); err != nil {
+					t.Fatalf("error registering answer for %s: %v", player, err)
+				}
+			}
+		}
+		if game.GameState == QuestionInProgress {
+			if _, err := game.NextState(); err != nil {
+				t.Fatalf("error showing results for question %d: %v", i, err)
+			}
+		}
+	}
+
+	for player, expected := range choices {
+		if fmt.Sprint(game.AnswerHistory[player]) != fmt.Sprint(expected) {
+			t.Errorf("expected the history of %s to be %v but got %v", player, expected, game.AnswerHistory[player])
+		}
+	}
+	copied := game.Copy()
+	copied.AnswerHistory["player1"][0] = 2
+	if game.AnswerHistory["player1"][0] != 0 {
+		t.Error("expected the copy to have its own answer history")
+	}
+
+	history := game.GetGameHistory()
+	if history.Questions != 3 || len(history.Players) != 2 {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if history.Players[0].Name != "alice" || fmt.Sprint(history.Players[1].Answers) != fmt.Sprint(choices["player2"]) {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
+
+func TestResultsColoring(t *testing.T) {
+	tests := []struct {
+		question        QuizQuestion
+		expectedIndices []int
+		expectedCorrect []bool
+	}{
+		{QuizQuestion{Question: "single", Answers: []string{"zero", "one", "two"}, Correct: 1}, []int{1}, []bool{false, true, false}},
+		{QuizQuestion{Question: "multi-select", Answers: []string{"zero", "one", "two"}, CorrectAnswers: []int{0, 2}}, []int{0, 2}, []bool{true, false, true}},
+		{QuizQuestion{Question: "ordering", Type: QuestionTypeOrdering, Answers: []string{"zero", "one", "two"}}, []int{}, []bool{false, false, false}},
+	}
+
+	for _, test := range tests {
+		game := Game{
+			Pin:     1,
+			Players: map[string]int{"player1": 0},
+			Quiz:    Quiz{QuestionDuration: 20, Questions: []QuizQuestion{test.question}},
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		results, err := game.GetQuestionResults()
+		if err != nil {
+			t.Fatalf("error getting results: %v", err)
+		}
+		if fmt.Sprint(results.CorrectIndices) != fmt.Sprint(test.expectedIndices) {
+			t.Errorf("expected correct indices %v for the %s question but got %v", test.expectedIndices, test.question.Question, results.CorrectIndices)
+		}
+		if fmt.Sprint(results.AnswerCorrect) != fmt.Sprint(test.expectedCorrect) {
+			t.Errorf("expected coloring %v for the %s question but got %v", test.expectedCorrect, test.question.Question, results.AnswerCorrect)
+		}
+		if results.Correct != test.question.Correct {
+			t.Errorf("expected Correct to remain %d but got %d", test.question.Correct, results.Correct)
+		}
+	}
+}
+
+func TestWrongAnswerPenalty(t *testing.T) {
+	tests := []struct {
+		name          string
+		quiz          Quiz
+		startingScore int
+		answer        int // -1 to let the question time out
+		expectedScore int
+	}{
+		{"correct", Quiz{PenalizeWrong: true, ScoringMode: ScoringFlat}, 0, 1, 100},
+		{"wrong with default penalty", Quiz{PenalizeWrong: true}, 200, 0, 150},
+		{"wrong with penalty", Quiz{PenalizeWrong: true, WrongPenalty: 30}, 200, 0, 170},
+		{"clamped", Quiz{PenalizeWrong: true, WrongPenalty: 30}, 10, 0, 0},
+		{"allow negative", Quiz{PenalizeWrong: true, WrongPenalty: 30, AllowNegative: true}, 10, 0, -20},
+		{"timeout", Quiz{PenalizeWrong: true, WrongPenalty: 30}, 200, -1, 200},
+		{"not penalized", Quiz{}, 200, 0, 200},
+	}
+
+	for _, test := range tests {
+		quiz := test.quiz
+		quiz.QuestionDuration = 20
+		quiz.Questions = []QuizQuestion{{Question: "question 0", Answers: []string{"zero", "one"}, Correct: 1}}
+		game := Game{
+			Pin:     1,
+			Players: map[string]int{"player1": test.startingScore, "player2": 0},
+			Quiz:    quiz,
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		if test.answer >= 0 {
+			if _, _, err := game.RegisterAnswer("player1", test.answer); err != nil {
+				t.Fatalf("error registering answer for the %s test: %v", test.name, err)
+			}
+		} else if _, err := game.NextState(); err != nil {
+			// the question ends without an answer from player1
+			t.Fatalf("error ending question: %v", err)
+		}
+		if score := game.Players["player1"]; score != test.expectedScore {
+			t.Errorf("expected a score of %d for the %s test but got %d", test.expectedScore, test.name, score)
+		}
+	}
+}
+
+func TestChangeAnswer(t *testing.T) {
+	newGame := func() Game {
+		game := Game{
+			Pin:     1,
+			Players: map[string]int{"player1": 0, "player2": 0},
+			Quiz: Quiz{
+				QuestionDuration:  20,
+				AllowAnswerChange: true,
+				ScoringMode:       ScoringFlat,
+				Questions:         []QuizQuestion{{Question: "question 0", Answers: []string{"zero", "one", "two"}, Correct: 1}},
+			},
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		// player1 answered the 2 previous questions correctly
+		game.Streaks = map[string]int{"player1": 2}
+		return game
+	}
+	check := func(name string, game Game, expectedScore int, expectedVotes []int, expectedCorrect bool, expectedStreak int) {
+		if score := game.Players["player1"]; score != expectedScore {
+			t.Errorf("%s: expected a score of %d but got %d", name, expectedScore, score)
+		}
+		if fmt.Sprint(game.Votes) != fmt.Sprint(expectedVotes) {
+			t.Errorf("%s: expected votes %v but got %v", name, expectedVotes, game.Votes)
+		}
+		if _, correct := game.CorrectPlayers["player1"]; correct != expectedCorrect {
+			t.Errorf("%s: expected correct to be %v", name, expectedCorrect)
+		}
+		if game.Streaks["player1"] != expectedStreak {
+			t.Errorf("%s: expected a streak of %d but got %d", name, expectedStreak, game.Streaks["player1"])
+		}
+		if len(game.AnswerLog["player1"]) != 1 || len(game.PlayersAnswered) != 1 || game.GameState != QuestionInProgress {
+			t.Errorf("%s: expected a single live answer but got %+v", name, game.AnswerLog["player1"])
+		}
+	}
+
+	// wrong to right - the streak bonus for the 2 earlier correct answers is
+	// awarded once the answer is right
+	game := newGame()
+	game.RegisterAnswer("player1", 0)
+	check("wrong", game, 0, []int{1, 0, 0}, false, 0)
+	game.RegisterAnswer("player1", 1)
+	check("wrong to right", game, 100+2*streakBonus, []int{0, 1, 0}, true, 3)
+
+	// right to wrong
+	game = newGame()
+	game.RegisterAnswer("player1", 1)
+	check("right", game, 100+2*streakBonus, []int{0, 1, 0}, true, 3)
+	game.RegisterAnswer("player1", 2)
+	check("right to wrong", game, 0, []int{0, 0, 1}, false, 0)
+
+	// the question still ends once every player has answered
+	game.RegisterAnswer("player2", 1)
+	if game.GameState != ShowResults {
+		t.Errorf("expected the question to end once all players answered but got state %d", game.GameState)
+	}
+
+	// answers cannot be changed unless the quiz allows it
+	game = newGame()
+	game.Quiz.AllowAnswerChange = false
+	game.RegisterAnswer("player1", 0)
+	game.RegisterAnswer("player1", 1)
+	check("locked", game, 0, []int{1, 0, 0}, false, 0)
+}
+
+func TestPublicViewWithholdsAnswer(t *testing.T) {
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0},
+		PlayerNames: map[string]string{"player1": "player1", "player2": "player2"},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			Questions: []QuizQuestion{
+				{Question: "question 0", Answers: []string{"zero", "one", "two"}, Correct: 1},
+			},
+		},
+	}
+	if view := game.PublicView(); view.Question != "" || view.CorrectIndices != nil {
+		t.Errorf("expected the lobby view to have no question but got %+v", view)
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	if _, _, err := game.RegisterAnswer("player1", 1); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	view := game.PublicView()
+	if view.Question != "question 0" || len(view.Answers) != 3 {
+		t.Errorf("expected the live question to be shown but got %+v", view)
+	}
+	if view.CorrectIndices != nil || view.Votes != nil {
+		t.Errorf("expected the live question to withhold the answer but got %+v", view)
+	}
+	encoded, err := json.Marshal(&view)
+	if err != nil {
+		t.Fatalf("error encoding view: %v", err)
+	}
+	if strings.Contains(string(encoded), "correct") || strings.Contains(string(encoded), "votes") {
+		t.Errorf("expected the encoded view to leave out the answer but got %s", encoded)
+	}
+
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	view = game.PublicView()
+	if fmt.Sprint(view.CorrectIndices) != "[1]" || fmt.Sprint(view.Votes) != "[0 1 0]" {
+		t.Errorf("expected the results to include the answer but got %+v", view)
+	}
+}
+
+func TestGameSummary(t *testing.T) {
+	questions := []QuizQuestion{}
+	for i := 0; i < 2; i++ {
+		questions = append(questions, QuizQuestion{
+			Question: fmt.Sprintf("question %d", i),
+			Answers:  []string{"zero", "one", "two"},
+			Correct:  1,
+		})
+	}
+	game := Game{
+		Pin:         1,
+		Players:     map[string]int{"player1": 0, "player2": 0, "player3": 0},
+		PlayerNames: map[string]string{"player1": "carol", "player2": "bob", "player3": "alice"},
+		Quiz:        Quiz{Name: "summary quiz", QuestionDuration: 20, Questions: questions},
+	}
+
+	// carol gets both right, alice and bob get one each, bob skips the
+	// second question
+	choices := map[string][]int{
+		"player1": {1, 1},
+		"player2": {1, -1},
+		"player3": {0, 1},
+	}
+	for i := range questions {
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting question %d: %v", i, err)
+		}
+		for player, answers := range choices {
+			if answers[i] >= 0 {
+				if _, _, err := game.RegisterAnswer(player, answers[i]); err != nil {
+					t.Fatalf("error registering answer for %s: %v", player, err)
+				}
+			}
+		}
+		if game.GameState == QuestionInProgress {
+			if _, err := game.NextState(); err != nil {
+				t.Fatalf("error showing results for question %d: %v", i, err)
+			}
+		}
+	}
+	if state, err := game.NextState(); err != nil || state != GameEnded {
+		t.Fatalf("expected the game to end but got state %d (%v)", state, err)
+	}
+	// give alice and bob the same score so that they share a rank
+	game.Players["player2"] = 100
+	game.Players["player3"] = 100
+
+	summary := game.GetGameSummary()
+	if summary.Pin != 1 || summary.QuizName != "summary quiz" || summary.TotalQuestions != 2 {
+		t.Errorf("unexpected summary header %+v", summary)
+	}
+	players := []string{}
+	for _, player := range summary.Players {
+		players = append(players, fmt.Sprintf("%d:%s:%d:%d/%d", player.Rank, player.Name, player.Score, player.Correct, player.Answered))
+	}
+	if expected := "[1:carol:" + fmt.Sprint(game.Players["player1"]) + ":2/2 2:alice:100:1/2 2:bob:100:1/1]"; fmt.Sprint(players) != expected {
+		t.Errorf("expected players %s but got %v", expected, players)
+	}
+	if len(summary.Questions) != 2 {
+		t.Fatalf("expected a breakdown of 2 questions but got %+v", summary.Questions)
+	}
+	if fmt.Sprint(summary.Questions[0].Votes) != "[1 2 0]" || fmt.Sprint(summary.Questions[1].Votes) != "[0 2 0]" {
+		t.Errorf("unexpected vote distributions %v and %v", summary.Questions[0].Votes, summary.Questions[1].Votes)
+	}
+	if summary.Questions[1].Question != "question 1" || len(summary.Questions[1].Answers) != 3 {
+		t.Errorf("unexpected question %+v", summary.Questions[1])
+	}
+}
+
+func TestFiftyFifty(t *testing.T) {
+	for _, shufflePerPlayer := range []bool{false, true} {
+		game := Game{
+			Pin:         1,
+			Players:     map[string]int{"player1": 0, "player2": 0},
+			PlayerNames: map[string]string{"player1": "player1", "player2": "player2"},
+			Quiz: Quiz{
+				QuestionDuration: 20,
+				FiftyFifty:       true,
+				ShufflePerPlayer: shufflePerPlayer,
+				Questions: []QuizQuestion{
+					{Question: "question 0", Answers: []string{"zero", "one", "two", "three"}, Correct: 2},
+					{Question: "question 1", Answers: []string{"zero", "one", "two", "three"}, Correct: 0},
+				},
+			},
+		}
+		if _, err := game.UseFiftyFifty("player1"); err == nil {
+			t.Error("expected an error using the lifeline before the game started")
+		}
+		if _, err := game.NextState(); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+
+		removed, err := game.UseFiftyFifty("player1")
+		if err != nil {
+			t.Fatalf("error using lifeline: %v", err)
+		}
+		if len(removed) != 2 || removed[0] == removed[1] {
+			t.Fatalf("expected two different answers to be removed but got %v", removed)
+		}
+		answers, _ := game.PlayerAnswers("player1")
+		for _, position := range removed {
+			if answers[position] == "two" {
+				t.Errorf("expected only wrong answers to be removed but got %v from %v", removed, answers)
+			}
+		}
+		if fmt.Sprint(game.RemovedAnswers("player1")) != fmt.Sprint(removed) {
+			t.Errorf("expected the removed answers to be stable but got %v and %v", removed, game.RemovedAnswers("player1"))
+		}
+		if game.RemovedAnswers("player2") != nil {
+			t.Error("expected the lifeline to only affect the player that used it")
+		}
+
+		// the lifeline can only be used once per game
+		if _, err := game.UseFiftyFifty("player1"); err == nil {
+			t.Error("expected an error using the lifeline twice")
+		}
+		// answers are still registered against the full set of answers
+		if _, _, err := game.RegisterAnswer("player1", 3); err != nil {
+			t.Errorf("expected the last answer to still be accepted: %v", err)
+		}
+		if _, _, err := game.RegisterAnswer("player2", 4); err == nil {
+			t.Error("expected an answer outside of the full set to be rejected")
+		}
+
+		for game.GameState != QuestionInProgress || game.QuestionIndex != 1 {
+			if _, err := game.NextState(); err != nil {
+				t.Fatalf("error moving to the next question: %v", err)
+			}
+		}
+		if game.RemovedAnswers("player1") != nil || game.FiftyFiftyAvailable("player1") {
+			t.Error("expected the used lifeline to be gone on the next question")
+		}
+		if _, err := game.UseFiftyFifty("player1"); err == nil {
+			t.Error("expected an error using the lifeline on a later question")
+		}
+		if !game.FiftyFiftyAvailable("player2") {
+			t.Error("expected player2 to still have the lifeline")
+		}
+	}
+
+	// true/false questions do not have enough wrong answers
+	game := Game{
+		Pin:     1,
+		Players: map[string]int{"player1": 0},
+		Quiz: Quiz{
+			QuestionDuration: 20,
+			FiftyFifty:       true,
+			Questions:        []QuizQuestion{{Question: "true or false", Type: QuestionTypeTrueFalse, Answers: []string{"true", "false"}}},
+		},
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	if _, err := game.UseFiftyFifty("player1"); err == nil {
+		t.Error("expected an error using the lifeline on a true/false question")
+	}
+}
+
+func TestConfiguredTopScorers(t *testing.T) {
+	tests := []struct {
+		topScorers int
+		players    int
+		expected   int
+	}{
+		{0, 8, 5},    // the default
+		{3, 8, 3},    // a podium
+		{3, 2, 2},    // fewer players than the podium
+		{10, 12, 10}, // a larger board
+		{10, 7, 7},
+		{100, 60, 50}, // capped
+	}
+
+	for _, test := range tests {
+		game := Game{
+			Pin:         1,
+			Players:     make(map[string]int),
+			PlayerNames: make(map[string]string),
+			Teams:       make(map[string]string),
+			Quiz:        Quiz{TopScorers: test.topScorers},
+		}
+		for i := 0; i < test.players; i++ {
+			sessionid := fmt.Sprintf("player%d", i)
+			game.Players[sessionid] = i * 10
+			game.PlayerNames[sessionid] = sessionid
+			game.Teams[sessionid] = fmt.Sprintf("team%d", i)
+		}
+
+		winners := game.GetWinners()
+		if len(winners) != test.expected {
+			t.Errorf("expected %d winners with TopScorers %d and %d players but got %d", test.expected, test.topScorers, test.players, len(winners))
+			continue
+		}
+		if winners[0].Score != (test.players-1)*10 {
+			t.Errorf("expected the highest score first but got %+v", winners[0])
+		}
+		if teams := game.GetTeamWinners(); len(teams) != test.expected {
+			t.Errorf("expected %d teams with TopScorers %d and %d teams but got %d", test.expected, test.topScorers, test.players, len(teams))
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 // --------------------
 // Client Hub Messages
 // --------------------
@@ -32,9 +34,10 @@ type ErrorToSessionMessage struct {
 }
 
 type BindGameToSessionMessage struct {
-	Sessionid string
-	Name      string
-	Pin       int
+	Sessionid   string
+	Name        string
+	Pin         int
+	Fingerprint string
 }
 
 type SetSessionScreenMessage struct {
@@ -73,9 +76,11 @@ type DeregisterClientMessage struct {
 // --------------------
 
 type AddPlayerToGameMessage struct {
-	Sessionid string
-	Name      string
-	Pin       int
+	Sessionid   string
+	Name        string
+	Pin         int
+	Fingerprint string // identifies the player's device - optional
+	Team        string // team the player plays for - optional
 }
 
 type SendGameMetadataMessage struct {
@@ -113,18 +118,29 @@ type RegisterAnswerMessage struct {
 	Sessionid string
 	Pin       int
 	Answer    int
+	Choices   []int // the player's ordering of the items for ordering questions or the selected answers for multi-select questions
+
+	QuestionIndex *int // question the player is answering - nil if the client did not send it
 }
 
-type CancelGameMessage struct {
+type FiftyFiftyMessage struct {
 	Clientid  uint64
 	Sessionid string
 	Pin       int
 }
 
-type HostGameLobbyMessage struct {
+type CancelGameMessage struct {
 	Clientid  uint64
 	Sessionid string
-	Quizid    int
+	Pin       int
+}
+
+type HostGameLobbyMessage struct {
+	Clientid         uint64
+	Sessionid        string
+	Quizid           int
+	QuestionDuration int    // overrides the quiz's question duration if set
+	Label            string // optional label to group the game with others
 }
 
 type SetQuizForGameMessage struct {
@@ -138,6 +154,25 @@ type StartGameMessage struct {
 	Pin       int
 }
 
+// Sent when the auto-start countdown for a game elapses
+type AutoStartGameMessage struct {
+	Pin int
+}
+
+// Sent periodically while the host lingers on the results of a question
+type HostWaitingMessage struct {
+	Pin           int
+	QuestionIndex int
+}
+
+// Sent when no answers have arrived for the idle window while a question is
+// in progress
+type QuestionIdleMessage struct {
+	Pin           int
+	QuestionIndex int
+	Generation    int
+}
+
 type ShowResultsMessage struct {
 	Clientid  uint64
 	Sessionid string
@@ -156,6 +191,101 @@ type NextQuestionMessage struct {
 	Pin       int
 }
 
+type BeginAnswersMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+// Sent when a player's client disconnects or reconnects
+type PlayerConnectionMessage struct {
+	Sessionid string
+	Pin       int
+	Connected bool
+}
+
+// Sent by the host to reveal the correct answer to the players after the
+// results are shown
+type EmphasizeAnswerMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type SkipQuestionMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type PauseGameMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type ResumeGameMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type RevealNextBarMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type ShuffleParticipantsMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type KickPlayerMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+	Player    string // name or session ID of the player to remove
+}
+
+// Sent when the host changes the shuffle settings in the lobby
+type SetShuffleMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+	Shuffle   ShuffleOptions
+}
+
+type PreviewQuizMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+}
+
+type FlagQuestionMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+	Reason    string
+}
+
+type SubmitQuestionMessage struct {
+	Clientid  uint64
+	Sessionid string
+	Pin       int
+	Question  QuizQuestion
+}
+
+// sent by the host to approve or reject a submitted question
+type ModerateSubmissionMessage struct {
+	Clientid   uint64
+	Sessionid  string
+	Pin        int
+	Submission int
+	Approve    bool
+}
+
 // used by frontend
 type DeleteGameMessage struct {
 	Clientid  uint64
@@ -163,13 +293,16 @@ type DeleteGameMessage struct {
 	Pin       int
 }
 
+// used by REST API
 type UpdateGameMessage struct {
-	Game
+	Game   Game
+	Result chan error
 }
 
 // used by REST API
 type DeleteGameByPin struct {
-	Pin int
+	Pin    int
+	Result chan error
 }
 
 // --------------------
@@ -212,6 +345,7 @@ type GetQuizResult struct {
 
 type AddQuizMessage struct {
 	Quiz   Quiz
+	Author string // admin user adding the quiz - may be empty
 	Result chan error
 }
 
@@ -220,6 +354,13 @@ type UpdateQuizMessage struct {
 	Result chan error
 }
 
+// restores quizzes from a backup - the quizzes keep their ids
+type RestoreQuizzesMessage struct {
+	Quizzes []Quiz
+	Replace bool // delete the quizzes that are not in the backup
+	Result  chan error
+}
+
 type GetSessionsMessage struct {
 	Result chan []Session
 }
@@ -229,6 +370,48 @@ type GetSessionMessage struct {
 	Result    chan *Session
 }
 
+// Sent by the session reaper to cancel games whose host has been
+// disconnected for longer than Grace
+type CancelAbandonedGamesMessage struct {
+	Grace time.Duration
+}
+
+type ReapSessionsMessage struct {
+	Result chan int // number of sessions reaped
+}
+
+type GetStoreStatusMessage struct {
+	Result chan StoreStatus
+}
+
+// Pushes an updated quiz into the games that are using it
+type PushQuizToGamesMessage struct {
+	Quiz   Quiz
+	Result chan []int // pins of the games that will use the update
+}
+
+type GetClientsMessage struct {
+	Result chan []ConnectedClient
+}
+
+// Fetches the number of connected websocket clients and their latency
+type GetConnectionStatsMessage struct {
+	Result chan ConnectionStats
+}
+
+type ConnectionStats struct {
+	Clients    int     `json:"clients"`
+	Measured   int     `json:"measured"`   // clients that have answered a ping - the round-trip times only cover these
+	AverageRTT float64 `json:"averagertt"` // milliseconds
+	P95RTT     float64 `json:"p95rtt"`     // milliseconds
+}
+
+// Force-disconnects a websocket client
+type DisconnectClientMessage struct {
+	Clientid uint64
+	Result   chan bool // false if the client is not connected
+}
+
 type GetGamesMessage struct {
 	Result chan []Game
 }
@@ -238,7 +421,71 @@ type GetGameMessage struct {
 	Result chan GetGameResult
 }
 
+type StoreStatus struct {
+	Persistent bool           `json:"persistent"`
+	Mode       string         `json:"mode"`           // redis or in-memory
+	Ping       string         `json:"ping,omitempty"` // PONG or the error returned when pinging redis
+	Keys       map[string]int `json:"keys"`           // number of keys with each prefix
+}
+
+// A websocket client and the session it is bound to
+type ConnectedClient struct {
+	Clientid  uint64 `json:"clientid"`
+	Sessionid string `json:"sessionid,omitempty"`
+	Screen    string `json:"screen,omitempty"`
+}
+
 type GetGameResult struct {
 	Game  Game
 	Error error
 }
+
+type GetGameStatusMessage struct {
+	Pin    int
+	Result chan GetGameStatusResult
+}
+
+type GetGameStatusResult struct {
+	Status GameStatus
+	Error  error
+}
+
+type GetPublicGameViewMessage struct {
+	Pin    int
+	Result chan GetPublicGameViewResult
+}
+
+type GetPublicGameViewResult struct {
+	View  PublicGameView
+	Error error
+}
+
+type GetPrintableQuestionMessage struct {
+	Pin    int
+	Result chan GetPrintableQuestionResult
+}
+
+type GetPrintableQuestionResult struct {
+	Question PrintableQuestion
+	Error    error
+}
+
+// Fetches the results of the current question without moving the game to
+// ShowResults
+type GetGameResultsMessage struct {
+	Pin    int
+	Result chan GetGameResultsResult
+}
+
+type GetGameResultsResult struct {
+	Results QuestionResults
+	Error   error
+}
+
+type GetStatsMessage struct {
+	Result chan []QuizStats
+}
+
+type GetQuestionFlagsMessage struct {
+	Result chan []QuestionFlag
+}
@@ -4,20 +4,153 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// questions must have at least this many answers to be imported
+const minAnswers = 2
+
+// points taken away for a wrong answer if the quiz penalizes wrong answers
+// without setting a penalty
+const defaultWrongPenalty = 50
+
+// number of players and teams listed as winners if the quiz does not set
+// TopScorers
+const defaultTopScorers = 5
+
+// keeps the winners payload small
+const maxTopScorers = 50
+
+const (
+	// Players choose one of the answers - questions without a type are
+	// multiple choice questions
+	QuestionTypeMultiple = "multiple"
+
+	// Players choose true or false - the question has exactly two answers
+	// that are always shown in the same order
+	QuestionTypeTrueFalse = "truefalse"
+
+	// Players put the answers of ordering questions in order - the answers
+	// are listed in the correct order
+	QuestionTypeOrdering = "ordering"
+
+	// Only the first player to answer a buzzer question correctly scores -
+	// the question ends as soon as someone gets it right
+	QuestionTypeBuzzer = "buzzer"
 )
 
 type QuizQuestion struct {
-	Question string   `json:"question"`
-	Answers  []string `json:"answers"`
-	Correct  int      `json:"correct"`
+	Question           string   `json:"question" yaml:"question"`
+	Answers            []string `json:"answers" yaml:"answers"`
+	Correct            int      `json:"correct" yaml:"correct"`
+	Preload            bool     `json:"preload" yaml:"preload,omitempty"`                                 // clients need time to load media before answers begin
+	Difficulty         int      `json:"difficulty" yaml:"difficulty,omitempty"`                           // weights scores in the difficulty-weighted leaderboard - treated as 1 if not set
+	OriginalIndices    []int    `json:"originalIndices,omitempty" yaml:"originalIndices,omitempty"`       // position of each answer before the answers were shuffled
+	ChoiceExplanations []string `json:"choiceExplanations,omitempty" yaml:"choiceExplanations,omitempty"` // why each answer is wrong - shown to players that chose it
+	Type               string   `json:"type,omitempty" yaml:"type,omitempty"`                             // "truefalse", "ordering" or "buzzer" - blank or "multiple" for multiple choice questions
+	CorrectAnswers     []int    `json:"correctAnswers,omitempty" yaml:"correctAnswers,omitempty"`         // makes this a multi-select question - players must select exactly these answers
+	Duration           int      `json:"duration,omitempty" yaml:"duration,omitempty"`                     // seconds to answer this question - overrides the quiz's question duration if set
 }
 
 func (q QuizQuestion) NumAnswers() int {
 	return len(q.Answers)
 }
 
+// Degenerate questions with fewer than the minimum number of answers may
+// still be loaded from the persistent store - they are treated as
+// informational and are not scored.
+func (q QuizQuestion) IsInformational() bool {
+	return q.NumAnswers() < minAnswers
+}
+
+func (q QuizQuestion) IsOrdering() bool {
+	return q.Type == QuestionTypeOrdering
+}
+
+func (q QuizQuestion) IsTrueFalse() bool {
+	return q.Type == QuestionTypeTrueFalse
+}
+
+func (q QuizQuestion) IsBuzzer() bool {
+	return q.Type == QuestionTypeBuzzer
+}
+
+// Multi-select questions have more than one correct answer - Correct is
+// ignored
+func (q QuizQuestion) IsMultiSelect() bool {
+	return len(q.CorrectAnswers) > 0
+}
+
+func (q QuizQuestion) IsCorrectAnswer(i int) bool {
+	if !q.IsMultiSelect() {
+		return i == q.Correct
+	}
+	for _, correct := range q.CorrectAnswers {
+		if correct == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the chosen answers are exactly the correct answers - a
+// single answer for multiple choice questions or the set of correct answers
+// for multi-select questions
+func (q QuizQuestion) IsCorrectChoice(choices []int) bool {
+	if !q.IsMultiSelect() {
+		return len(choices) == 1 && choices[0] == q.Correct
+	}
+	if len(choices) != len(q.CorrectAnswers) {
+		return false
+	}
+	for _, choice := range choices {
+		if !q.IsCorrectAnswer(choice) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q QuizQuestion) Validate() error {
+	if q.NumAnswers() < minAnswers {
+		return fmt.Errorf("question \"%s\" has %d answer(s) - at least %d are required", q.Question, q.NumAnswers(), minAnswers)
+	}
+	switch q.Type {
+	case "", QuestionTypeMultiple, QuestionTypeOrdering, QuestionTypeBuzzer:
+	case QuestionTypeTrueFalse:
+		if q.NumAnswers() != 2 {
+			return fmt.Errorf("true/false question \"%s\" has %d answers - exactly 2 are required", q.Question, q.NumAnswers())
+		}
+	default:
+		return fmt.Errorf("question \"%s\" has unknown type \"%s\"", q.Question, q.Type)
+	}
+	if q.Correct < 0 || q.Correct >= q.NumAnswers() {
+		return fmt.Errorf("question \"%s\" has %d answers but the correct answer is %d", q.Question, q.NumAnswers(), q.Correct)
+	}
+	if q.IsMultiSelect() {
+		if q.IsOrdering() || q.IsTrueFalse() || q.IsBuzzer() {
+			return fmt.Errorf("%s question \"%s\" cannot have multiple correct answers", q.Type, q.Question)
+		}
+		if !isSelection(q.CorrectAnswers, q.NumAnswers()) {
+			return fmt.Errorf("question \"%s\" has %d answers but the correct answers are %v", q.Question, q.NumAnswers(), q.CorrectAnswers)
+		}
+	}
+	if q.Duration < 0 {
+		return fmt.Errorf("question \"%s\" has negative duration %d", q.Question, q.Duration)
+	}
+	if len(q.ChoiceExplanations) > q.NumAnswers() {
+		return fmt.Errorf("question \"%s\" has %d answers but %d choice explanations", q.Question, q.NumAnswers(), len(q.ChoiceExplanations))
+	}
+	return nil
+}
+
 func (q QuizQuestion) ShuffleAnswers() QuizQuestion {
 	places := []int{}
 	for i := 0; i < len(q.Answers); i++ {
@@ -32,26 +165,158 @@ func (q QuizQuestion) ShuffleAnswers() QuizQuestion {
 	}
 
 	q.Correct = newIndex[q.Correct]
+	if q.IsMultiSelect() {
+		correctAnswers := make([]int, len(q.CorrectAnswers))
+		for i, correct := range q.CorrectAnswers {
+			correctAnswers[i] = newIndex[correct]
+		}
+		q.CorrectAnswers = correctAnswers
+	}
 	newAnswers := make([]string, len(q.Answers))
+	originalIndices := make([]int, len(q.Answers))
 	for i, answer := range q.Answers {
 		newAnswers[newIndex[i]] = answer
+		originalIndices[newIndex[i]] = q.OriginalIndex(i)
 	}
 	q.Answers = newAnswers
+	q.OriginalIndices = originalIndices
+	if len(q.ChoiceExplanations) > 0 {
+		newExplanations := make([]string, len(q.Answers))
+		for i := range q.Answers {
+			newExplanations[newIndex[i]] = q.ChoiceExplanation(i)
+		}
+		q.ChoiceExplanations = newExplanations
+	}
 	return q
 }
 
+// Returns the explanation of why the answer at index i is wrong - blank if
+// there is none
+func (q QuizQuestion) ChoiceExplanation(i int) string {
+	if i < 0 || i >= len(q.ChoiceExplanations) {
+		return ""
+	}
+	return q.ChoiceExplanations[i]
+}
+
+func (q QuizQuestion) Weight() int {
+	if q.Difficulty <= 0 {
+		return 1
+	}
+	return q.Difficulty
+}
+
+// Returns the position of the answer at index i before the answers were
+// shuffled
+func (q QuizQuestion) OriginalIndex(i int) int {
+	if i < 0 || i >= len(q.OriginalIndices) {
+		return i
+	}
+	return q.OriginalIndices[i]
+}
+
+// Returns the text of the answers at the given indices separated by commas
+func (q QuizQuestion) joinAnswers(indices []int) string {
+	answers := []string{}
+	for _, i := range indices {
+		if i >= 0 && i < q.NumAnswers() {
+			answers = append(answers, q.Answers[i])
+		}
+	}
+	return strings.Join(answers, ", ")
+}
+
+// Returns true if selection contains at least one of 0 to n-1 and no index
+// more than once
+func isSelection(selection []int, n int) bool {
+	if len(selection) == 0 {
+		return false
+	}
+	seen := make([]bool, n)
+	for _, index := range selection {
+		if index < 0 || index >= n || seen[index] {
+			return false
+		}
+		seen[index] = true
+	}
+	return true
+}
+
+// Identifies the question across games by its text and answers
+func (q QuizQuestion) Hash() string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s", q.Question, strings.Join(q.Answers, "\x00"))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
 func (q QuizQuestion) String() string {
 	s, _ := ConvertToJSON(q)
 	return s
 }
 
 type Quiz struct {
-	Id               int            `json:"id"`
-	Name             string         `json:"name"`
-	QuestionDuration int            `json:"questionDuration"`
-	ShuffleQuestions bool           `json:"shuffleQuestions"`
-	ShuffleAnswers   bool           `json:"shuffleAnswers"`
-	Questions        []QuizQuestion `json:"questions"`
+	Id                  int            `json:"id" yaml:"id"`
+	Name                string         `json:"name" yaml:"name"`
+	QuestionDuration    int            `json:"questionDuration" yaml:"questionDuration"`
+	ShuffleQuestions    bool           `json:"shuffleQuestions" yaml:"shuffleQuestions,omitempty"`
+	ShuffleAnswers      bool           `json:"shuffleAnswers" yaml:"shuffleAnswers,omitempty"`
+	ShufflePerPlayer    bool           `json:"shufflePerPlayer" yaml:"shufflePerPlayer,omitempty"`       // each player sees the answers in a different order
+	PreloadDelay        int            `json:"preloadDelay" yaml:"preloadDelay,omitempty"`               // seconds before answers begin for preload questions unless the host begins them sooner - 0 disables preloading
+	WeightByDifficulty  bool           `json:"weightByDifficulty" yaml:"weightByDifficulty,omitempty"`   // rank the final leaderboard by difficulty-weighted scores
+	RevealOneAtATime    bool           `json:"revealOneAtATime" yaml:"revealOneAtATime,omitempty"`       // the host reveals the vote bars in the results one at a time
+	LiveCorrectCount    bool           `json:"liveCorrectCount" yaml:"liveCorrectCount,omitempty"`       // show the host how many players answered correctly while the question is live - this may spoil the reveal
+	ExcludeDisconnected bool           `json:"excludeDisconnected" yaml:"excludeDisconnected,omitempty"` // leave players that are disconnected out of the winners - they are included by default
+	AutoStartPlayers    int            `json:"autoStartPlayers" yaml:"autoStartPlayers,omitempty"`       // start the game without the host once this many players have joined - 0 disables auto-start
+	AutoStartDelay      int            `json:"autoStartDelay" yaml:"autoStartDelay,omitempty"`           // seconds to wait before auto-starting the game
+	PracticeMode        bool           `json:"practiceMode" yaml:"practiceMode,omitempty"`               // players may retry wrong answers
+	MaxAttempts         int            `json:"maxAttempts" yaml:"maxAttempts,omitempty"`                 // maximum attempts per question in practice mode - 0 allows unlimited attempts
+	AcceptSubmissions   bool           `json:"acceptSubmissions" yaml:"acceptSubmissions,omitempty"`     // players may submit questions in the lobby - the host approves them before they are added to the game
+	PickQuestions       int            `json:"pickQuestions" yaml:"pickQuestions,omitempty"`             // ask this many questions picked from the quiz at random - 0 asks all the questions
+	ScoringMode         string         `json:"scoringMode" yaml:"scoringMode,omitempty"`                 // linear (the default), flat or exponential
+	PenalizeWrong       bool           `json:"penalizeWrong" yaml:"penalizeWrong,omitempty"`             // wrong answers cost points - players that do not answer are not penalized
+	WrongPenalty        int            `json:"wrongPenalty" yaml:"wrongPenalty,omitempty"`               // points taken away for a wrong answer - 0 uses the default penalty
+	AllowNegative       bool           `json:"allowNegative" yaml:"allowNegative,omitempty"`             // penalties may take scores below 0
+	AllowAnswerChange   bool           `json:"allowAnswerChange" yaml:"allowAnswerChange,omitempty"`     // players may change their answer while the question is live - ignored in practice mode
+	FiftyFifty          bool           `json:"fiftyFifty" yaml:"fiftyFifty,omitempty"`                   // each player may remove two wrong answers from one question per game
+	TopScorers          int            `json:"topScorers" yaml:"topScorers,omitempty"`                   // number of players and teams listed as winners - 0 lists the default of 5
+	Category            string         `json:"category" yaml:"category,omitempty"`                       // groups quizzes in large libraries - e.g. "Science"
+	Tags                []string       `json:"tags" yaml:"tags,omitempty"`
+	CreatedBy           string         `json:"createdBy" yaml:"createdBy,omitempty"` // admin user that added the quiz
+	CreatedAt           time.Time      `json:"createdAt" yaml:"createdAt,omitempty"`
+	UpdatedAt           time.Time      `json:"updatedAt" yaml:"updatedAt,omitempty"`
+	Questions           []QuizQuestion `json:"questions" yaml:"questions"`
+}
+
+func (q *Quiz) HasTag(tag string) bool {
+	for _, t := range q.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the points taken away for a wrong answer - 0 if wrong answers are
+// not penalized
+func (q Quiz) Penalty() int {
+	if !q.PenalizeWrong {
+		return 0
+	}
+	if q.WrongPenalty > 0 {
+		return q.WrongPenalty
+	}
+	return defaultWrongPenalty
+}
+
+// Returns the number of players and teams listed as winners
+func (q Quiz) WinnerCount() int {
+	if q.TopScorers <= 0 {
+		return defaultTopScorers
+	}
+	if q.TopScorers > maxTopScorers {
+		return maxTopScorers
+	}
+	return q.TopScorers
 }
 
 // Shuffle questions
@@ -70,6 +335,74 @@ func (q *Quiz) Shuffle() {
 	q.Questions = shuffled
 }
 
+// Returns true if the answers to the question are shuffled when the quiz is
+// set up for a game - the items in ordering questions are always scrambled
+// and true/false questions keep their fixed order
+func (q Quiz) ShufflesAnswers(question QuizQuestion) bool {
+	if question.IsOrdering() {
+		return true
+	}
+	return q.ShuffleAnswers && !question.IsTrueFalse()
+}
+
+// Keeps n of the questions picked at random, in their original order.
+// Questions whose hashes are in avoid are only picked if there are not
+// enough other questions.
+func (q *Quiz) Pick(n int, avoid map[string]struct{}) {
+	if n <= 0 || n >= len(q.Questions) {
+		return
+	}
+	fresh := []int{}
+	used := []int{}
+	for i, question := range q.Questions {
+		if _, ok := avoid[question.Hash()]; ok {
+			used = append(used, i)
+			continue
+		}
+		fresh = append(fresh, i)
+	}
+	rand.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+	rand.Shuffle(len(used), func(i, j int) { used[i], used[j] = used[j], used[i] })
+
+	picked := append(fresh, used...)[:n]
+	sort.Ints(picked)
+	questions := make([]QuizQuestion, n)
+	for i, index := range picked {
+		questions[i] = q.Questions[index]
+	}
+	q.Questions = questions
+}
+
+// Returns the number of seconds players have to answer the question at index
+// i - the question's own duration if it has one, otherwise the quiz's
+// question duration
+func (q Quiz) QuestionDurationAt(i int) int {
+	if i >= 0 && i < len(q.Questions) && q.Questions[i].Duration > 0 {
+		return q.Questions[i].Duration
+	}
+	return q.QuestionDuration
+}
+
+// Returns the number of seconds players have to answer all the questions
+func (q Quiz) TotalDuration() int {
+	total := 0
+	for i := range q.Questions {
+		total += q.QuestionDurationAt(i)
+	}
+	return total
+}
+
+// Returns the number of seconds a game of the quiz is expected to take - if
+// only some of the questions are picked for each game, the estimate is based
+// on the average question duration
+func (q Quiz) EstimatedDuration() int {
+	total := q.TotalDuration()
+	if n := q.NumQuestions(); q.PickQuestions > 0 && q.PickQuestions < n {
+		return total * q.PickQuestions / n
+	}
+	return total
+}
+
 func (q Quiz) NumQuestions() int {
 	return len(q.Questions)
 }
@@ -81,6 +414,21 @@ func (q Quiz) GetQuestion(i int) (QuizQuestion, error) {
 	return q.Questions[i], nil
 }
 
+func (q Quiz) Validate() error {
+	if !validScoringMode(q.ScoringMode) {
+		return fmt.Errorf("quiz \"%s\" has unknown scoring mode \"%s\"", q.Name, q.ScoringMode)
+	}
+	if q.TopScorers < 0 || q.TopScorers > maxTopScorers {
+		return fmt.Errorf("quiz \"%s\" lists %d top scorers - it must list between 0 and %d", q.Name, q.TopScorers, maxTopScorers)
+	}
+	for i, question := range q.Questions {
+		if err := question.Validate(); err != nil {
+			return fmt.Errorf("invalid question %d in quiz \"%s\": %v", i, q.Name, err)
+		}
+	}
+	return nil
+}
+
 func (q Quiz) Marshal() ([]byte, error) {
 	var b bytes.Buffer
 	enc := json.NewEncoder(&b)
@@ -90,6 +438,34 @@ func (q Quiz) Marshal() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// Converts the quiz to YAML for hand-editing
+func (q Quiz) MarshalToYAML() ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(q); err != nil {
+		return nil, fmt.Errorf("error converting quiz to YAML: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("error converting quiz to YAML: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// Ingests a single Quiz object in YAML
+func UnmarshalQuizYAML(r io.Reader) (Quiz, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var quiz Quiz
+	if err := dec.Decode(&quiz); err != nil {
+		return Quiz{}, err
+	}
+	if err := quiz.Validate(); err != nil {
+		return Quiz{}, err
+	}
+	return quiz, nil
+}
+
 // Ingests a single Quiz object in JSON
 func UnmarshalQuiz(r io.Reader) (Quiz, error) {
 	dec := json.NewDecoder(r)
@@ -97,6 +473,9 @@ func UnmarshalQuiz(r io.Reader) (Quiz, error) {
 	if err := dec.Decode(&quiz); err != nil {
 		return Quiz{}, err
 	}
+	if err := quiz.Validate(); err != nil {
+		return Quiz{}, err
+	}
 	return quiz, nil
 }
 
@@ -107,5 +486,28 @@ func UnmarshalQuizzes(r io.Reader) ([]Quiz, error) {
 	if err := dec.Decode(&quizzes); err != nil {
 		return nil, err
 	}
+	for _, quiz := range quizzes {
+		if err := quiz.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	return quizzes, nil
 }
+
+// Play statistics for a single quiz
+type QuizStats struct {
+	Quizid  int `json:"quizid"`
+	Plays   int `json:"plays"`   // number of games started with this quiz
+	Players int `json:"players"` // total number of players served
+}
+
+// A player's report that a question is problematic - kept after the game is
+// deleted so that the quiz can be fixed
+type QuestionFlag struct {
+	Quizid        int       `json:"quizid"`
+	Pin           int       `json:"pin"`
+	QuestionIndex int       `json:"questionindex"`
+	Question      string    `json:"question"`
+	Reason        string    `json:"reason"`
+	Time          time.Time `json:"time"`
+}
@@ -1,7 +1,10 @@
 package common
 
 import (
+	"bytes"
+	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,3 +51,255 @@ func TestShufflAnswers(t *testing.T) {
 		}
 	}
 }
+
+func TestUnmarshalQuizRejectsSingleAnswer(t *testing.T) {
+	tests := []struct {
+		json        string
+		expectError bool
+	}{
+		{`{"name":"valid","questions":[{"question":"q","answers":["a","b"],"correct":0}]}`, false},
+		{`{"name":"single","questions":[{"question":"q","answers":["a"],"correct":0}]}`, true},
+		{`{"name":"none","questions":[{"question":"q","answers":[],"correct":0}]}`, true},
+		{`{"name":"mixed","questions":[{"question":"q","answers":["a","b"],"correct":1},{"question":"q","answers":["a","b","c"],"correct":2}]}`, false},
+		{`{"name":"correct out of range","questions":[{"question":"q","answers":["a","b"],"correct":2}]}`, true},
+		{`{"name":"negative correct","questions":[{"question":"q","answers":["a","b"],"correct":-1}]}`, true},
+		{`{"name":"explanations","questions":[{"question":"q","answers":["a","b"],"correct":0,"choiceExplanations":["","b is wrong"]}]}`, false},
+		{`{"name":"ordering","questions":[{"type":"ordering","question":"q","answers":["a","b","c"]}]}`, false},
+		{`{"name":"unknown type","questions":[{"type":"essay","question":"q","answers":["a","b"],"correct":0}]}`, true},
+		{`{"name":"multiple","questions":[{"type":"multiple","question":"q","answers":["a","b","c"],"correct":2}]}`, false},
+		{`{"name":"truefalse","questions":[{"type":"truefalse","question":"q","answers":["True","False"],"correct":1}]}`, false},
+		{`{"name":"truefalse with 3 answers","questions":[{"type":"truefalse","question":"q","answers":["True","False","Maybe"],"correct":0}]}`, true},
+		{`{"name":"multi-select","questions":[{"question":"q","answers":["a","b","c"],"correctAnswers":[0,2]}]}`, false},
+		{`{"name":"multi-select out of range","questions":[{"question":"q","answers":["a","b","c"],"correctAnswers":[0,3]}]}`, true},
+		{`{"name":"multi-select repeated","questions":[{"question":"q","answers":["a","b","c"],"correctAnswers":[1,1]}]}`, true},
+		{`{"name":"multi-select ordering","questions":[{"type":"ordering","question":"q","answers":["a","b","c"],"correctAnswers":[0,1]}]}`, true},
+		{`{"name":"buzzer","questions":[{"type":"buzzer","question":"q","answers":["a","b","c"],"correct":1}]}`, false},
+		{`{"name":"multi-select buzzer","questions":[{"type":"buzzer","question":"q","answers":["a","b","c"],"correctAnswers":[0,1]}]}`, true},
+		{`{"name":"truefalse correct out of range","questions":[{"type":"truefalse","question":"q","answers":["True","False"],"correct":2}]}`, true},
+		{`{"name":"too many explanations","questions":[{"question":"q","answers":["a","b"],"correct":0,"choiceExplanations":["","b","c"]}]}`, true},
+	}
+
+	for testIndex, test := range tests {
+		_, err := UnmarshalQuiz(strings.NewReader(test.json))
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error value %v for test index %d", err, testIndex)
+		}
+	}
+
+	bulk := `[{"name":"valid","questions":[{"question":"q","answers":["a","b"],"correct":0}]},{"name":"single","questions":[{"question":"q","answers":["a"],"correct":0}]}]`
+	if _, err := UnmarshalQuizzes(strings.NewReader(bulk)); err == nil {
+		t.Error("expected bulk import with a single-answer question to be rejected")
+	}
+}
+
+func TestShuffleAnswersOriginalIndices(t *testing.T) {
+	original := QuizQuestion{
+		Question: "question",
+		Answers:  []string{"zero", "one", "two", "three", "four", "five"},
+		Correct:  4,
+	}
+
+	if original.OriginalIndex(3) != 3 {
+		t.Errorf("expected unshuffled question to map to itself")
+	}
+
+	// shuffling twice should still map back to the original positions
+	shuffled := original.ShuffleAnswers().ShuffleAnswers()
+	if len(shuffled.OriginalIndices) != len(original.Answers) {
+		t.Fatalf("expected %d original indices but got %d", len(original.Answers), len(shuffled.OriginalIndices))
+	}
+	for i, answer := range shuffled.Answers {
+		if original.Answers[shuffled.OriginalIndex(i)] != answer {
+			t.Errorf("answer %s at shuffled index %d maps to original answer %s", answer, i, original.Answers[shuffled.OriginalIndex(i)])
+		}
+	}
+	if shuffled.OriginalIndex(shuffled.Correct) != original.Correct {
+		t.Errorf("expected correct answer to map to original index %d but got %d", original.Correct, shuffled.OriginalIndex(shuffled.Correct))
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	quiz := Quiz{
+		Id:               3,
+		Name:             "round trip",
+		QuestionDuration: 20,
+		ShuffleAnswers:   true,
+		PreloadDelay:     5,
+		Questions: []QuizQuestion{
+			{
+				Question:   "question 0",
+				Answers:    []string{"zero", "one", "two"},
+				Correct:    2,
+				Preload:    true,
+				Difficulty: 3,
+			},
+			{
+				Question: "question: with a colon",
+				Answers:  []string{"yes", "no"},
+				Correct:  0,
+			},
+		},
+	}
+
+	data, err := quiz.MarshalToYAML()
+	if err != nil {
+		t.Fatalf("error converting quiz to YAML: %v", err)
+	}
+	decoded, err := UnmarshalQuizYAML(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error parsing YAML: %v\n%s", err, data)
+	}
+
+	expected, _ := quiz.Marshal()
+	actual, _ := decoded.Marshal()
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("expected quiz to survive a YAML round trip\nexpected: %s\nactual:   %s", expected, actual)
+	}
+
+	if _, err := UnmarshalQuizYAML(strings.NewReader("name: invalid\nquestions:\n- question: q\n  answers: [only]\n")); err == nil {
+		t.Error("expected YAML quiz with a single-answer question to be rejected")
+	}
+}
+
+func TestTrueFalseRoundTrip(t *testing.T) {
+	quiz := Quiz{
+		Name:           "truefalse",
+		ShuffleAnswers: true,
+		Questions: []QuizQuestion{
+			{Type: QuestionTypeTrueFalse, Question: "The sky is blue", Answers: []string{"True", "False"}, Correct: 0},
+			{Question: "legacy", Answers: []string{"a", "b", "c"}, Correct: 2},
+		},
+	}
+	b, err := quiz.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling quiz: %v", err)
+	}
+	unmarshaled, err := UnmarshalQuiz(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("error unmarshaling quiz: %v", err)
+	}
+	if !unmarshaled.Questions[0].IsTrueFalse() || unmarshaled.Questions[0].Correct != 0 {
+		t.Errorf("expected true/false question with correct answer 0 but got %+v", unmarshaled.Questions[0])
+	}
+	if unmarshaled.Questions[1].Type != "" {
+		t.Errorf("expected question without a type to stay untyped but got \"%s\"", unmarshaled.Questions[1].Type)
+	}
+
+	// true/false questions keep their fixed order even if the quiz shuffles
+	// answers
+	if unmarshaled.ShufflesAnswers(unmarshaled.Questions[0]) {
+		t.Error("expected true/false question not to be shuffled")
+	}
+	if !unmarshaled.ShufflesAnswers(unmarshaled.Questions[1]) {
+		t.Error("expected multiple choice question to be shuffled")
+	}
+
+	// clients are told the type of the live question
+	unmarshaled.QuestionDuration = 20
+	game := Game{
+		Players:     map[string]int{"player1": 0},
+		PlayerNames: map[string]string{"player1": "player1"},
+		Quiz:        unmarshaled,
+	}
+	if _, err := game.NextState(); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	_, current, err := game.GetCurrentQuestion()
+	if err != nil {
+		t.Fatalf("error getting current question: %v", err)
+	}
+	if current.Type != QuestionTypeTrueFalse {
+		t.Errorf("expected current question type \"%s\" but got \"%s\"", QuestionTypeTrueFalse, current.Type)
+	}
+}
+
+func TestPickQuestions(t *testing.T) {
+	quiz := Quiz{}
+	for i := 0; i < 5; i++ {
+		quiz.Questions = append(quiz.Questions, QuizQuestion{Question: fmt.Sprintf("question %d", i), Answers: []string{"a", "b"}})
+	}
+	avoid := map[string]struct{}{
+		quiz.Questions[0].Hash(): {},
+		quiz.Questions[1].Hash(): {},
+		quiz.Questions[2].Hash(): {},
+	}
+
+	// only 2 questions have not been asked so 2 of the avoided questions
+	// are repeated
+	picked := quiz
+	picked.Pick(4, avoid)
+	if picked.NumQuestions() != 4 {
+		t.Fatalf("expected 4 questions to be picked but got %d", picked.NumQuestions())
+	}
+	repeats := 0
+	seen := make(map[string]struct{})
+	for i, question := range picked.Questions {
+		if _, ok := avoid[question.Hash()]; ok {
+			repeats++
+		}
+		seen[question.Question] = struct{}{}
+		if i > 0 && question.Question < picked.Questions[i-1].Question {
+			t.Errorf("expected picked questions to keep their order but got %v", picked.Questions)
+		}
+	}
+	if repeats != 2 || len(seen) != 4 {
+		t.Errorf("expected 4 distinct questions with 2 repeats but got %d distinct with %d repeats", len(seen), repeats)
+	}
+	for _, question := range []string{"question 3", "question 4"} {
+		if _, ok := seen[question]; !ok {
+			t.Errorf("expected %s to be picked because it has not been asked", question)
+		}
+	}
+
+	// picking at least as many questions as the quiz has keeps them all
+	all := quiz
+	all.Pick(5, avoid)
+	if all.NumQuestions() != 5 {
+		t.Errorf("expected all 5 questions to be kept but got %d", all.NumQuestions())
+	}
+}
+
+func TestEstimatedDuration(t *testing.T) {
+	questions := func(durations ...int) []QuizQuestion {
+		list := []QuizQuestion{}
+		for i, duration := range durations {
+			list = append(list, QuizQuestion{Question: fmt.Sprintf("question %d", i), Answers: []string{"zero", "one"}, Duration: duration})
+		}
+		return list
+	}
+	tests := []struct {
+		name     string
+		quiz     Quiz
+		expected int
+	}{
+		{"uniform", Quiz{QuestionDuration: 20, Questions: questions(0, 0, 0)}, 60},
+		{"per-question", Quiz{QuestionDuration: 20, Questions: questions(10, 0, 45)}, 75},
+		{"picked", Quiz{QuestionDuration: 20, PickQuestions: 2, Questions: questions(10, 20, 30, 40)}, 50},
+		{"empty", Quiz{QuestionDuration: 20}, 0},
+	}
+	for _, test := range tests {
+		if duration := test.quiz.EstimatedDuration(); duration != test.expected {
+			t.Errorf("expected the %s quiz to take %d seconds but got %d", test.name, test.expected, duration)
+		}
+	}
+}
+
+func TestTopScorersValidation(t *testing.T) {
+	tests := []struct {
+		topScorers int
+		valid      bool
+	}{
+		{0, true},
+		{3, true},
+		{maxTopScorers, true},
+		{-1, false},
+		{maxTopScorers + 1, false},
+	}
+
+	for _, test := range tests {
+		quiz := Quiz{Name: "test", TopScorers: test.topScorers}
+		if err := quiz.Validate(); (err == nil) != test.valid {
+			t.Errorf("expected TopScorers %d to be valid=%v but got %v", test.topScorers, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A detailed breakdown of a single player's performance in a game
+type ReportCard struct {
+	Pin       int                  `json:"pin"`
+	Name      string               `json:"name"`
+	Score     int                  `json:"score"`
+	Questions []ReportCardQuestion `json:"questions"`
+	Truncated int                  `json:"truncated,omitempty"` // number of the player's earliest answers dropped from the answer log - those questions are reported as unanswered
+}
+
+type ReportCardQuestion struct {
+	QuestionIndex int    `json:"questionindex"`
+	Question      string `json:"question"`
+	Answered      bool   `json:"answered"`
+	Chosen        int    `json:"chosen"` // -1 if the player did not answer
+	ChosenAnswer  string `json:"chosenanswer"`
+	CorrectAnswer string `json:"correctanswer"`
+
+	// positions in the quiz before the answers were shuffled - used to
+	// compare results across games
+	OriginalChosen  int `json:"originalchosen"`
+	OriginalCorrect int `json:"originalcorrect"`
+
+	Correct      bool `json:"correct"`
+	Score        int  `json:"score"`
+	ResponseTime int  `json:"responsetime"` // milliseconds
+}
+
+// Returns the session ID of the player - player can either be the player's
+// session ID or name.
+func (g *Game) findPlayer(player string) (string, bool) {
+	if _, ok := g.Players[player]; ok {
+		return player, true
+	}
+	for sessionid, name := range g.PlayerNames {
+		if name == player {
+			return sessionid, true
+		}
+	}
+	return "", false
+}
+
+// Builds a report card for the player covering all questions that have been
+// shown so far. player can either be the player's session ID or name.
+func (g *Game) GetReportCard(player string) (ReportCard, error) {
+	sessionid, ok := g.findPlayer(player)
+	if !ok {
+		return ReportCard{}, fmt.Errorf("player %s is not part of game %d", player, g.Pin)
+	}
+
+	records := make(map[int]AnswerRecord)
+	for _, record := range g.AnswerLog[sessionid] {
+		records[record.QuestionIndex] = record
+	}
+
+	// only include questions that have been shown
+	shown := 0
+	if g.GameState == GameEnded {
+		shown = g.Quiz.NumQuestions()
+	} else if g.GameState != GameNotStarted {
+		shown = g.QuestionIndex + 1
+	}
+	if shown > g.Quiz.NumQuestions() {
+		shown = g.Quiz.NumQuestions()
+	}
+
+	report := ReportCard{
+		Pin:       g.Pin,
+		Name:      g.PlayerNames[sessionid],
+		Score:     g.Players[sessionid],
+		Questions: []ReportCardQuestion{},
+		Truncated: g.TruncatedAnswers[sessionid].Count,
+	}
+	for i := 0; i < shown; i++ {
+		question, err := g.Quiz.GetQuestion(i)
+		if err != nil {
+			return ReportCard{}, err
+		}
+		entry := ReportCardQuestion{
+			QuestionIndex:   i,
+			Question:        question.Question,
+			Chosen:          -1,
+			OriginalChosen:  -1,
+			OriginalCorrect: question.OriginalIndex(question.Correct),
+		}
+		if question.IsMultiSelect() {
+			entry.CorrectAnswer = question.joinAnswers(question.CorrectAnswers)
+		} else if question.Correct >= 0 && question.Correct < question.NumAnswers() {
+			entry.CorrectAnswer = question.Answers[question.Correct]
+		}
+		if record, ok := records[i]; ok {
+			entry.Answered = true
+			entry.Chosen = record.Answer
+			entry.OriginalChosen = question.OriginalIndex(record.Answer)
+			if record.Answer >= 0 && record.Answer < question.NumAnswers() {
+				entry.ChosenAnswer = question.Answers[record.Answer]
+			} else if record.Selection != nil {
+				entry.ChosenAnswer = question.joinAnswers(record.Selection)
+			}
+			entry.Correct = record.Correct
+			entry.Score = record.Score
+			entry.ResponseTime = record.ResponseTime
+		}
+		report.Questions = append(report.Questions, entry)
+	}
+	return report, nil
+}
+
+// The final standings of a game - posted to the results webhook when the
+// game ends
+type GameResults struct {
+	Pin      int                 `json:"pin"`
+	QuizId   int                 `json:"quizid"`
+	QuizName string              `json:"quizname"`
+	Label    string              `json:"label,omitempty"`
+	EndedAt  time.Time           `json:"endedat"`
+	Players  []GameResultsPlayer `json:"players"` // highest score first
+	Teams    []PlayerScore       `json:"teams,omitempty"`
+}
+
+type GameResultsPlayer struct {
+	Name     string `json:"name"`
+	Team     string `json:"team,omitempty"`
+	Score    int    `json:"score"`
+	Correct  int    `json:"correct"`  // number of questions answered correctly
+	Answered int    `json:"answered"` // number of questions answered
+}
+
+// Lists every player in the game - players with the same score are listed by
+// name
+func (g *Game) GetGameResults() GameResults {
+	results := GameResults{
+		Pin:      g.Pin,
+		QuizId:   g.Quiz.Id,
+		QuizName: g.Quiz.Name,
+		Label:    g.Label,
+		EndedAt:  time.Now(),
+		Players:  []GameResultsPlayer{},
+		Teams:    g.GetTeamWinners(),
+	}
+	for sessionid, score := range g.Players {
+		player := GameResultsPlayer{
+			Name:     g.PlayerNames[sessionid],
+			Team:     g.Teams[sessionid],
+			Score:    score,
+			Answered: len(g.AnswerLog[sessionid]) + g.TruncatedAnswers[sessionid].Count,
+			Correct:  g.TruncatedAnswers[sessionid].Correct,
+		}
+		for _, record := range g.AnswerLog[sessionid] {
+			if record.Correct {
+				player.Correct++
+			}
+		}
+		results.Players = append(results.Players, player)
+	}
+	sort.Slice(results.Players, func(i, j int) bool {
+		if results.Players[i].Score != results.Players[j].Score {
+			return results.Players[i].Score > results.Players[j].Score
+		}
+		return results.Players[i].Name < results.Players[j].Name
+	})
+	return results
+}
+
+// The answer each player chose for each question that has been asked
+type GameHistory struct {
+	Pin       int                   `json:"pin"`
+	Questions int                   `json:"questions"` // number of questions asked
+	Players   []PlayerAnswerHistory `json:"players"`   // ordered by name
+}
+
+type PlayerAnswerHistory struct {
+	Name    string `json:"name"`
+	Answers []int  `json:"answers"` // -1 if the player did not answer or the question takes more than one answer
+}
+
+func (g *Game) GetGameHistory() GameHistory {
+	asked := 0
+	if g.GameState != GameNotStarted {
+		asked = g.QuestionIndex + 1
+		if n := g.Quiz.NumQuestions(); asked > n {
+			asked = n
+		}
+	}
+	history := GameHistory{
+		Pin:       g.Pin,
+		Questions: asked,
+		Players:   []PlayerAnswerHistory{},
+	}
+	for sessionid := range g.Players {
+		answers := make([]int, asked)
+		for i := range answers {
+			answers[i] = -1
+		}
+		copy(answers, g.AnswerHistory[sessionid])
+		history.Players = append(history.Players, PlayerAnswerHistory{
+			Name:    g.PlayerNames[sessionid],
+			Answers: answers,
+		})
+	}
+	sort.Slice(history.Players, func(i, j int) bool {
+		return history.Players[i].Name < history.Players[j].Name
+	})
+	return history
+}
+
+// Everything the host's results screen needs once a game has ended
+type GameSummary struct {
+	Pin            int                   `json:"pin"`
+	QuizName       string                `json:"quizname"`
+	TotalQuestions int                   `json:"totalquestions"`
+	Players        []GameSummaryPlayer   `json:"players"` // highest score first
+	Teams          []PlayerScore         `json:"teams,omitempty"`
+	Questions      []GameSummaryQuestion `json:"questions"` // only includes questions with an answer history
+}
+
+type GameSummaryPlayer struct {
+	GameResultsPlayer
+	Rank int `json:"rank"` // players with the same score share a rank
+}
+
+type GameSummaryQuestion struct {
+	QuestionIndex int      `json:"questionindex"`
+	Question      string   `json:"question"`
+	Answers       []string `json:"answers"`
+	Votes         []int    `json:"votes"` // number of players that chose each answer
+}
+
+func (g *Game) GetGameSummary() GameSummary {
+	results := g.GetGameResults()
+	summary := GameSummary{
+		Pin:            g.Pin,
+		QuizName:       g.Quiz.Name,
+		TotalQuestions: g.Quiz.NumQuestions(),
+		Players:        make([]GameSummaryPlayer, len(results.Players)),
+		Teams:          results.Teams,
+		Questions:      []GameSummaryQuestion{},
+	}
+	for i, player := range results.Players {
+		rank := i + 1
+		if i > 0 && player.Score == results.Players[i-1].Score {
+			rank = summary.Players[i-1].Rank
+		}
+		summary.Players[i] = GameSummaryPlayer{GameResultsPlayer: player, Rank: rank}
+	}
+
+	if g.AnswerHistory == nil {
+		return summary
+	}
+	history := g.GetGameHistory()
+	for i := 0; i < history.Questions; i++ {
+		question, err := g.Quiz.GetQuestion(i)
+		if err != nil {
+			continue
+		}
+		entry := GameSummaryQuestion{
+			QuestionIndex: i,
+			Question:      question.Question,
+			Answers:       question.Answers,
+			Votes:         make([]int, len(question.Answers)),
+		}
+		for _, player := range history.Players {
+			if answer := player.Answers[i]; answer >= 0 && answer < len(entry.Votes) {
+				entry.Votes[answer]++
+			}
+		}
+		summary.Questions = append(summary.Questions, entry)
+	}
+	return summary
+}
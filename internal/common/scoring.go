@@ -0,0 +1,64 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// How the score for a correct answer depends on how quickly it came in
+const (
+	// Scores range from 100 for answers at the deadline to 200 for instant
+	// answers - quizzes without a scoring mode are scored this way
+	ScoringLinear = "linear"
+
+	// Every correct answer scores 100 however long it took
+	ScoringFlat = "flat"
+
+	// Scores range from 100 to 200 like linear scoring but the bonus falls
+	// off steeply - an answer with half the time left only earns 20 of the
+	// 100 bonus points
+	ScoringExponential = "exponential"
+)
+
+// Returns the score for a correct answer with timeLeft remaining -
+// questionDuration is in seconds
+type scoringFunc func(timeLeft time.Duration, questionDuration int) int
+
+var scoringFuncs = map[string]scoringFunc{
+	"":                 calculateScore,
+	ScoringLinear:      calculateScore,
+	ScoringFlat:        flatScore,
+	ScoringExponential: exponentialScore,
+}
+
+// Returns the scoring function for mode - unknown modes are scored linearly
+func scoringFor(mode string) scoringFunc {
+	if f, ok := scoringFuncs[mode]; ok {
+		return f
+	}
+	return calculateScore
+}
+
+func validScoringMode(mode string) bool {
+	_, ok := scoringFuncs[mode]
+	return ok
+}
+
+func flatScore(timeLeft time.Duration, questionDuration int) int {
+	return 100
+}
+
+func exponentialScore(timeLeft time.Duration, questionDuration int) int {
+	duration := time.Duration(questionDuration) * time.Second
+	if duration <= 0 {
+		return 100
+	}
+	if timeLeft < 0 {
+		timeLeft = 0
+	}
+	if timeLeft > duration {
+		timeLeft = duration
+	}
+	fraction := float64(timeLeft.Milliseconds()) / float64(duration.Milliseconds())
+	return 100 + int((math.Pow(16, fraction)-1)*100/15)
+}
@@ -7,14 +7,28 @@ import (
 	"time"
 )
 
+// number of games kept in each session's game history
+const maxGameHistory = 10
+
 type Session struct {
-	Id       string    `json:"id"`
-	ClientId uint64    `json:"clientid"`
-	Screen   string    `json:"screen"`
-	Gamepin  int       `json:"gamepin"`
-	Name     string    `json:"name"`
-	Admin    bool      `json:"admin"`
-	Expiry   time.Time `json:"expiry"`
+	Id          string              `json:"id"`
+	ClientId    uint64              `json:"clientid"`
+	Screen      string              `json:"screen"`
+	Gamepin     int                 `json:"gamepin"`
+	Name        string              `json:"name"`
+	Admin       bool                `json:"admin"`
+	Expiry      time.Time           `json:"expiry"`
+	Fingerprint string              `json:"fingerprint,omitempty"` // device fingerprint sent when joining a game
+	Games       []GameParticipation `json:"games,omitempty"`       // games the session is in or was recently in - oldest first
+}
+
+// A game that a session joined or hosted
+type GameParticipation struct {
+	Pin      int        `json:"pin"`
+	Name     string     `json:"name,omitempty"` // name the player joined under - blank for hosts
+	Host     bool       `json:"host,omitempty"`
+	JoinedAt time.Time  `json:"joinedat"`
+	LeftAt   *time.Time `json:"leftat,omitempty"` // nil while the session is still in the game
 }
 
 func UnmarshalSession(b []byte) (*Session, error) {
@@ -37,12 +51,44 @@ func (s Session) Marshal() ([]byte, error) {
 
 func (s *Session) Copy() Session {
 	return Session{
-		Id:       s.Id,
-		ClientId: s.ClientId,
-		Screen:   s.Screen,
-		Gamepin:  s.Gamepin,
-		Name:     s.Name,
-		Admin:    s.Admin,
-		Expiry:   s.Expiry,
+		Id:          s.Id,
+		ClientId:    s.ClientId,
+		Screen:      s.Screen,
+		Gamepin:     s.Gamepin,
+		Name:        s.Name,
+		Admin:       s.Admin,
+		Expiry:      s.Expiry,
+		Fingerprint: s.Fingerprint,
+		Games:       append([]GameParticipation(nil), s.Games...),
+	}
+}
+
+// Records that the session joined a game - only the most recent games are
+// kept
+func (s *Session) JoinedGame(pin int, name string, host bool, now time.Time) {
+	if last := len(s.Games) - 1; last >= 0 && s.Games[last].Pin == pin && s.Games[last].LeftAt == nil {
+		// rejoining the game the session is already in
+		s.Games[last].Name = name
+		s.Games[last].Host = host
+		return
+	}
+	s.Games = append(s.Games, GameParticipation{
+		Pin:      pin,
+		Name:     name,
+		Host:     host,
+		JoinedAt: now,
+	})
+	if len(s.Games) > maxGameHistory {
+		s.Games = append([]GameParticipation(nil), s.Games[len(s.Games)-maxGameHistory:]...)
+	}
+}
+
+// Records that the session left a game
+func (s *Session) LeftGame(pin int, now time.Time) {
+	for i := range s.Games {
+		if s.Games[i].Pin == pin && s.Games[i].LeftAt == nil {
+			left := now
+			s.Games[i].LeftAt = &left
+		}
 	}
 }
@@ -6,26 +6,119 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kwkoo/go-quiz/internal/common"
 	"github.com/kwkoo/go-quiz/internal/messaging"
 )
 
+// replaced in tests to simulate serialization errors
+var convertToJSON = common.ConvertToJSON
+
+const (
+	DefaultPinLength = 6
+	maxPinLength     = 9    // longer pins may not fit in an int on 32-bit platforms
+	maxPinAttempts   = 1000 // attempts at generating an unused pin before giving up
+)
+
+// players have to wait this long between flagging questions
+const flagInterval = 5 * time.Second
+
 type Games struct {
-	mutex  sync.RWMutex
-	all    map[int]*common.Game // map key is the game pin
-	engine *PersistenceEngine
-	msghub messaging.MessageHub
+	mutex              sync.RWMutex
+	all                map[int]*common.Game // map key is the game pin
+	hosting            map[string]int       // host session ID to the pin of the last game they created
+	engine             Store
+	writer             *PersistenceBreaker // game writes go through the breaker
+	stats              *PlayStats
+	recentQuestions    *RecentQuestions     // questions picked for the last game that used each quiz
+	questionFlags      *QuestionFlags       // questions flagged by players - kept after the games are deleted
+	lastFlags          map[string]time.Time // session ID to the time the player last flagged a question
+	heartbeat          *Heartbeat
+	msghub             messaging.MessageHub
+	disambiguateNames  bool               // append a suffix to duplicate names instead of rejecting them
+	oneJoinPerDevice   bool               // reject joins from devices that have already joined the game
+	hostWaitInterval   time.Duration      // interval between host status updates sent to players while the host lingers on the results - 0 disables the updates
+	hostWaits          map[int]int        // game pin to the index of the question whose results players are waiting on
+	compress           bool               // gzip games before persisting them
+	caseSensitiveNames bool               // player names that differ only in case are allowed in the same game
+	answerGrace        time.Duration      // answers that arrive this long after the deadline still count
+	answerLogCap       int                // number of answers kept in each player's answer log - 0 keeps every answer
+	mergeRejoins       bool               // players that rejoin the lobby with a new session under the same name take over their previous slot
+	advanceWhenIdle    time.Duration      // questions end early when no answers arrive for this long - 0 disables early advancement
+	idleTimers         map[int]*idleTimer // game pin to the timer that ends the current question once answers stop arriving
+	webhook            *ResultsWebhook    // receives the results of each game when it ends - nil if no webhook is configured
+	pinLength          int                // number of digits in game pins
+	lobbyDisplayCap    int                // the host's lobby shows the player count and a sample of this many names once there are more players - 0 lists every player
+}
+
+// Restarted on each answer - the generation lets stale timers that fired
+// before they could be stopped be ignored
+type idleTimer struct {
+	timer      *time.Timer
+	generation int
+}
+
+// Settings for InitGames - the zero value turns off every optional behaviour
+type GamesOptions struct {
+	SlowWriteThreshold time.Duration   // writes to the persistent store that take longer than this are considered slow - game writes are held in memory while the store is slow
+	DisambiguateNames  bool            // append a suffix to duplicate names instead of rejecting them
+	OneJoinPerDevice   bool            // reject joins from devices that have already joined the game
+	HostWaitInterval   time.Duration   // interval between host status updates sent to players while the host lingers on the results - 0 disables the updates
+	Compress           bool            // gzip games before persisting them
+	CaseSensitiveNames bool            // player names that differ only in case are allowed in the same game
+	MergeRejoins       bool            // players that rejoin the lobby with a new session under the same name take over their previous slot
+	AdvanceWhenIdle    time.Duration   // questions end early when no answers arrive for this long - 0 disables early advancement
+	Webhook            *ResultsWebhook // receives the results of each game when it ends - nil if no webhook is configured
+	PinLength          int             // number of digits in game pins - 0 uses DefaultPinLength
+	LobbyDisplayCap    int             // the host's lobby shows the player count and a sample of this many names once there are more players - 0 lists every player
+	AnswerGrace        time.Duration   // answers that arrive this long after the deadline still count
+	AnswerLogCap       int             // number of answers kept in each player's answer log - 0 keeps every answer
 }
 
-func InitGames(msghub messaging.MessageHub, engine *PersistenceEngine) *Games {
+func InitGames(msghub messaging.MessageHub, engine Store, options GamesOptions) *Games {
+	pinLength := options.PinLength
+	if pinLength <= 0 || pinLength > maxPinLength {
+		if pinLength != 0 {
+			log.Printf("pin length %d is not between 1 and %d - using %d", pinLength, maxPinLength, DefaultPinLength)
+		}
+		pinLength = DefaultPinLength
+	}
+	// game writes and counter increments go through the breaker
+	var writer *PersistenceBreaker
+	if engine != nil {
+		writer = NewPersistenceBreaker(engine, options.SlowWriteThreshold)
+	}
 	games := Games{
-		all:    make(map[int]*common.Game),
-		engine: engine,
-		msghub: msghub,
+		all:                make(map[int]*common.Game),
+		hosting:            make(map[string]int),
+		engine:             engine,
+		writer:             writer,
+		stats:              NewPlayStats(engine, writer),
+		recentQuestions:    NewRecentQuestions(engine, writer),
+		questionFlags:      NewQuestionFlags(engine),
+		lastFlags:          make(map[string]time.Time),
+		heartbeat:          NewHeartbeat("games"),
+		msghub:             msghub,
+		disambiguateNames:  options.DisambiguateNames,
+		oneJoinPerDevice:   options.OneJoinPerDevice,
+		hostWaitInterval:   options.HostWaitInterval,
+		hostWaits:          make(map[int]int),
+		compress:           options.Compress,
+		caseSensitiveNames: options.CaseSensitiveNames,
+		mergeRejoins:       options.MergeRejoins,
+		advanceWhenIdle:    options.AdvanceWhenIdle,
+		idleTimers:         make(map[int]*idleTimer),
+		webhook:            options.Webhook,
+		pinLength:          pinLength,
+		lobbyDisplayCap:    options.LobbyDisplayCap,
+		answerGrace:        options.AnswerGrace,
+		answerLogCap:       options.AnswerLogCap,
 	}
 
 	if engine == nil {
@@ -50,6 +143,13 @@ func InitGames(msghub messaging.MessageHub, engine *PersistenceEngine) *Games {
 			continue
 		}
 		games.all[game.Pin] = game
+		if game.GameState != common.GameEnded {
+			games.hosting[game.Host] = game.Pin
+		}
+		if game.GameState == common.GameNotStarted && game.AutoStarting {
+			// the countdown did not survive the restart so it starts over
+			games.scheduleAutoStart(game.Pin, time.Duration(game.Quiz.AutoStartDelay)*time.Second)
+		}
 	}
 
 	return &games
@@ -57,9 +157,14 @@ func InitGames(msghub messaging.MessageHub, engine *PersistenceEngine) *Games {
 
 func (g *Games) Run(ctx context.Context, shutdownComplete func()) {
 	gamesHub := g.msghub.GetTopic(messaging.GamesTopic)
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	g.heartbeat.Beat()
 
 	for {
 		select {
+		case <-ticker.C:
+			g.heartbeat.Beat()
 
 		case msg, ok := <-gamesHub:
 			if !ok {
@@ -79,6 +184,8 @@ func (g *Games) Run(ctx context.Context, shutdownComplete func()) {
 				g.processQueryDisplayChoicesMessage(m)
 			case common.QueryPlayerResultsMessage:
 				g.processQueryPlayerResultsMessage(m)
+			case common.FiftyFiftyMessage:
+				g.processFiftyFiftyMessage(m)
 			case common.RegisterAnswerMessage:
 				g.processRegisterAnswerMessage(m)
 			case common.CancelGameMessage:
@@ -89,22 +196,72 @@ func (g *Games) Run(ctx context.Context, shutdownComplete func()) {
 				g.processSetQuizForGameMessage(m)
 			case common.StartGameMessage:
 				g.processStartGameMessage(m)
+			case common.AutoStartGameMessage:
+				g.processAutoStartGameMessage(m)
+			case common.HostWaitingMessage:
+				g.processHostWaitingMessage(m)
+			case common.QuestionIdleMessage:
+				g.processQuestionIdleMessage(m)
 			case common.ShowResultsMessage:
 				g.processShowResultsMessage(m)
 			case common.QueryHostResultsMessage:
 				g.processQueryHostResultsMessage(m)
 			case common.NextQuestionMessage:
 				g.processNextQuestionMessage(m)
+			case common.BeginAnswersMessage:
+				g.processBeginAnswersMessage(m)
+			case common.CancelAbandonedGamesMessage:
+				g.processCancelAbandonedGamesMessage(m)
+			case common.PlayerConnectionMessage:
+				g.processPlayerConnectionMessage(m)
+			case common.SkipQuestionMessage:
+				g.processSkipQuestionMessage(m)
+			case common.PauseGameMessage:
+				g.processPauseGameMessage(m)
+			case common.ResumeGameMessage:
+				g.processResumeGameMessage(m)
+			case common.RevealNextBarMessage:
+				g.processRevealNextBarMessage(m)
+			case common.EmphasizeAnswerMessage:
+				g.processEmphasizeAnswerMessage(m)
+			case common.ShuffleParticipantsMessage:
+				g.processShuffleParticipantsMessage(m)
+			case common.KickPlayerMessage:
+				g.processKickPlayerMessage(m)
+			case common.SetShuffleMessage:
+				g.processSetShuffleMessage(m)
+			case common.PreviewQuizMessage:
+				g.processPreviewQuizMessage(m)
+			case common.FlagQuestionMessage:
+				g.processFlagQuestionMessage(m)
+			case common.SubmitQuestionMessage:
+				g.processSubmitQuestionMessage(m)
+			case common.ModerateSubmissionMessage:
+				g.processModerateSubmissionMessage(m)
 			case common.DeleteGameMessage:
 				g.processDeleteGameMessage(m)
-			case common.UpdateGameMessage:
+			case *common.UpdateGameMessage:
 				g.processUpdateGameMessage(m)
-			case common.DeleteGameByPin:
+			case *common.DeleteGameByPin:
 				g.processDeleteGameByPin(m)
 			case *common.GetGamesMessage:
 				g.processGetGamesMessage(m)
 			case *common.GetGameMessage:
 				g.processGetGameMessage(m)
+			case *common.GetGameStatusMessage:
+				g.processGetGameStatusMessage(m)
+			case *common.GetPublicGameViewMessage:
+				g.processGetPublicGameViewMessage(m)
+			case *common.GetPrintableQuestionMessage:
+				g.processGetPrintableQuestionMessage(m)
+			case *common.GetGameResultsMessage:
+				g.processGetGameResultsMessage(m)
+			case *common.GetStatsMessage:
+				g.processGetStatsMessage(m)
+			case *common.GetQuestionFlagsMessage:
+				g.processGetQuestionFlagsMessage(m)
+			case *common.PushQuizToGamesMessage:
+				g.processPushQuizToGamesMessage(m)
 			default:
 				log.Printf("unrecognized message type %T received on %s topic", msg, messaging.GamesTopic)
 			}
@@ -117,6 +274,10 @@ func (g *Games) Run(ctx context.Context, shutdownComplete func()) {
 	}
 }
 
+func (g *Games) Heartbeat() *Heartbeat {
+	return g.heartbeat
+}
+
 func (g *Games) processGetGameMessage(msg *common.GetGameMessage) {
 	game, err := g.get(msg.Pin)
 	msg.Result <- common.GetGameResult{
@@ -126,35 +287,119 @@ func (g *Games) processGetGameMessage(msg *common.GetGameMessage) {
 	close(msg.Result)
 }
 
+func (g *Games) processGetGameStatusMessage(msg *common.GetGameStatusMessage) {
+	defer close(msg.Result)
+	game, err := g.getGamePointer(msg.Pin)
+	if err != nil {
+		msg.Result <- common.GetGameStatusResult{Error: err}
+		return
+	}
+	g.mutex.RLock()
+	status := game.Status()
+	g.mutex.RUnlock()
+	msg.Result <- common.GetGameStatusResult{Status: status}
+}
+
+// Answers the unauthenticated public view - the result is always sent so
+// that the HTTP handler never waits on it
+func (g *Games) processGetPublicGameViewMessage(msg *common.GetPublicGameViewMessage) {
+	defer close(msg.Result)
+	game, err := g.getGamePointer(msg.Pin)
+	if err != nil {
+		msg.Result <- common.GetPublicGameViewResult{Error: err}
+		return
+	}
+	g.mutex.RLock()
+	view := game.PublicView()
+	g.mutex.RUnlock()
+	msg.Result <- common.GetPublicGameViewResult{View: view}
+}
+
+// read-only - the game state is left untouched
+func (g *Games) processGetGameResultsMessage(msg *common.GetGameResultsMessage) {
+	defer close(msg.Result)
+	game, err := g.getGamePointer(msg.Pin)
+	if err != nil {
+		msg.Result <- common.GetGameResultsResult{Error: err}
+		return
+	}
+	g.mutex.RLock()
+	if game.GameState == common.GameNotStarted {
+		g.mutex.RUnlock()
+		msg.Result <- common.GetGameResultsResult{Error: common.NewUnexpectedStateError(common.GameNotStarted, fmt.Sprintf("game %d has not started", msg.Pin))}
+		return
+	}
+	results, err := game.GetQuestionResults()
+	g.mutex.RUnlock()
+	msg.Result <- common.GetGameResultsResult{Results: results, Error: err}
+}
+
+// read-only - the game state is left untouched
+func (g *Games) processGetPrintableQuestionMessage(msg *common.GetPrintableQuestionMessage) {
+	defer close(msg.Result)
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		msg.Result <- common.GetPrintableQuestionResult{Error: err}
+		return
+	}
+	question, err := game.GetPrintableQuestion()
+	msg.Result <- common.GetPrintableQuestionResult{Question: question, Error: err}
+}
+
+func (g *Games) processGetStatsMessage(msg *common.GetStatsMessage) {
+	// scanning the persistent store can take a while so it is done outside
+	// the games loop
+	go func() {
+		msg.Result <- g.stats.GetAll()
+		close(msg.Result)
+	}()
+}
+
+func (g *Games) processGetQuestionFlagsMessage(msg *common.GetQuestionFlagsMessage) {
+	msg.Result <- g.questionFlags.GetAll()
+	close(msg.Result)
+}
+
+func (g *Games) processPushQuizToGamesMessage(msg *common.PushQuizToGamesMessage) {
+	msg.Result <- g.pushQuiz(msg.Quiz)
+	close(msg.Result)
+}
+
 func (g *Games) processGetGamesMessage(msg *common.GetGamesMessage) {
 	msg.Result <- g.getAll()
 	close(msg.Result)
 }
 
-func (g *Games) processDeleteGameByPin(msg common.DeleteGameByPin) {
-	g.delete(msg.Pin)
+func (g *Games) processDeleteGameByPin(msg *common.DeleteGameByPin) {
+	if !g.teardown(msg.Pin, "entrance") {
+		msg.Result <- common.NewNoSuchGameError(msg.Pin)
+		close(msg.Result)
+		return
+	}
+	msg.Result <- nil
+	close(msg.Result)
 }
 
-func (g *Games) processUpdateGameMessage(msg common.UpdateGameMessage) {
-	g.update(msg.Game)
+func (g *Games) processUpdateGameMessage(msg *common.UpdateGameMessage) {
+	msg.Result <- g.validatedUpdate(msg.Game)
+	close(msg.Result)
 }
 
 func (g *Games) processDeleteGameMessage(msg common.DeleteGameMessage) {
+	if _, err := g.getGamePointer(msg.Pin); err != nil {
+		if _, ok := err.(*common.NoSuchGameError); ok {
+			// the game was deleted through the REST API while this message
+			// was in flight - the host has already been released
+			log.Printf("not deleting game %d because it no longer exists", msg.Pin)
+			return
+		}
+	}
 	if _, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin); !ok {
 		log.Printf("could not delete game because %s is not a game host", msg.Sessionid)
 		return
 	}
 
-	g.delete(msg.Pin)
-	g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-		Sessionid: msg.Sessionid,
-		Pin:       -1,
-	})
-
-	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-		Sessionid:  msg.Sessionid,
-		Nextscreen: "host-select-quiz",
-	})
+	g.teardown(msg.Pin, "host-select-quiz")
 }
 
 func (g *Games) processNextQuestionMessage(msg common.NextQuestionMessage) {
@@ -215,415 +460,378 @@ func (g *Games) processNextQuestionMessage(msg common.NextQuestionMessage) {
 	}
 }
 
-func (g *Games) processQueryHostResultsMessage(msg common.QueryHostResultsMessage) {
-	g.sendQuestionResultsToHost(msg.Clientid, msg.Sessionid, msg.Pin)
-}
-
-// returns ok if successful
-func (g *Games) sendQuestionResultsToHost(client uint64, sessionid string, pin int) (common.Game, bool) {
-	game, ok := g.ensureUserIsGameHost(client, sessionid, pin)
+func (g *Games) processBeginAnswersMessage(msg common.BeginAnswersMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
 	if !ok {
-		log.Printf("not sending question results to host because %s is not a game host", sessionid)
-		return common.Game{}, false
+		log.Printf("could not begin answers because %s is not a game host", msg.Sessionid)
+		return
 	}
 
-	if err := g.showResults(pin); err != nil {
+	if err := g.beginAnswers(game.Pin); err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  sessionid,
-			Message:    fmt.Sprintf("error moving game to show results state: %v", err),
+			Sessionid:  msg.Sessionid,
+			Message:    "error beginning answers: " + err.Error(),
 			Nextscreen: "",
 		})
-		return common.Game{}, false
+		return
 	}
 
-	results, err := g.getQuestionResults(pin)
-	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  sessionid,
-			Message:    fmt.Sprintf("error getting question results: %v", err),
-			Nextscreen: "",
-		})
-		return common.Game{}, false
+	// resend the question to the host so that the timer starts
+	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+		Sessionid:  msg.Sessionid,
+		Nextscreen: "host-show-question",
+	})
+	g.sendQuestionContextToPlayers(game.Pin)
+}
+
+func (g *Games) processSkipQuestionMessage(msg common.SkipQuestionMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("could not skip question because %s is not a game host", msg.Sessionid)
+		return
 	}
 
-	encoded, err := common.ConvertToJSON(&results)
-	if err != nil {
+	if err := g.skipQuestion(game.Pin); err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  sessionid,
-			Message:    fmt.Sprintf("error converting question results payload to JSON: %v", err),
+			Sessionid:  msg.Sessionid,
+			Message:    "error skipping question: " + err.Error(),
 			Nextscreen: "",
 		})
-		return common.Game{}, false
+		return
 	}
 
-	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
-		Clientid: client,
-		Message:  "question-results " + encoded,
-	})
-
-	return *game, true
+	g.processShowResultsMessage(common.ShowResultsMessage(msg))
 }
 
-func (g *Games) sendGamePlayersToAnswerQuestionScreen(sessionid string, game common.Game) {
-	question, err := game.Quiz.GetQuestion(game.QuestionIndex)
-	if err != nil {
+func (g *Games) processPauseGameMessage(msg common.PauseGameMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("could not pause game because %s is not a game host", msg.Sessionid)
+		return
+	}
+
+	if err := g.pauseGame(game.Pin); err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  sessionid,
-			Message:    fmt.Sprintf("error getting question: %v", err),
+			Sessionid:  msg.Sessionid,
+			Message:    "error pausing game: " + err.Error(),
 			Nextscreen: "",
 		})
 		return
 	}
-	answerCount := len(question.Answers)
-	for pid := range game.Players {
-		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
-			Sessionid: pid,
-			Message:   fmt.Sprintf("display-choices %d", answerCount),
-		})
-		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-			Sessionid:  pid,
-			Nextscreen: "answer-question",
-		})
-	}
+
+	// resend the question to the host so that the timer stops
+	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+		Sessionid:  msg.Sessionid,
+		Nextscreen: "host-show-question",
+	})
+	g.sendQuestionContextToPlayers(game.Pin)
 }
 
-func (g *Games) processShowResultsMessage(msg common.ShowResultsMessage) {
-	game, ok := g.sendQuestionResultsToHost(msg.Clientid, msg.Sessionid, msg.Pin)
+func (g *Games) processResumeGameMessage(msg common.ResumeGameMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
 	if !ok {
+		log.Printf("could not resume game because %s is not a game host", msg.Sessionid)
 		return
 	}
 
+	if err := g.resumeGame(game.Pin); err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error resuming game: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	// resend the question to the host so that the timer restarts
 	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
 		Sessionid:  msg.Sessionid,
-		Nextscreen: "host-show-results",
+		Nextscreen: "host-show-question",
 	})
+	g.sendQuestionContextToPlayers(game.Pin)
 
-	playerResults := struct {
-		Correct bool `json:"correct"`
-		Score   int  `json:"score"`
-	}{}
-
-	for pid, score := range game.Players {
-		_, playerCorrect := game.CorrectPlayers[pid]
-		playerResults.Correct = playerCorrect
-		playerResults.Score = score
-
-		// we're doing this here to set the state for disconnected players
-		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-			Sessionid:  pid,
-			Nextscreen: "display-player-results",
-		})
+	// the idle timer may have fired while the game was paused
+	if updated, err := g.get(game.Pin); err == nil {
+		g.resetIdleTimer(updated.Pin, updated.QuestionIndex)
+	}
+}
 
-		encoded, err := common.ConvertToJSON(&playerResults)
-		if err != nil {
-			log.Printf("error converting player-results payload to JSON: %v", err)
-			continue
-		}
+// lets the players know that the countdown has changed
+func (g *Games) sendQuestionContextToPlayers(pin int) {
+	game, err := g.get(pin)
+	if err != nil {
+		log.Printf("could not retrieve game %d: %v", pin, err)
+		return
+	}
+	questionContext := questionContextMessage(&game)
+	if questionContext == "" {
+		return
+	}
+	for pid := range game.Players {
 		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
 			Sessionid: pid,
-			Message:   "player-results " + encoded,
+			Message:   questionContext,
 		})
 	}
 }
 
-// returns true if successful (treat it as an ok flag)
-func (g *Games) ensureUserIsGameHost(client uint64, sessionid string, pin int) (*common.Game, bool) {
-	game, err := g.getGamePointer(pin)
-	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-			Sessionid: sessionid,
-			Pin:       -1,
-		})
+// marks a player as connected or disconnected so the host can see who has
+// dropped out
+func (g *Games) processPlayerConnectionMessage(msg common.PlayerConnectionMessage) {
+	g.setPlayerConnected(msg.Pin, msg.Sessionid, msg.Connected)
+}
 
-		if _, ok := err.(*common.NoSuchGameError); ok {
-			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-				Sessionid:  sessionid,
-				Message:    err.Error(),
-				Nextscreen: "entrance",
-			})
-			return nil, false
+// Cancels the games whose host has not reconnected within the grace period
+// and sends their players back to the entrance
+func (g *Games) processCancelAbandonedGamesMessage(msg common.CancelAbandonedGamesMessage) {
+	now := time.Now()
+	abandoned := []int{}
+	g.mutex.RLock()
+	for pin, game := range g.all {
+		if game.HostAbandoned(msg.Grace, now) {
+			abandoned = append(abandoned, pin)
 		}
+	}
+	g.mutex.RUnlock()
 
-		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  sessionid,
-			Message:    "error fetching game: " + err.Error(),
-			Nextscreen: "entrance",
-		})
+	for _, pin := range abandoned {
+		if g.teardown(pin, "entrance") {
+			log.Printf("cancelled game %d because the host did not reconnect within %v", pin, msg.Grace)
+		}
+	}
+}
 
-		return nil, false
+func (g *Games) processRevealNextBarMessage(msg common.RevealNextBarMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("could not reveal next bar because %s is not a game host", msg.Sessionid)
+		return
 	}
 
-	if sessionid != game.Host {
+	if _, err := g.revealNextBar(game.Pin); err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  sessionid,
-			Message:    "you are not the host of the game",
-			Nextscreen: "entrance",
+			Sessionid:  msg.Sessionid,
+			Message:    "error revealing next bar: " + err.Error(),
+			Nextscreen: "",
 		})
-		return nil, false
+		return
 	}
 
-	return game, true
+	// resend the results so that the host sees the newly revealed bar
+	g.sendQuestionResultsToHost(msg.Clientid, msg.Sessionid, msg.Pin)
 }
 
-func (g *Games) processStartGameMessage(msg common.StartGameMessage) {
-	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
-	if !ok {
-		log.Printf("not starting game because %s is not a game host", msg.Sessionid)
+func (g *Games) processEmphasizeAnswerMessage(msg common.EmphasizeAnswerMessage) {
+	if _, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin); !ok {
+		log.Printf("could not emphasize answer because %s is not a game host", msg.Sessionid)
 		return
 	}
-
-	gameState, err := g.nextState(game.Pin)
+	game, err := g.get(msg.Pin)
 	if err != nil {
+		return
+	}
+	if game.GameState != common.ShowResults {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "error starting game: " + err.Error(),
-			Nextscreen: "host-select-quiz",
+			Message:    "the correct answer can only be emphasized while the results are shown",
+			Nextscreen: "",
 		})
 		return
 	}
-	if gameState != common.QuestionInProgress {
-		if gameState == common.ShowResults {
-			g.msghub.Send(messaging.GamesTopic, common.ShowResultsMessage(msg))
-			return
-		}
-		if gameState == common.GameEnded {
-			g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-				Sessionid:  msg.Sessionid,
-				Nextscreen: "host-select-quiz",
-			})
-			return
-		}
-
+	question, err := game.Quiz.GetQuestion(game.QuestionIndex)
+	if err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    fmt.Sprintf("game was not in an expected state: %d", gameState),
+			Message:    fmt.Sprintf("error getting question: %v", err),
 			Nextscreen: "",
 		})
 		return
 	}
 
-	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-		Sessionid:  msg.Sessionid,
-		Nextscreen: "host-show-question",
-	})
-
-	g.sendGamePlayersToAnswerQuestionScreen(msg.Sessionid, *game)
-}
-
-func (g *Games) processSetQuizForGameMessage(msg common.SetQuizForGameMessage) {
-	g.setGameQuiz(msg.Pin, msg.Quiz)
-}
-
-func (g *Games) processHostGameLobbyMessage(msg common.HostGameLobbyMessage) {
-	// create new game
-	pin, err := g.add(msg.Sessionid)
-	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  msg.Sessionid,
-			Message:    "could not add game: " + err.Error(),
-			Nextscreen: "host-select-quiz",
+	for pid := range game.Players {
+		encoded, err := emphasizedAnswer(&game, question, pid)
+		if err != nil {
+			log.Printf("error converting emphasize-answer payload for player %s to JSON: %v", pid, err)
+			continue
+		}
+		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+			Sessionid: pid,
+			Message:   "emphasize-answer " + encoded,
 		})
-		log.Printf("could not add game: " + err.Error())
-		return
 	}
+}
 
-	g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-		Sessionid: msg.Sessionid,
-		Pin:       pin,
-	})
-
-	g.msghub.Send(messaging.QuizzesTopic, common.LookupQuizForGameMessage{
-		Clientid:  msg.Clientid,
-		Sessionid: msg.Sessionid,
-		Quizid:    msg.Quizid,
-		Pin:       pin,
-	})
+// Returns the JSON payload revealing the correct answer to the player
+func emphasizedAnswer(game *common.Game, question common.QuizQuestion, sessionid string) (string, error) {
+	emphasis := struct {
+		Index  int      `json:"index"`            // position of the correct answer as displayed to the player - -1 for ordering questions
+		Answer string   `json:"answer,omitempty"` // text of the correct answer
+		Order  []string `json:"order,omitempty"`  // items in the correct order for ordering questions
+		Others []int    `json:"others,omitempty"` // positions of the other correct answers as displayed to the player for multi-select questions
+	}{
+		Index: -1,
+	}
+	if question.IsOrdering() {
+		emphasis.Order = make([]string, question.NumAnswers())
+		for i, item := range question.Answers {
+			emphasis.Order[question.OriginalIndex(i)] = item
+		}
+		return common.ConvertToJSON(&emphasis)
+	}
+	correct := []int{question.Correct}
+	if question.IsMultiSelect() {
+		correct = question.CorrectAnswers
+	}
+	answers := []string{}
+	positions := []int{}
+	order := game.PlayerAnswerOrder(sessionid)
+	for _, index := range correct {
+		if index < 0 || index >= question.NumAnswers() {
+			continue
+		}
+		answers = append(answers, question.Answers[index])
+		position := index
+		for p, i := range order {
+			// the player sees the answers in a different order
+			if i == index {
+				position = p
+				break
+			}
+		}
+		positions = append(positions, position)
+	}
+	emphasis.Answer = strings.Join(answers, ", ")
+	if len(positions) > 0 {
+		emphasis.Index = positions[0]
+		emphasis.Others = positions[1:]
+	}
+	return common.ConvertToJSON(&emphasis)
 }
 
-func (g *Games) processCancelGameMessage(msg common.CancelGameMessage) {
+// sends the participants list to the host in a random order
+func (g *Games) processShuffleParticipantsMessage(msg common.ShuffleParticipantsMessage) {
 	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
 	if !ok {
-		log.Printf("not cancelling game because %s is not a game host", msg.Sessionid)
+		log.Printf("not shuffling participants because %s is not a game host", msg.Sessionid)
 		return
 	}
 
-	players := game.GetPlayers()
-	players = append(players, game.Host)
-	g.msghub.Send(messaging.SessionsTopic, common.DeregisterGameFromSessionsMessage{
-		Sessions: players,
-	})
+	g.mutex.RLock()
+	copied := game.Copy()
+	g.mutex.RUnlock()
 
-	for _, playerid := range players {
-		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-			Sessionid:  playerid,
-			Nextscreen: "entrance",
-		})
+	// large games are capped the same way as the lobby list - the sample is
+	// taken from the shuffled names so that it is random as well
+	players := copied.ShuffledPlayerNames()
+	capped := g.lobbyDisplayCap > 0 && len(players) > g.lobbyDisplayCap
+	if capped {
+		players = players[:g.lobbyDisplayCap]
 	}
-
-	g.delete(game.Pin)
+	g.sendPlayerNamesToHost(copied, players, capped)
 }
 
-func (g *Games) processRegisterAnswerMessage(msg common.RegisterAnswerMessage) {
-	answersUpdate, err := g.registerAnswer(msg.Pin, msg.Sessionid, msg.Answer)
-	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-			Sessionid: msg.Sessionid,
-			Pin:       -1,
-		})
-
-		if _, ok := err.(*common.NoSuchGameError); ok {
-			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-				Sessionid:  msg.Sessionid,
-				Message:    err.Error(),
-				Nextscreen: "entrance",
-			})
-			return
-		}
-
-		if errState, ok := err.(*common.UnexpectedStateError); ok {
-			switch errState.CurrentState {
-			case common.GameNotStarted:
-				g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-					Sessionid:  msg.Sessionid,
-					Nextscreen: "wait-for-game-start",
-				})
-
-			case common.ShowResults:
-				g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-					Sessionid:  msg.Sessionid,
-					Nextscreen: "display-player-results",
-				})
-
-			default:
-				g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-					Sessionid:  msg.Sessionid,
-					Nextscreen: "entrance",
-				})
-			}
-			return
-		}
+// Removes a player from the game at the host's request - the player is sent
+// back to the entrance and the host gets the updated list of participants
+func (g *Games) processKickPlayerMessage(msg common.KickPlayerMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("not kicking player because %s is not a game host", msg.Sessionid)
+		return
+	}
 
+	g.mutex.Lock()
+	playerid, ok := game.FindPlayer(msg.Player)
+	if !ok {
+		g.mutex.Unlock()
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "error registering answer: " + err.Error(),
+			Message:    fmt.Sprintf("%s is not a player in this game", msg.Player),
 			Nextscreen: "",
 		})
 		return
 	}
+	name := game.PlayerNames[playerid]
+	game.DeletePlayer(playerid)
+	// the kicked player may have been the last one the question was
+	// waiting on
+	var answersUpdate *common.AnswersUpdate
+	if game.GameState == common.QuestionInProgress {
+		update := game.UpdateAnswers()
+		answersUpdate = &update
+	}
+	g.mutex.Unlock()
+	g.persist(game)
+	log.Printf("host removed player %s (%s) from game %d", name, playerid, msg.Pin)
 
-	// send this player to wait for question to end screen
+	g.msghub.Send(messaging.SessionsTopic, common.DeregisterGameFromSessionsMessage{
+		Sessions: []string{playerid},
+	})
 	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-		Sessionid:  msg.Sessionid,
-		Nextscreen: "wait-for-question-end",
+		Sessionid:  playerid,
+		Nextscreen: "entrance",
 	})
 
-	encoded, err := common.ConvertToJSON(&answersUpdate)
-	if err != nil {
-		log.Printf("error converting players-answered payload to JSON: %v", err)
-		return
+	if answersUpdate != nil {
+		if encoded, err := common.ConvertToJSON(answersUpdate); err == nil {
+			g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+				Sessionid: msg.Sessionid,
+				Message:   "players-answered " + encoded,
+			})
+		} else {
+			log.Printf("error converting players-answered payload to JSON: %v", err)
+		}
 	}
 
-	game, err := g.get(msg.Pin)
+	updated, err := g.get(msg.Pin)
 	if err != nil {
 		log.Printf("could not retrieve game %d: %v", msg.Pin, err)
 		return
 	}
-	host := game.Host
-	if host == "" {
-		return
-	}
-
-	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
-		Sessionid: host,
-		Message:   "players-answered " + encoded,
-	})
+	g.sendParticipantsToHost(updated, "")
 }
 
-// player may have been disconnected - now they need to know about
-// their results
-func (g *Games) processQueryPlayerResultsMessage(msg common.QueryPlayerResultsMessage) {
-	game, err := g.get(msg.Pin)
-	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-			Sessionid: msg.Sessionid,
-			Pin:       -1,
-		})
-
-		if _, ok := err.(*common.NoSuchGameError); ok {
-			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-				Sessionid:  msg.Sessionid,
-				Message:    err.Error(),
-				Nextscreen: "entrance",
-			})
-			return
-		}
-
-		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  msg.Sessionid,
-			Message:    "error fetching game: " + err.Error(),
-			Nextscreen: "entrance",
-		})
-
+// Changes the shuffle settings of a game that has not started - the game's
+// unshuffled copy of its quiz is shuffled again with the new settings while
+// the stored quiz is left alone
+func (g *Games) processSetShuffleMessage(msg common.SetShuffleMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("not changing shuffle settings because %s is not a game host", msg.Sessionid)
 		return
 	}
 
-	_, correct := game.CorrectPlayers[msg.Sessionid]
-	score, ok := game.Players[msg.Sessionid]
-	if !ok {
-		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-			Sessionid: msg.Sessionid,
-			Pin:       -1,
-		})
+	g.mutex.Lock()
+	if game.GameState != common.GameNotStarted {
+		g.mutex.Unlock()
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "you do not have a score in this game",
-			Nextscreen: "entrance",
+			Message:    "shuffle settings can only be changed before the game starts",
+			Nextscreen: "",
 		})
 		return
 	}
+	shuffle := msg.Shuffle
+	game.ShuffleOverride = &shuffle
+	game.ShuffleQuiz()
+	g.mutex.Unlock()
+	g.persist(game)
+}
 
-	playerResults := struct {
-		Correct bool `json:"correct"`
-		Score   int  `json:"score"`
-	}{
-		Correct: correct,
-		Score:   score,
-	}
-
-	encoded, err := common.ConvertToJSON(&playerResults)
-	if err != nil {
-		log.Printf("error converting player-results payload to JSON: %v", err)
+// sends the game's quiz (including the correct answers) to the host
+func (g *Games) processPreviewQuizMessage(msg common.PreviewQuizMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("not sending quiz preview because %s is not a game host", msg.Sessionid)
 		return
 	}
 
-	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
-		Clientid: msg.Clientid,
-		Message:  "player-results " + encoded,
-	})
-}
-
-// player may have been disconnected - now they need to know how many
-// answers to enable
-func (g *Games) processQueryDisplayChoicesMessage(msg common.QueryDisplayChoicesMessage) {
-	currentQuestion, err := g.getCurrentQuestion(msg.Pin)
+	g.mutex.RLock()
+	encoded, err := common.ConvertToJSON(&game.Quiz)
+	g.mutex.RUnlock()
 	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
-			Sessionid: msg.Sessionid,
-			Pin:       -1,
-		})
-
-		if _, ok := err.(*common.NoSuchGameError); ok {
-			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-				Sessionid:  msg.Sessionid,
-				Message:    err.Error(),
-				Nextscreen: "entrance",
-			})
-			return
-		}
-
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "error retrieving current question: " + err.Error(),
+			Message:    "error converting quiz-preview payload to JSON: " + err.Error(),
 			Nextscreen: "",
 		})
 		return
@@ -631,361 +839,1863 @@ func (g *Games) processQueryDisplayChoicesMessage(msg common.QueryDisplayChoices
 
 	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
 		Clientid: msg.Clientid,
-		Message:  fmt.Sprintf("display-choices %d", len(currentQuestion.Answers)),
+		Message:  "quiz-preview " + encoded,
 	})
 }
 
-func (g *Games) processHostShowGameResultsMessage(msg common.HostShowGameResultsMessage) {
-	winners, err := g.getWinners(msg.Pin)
+func (g *Games) processFlagQuestionMessage(msg common.FlagQuestionMessage) {
+	questionIndex, count, err := g.flagQuestion(msg.Pin, msg.Sessionid, msg.Reason)
 	if err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "error retrieving game winners: " + err.Error(),
+			Message:    "could not flag question: " + err.Error(),
 			Nextscreen: "",
 		})
+		return
+	}
 
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		log.Printf("could not retrieve game %d: %v", msg.Pin, err)
+		return
+	}
+	if game.Host == "" {
 		return
 	}
 
-	encoded, err := common.ConvertToJSON(&winners)
+	flagged := struct {
+		QuestionIndex int `json:"questionindex"`
+		Flags         int `json:"flags"`
+	}{
+		QuestionIndex: questionIndex,
+		Flags:         count,
+	}
+	encoded, err := common.ConvertToJSON(&flagged)
 	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  msg.Sessionid,
-			Message:    "error converting show-winners payload to JSON: " + err.Error(),
-			Nextscreen: "",
-		})
+		log.Printf("error converting question-flagged payload to JSON: %v", err)
 		return
 	}
-	log.Printf("winners for game %d: %s", msg.Pin, encoded)
 
-	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
-		Clientid: msg.Clientid,
-		Message:  "show-winners " + encoded,
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: game.Host,
+		Message:   "question-flagged " + encoded,
 	})
 }
 
-func (g *Games) processHostShowQuestionMessage(msg common.HostShowQuestionMessage) {
-	currentQuestion, err := g.getCurrentQuestion(msg.Pin)
-	if err != nil {
-		// if the host disconnected while the question was live, and if
-		// the game state has now changed, we may need to move the host to
-		// the relevant screen
-		unexpectedState, ok := err.(*common.UnexpectedStateError)
-		if ok && unexpectedState.CurrentState == common.ShowResults {
-			g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
-				Sessionid:  msg.Sessionid,
-				Nextscreen: "show-results",
-			})
-			return
-		}
-
+func (g *Games) processSubmitQuestionMessage(msg common.SubmitQuestionMessage) {
+	if _, err := g.submitQuestion(msg.Pin, msg.Sessionid, msg.Question); err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "error retrieving question: " + err.Error(),
+			Message:    "could not submit question: " + err.Error(),
 			Nextscreen: "",
 		})
 		return
 	}
 
-	encoded, err := common.ConvertToJSON(&currentQuestion)
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: msg.Sessionid,
+		Message:   "question-submitted",
+	})
+	g.sendSubmissionsToHost(msg.Pin)
+}
+
+func (g *Games) processModerateSubmissionMessage(msg common.ModerateSubmissionMessage) {
+	if _, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin); !ok {
+		log.Printf("not moderating submission because %s is not a game host", msg.Sessionid)
+		return
+	}
+
+	submission, err := g.moderateSubmission(msg.Pin, msg.Submission, msg.Approve)
 	if err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 			Sessionid:  msg.Sessionid,
-			Message:    "error converting question to JSON: " + err.Error(),
+			Message:    "could not moderate question: " + err.Error(),
 			Nextscreen: "",
 		})
 		return
 	}
 
-	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
-		Clientid: msg.Clientid,
-		Message:  "host-show-question " + encoded,
+	// let the player know what happened to their question
+	result := "question-rejected"
+	if msg.Approve {
+		result = "question-approved"
+	}
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: submission.Sessionid,
+		Message:   result,
 	})
+	g.sendSubmissionsToHost(msg.Pin)
 }
 
-func (g *Games) processSendGameMetadataMessage(msg common.SendGameMetadataMessage) {
-	game, err := g.get(msg.Pin)
+// sends the questions waiting for approval to the host
+func (g *Games) sendSubmissionsToHost(pin int) {
+	game, err := g.get(pin)
 	if err != nil {
-		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  msg.Sessionid,
-			Message:    fmt.Sprintf("could not retrieve game %d", msg.Pin),
-			Nextscreen: "entrance",
-		})
+		log.Printf("could not retrieve game %d: %v", pin, err)
+		return
+	}
+	if game.Host == "" {
 		return
 	}
 
-	// send over game object with lobby-game-metadata
-	gameMetadata := struct {
-		Pin     int      `json:"pin"`
-		Name    string   `json:"name"`
-		Host    string   `json:"host"`
-		Players []string `json:"players"`
+	submissions := struct {
+		Submissions   []common.SubmittedQuestion `json:"submissions"`
+		QuestionCount int                        `json:"questioncount"`
 	}{
-		Pin:     game.Pin,
-		Name:    game.Quiz.Name,
-		Host:    game.Host,
-		Players: game.GetPlayerNames(),
+		Submissions:   game.Submissions,
+		QuestionCount: game.Quiz.NumQuestions(),
+	}
+	encoded, err := common.ConvertToJSON(&submissions)
+	if err != nil {
+		log.Printf("error converting submitted-questions payload to JSON: %v", err)
+		return
 	}
 
-	encoded, err := common.ConvertToJSON(&gameMetadata)
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: game.Host,
+		Message:   "submitted-questions " + encoded,
+	})
+}
+
+func (g *Games) processQueryHostResultsMessage(msg common.QueryHostResultsMessage) {
+	g.sendQuestionResultsToHost(msg.Clientid, msg.Sessionid, msg.Pin)
+}
+
+// returns ok if successful
+func (g *Games) sendQuestionResultsToHost(client uint64, sessionid string, pin int) (common.Game, bool) {
+	game, ok := g.ensureUserIsGameHost(client, sessionid, pin)
+	if !ok {
+		log.Printf("not sending question results to host because %s is not a game host", sessionid)
+		return common.Game{}, false
+	}
+
+	if err := g.showResults(pin); err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  sessionid,
+			Message:    fmt.Sprintf("error moving game to show results state: %v", err),
+			Nextscreen: "",
+		})
+		return common.Game{}, false
+	}
+
+	results, err := g.getQuestionResults(pin)
 	if err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  msg.Sessionid,
-			Message:    "error converting lobby-game-metadata payload to JSON: " + err.Error(),
+			Sessionid:  sessionid,
+			Message:    fmt.Sprintf("error getting question results: %v", err),
 			Nextscreen: "",
 		})
-		return
+		return common.Game{}, false
 	}
 
-	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
-		Clientid: msg.Clientid,
-		Message:  "lobby-game-metadata " + encoded,
+	encoded, err := common.ConvertToJSON(&results)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  sessionid,
+			Message:    fmt.Sprintf("error converting question results payload to JSON: %v", err),
+			Nextscreen: "",
+		})
+		return common.Game{}, false
+	}
+
+	// the results are sent to the host's session rather than the client
+	// that asked for them because timers show the results without a client -
+	// the sessions handler looks up the host's current client
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: sessionid,
+		Message:   "question-results " + encoded,
 	})
+
+	return *game, true
 }
 
-// returns true if processed
-func (g *Games) processAddPlayerToGameMessage(msg common.AddPlayerToGameMessage) {
-	if err := g.addPlayerToGame(msg); err != nil {
+func (g *Games) sendGamePlayersToAnswerQuestionScreen(sessionid string, game common.Game) {
+	question, err := game.Quiz.GetQuestion(game.QuestionIndex)
+	if err != nil {
 		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
-			Sessionid:  msg.Sessionid,
-			Message:    "could not add player to game: " + err.Error(),
-			Nextscreen: "entrance",
+			Sessionid:  sessionid,
+			Message:    fmt.Sprintf("error getting question: %v", err),
+			Nextscreen: "",
+		})
+		return
+	}
+	answerCount := len(question.Answers)
+	questionContext := questionContextMessage(&game)
+	for pid := range game.Players {
+		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+			Sessionid: pid,
+			Message:   displayChoices(&game, pid, answerCount),
+		})
+		if questionContext != "" {
+			g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+				Sessionid: pid,
+				Message:   questionContext,
+			})
+		}
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  pid,
+			Nextscreen: "answer-question",
 		})
+	}
+}
+
+func (g *Games) processShowResultsMessage(msg common.ShowResultsMessage) {
+	game, ok := g.sendQuestionResultsToHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
 		return
 	}
 
-	g.msghub.Send(messaging.SessionsTopic, common.BindGameToSessionMessage(msg))
 	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
 		Sessionid:  msg.Sessionid,
-		Nextscreen: "wait-for-game-start",
+		Nextscreen: "host-show-results",
 	})
 
-	// inform game host of new player
+	playerResults := struct {
+		Correct     bool   `json:"correct"`
+		Score       int    `json:"score"`
+		Explanation string `json:"explanation,omitempty"` // why the player's answer was wrong
+		Streak      int    `json:"streak"`                // number of questions in a row the player has answered correctly
+	}{}
+
+	for pid, score := range game.Players {
+		_, playerCorrect := game.CorrectPlayers[pid]
+		playerResults.Correct = playerCorrect
+		playerResults.Score = score
+		playerResults.Explanation = game.PlayerChoiceExplanation(pid)
+		playerResults.Streak = game.CurrentStreak(pid)
+
+		// we're doing this here to set the state for disconnected players
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  pid,
+			Nextscreen: "display-player-results",
+		})
+
+		encoded, err := convertToJSON(&playerResults)
+		if err != nil {
+			// sending the player back to the results screen makes the client
+			// query for the results again
+			log.Printf("error converting player-results payload for player %s in game %d to JSON: %v", pid, game.Pin, err)
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  pid,
+				Message:    "error retrieving your results",
+				Nextscreen: "display-player-results",
+			})
+			continue
+		}
+		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+			Sessionid: pid,
+			Message:   "player-results " + encoded,
+		})
+	}
+
+	g.startHostWait(game.Pin, game.QuestionIndex)
+}
+
+func (g *Games) processHostWaitingMessage(msg common.HostWaitingMessage) {
+	game, err := g.get(msg.Pin)
+	if err != nil || game.GameState != common.ShowResults || game.QuestionIndex != msg.QuestionIndex {
+		// the host has moved on
+		g.mutex.Lock()
+		if index, ok := g.hostWaits[msg.Pin]; ok && index == msg.QuestionIndex {
+			delete(g.hostWaits, msg.Pin)
+		}
+		g.mutex.Unlock()
+		return
+	}
+
+	status := struct {
+		Message       string `json:"message"`
+		HostConnected bool   `json:"hostconnected"`
+	}{
+		Message:       "The host is preparing the next question",
+		HostConnected: !game.HostDisconnected,
+	}
+	if game.HostDisconnected {
+		status.Message = "Waiting for the host to reconnect"
+	}
+	encoded, err := common.ConvertToJSON(&status)
+	if err != nil {
+		log.Printf("error converting host-status payload to JSON: %v", err)
+		return
+	}
+	for pid := range game.Players {
+		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+			Sessionid: pid,
+			Message:   "host-status " + encoded,
+		})
+	}
+	g.scheduleHostWait(msg.Pin, msg.QuestionIndex)
+}
+
+// Answers have stopped arriving - assume that everyone who is going to
+// answer has and move on to the results
+func (g *Games) processQuestionIdleMessage(msg common.QuestionIdleMessage) {
+	g.mutex.Lock()
+	current, ok := g.idleTimers[msg.Pin]
+	if !ok || current.generation != msg.Generation {
+		// an answer arrived after the timer fired
+		g.mutex.Unlock()
+		return
+	}
+	delete(g.idleTimers, msg.Pin)
+	g.mutex.Unlock()
+
 	game, err := g.get(msg.Pin)
+	if err != nil || game.GameState != common.QuestionInProgress || game.QuestionIndex != msg.QuestionIndex || game.Paused || game.Host == "" {
+		return
+	}
+	log.Printf("no answers for %v in game %d - showing results of question %d", g.advanceWhenIdle, msg.Pin, msg.QuestionIndex)
+	g.processShowResultsMessage(common.ShowResultsMessage{
+		Sessionid: game.Host,
+		Pin:       msg.Pin,
+	})
+}
+
+// returns true if successful (treat it as an ok flag)
+func (g *Games) ensureUserIsGameHost(client uint64, sessionid string, pin int) (*common.Game, bool) {
+	game, err := g.getGamePointer(pin)
 	if err != nil {
-		log.Printf("could not retrieve game %d: %v", msg.Pin, err)
+		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+			Sessionid: sessionid,
+			Pin:       -1,
+		})
+
+		if _, ok := err.(*common.NoSuchGameError); ok {
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    err.Error(),
+				Nextscreen: "entrance",
+			})
+			return nil, false
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  sessionid,
+			Message:    "error fetching game: " + err.Error(),
+			Nextscreen: "entrance",
+		})
+
+		return nil, false
+	}
+
+	if sessionid != game.Host {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  sessionid,
+			Message:    "you are not the host of the game",
+			Nextscreen: "entrance",
+		})
+		return nil, false
+	}
+
+	return game, true
+}
+
+func (g *Games) processStartGameMessage(msg common.StartGameMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("not starting game because %s is not a game host", msg.Sessionid)
 		return
 	}
-	host := game.Host
-	if host == "" {
-		log.Printf("could not inform host of new player because game %d has not host", msg.Pin)
+
+	// starting a game without any questions would end it immediately
+	g.mutex.RLock()
+	emptyQuiz := game.GameState == common.GameNotStarted && game.Quiz.NumQuestions() == 0
+	g.mutex.RUnlock()
+	if emptyQuiz {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "cannot start game because the quiz has no questions",
+			Nextscreen: "",
+		})
 		return
 	}
-	players := game.GetPlayerNames()
-	encoded, err := common.ConvertToJSON(&players)
 
+	gameState, err := g.nextState(game.Pin)
 	if err != nil {
-		log.Printf("error encoding player names: %v", err)
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error starting game: " + err.Error(),
+			Nextscreen: "host-select-quiz",
+		})
+		return
+	}
+	if gameState != common.QuestionInProgress {
+		if gameState == common.ShowResults {
+			g.msghub.Send(messaging.GamesTopic, common.ShowResultsMessage(msg))
+			return
+		}
+		if gameState == common.GameEnded {
+			g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+				Sessionid:  msg.Sessionid,
+				Nextscreen: "host-select-quiz",
+			})
+			return
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    fmt.Sprintf("game was not in an expected state: %d", gameState),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	if game.QuestionIndex == 0 {
+		g.mutex.RLock()
+		quizid, players := game.Quiz.Id, len(game.Players)
+		g.mutex.RUnlock()
+		g.stats.RecordGameStarted(quizid, players)
+	}
+
+	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+		Sessionid:  msg.Sessionid,
+		Nextscreen: "host-show-question",
+	})
+
+	g.sendGamePlayersToAnswerQuestionScreen(msg.Sessionid, *game)
+}
+
+// Starts the game on behalf of the host unless the host has already started
+// or cancelled it
+func (g *Games) processAutoStartGameMessage(msg common.AutoStartGameMessage) {
+	game, err := g.getGamePointer(msg.Pin)
+	if err != nil {
+		return
+	}
+	g.mutex.RLock()
+	host, state := game.Host, game.GameState
+	g.mutex.RUnlock()
+	if state != common.GameNotStarted {
+		return
+	}
+
+	g.processStartGameMessage(common.StartGameMessage{
+		Sessionid: host,
+		Pin:       msg.Pin,
+	})
+}
+
+func (g *Games) processSetQuizForGameMessage(msg common.SetQuizForGameMessage) {
+	g.setGameQuiz(msg.Pin, msg.Quiz)
+}
+
+func (g *Games) processHostGameLobbyMessage(msg common.HostGameLobbyMessage) {
+	// a session can only host one game at a time so that abandoned lobbies
+	// do not pile up
+	if existing, ok := g.hostedGame(msg.Sessionid); ok {
+		// send the host back to the lobby of the existing game so that it
+		// can be run or cancelled
+		log.Printf("session %s is already hosting game %d - not creating another game", msg.Sessionid, existing)
+		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+			Sessionid: msg.Sessionid,
+			Pin:       existing,
+		})
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  msg.Sessionid,
+			Nextscreen: "host-game-lobby",
+		})
+		return
+	}
+
+	// create new game
+	pin, err := g.add(msg.Sessionid)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "could not add game: " + err.Error(),
+			Nextscreen: "host-select-quiz",
+		})
+		log.Printf("could not add game: " + err.Error())
+		return
+	}
+	if msg.QuestionDuration > 0 {
+		g.setGameQuestionDuration(pin, msg.QuestionDuration)
+	}
+	if msg.Label != "" {
+		g.setGameLabel(pin, msg.Label)
+	}
+
+	g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+		Sessionid: msg.Sessionid,
+		Pin:       pin,
+	})
+
+	g.msghub.Send(messaging.QuizzesTopic, common.LookupQuizForGameMessage{
+		Clientid:  msg.Clientid,
+		Sessionid: msg.Sessionid,
+		Quizid:    msg.Quizid,
+		Pin:       pin,
+	})
+}
+
+func (g *Games) processCancelGameMessage(msg common.CancelGameMessage) {
+	game, ok := g.ensureUserIsGameHost(msg.Clientid, msg.Sessionid, msg.Pin)
+	if !ok {
+		log.Printf("not cancelling game because %s is not a game host", msg.Sessionid)
+		return
+	}
+
+	g.teardown(game.Pin, "entrance")
+}
+
+func (g *Games) processRegisterAnswerMessage(msg common.RegisterAnswerMessage) {
+	var answersUpdate common.AnswersUpdate
+	var err error
+	if msg.Choices != nil {
+		answersUpdate, err = g.registerChoices(msg.Pin, msg.Sessionid, msg.QuestionIndex, msg.Choices)
+	} else {
+		answersUpdate, err = g.registerAnswer(msg.Pin, msg.Sessionid, msg.QuestionIndex, msg.Answer)
+	}
+	if err != nil {
+		if _, ok := err.(*common.StaleAnswerError); ok {
+			// the player has already been sent the current question
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error() + " - your answer was not counted",
+				Nextscreen: "",
+			})
+			return
+		}
+
+		if _, ok := err.(*common.RetryAnswerError); ok {
+			// keep the player on the answer screen and let them answer again
+			ack := common.AnswerAcknowledgement{Choice: msg.Answer, Choices: msg.Choices}
+			if msg.Choices != nil {
+				ack.Choice = -1
+			}
+			g.sendAnswerRecorded(msg.Sessionid, ack)
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error(),
+				Nextscreen: "",
+			})
+			if game, err := g.get(msg.Pin); err == nil {
+				if question, err := game.Quiz.GetQuestion(game.QuestionIndex); err == nil {
+					g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+						Sessionid: msg.Sessionid,
+						Message:   displayChoices(&game, msg.Sessionid, question.NumAnswers()),
+					})
+				}
+			}
+			return
+		}
+
+		if _, ok := err.(*common.AttemptsExhaustedError); ok {
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error(),
+				Nextscreen: "wait-for-question-end",
+			})
+			return
+		}
+
+		if _, ok := err.(*common.AnswersNotOpenError); ok {
+			// keep the player on the answer screen
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error(),
+				Nextscreen: "",
+			})
+			return
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+			Sessionid: msg.Sessionid,
+			Pin:       -1,
+		})
+
+		if _, ok := err.(*common.NoSuchGameError); ok {
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error(),
+				Nextscreen: "entrance",
+			})
+			return
+		}
+
+		if errState, ok := err.(*common.UnexpectedStateError); ok {
+			switch errState.CurrentState {
+			case common.GameNotStarted:
+				g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+					Sessionid:  msg.Sessionid,
+					Nextscreen: "wait-for-game-start",
+				})
+
+			case common.ShowResults:
+				g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+					Sessionid:  msg.Sessionid,
+					Nextscreen: "display-player-results",
+				})
+
+			default:
+				g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+					Sessionid:  msg.Sessionid,
+					Nextscreen: "entrance",
+				})
+			}
+			return
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error registering answer: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		log.Printf("could not retrieve game %d: %v", msg.Pin, err)
+		return
+	}
+
+	if game.AnswerChangeAllowed() && game.GameState == common.QuestionInProgress {
+		// keep the player on the answer screen so that they can change
+		// their answer
+		if question, err := game.Quiz.GetQuestion(game.QuestionIndex); err == nil {
+			g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+				Sessionid: msg.Sessionid,
+				Message:   displayChoices(&game, msg.Sessionid, question.NumAnswers()),
+			})
+		}
+	} else {
+		// send this player to wait for question to end screen
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  msg.Sessionid,
+			Nextscreen: "wait-for-question-end",
+		})
+	}
+
+	encoded, err := common.ConvertToJSON(&answersUpdate)
+	if err != nil {
+		log.Printf("error converting players-answered payload to JSON: %v", err)
+		return
+	}
+
+	if ack, ok := game.RecordedAnswer(msg.Sessionid); ok {
+		g.sendAnswerRecorded(msg.Sessionid, ack)
+	}
+
+	// players that run out of attempts in practice mode are shown the
+	// correct answer
+	if game.AttemptsExhausted(msg.Sessionid) {
+		if question, err := game.Quiz.GetQuestion(game.QuestionIndex); err == nil {
+			if emphasis, err := emphasizedAnswer(&game, question, msg.Sessionid); err == nil {
+				g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+					Sessionid: msg.Sessionid,
+					Message:   "emphasize-answer " + emphasis,
+				})
+			}
+		}
+	}
+
+	if !answersUpdate.AllAnswered {
+		g.resetIdleTimer(msg.Pin, game.QuestionIndex)
+	}
+
+	host := game.Host
+	if host == "" {
+		return
+	}
+
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: host,
+		Message:   "players-answered " + encoded,
+	})
+}
+
+// lets the player know which choice was recorded for them
+func (g *Games) sendAnswerRecorded(sessionid string, ack common.AnswerAcknowledgement) {
+	encoded, err := common.ConvertToJSON(&ack)
+	if err != nil {
+		log.Printf("error converting answer-recorded payload to JSON: %v", err)
+		return
+	}
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: sessionid,
+		Message:   "answer-recorded " + encoded,
+	})
+}
+
+// player may have been disconnected - now they need to know about
+// their results
+func (g *Games) processQueryPlayerResultsMessage(msg common.QueryPlayerResultsMessage) {
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+			Sessionid: msg.Sessionid,
+			Pin:       -1,
+		})
+
+		if _, ok := err.(*common.NoSuchGameError); ok {
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error(),
+				Nextscreen: "entrance",
+			})
+			return
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error fetching game: " + err.Error(),
+			Nextscreen: "entrance",
+		})
+
+		return
+	}
+
+	_, correct := game.CorrectPlayers[msg.Sessionid]
+	score, ok := game.Players[msg.Sessionid]
+	if !ok {
+		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+			Sessionid: msg.Sessionid,
+			Pin:       -1,
+		})
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "you do not have a score in this game",
+			Nextscreen: "entrance",
+		})
+		return
+	}
+
+	// results are not available while the question is still live
+	if _, err := g.getCurrentQuestion(msg.Pin); err == nil {
+		nextscreen := "answer-question"
+		if g.answerIsFinal(msg.Pin, msg.Sessionid) {
+			nextscreen = "wait-for-question-end"
+		}
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  msg.Sessionid,
+			Nextscreen: nextscreen,
+		})
+		return
+	}
+
+	playerResults := struct {
+		Correct     bool   `json:"correct"`
+		Score       int    `json:"score"`
+		Explanation string `json:"explanation,omitempty"` // why the player's answer was wrong
+		Streak      int    `json:"streak"`                // number of questions in a row the player has answered correctly
+	}{
+		Correct:     correct,
+		Score:       score,
+		Explanation: game.PlayerChoiceExplanation(msg.Sessionid),
+		Streak:      game.CurrentStreak(msg.Sessionid),
+	}
+
+	encoded, err := common.ConvertToJSON(&playerResults)
+	if err != nil {
+		log.Printf("error converting player-results payload to JSON: %v", err)
+		return
+	}
+
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  "player-results " + encoded,
+	})
+}
+
+// player may have been disconnected - now they need to know how many
+// answers to enable
+func (g *Games) processQueryDisplayChoicesMessage(msg common.QueryDisplayChoicesMessage) {
+	currentQuestion, err := g.getCurrentQuestion(msg.Pin)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.SetSessionGamePinMessage{
+			Sessionid: msg.Sessionid,
+			Pin:       -1,
+		})
+
+		if _, ok := err.(*common.NoSuchGameError); ok {
+			g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  msg.Sessionid,
+				Message:    err.Error(),
+				Nextscreen: "entrance",
+			})
+			return
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error retrieving current question: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	// the player may have answered before reconnecting
+	if g.answerIsFinal(msg.Pin, msg.Sessionid) {
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  msg.Sessionid,
+			Nextscreen: "wait-for-question-end",
+		})
+		return
+	}
+
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		log.Printf("could not retrieve game %d: %v", msg.Pin, err)
+		return
+	}
+
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  displayChoices(&game, msg.Sessionid, len(currentQuestion.Answers)),
+	})
+	if questionContext := questionContextMessage(&game); questionContext != "" {
+		g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+			Clientid: msg.Clientid,
+			Message:  questionContext,
+		})
+	}
+}
+
+// Spends the player's 50:50 lifeline and sends the player the positions of
+// the removed answers - the rest of the player's screen is left alone so that
+// an answer the player can still change is kept
+func (g *Games) processFiftyFiftyMessage(msg common.FiftyFiftyMessage) {
+	game, err := g.getGamePointer(msg.Pin)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    err.Error(),
+			Nextscreen: "entrance",
+		})
+		return
+	}
+
+	g.mutex.Lock()
+	removed, err := game.UseFiftyFifty(msg.Sessionid)
+	if err != nil {
+		g.mutex.Unlock()
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "could not use lifeline: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+	questionIndex := game.QuestionIndex
+	g.mutex.Unlock()
+	g.persist(game)
+	log.Printf("player %s used 50:50 on question %d in game %d - removed %v", msg.Sessionid, questionIndex, msg.Pin, removed)
+
+	encoded, err := common.ConvertToJSON(&removed)
+	if err != nil {
+		log.Printf("error converting fifty-fifty-removed payload to JSON: %v", err)
+		return
+	}
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  "fifty-fifty-removed " + encoded,
+	})
+}
+
+// Returns the display-choices message for a player - if the quiz shuffles
+// answers per player, the answers are appended in the player's order
+func displayChoices(game *common.Game, sessionid string, answerCount int) string {
+	payload := struct {
+		Type        string   `json:"type,omitempty"`
+		MultiSelect bool     `json:"multiselect,omitempty"` // players select all the answers they think are correct
+		Answers     []string `json:"answers,omitempty"`
+		FiftyFifty  bool     `json:"fiftyfifty,omitempty"` // the player can use the 50:50 lifeline on this question
+		Removed     []int    `json:"removed,omitempty"`    // positions of the answers removed by the player's 50:50 lifeline
+	}{}
+	if question, err := game.Quiz.GetQuestion(game.QuestionIndex); err == nil {
+		payload.Type = question.Type
+		payload.MultiSelect = question.IsMultiSelect()
+	}
+	payload.FiftyFifty = game.FiftyFiftyAvailable(sessionid)
+	payload.Removed = game.RemovedAnswers(sessionid)
+	if game.Quiz.ShufflePerPlayer {
+		answers, err := game.PlayerAnswers(sessionid)
+		if err != nil {
+			log.Printf("error getting answers for player %s: %v", sessionid, err)
+		}
+		payload.Answers = answers
+	}
+	if payload.Type == "" && !payload.MultiSelect && payload.Answers == nil && !payload.FiftyFifty && payload.Removed == nil {
+		return fmt.Sprintf("display-choices %d", answerCount)
+	}
+	encoded, err := common.ConvertToJSON(&payload)
+	if err != nil {
+		log.Printf("error converting display-choices payload to JSON: %v", err)
+		return fmt.Sprintf("display-choices %d", answerCount)
+	}
+	return fmt.Sprintf("display-choices %d %s", answerCount, encoded)
+}
+
+// Returns a blank string if the context could not be encoded - players can
+// still answer without it
+func questionContextMessage(game *common.Game) string {
+	questionContext := game.GetPlayerQuestionContext()
+	encoded, err := common.ConvertToJSON(&questionContext)
+	if err != nil {
+		log.Printf("error converting question-context payload to JSON: %v", err)
+		return ""
+	}
+	return "question-context " + encoded
+}
+
+func (g *Games) processHostShowGameResultsMessage(msg common.HostShowGameResultsMessage) {
+	winners, err := g.getWinners(msg.Pin)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error retrieving game winners: " + err.Error(),
+			Nextscreen: "",
+		})
+
+		return
+	}
+
+	encoded, err := common.ConvertToJSON(&winners)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error converting show-winners payload to JSON: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+	log.Printf("winners for game %d: %s", msg.Pin, encoded)
+
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  "show-winners " + encoded,
+	})
+
+	g.sendGameSummary(msg.Clientid, msg.Pin)
+
+	// team standings are only sent if players joined teams
+	teams, err := g.getTeamWinners(msg.Pin)
+	if err != nil || len(teams) == 0 {
+		return
+	}
+	encoded, err = common.ConvertToJSON(&teams)
+	if err != nil {
+		log.Printf("error converting show-team-winners payload to JSON: %v", err)
+		return
+	}
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  "show-team-winners " + encoded,
+	})
+}
+
+// Sends the full standings so that the results screen can show a complete
+// breakdown of the game
+func (g *Games) sendGameSummary(clientid uint64, pin int) {
+	summary, err := g.getGameSummary(pin)
+	if err != nil {
+		log.Printf("error retrieving summary of game %d: %v", pin, err)
+		return
+	}
+	encoded, err := common.ConvertToJSON(&summary)
+	if err != nil {
+		log.Printf("error converting game-summary payload to JSON: %v", err)
+		return
+	}
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: clientid,
+		Message:  "game-summary " + encoded,
+	})
+}
+
+// Also sent when the host reconnects mid-question - the time left is
+// recomputed from the question's deadline and the deadline is left alone
+func (g *Games) processHostShowQuestionMessage(msg common.HostShowQuestionMessage) {
+	currentQuestion, err := g.getCurrentQuestion(msg.Pin)
+	if err != nil {
+		// if the host disconnected while the question was live, and if
+		// the game state has now changed, we may need to move the host to
+		// the relevant screen
+		unexpectedState, ok := err.(*common.UnexpectedStateError)
+		if ok && unexpectedState.CurrentState == common.ShowResults {
+			g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+				Sessionid:  msg.Sessionid,
+				Nextscreen: "show-results",
+			})
+			return
+		}
+
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error retrieving question: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	encoded, err := common.ConvertToJSON(&currentQuestion)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error converting question to JSON: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  "host-show-question " + encoded,
+	})
+}
+
+func (g *Games) processSendGameMetadataMessage(msg common.SendGameMetadataMessage) {
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    fmt.Sprintf("could not retrieve game %d", msg.Pin),
+			Nextscreen: "entrance",
+		})
+		return
+	}
+
+	// send over game object with lobby-game-metadata
+	players, _ := g.lobbyPlayerNames(game, "")
+	gameMetadata := struct {
+		Pin              int      `json:"pin"`
+		Name             string   `json:"name"`
+		Host             string   `json:"host"`
+		Players          []string `json:"players"`
+		PlayerCount      int      `json:"playercount"` // more than the number of players listed if the list was capped
+		ShuffleQuestions bool     `json:"shufflequestions"`
+		ShuffleAnswers   bool     `json:"shuffleanswers"`
+	}{
+		Pin:              game.Pin,
+		Name:             game.Quiz.Name,
+		Host:             game.Host,
+		Players:          players,
+		PlayerCount:      len(game.Players),
+		ShuffleQuestions: game.Quiz.ShuffleQuestions,
+		ShuffleAnswers:   game.Quiz.ShuffleAnswers,
+	}
+
+	encoded, err := common.ConvertToJSON(&gameMetadata)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "error converting lobby-game-metadata payload to JSON: " + err.Error(),
+			Nextscreen: "",
+		})
+		return
+	}
+
+	g.msghub.Send(messaging.ClientHubTopic, common.ClientMessage{
+		Clientid: msg.Clientid,
+		Message:  "lobby-game-metadata " + encoded,
+	})
+}
+
+// returns true if processed
+func (g *Games) processAddPlayerToGameMessage(msg common.AddPlayerToGameMessage) {
+	name, err := g.addPlayerToGame(msg)
+	if err != nil {
+		g.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+			Sessionid:  msg.Sessionid,
+			Message:    "could not add player to game: " + err.Error(),
+			Nextscreen: "entrance",
+		})
+		return
+	}
+
+	g.msghub.Send(messaging.SessionsTopic, common.BindGameToSessionMessage{
+		Sessionid:   msg.Sessionid,
+		Name:        name,
+		Pin:         msg.Pin,
+		Fingerprint: msg.Fingerprint,
+	})
+	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+		Sessionid:  msg.Sessionid,
+		Nextscreen: "wait-for-game-start",
+	})
+
+	// inform game host of new player
+	game, err := g.get(msg.Pin)
+	if err != nil {
+		log.Printf("could not retrieve game %d: %v", msg.Pin, err)
+		return
+	}
+	if game.Host == "" {
+		log.Printf("could not inform host of new player because game %d has not host", msg.Pin)
+		return
+	}
+	g.sendParticipantsToHost(game, msg.Sessionid)
+
+	// let the player know that they can submit questions
+	if game.Quiz.AcceptSubmissions {
+		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+			Sessionid: msg.Sessionid,
+			Message:   "submissions-open",
+		})
+	}
+}
+
+// Sends the names of the players in the lobby to the host - newest is the
+// player that should be included if the list has to be capped
+func (g *Games) sendParticipantsToHost(game common.Game, newest string) {
+	players, capped := g.lobbyPlayerNames(game, newest)
+	g.sendPlayerNamesToHost(game, players, capped)
+}
+
+// Sends a summary instead of the list if the names were capped
+func (g *Games) sendPlayerNamesToHost(game common.Game, players []string, capped bool) {
+	host := game.Host
+	if capped {
+		// only send a sample of the names so that large games don't
+		// send the whole roster on every join
+		summary := struct {
+			Count  int      `json:"count"`
+			Sample []string `json:"sample"`
+		}{
+			Count:  len(game.Players),
+			Sample: players,
+		}
+		encoded, err := common.ConvertToJSON(&summary)
+		if err != nil {
+			log.Printf("error encoding participants summary: %v", err)
+			return
+		}
+		g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+			Sessionid: host,
+			Message:   "participants-summary " + encoded,
+		})
+		return
+	}
+	encoded, err := common.ConvertToJSON(&players)
+
+	if err != nil {
+		log.Printf("error encoding player names: %v", err)
+		return
+	}
+
+	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
+		Sessionid: host,
+		Message:   "participants-list " + encoded,
+	})
+}
+
+// Flushes game writes that were held while the persistent store was slow
+func (g *Games) RunPersistenceBreaker(ctx context.Context, shutdownComplete func()) {
+	g.writer.Run(ctx, shutdownComplete)
+}
+
+// Flushes the game writes that are still held - called once the other
+// goroutines have shut down so that their last writes are not lost
+func (g *Games) FlushHeldWrites() {
+	g.writer.Flush()
+}
+
+func (g *Games) persist(game *common.Game) {
+	if g.writer == nil {
+		return
+	}
+	data, err := g.marshal(game)
+	if err != nil {
+		log.Printf("error trying to convert game %d to JSON: %v", game.Pin, err)
+		return
+	}
+	if err := g.writer.Set(fmt.Sprintf("game:%d", game.Pin), data, 0); err != nil {
+		log.Printf("error trying to persist game %d: %v", game.Pin, err)
+	}
+}
+
+func (g *Games) marshal(game *common.Game) ([]byte, error) {
+	if g.compress {
+		return game.MarshalCompressed()
+	}
+	return game.Marshal()
+}
+
+// called by the REST API
+func (g *Games) getAll() []common.Game {
+	if g.engine == nil {
+		all := []common.Game{}
+		for _, game := range g.all {
+			all = append(all, *game)
+		}
+		return all
+	}
+
+	keys, err := g.engine.GetKeys("game")
+	if err != nil {
+		log.Printf("error getting all game keys from persistent store: %v", err)
+		return nil
+	}
+	keys = mergeHeldKeys(keys, g.writer.HeldKeys("game:"))
+	all := []common.Game{}
+	for _, key := range keys {
+		key = key[len("game:"):]
+		keyInt, err := strconv.Atoi(key)
+		if err != nil {
+			log.Printf("could not convert game key %s to int: %v", key[len("game:"):], err)
+			continue
+		}
+		game, err := g.get(keyInt)
+		if err != nil {
+			log.Print(err.Error())
+			continue
+		}
+		all = append(all, game)
+	}
+	return all
+}
+
+// Games that were added or deleted while the persistent store is degraded
+// are only known to the breaker
+func mergeHeldKeys(keys []string, held map[string]bool) []string {
+	merged := []string{}
+	for _, key := range keys {
+		deleted, ok := held[key]
+		delete(held, key)
+		if ok && deleted {
+			continue
+		}
+		merged = append(merged, key)
+	}
+	added := []string{}
+	for key, deleted := range held {
+		if !deleted {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	return append(merged, added...)
+}
+
+func (g *Games) add(host string) (int, error) {
+	game := common.Game{
+		Host:               host,
+		Players:            make(map[string]int),
+		PlayerNames:        make(map[string]string),
+		PlayersAnswered:    make(map[string]struct{}),
+		CaseSensitiveNames: g.caseSensitiveNames,
+		AnswerGrace:        int(g.answerGrace / time.Millisecond),
+		AnswerLogCap:       g.answerLogCap,
+	}
+
+	for i := 0; i < maxPinAttempts; i++ {
+		pin, err := generatePin(g.pinLength)
+		if err != nil {
+			return 0, err
+		}
+		game.Pin = pin
+
+		g.mutex.RLock()
+		_, taken := g.all[pin]
+		g.mutex.RUnlock()
+		if taken {
+			continue
+		}
+		// the pin is reserved outside the lock - the breaker waits no longer
+		// than the slow write threshold for the store
+		reservation, reserved := g.reservePin(&game)
+		if !reserved {
+			continue
+		}
+
+		// checked again under the lock so that games created concurrently
+		// cannot end up with the same pin
+		g.mutex.Lock()
+		if _, taken := g.all[pin]; taken {
+			g.mutex.Unlock()
+			g.writer.Release(fmt.Sprintf("game:%d", pin), reservation)
+			continue
+		}
+		g.all[pin] = &game
+		g.hosting[host] = pin
+		g.mutex.Unlock()
+		return pin, nil
+	}
+	return 0, errors.New("could not generate unique game pin")
+}
+
+// Writes the new game to the persistent store only if no other instance has
+// a game with the same pin - returns false if the pin is taken. The write
+// goes through the breaker so the game is held in memory if the store is
+// degraded. The written value is returned so that the reservation can be
+// released if the game is not added after all.
+func (g *Games) reservePin(game *common.Game) ([]byte, bool) {
+	if g.writer == nil {
+		return nil, true
+	}
+	data, err := g.marshal(game)
+	if err != nil {
+		log.Printf("error trying to convert game %d to JSON: %v", game.Pin, err)
+		return nil, true
+	}
+	reserved, err := g.writer.SetNX(fmt.Sprintf("game:%d", game.Pin), data, 0)
+	if err != nil {
+		log.Printf("error reserving pin %d in the persistent store: %v", game.Pin, err)
+		return data, true
+	}
+	return data, reserved
+}
+
+// Returns a pin with exactly length digits - all pins are equally likely and
+// 0 is never returned
+func generatePin(length int) (int, error) {
+	low := 1
+	for i := 1; i < length; i++ {
+		low *= 10
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(low*10-low)))
+	if err != nil {
+		return 0, fmt.Errorf("error generating game pin: %v", err)
+	}
+	return low + int(n.Int64()), nil
+}
+
+func (g *Games) getGamePointer(pin int) (*common.Game, error) {
+	g.mutex.RLock()
+	game, ok := g.all[pin]
+	g.mutex.RUnlock()
+
+	if ok {
+		return game, nil
+	}
+
+	if g.engine == nil {
+		return nil, common.NewNoSuchGameError(pin)
+	}
+
+	// game doesn't exist in memory - see if it's in the persistent store
+	data, err := g.engine.Get(fmt.Sprintf("game:%d", pin))
+	if err != nil {
+		return nil, common.NewNoSuchGameError(pin)
+	}
+	game, err = common.UnmarshalGame(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve game %d from persistent store: %v", pin, err)
+	}
+
+	g.mutex.Lock()
+	g.all[pin] = game
+	g.mutex.Unlock()
+
+	return game, nil
+}
+
+// called by the REST API
+func (g *Games) get(pin int) (common.Game, error) {
+	gp, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.Game{}, err
+	}
+
+	return gp.Copy(), nil
+}
+
+// Updates an existing game - the host of the game cannot be changed
+func (g *Games) validatedUpdate(game common.Game) error {
+	existing, err := g.getGamePointer(game.Pin)
+	if err != nil {
+		return err
+	}
+	g.mutex.RLock()
+	host := existing.Host
+	g.mutex.RUnlock()
+	if game.Host != host {
+		return fmt.Errorf("the host of game %d cannot be changed", game.Pin)
+	}
+	g.update(game)
+	return nil
+}
+
+func (g *Games) update(game common.Game) {
+	p := &game
+
+	g.mutex.Lock()
+	g.all[game.Pin] = p
+	g.mutex.Unlock()
+
+	g.persist(p)
+}
+
+// Returns the pin of the game that the session is hosting - false if the
+// session is not hosting a game or the game has ended
+func (g *Games) hostedGame(sessionid string) (int, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	pin, ok := g.hosting[sessionid]
+	if !ok {
+		return 0, false
+	}
+	game, ok := g.all[pin]
+	if !ok || game.Host != sessionid || game.GameState == common.GameEnded {
+		return 0, false
+	}
+	return pin, true
+}
+
+// Returns a copy of the removed game - false if the game did not exist
+func (g *Games) delete(pin int) (common.Game, bool) {
+	g.mutex.Lock()
+	game, ok := g.all[pin]
+	if !ok {
+		g.mutex.Unlock()
+		return common.Game{}, false
+	}
+	if g.hosting[game.Host] == pin {
+		delete(g.hosting, game.Host)
+	}
+	delete(g.all, pin)
+	removed := game.Copy()
+	g.mutex.Unlock()
+
+	g.writer.Delete(fmt.Sprintf("game:%d", pin))
+	return removed, true
+}
+
+// Deletes the game and sends its players to the entrance and its host to
+// hostScreen. Deleting a game that was already deleted - e.g. when the host
+// and the REST API delete the game at the same time - does nothing so that
+// the sessions are only released once. Returns false if the game did not
+// exist.
+func (g *Games) teardown(pin int, hostScreen string) bool {
+	game, ok := g.delete(pin)
+	if !ok {
+		return false
+	}
+
+	players := game.GetPlayers()
+	g.msghub.Send(messaging.SessionsTopic, common.DeregisterGameFromSessionsMessage{
+		Sessions: append(players, game.Host),
+	})
+	for _, playerid := range players {
+		g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+			Sessionid:  playerid,
+			Nextscreen: "entrance",
+		})
+	}
+	g.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+		Sessionid:  game.Host,
+		Nextscreen: hostScreen,
+	})
+	return true
+}
+
+// Returns the name that the player was added with - this may differ from
+// the requested name if duplicate names are disambiguated
+func (g *Games) addPlayerToGame(msg common.AddPlayerToGameMessage) (string, error) {
+	game, err := g.getGamePointer(msg.Pin)
+	if err != nil {
+		return "", common.NewNoSuchGameError(msg.Pin)
+	}
+
+	if game.GameState != common.GameNotStarted {
+		return "", errors.New("game is not accepting new players")
+	}
+
+	if g.oneJoinPerDevice && msg.Fingerprint == "" {
+		return "", errors.New("your device could not be identified")
+	}
+
+	name := strings.TrimSpace(msg.Name)
+	g.mutex.Lock()
+	if g.mergeRejoins {
+		if previous, ok := game.ReplacePlayer(name, msg.Sessionid); ok {
+			game.RecordFingerprint(msg.Sessionid, msg.Fingerprint)
+			name = game.PlayerNames[msg.Sessionid]
+			g.mutex.Unlock()
+			log.Printf("player %s rejoined game %d with session %s - replacing session %s", name, msg.Pin, msg.Sessionid, previous)
+			g.persist(game)
+			g.msghub.Send(messaging.SessionsTopic, common.DeregisterGameFromSessionsMessage{
+				Sessions: []string{previous},
+			})
+			return name, nil
+		}
+	}
+	if g.oneJoinPerDevice && game.DeviceJoined(msg.Sessionid, msg.Fingerprint) {
+		g.mutex.Unlock()
+		return "", errors.New("another player has already joined the game from this device")
+	}
+	if game.NameExistsInGame(name) {
+		if !g.disambiguateNames {
+			g.mutex.Unlock()
+			return "", common.NewNameExistsInGameError(name, msg.Pin)
+		}
+		if existing, ok := game.PlayerNames[msg.Sessionid]; ok {
+			// player is already in the game
+			g.mutex.Unlock()
+			return existing, nil
+		}
+		name = game.UniqueName(name)
+	}
+	changed := game.AddPlayer(msg.Sessionid, name)
+	autoStart := false
+	if changed {
+		game.RecordFingerprint(msg.Sessionid, msg.Fingerprint)
+		game.SetTeam(msg.Sessionid, strings.TrimSpace(msg.Team))
+		autoStart = game.ClaimAutoStart()
+	}
+	delay := time.Duration(game.Quiz.AutoStartDelay) * time.Second
+	g.mutex.Unlock()
+	if changed {
+		g.persist(game)
+	}
+	if autoStart {
+		g.scheduleAutoStart(msg.Pin, delay)
+	}
+	return name, nil
+}
+
+func (g *Games) scheduleAutoStart(pin int, delay time.Duration) {
+	log.Printf("game %d will auto-start in %v", pin, delay)
+	time.AfterFunc(delay, func() {
+		g.msghub.Send(messaging.GamesTopic, common.AutoStartGameMessage{Pin: pin})
+	})
+}
+
+// Returns the names of the players to list in the host's lobby and true if
+// the list was capped at a sample of the names - newest is listed first in
+// the sample
+func (g *Games) lobbyPlayerNames(game common.Game, newest string) ([]string, bool) {
+	if g.lobbyDisplayCap <= 0 || len(game.PlayerNames) <= g.lobbyDisplayCap {
+		return game.GetPlayerNames(), false
+	}
+	return game.SamplePlayerNames(g.lobbyDisplayCap, newest), true
+}
+
+// Starts sending host status updates to players if they are not already
+// waiting on the results of this question
+func (g *Games) startHostWait(pin, questionIndex int) {
+	if g.hostWaitInterval <= 0 {
+		return
+	}
+	g.mutex.Lock()
+	if index, ok := g.hostWaits[pin]; ok && index == questionIndex {
+		g.mutex.Unlock()
 		return
 	}
+	g.hostWaits[pin] = questionIndex
+	g.mutex.Unlock()
+	g.scheduleHostWait(pin, questionIndex)
+}
 
-	g.msghub.Send(messaging.SessionsTopic, common.SessionMessage{
-		Sessionid: host,
-		Message:   "participants-list " + encoded,
+func (g *Games) scheduleHostWait(pin, questionIndex int) {
+	time.AfterFunc(g.hostWaitInterval, func() {
+		g.msghub.Send(messaging.GamesTopic, common.HostWaitingMessage{
+			Pin:           pin,
+			QuestionIndex: questionIndex,
+		})
 	})
 }
 
-func (g *Games) persist(game *common.Game) {
-	if g.engine == nil {
+// Restarts the countdown to ending the question early - called whenever an
+// answer arrives
+func (g *Games) resetIdleTimer(pin, questionIndex int) {
+	if g.advanceWhenIdle <= 0 {
 		return
 	}
-	data, err := game.Marshal()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	generation := 0
+	if current, ok := g.idleTimers[pin]; ok {
+		current.timer.Stop()
+		generation = current.generation + 1
+	}
+	g.idleTimers[pin] = &idleTimer{
+		timer: time.AfterFunc(g.advanceWhenIdle, func() {
+			g.msghub.Send(messaging.GamesTopic, common.QuestionIdleMessage{
+				Pin:           pin,
+				QuestionIndex: questionIndex,
+				Generation:    generation,
+			})
+		}),
+		generation: generation,
+	}
+}
+
+func (g *Games) setGameQuiz(pin int, quiz common.Quiz) {
+	game, err := g.getGamePointer(pin)
 	if err != nil {
-		log.Printf("error trying to convert game %d to JSON: %v", game.Pin, err)
 		return
 	}
-	if err := g.engine.Set(fmt.Sprintf("game:%d", game.Pin), data, 0); err != nil {
-		log.Printf("error trying to persist game %d: %v", game.Pin, err)
-	}
-}
 
-// called by the REST API
-func (g *Games) getAll() []common.Game {
-	if g.engine == nil {
-		all := []common.Game{}
-		for _, game := range g.all {
-			all = append(all, *game)
+	if quiz.PickQuestions > 0 {
+		// avoid the questions asked in the last game that used the quiz
+		quiz.Pick(quiz.PickQuestions, g.recentQuestions.Get(quiz.Id))
+		hashes := make([]string, quiz.NumQuestions())
+		for i, question := range quiz.Questions {
+			hashes[i] = question.Hash()
 		}
-		return all
+		g.recentQuestions.Set(quiz.Id, hashes)
 	}
 
-	keys, err := g.engine.GetKeys("game")
-	if err != nil {
-		log.Printf("error getting all game keys from persistent store: %v", err)
-		return nil
-	}
-	all := []common.Game{}
-	for _, key := range keys {
-		key = key[len("game:"):]
-		keyInt, err := strconv.Atoi(key)
-		if err != nil {
-			log.Printf("could not convert game key %s to int: %v", key[len("game:"):], err)
+	g.mutex.Lock()
+	game.SetQuiz(quiz)
+	g.all[pin] = game // this is redundant
+	g.mutex.Unlock()
+
+	g.persist(game)
+}
+
+// Pushes an updated quiz into the games using it - games that have not
+// started switch to the update immediately, games in progress switch at the
+// next question so that the live question is not changed. Games in progress
+// that shuffle their questions are left alone because their unasked questions
+// cannot be matched with the update, and so are games that pick their
+// questions because they already have their draw. Returns the pins of the
+// updated games.
+func (g *Games) pushQuiz(quiz common.Quiz) []int {
+	pins := []int{}
+	for _, copied := range g.getAll() {
+		if copied.Quiz.Id != quiz.Id || copied.GameState == common.GameEnded {
 			continue
 		}
-		game, err := g.get(keyInt)
-		if err != nil {
-			log.Print(err.Error())
+		if copied.Quiz.PickQuestions > 0 {
+			log.Printf("not pushing quiz %d to game %d because the game picks its questions", quiz.Id, copied.Pin)
 			continue
 		}
-		all = append(all, game)
-	}
-	return all
-}
-
-func (g *Games) add(host string) (int, error) {
-	game := common.Game{
-		Host:            host,
-		Players:         make(map[string]int),
-		PlayerNames:     make(map[string]string),
-		PlayersAnswered: make(map[string]struct{}),
-	}
-
-	for i := 0; i < 5; i++ {
-		pin := generatePin()
-		if exists, _ := g.getGamePointer(pin); exists != nil {
+		if copied.GameState == common.GameNotStarted {
+			g.setGameQuiz(copied.Pin, quiz)
+			pins = append(pins, copied.Pin)
 			continue
 		}
-		game.Pin = pin
+		if copied.Quiz.ShuffleQuestions {
+			log.Printf("not pushing quiz %d to game %d because the game shuffles its questions", quiz.Id, copied.Pin)
+			continue
+		}
+		game, err := g.getGamePointer(copied.Pin)
+		if err != nil {
+			continue
+		}
+		pending := quiz
 		g.mutex.Lock()
-		g.all[pin] = &game
+		game.ShuffleOverride.Apply(&pending)
+		game.PendingQuiz = &pending
 		g.mutex.Unlock()
-		g.persist(&game)
-		return pin, nil
+		g.persist(game)
+		pins = append(pins, copied.Pin)
 	}
-	return 0, errors.New("could not generate unique game pin")
+	sort.Ints(pins)
+	return pins
 }
 
-func generatePin() int {
-	b := make([]byte, 4)
-	rand.Read(b)
+func (g *Games) setGameQuestionDuration(pin, duration int) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return
+	}
 
-	total := int(b[0]) + int(b[1]) + int(b[2]) + int(b[3])
-	total = total % 998
-	total++
-	return total
-}
+	g.mutex.Lock()
+	game.QuestionDuration = duration
+	g.mutex.Unlock()
 
-func (g *Games) getGamePointer(pin int) (*common.Game, error) {
-	g.mutex.RLock()
-	game, ok := g.all[pin]
-	g.mutex.RUnlock()
+	g.persist(game)
+}
 
-	if ok {
-		return game, nil
+func (g *Games) setGameLabel(pin int, label string) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return
 	}
 
-	if g.engine == nil {
-		return nil, common.NewNoSuchGameError(pin)
-	}
+	g.mutex.Lock()
+	game.Label = label
+	g.mutex.Unlock()
 
-	// game doesn't exist in memory - see if it's in the persistent store
-	data, err := g.engine.Get(fmt.Sprintf("game:%d", pin))
-	if err != nil {
-		return nil, common.NewNoSuchGameError(pin)
-	}
-	game, err = common.UnmarshalGame(data)
+	g.persist(game)
+}
+
+// Advances the game state to the next state - returns the new state
+func (g *Games) nextState(pin int) (int, error) {
+	game, err := g.getGamePointer(pin)
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve game %d from persistent store: %v", pin, err)
+		return 0, common.NewNoSuchGameError(pin)
 	}
 
 	g.mutex.Lock()
-	g.all[pin] = game
+	previous := game.GameState
+	state, err := game.NextState()
+	var results common.GameResults
+	ended := state == common.GameEnded && previous != common.GameEnded && previous != common.GameNotStarted
+	if ended {
+		results = game.GetGameResults()
+	}
 	g.mutex.Unlock()
-
-	return game, nil
+	g.persist(game)
+	if ended {
+		g.webhook.Send(results)
+	}
+	return state, err
 }
 
-// called by the REST API
-func (g *Games) get(pin int) (common.Game, error) {
-	gp, err := g.getGamePointer(pin)
+func (g *Games) beginAnswers(pin int) error {
+	game, err := g.getGamePointer(pin)
 	if err != nil {
-		return common.Game{}, err
+		return common.NewNoSuchGameError(pin)
 	}
 
-	return gp.Copy(), nil
+	g.mutex.Lock()
+	err = game.BeginAnswers()
+	g.mutex.Unlock()
+	if err == nil {
+		g.persist(game)
+	}
+	return err
 }
 
-func (g *Games) update(game common.Game) {
-	p := &game
+func (g *Games) skipQuestion(pin int) error {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.NewNoSuchGameError(pin)
+	}
 
 	g.mutex.Lock()
-	g.all[game.Pin] = p
+	err = game.SkipQuestion()
 	g.mutex.Unlock()
-
-	g.persist(p)
+	if err == nil {
+		g.persist(game)
+	}
+	return err
 }
 
-func (g *Games) delete(pin int) {
+func (g *Games) pauseGame(pin int) error {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.NewNoSuchGameError(pin)
+	}
+
 	g.mutex.Lock()
-	delete(g.all, pin)
+	err = game.Pause()
 	g.mutex.Unlock()
+	if err == nil {
+		g.persist(game)
+	}
+	return err
+}
 
-	if g.engine != nil {
-		g.engine.Delete(fmt.Sprintf("game:%d", pin))
+func (g *Games) resumeGame(pin int) error {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.NewNoSuchGameError(pin)
 	}
 
+	g.mutex.Lock()
+	err = game.Resume()
+	g.mutex.Unlock()
+	if err == nil {
+		g.persist(game)
+	}
+	return err
 }
 
-func (g *Games) addPlayerToGame(msg common.AddPlayerToGameMessage) error {
-	game, err := g.getGamePointer(msg.Pin)
+// Records the player's connection state and persists the game if it changed
+func (g *Games) setPlayerConnected(pin int, sessionid string, connected bool) {
+	game, err := g.getGamePointer(pin)
 	if err != nil {
-		return common.NewNoSuchGameError(msg.Pin)
+		return
 	}
 
-	if game.GameState != common.GameNotStarted {
-		return errors.New("game is not accepting new players")
+	g.mutex.Lock()
+	changed := game.SetConnected(sessionid, connected)
+	g.mutex.Unlock()
+	if changed {
+		g.persist(game)
 	}
+}
 
-	name := strings.TrimSpace(msg.Name)
-	g.mutex.Lock()
-	if game.NameExistsInGame(name) {
-		g.mutex.Unlock()
-		return common.NewNameExistsInGameError(name, msg.Pin)
+func (g *Games) revealNextBar(pin int) (bool, error) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return false, common.NewNoSuchGameError(pin)
 	}
-	changed := game.AddPlayer(msg.Sessionid, name)
+
+	g.mutex.Lock()
+	done, err := game.RevealNextBar()
 	g.mutex.Unlock()
-	if changed {
+	if err == nil {
 		g.persist(game)
 	}
-	return nil
+	return done, err
 }
 
-func (g *Games) setGameQuiz(pin int, quiz common.Quiz) {
+// Returns the index of the flagged question and the number of flags for it
+func (g *Games) flagQuestion(pin int, sessionid, reason string) (int, int, error) {
 	game, err := g.getGamePointer(pin)
 	if err != nil {
-		return
+		return 0, 0, common.NewNoSuchGameError(pin)
 	}
 
-	if quiz.ShuffleQuestions {
-		quiz.Shuffle()
+	now := time.Now()
+	g.mutex.Lock()
+	if last, ok := g.lastFlags[sessionid]; ok && now.Sub(last) < flagInterval {
+		g.mutex.Unlock()
+		return 0, 0, errors.New("you are flagging questions too quickly - try again later")
+	}
+	count, err := game.FlagQuestion(sessionid, reason)
+	questionIndex := game.QuestionIndex
+	var flag common.QuestionFlag
+	if err == nil {
+		g.recordFlagTime(sessionid, now)
+		flag = common.QuestionFlag{
+			Quizid:        game.Quiz.Id,
+			Pin:           pin,
+			QuestionIndex: questionIndex,
+			Reason:        game.Flags[questionIndex][sessionid],
+			Time:          now,
+		}
+		if question, err := game.Quiz.GetQuestion(questionIndex); err == nil {
+			flag.Question = question.Question
+		}
+	}
+	g.mutex.Unlock()
+	if err != nil {
+		return 0, 0, err
 	}
+	g.persist(game)
+	g.questionFlags.Add(flag)
+	return questionIndex, count, nil
+}
 
-	if quiz.ShuffleAnswers {
-		for i, question := range quiz.Questions {
-			quiz.Questions[i] = question.ShuffleAnswers()
+// Must be called with the mutex held - times that can no longer limit a
+// player are dropped so that the map does not grow with every session
+func (g *Games) recordFlagTime(sessionid string, now time.Time) {
+	for k, last := range g.lastFlags {
+		if now.Sub(last) >= flagInterval {
+			delete(g.lastFlags, k)
 		}
 	}
+	g.lastFlags[sessionid] = now
+}
+
+func (g *Games) submitQuestion(pin int, sessionid string, question common.QuizQuestion) (common.SubmittedQuestion, error) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.SubmittedQuestion{}, common.NewNoSuchGameError(pin)
+	}
 
 	g.mutex.Lock()
-	game.SetQuiz(quiz)
-	g.all[pin] = game // this is redundant
+	submission, err := game.SubmitQuestion(sessionid, question)
 	g.mutex.Unlock()
-
+	if err != nil {
+		return common.SubmittedQuestion{}, err
+	}
 	g.persist(game)
+	return submission, nil
 }
 
-// Advances the game state to the next state - returns the new state
-func (g *Games) nextState(pin int) (int, error) {
+func (g *Games) moderateSubmission(pin, id int, approve bool) (common.SubmittedQuestion, error) {
 	game, err := g.getGamePointer(pin)
 	if err != nil {
-		return 0, common.NewNoSuchGameError(pin)
+		return common.SubmittedQuestion{}, common.NewNoSuchGameError(pin)
 	}
 
 	g.mutex.Lock()
-	state, err := game.NextState()
+	submission, err := game.ModerateSubmission(id, approve)
 	g.mutex.Unlock()
+	if err != nil {
+		return common.SubmittedQuestion{}, err
+	}
 	g.persist(game)
-	return state, err
+	return submission, nil
 }
 
 // A special instance of NextState() - if we are in the QuestionInProgress
@@ -1023,13 +2733,60 @@ func (g *Games) getCurrentQuestion(pin int) (common.GameCurrentQuestion, error)
 	return currentQuestion, err
 }
 
-func (g *Games) registerAnswer(pin int, sessionid string, answerIndex int) (common.AnswersUpdate, error) {
+func (g *Games) answerIsFinal(pin int, sessionid string) bool {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return false
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return game.AnswerIsFinal(sessionid)
+}
+
+// choices is either an ordering or a selection depending on the type of the
+// current question. questionIndex is the question the player is answering -
+// the answer is registered against the current question if it is nil.
+func (g *Games) registerChoices(pin int, sessionid string, questionIndex *int, choices []int) (common.AnswersUpdate, error) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.AnswersUpdate{}, common.NewNoSuchGameError(pin)
+	}
+
+	g.mutex.Lock()
+	if questionIndex != nil {
+		if err := game.EnsureCurrentQuestion(*questionIndex); err != nil {
+			g.mutex.Unlock()
+			return common.AnswersUpdate{}, err
+		}
+	}
+	register := game.RegisterOrdering
+	if question, err := game.Quiz.GetQuestion(game.QuestionIndex); err == nil && question.IsMultiSelect() {
+		register = game.RegisterSelection
+	}
+	changed, update, err := register(sessionid, choices)
+	g.mutex.Unlock()
+	if changed {
+		g.persist(game)
+	}
+	return update, err
+}
+
+// questionIndex is the question the player is answering - the answer is
+// registered against the current question if it is nil
+func (g *Games) registerAnswer(pin int, sessionid string, questionIndex *int, answerIndex int) (common.AnswersUpdate, error) {
 	game, err := g.getGamePointer(pin)
 	if err != nil {
 		return common.AnswersUpdate{}, common.NewNoSuchGameError(pin)
 	}
 
 	g.mutex.Lock()
+	if questionIndex != nil {
+		if err := game.EnsureCurrentQuestion(*questionIndex); err != nil {
+			g.mutex.Unlock()
+			return common.AnswersUpdate{}, err
+		}
+	}
 	changed, update, err := game.RegisterAnswer(sessionid, answerIndex)
 	g.mutex.Unlock()
 	if changed {
@@ -1049,6 +2806,28 @@ func (g *Games) getQuestionResults(pin int) (common.QuestionResults, error) {
 	return game.GetQuestionResults()
 }
 
+func (g *Games) getTeamWinners(pin int) ([]common.PlayerScore, error) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return []common.PlayerScore{}, common.NewNoSuchGameError(pin)
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return game.GetTeamWinners(), nil
+}
+
+func (g *Games) getGameSummary(pin int) (common.GameSummary, error) {
+	game, err := g.getGamePointer(pin)
+	if err != nil {
+		return common.GameSummary{}, common.NewNoSuchGameError(pin)
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return game.GetGameSummary(), nil
+}
+
 func (g *Games) getWinners(pin int) ([]common.PlayerScore, error) {
 	game, err := g.getGamePointer(pin)
 	if err != nil {
@@ -1057,5 +2836,8 @@ func (g *Games) getWinners(pin int) ([]common.PlayerScore, error) {
 
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
+	if game.Quiz.WeightByDifficulty {
+		return game.GetDifficultyWeightedWinners(), nil
+	}
 	return game.GetWinners(), nil
 }
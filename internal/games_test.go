@@ -0,0 +1,2372 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+	"github.com/kwkoo/go-quiz/internal/messaging"
+)
+
+// records all sent messages so that they can be inspected by tests
+type fakeMessageHub struct {
+	mux  sync.Mutex
+	sent map[string][]interface{}
+}
+
+func newFakeMessageHub() *fakeMessageHub {
+	return &fakeMessageHub{sent: make(map[string][]interface{})}
+}
+
+func (mh *fakeMessageHub) Send(topicname string, msg interface{}) {
+	mh.mux.Lock()
+	defer mh.mux.Unlock()
+	mh.sent[topicname] = append(mh.sent[topicname], msg)
+}
+
+func (mh *fakeMessageHub) Close() {}
+
+func (mh *fakeMessageHub) GetTopic(name string) chan interface{} {
+	return make(chan interface{})
+}
+
+// returns all messages sent to the topic since the last call
+func (mh *fakeMessageHub) drain(topic string) []interface{} {
+	mh.mux.Lock()
+	defer mh.mux.Unlock()
+	msgs := mh.sent[topic]
+	delete(mh.sent, topic)
+	return msgs
+}
+
+func newTestGames() (*Games, *fakeMessageHub) {
+	mh := newFakeMessageHub()
+	return InitGames(mh, nil, GamesOptions{}), mh
+}
+
+// adds a game with the given host, players and quiz to games
+func addTestGame(t *testing.T, games *Games, host string, players []string, quiz common.Quiz) int {
+	pin, err := games.add(host)
+	if err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+	games.setGameQuiz(pin, quiz)
+	for _, player := range players {
+		if _, err := games.addPlayerToGame(common.AddPlayerToGameMessage{
+			Sessionid: player,
+			Name:      player,
+			Pin:       pin,
+		}); err != nil {
+			t.Fatalf("error adding player %s to game: %v", player, err)
+		}
+	}
+	return pin
+}
+
+func testQuiz() common.Quiz {
+	return common.Quiz{
+		Id:               1,
+		Name:             "test quiz",
+		QuestionDuration: 20,
+		Questions: []common.QuizQuestion{
+			{
+				Question: "question 0",
+				Answers:  []string{"zero", "one", "two", "three"},
+				Correct:  1,
+			},
+			{
+				Question: "question 1",
+				Answers:  []string{"zero", "one", "two", "three"},
+				Correct:  2,
+			},
+		},
+	}
+}
+
+// returns the messages sent to a session with the given prefix
+func sessionMessages(msgs []interface{}, sessionid, prefix string) []string {
+	matched := []string{}
+	for _, msg := range msgs {
+		m, ok := msg.(common.SessionMessage)
+		if !ok || m.Sessionid != sessionid || !strings.HasPrefix(m.Message, prefix) {
+			continue
+		}
+		matched = append(matched, m.Message)
+	}
+	return matched
+}
+
+func TestFlagQuestionNotifiesHost(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	games.processFlagQuestionMessage(common.FlagQuestionMessage{Sessionid: "player1", Pin: pin})
+	games.processFlagQuestionMessage(common.FlagQuestionMessage{Sessionid: "player2", Pin: pin})
+
+	notifications := sessionMessages(mh.drain(messaging.SessionsTopic), "host", "question-flagged ")
+	if len(notifications) != 2 {
+		t.Fatalf("expected host to receive 2 notifications but got %d", len(notifications))
+	}
+	expected := `question-flagged {"questionindex":0,"flags":2}`
+	if strings.TrimSpace(notifications[1]) != expected {
+		t.Errorf("expected %s but got %s", expected, notifications[1])
+	}
+}
+
+func TestFlaggedQuestionsAreKept(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	games.processFlagQuestionMessage(common.FlagQuestionMessage{Sessionid: "player1", Pin: pin, Reason: "ambiguous"})
+
+	// players have to wait before flagging another question
+	if err := games.showResults(pin); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error moving to the next question: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+	games.processFlagQuestionMessage(common.FlagQuestionMessage{Sessionid: "player1", Pin: pin, Reason: "again"})
+	rejected := false
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.ErrorToSessionMessage); ok && m.Sessionid == "player1" {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("expected the second flag to be rejected")
+	}
+
+	// the flags outlive the game
+	games.delete(pin)
+	msg := &common.GetQuestionFlagsMessage{Result: make(chan []common.QuestionFlag, 1)}
+	games.processGetQuestionFlagsMessage(msg)
+	flags := <-msg.Result
+	if len(flags) != 1 {
+		t.Fatalf("expected 1 flag but got %+v", flags)
+	}
+	if flags[0].Quizid != 1 || flags[0].Pin != pin || flags[0].Question != "question 0" || flags[0].Reason != "ambiguous" {
+		t.Errorf("unexpected flag %+v", flags[0])
+	}
+}
+
+func TestDisambiguateDuplicateNames(t *testing.T) {
+	tests := []struct {
+		disambiguate  bool
+		expectedNames []string
+		expectError   []bool
+	}{
+		{false, []string{"Alex", "", ""}, []bool{false, true, true}},
+		{true, []string{"Alex", "Alex (2)", "Alex (3)"}, []bool{false, false, false}},
+	}
+
+	for testIndex, test := range tests {
+		games, _ := newTestGames()
+		games.disambiguateNames = test.disambiguate
+		pin := addTestGame(t, games, "host", nil, testQuiz())
+
+		for i, sessionid := range []string{"player1", "player2", "player3"} {
+			name, err := games.addPlayerToGame(common.AddPlayerToGameMessage{
+				Sessionid: sessionid,
+				Name:      "Alex",
+				Pin:       pin,
+			})
+			if (err != nil) != test.expectError[i] {
+				t.Errorf("unexpected error value %v for player %d in test index %d", err, i, testIndex)
+			}
+			if name != test.expectedNames[i] {
+				t.Errorf("expected name %s but got %s for player %d in test index %d", test.expectedNames[i], name, i, testIndex)
+			}
+		}
+	}
+}
+
+func TestPreviewQuiz(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1"}, testQuiz())
+
+	games.processPreviewQuizMessage(common.PreviewQuizMessage{Clientid: 2, Sessionid: "player1", Pin: pin})
+	if msgs := mh.drain(messaging.ClientHubTopic); len(msgs) != 0 {
+		t.Errorf("expected non-host not to receive a preview but got %v", msgs)
+	}
+	if errors := mh.drain(messaging.SessionsTopic); len(errors) == 0 {
+		t.Error("expected non-host to receive an error")
+	}
+
+	games.processPreviewQuizMessage(common.PreviewQuizMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	msgs := mh.drain(messaging.ClientHubTopic)
+	if len(msgs) != 1 {
+		t.Fatalf("expected host to receive 1 message but got %d", len(msgs))
+	}
+	m := msgs[0].(common.ClientMessage)
+	if m.Clientid != 1 || !strings.HasPrefix(m.Message, "quiz-preview ") {
+		t.Fatalf("unexpected message %v", m)
+	}
+	quiz, err := common.UnmarshalQuiz(strings.NewReader(strings.TrimPrefix(m.Message, "quiz-preview ")))
+	if err != nil {
+		t.Fatalf("error unmarshaling quiz preview: %v", err)
+	}
+	if quiz.NumQuestions() != testQuiz().NumQuestions() {
+		t.Errorf("expected %d questions but got %d", testQuiz().NumQuestions(), quiz.NumQuestions())
+	}
+	for i, question := range quiz.Questions {
+		if question.Correct != testQuiz().Questions[i].Correct {
+			t.Errorf("expected preview to include the correct answer for question %d", i)
+		}
+	}
+}
+
+func TestUpdateGameValidation(t *testing.T) {
+	games, _ := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1"}, testQuiz())
+
+	game, err := games.get(pin)
+	if err != nil {
+		t.Fatalf("error getting game: %v", err)
+	}
+
+	hostChange := game.Copy()
+	hostChange.Host = "intruder"
+	if err := games.validatedUpdate(hostChange); err == nil {
+		t.Error("expected host change to be rejected")
+	}
+
+	unknownPin := game.Copy()
+	unknownPin.Pin = pin + 1
+	if err := games.validatedUpdate(unknownPin); err == nil {
+		t.Error("expected update to a nonexistent game to be rejected")
+	}
+	if _, err := games.get(pin + 1); err == nil {
+		t.Error("expected rejected update not to create a game")
+	}
+
+	valid := game.Copy()
+	valid.Players["player1"] = 500
+	if err := games.validatedUpdate(valid); err != nil {
+		t.Fatalf("expected valid update to succeed but got %v", err)
+	}
+	updated, _ := games.get(pin)
+	if updated.Host != "host" || updated.Players["player1"] != 500 {
+		t.Errorf("expected game to be updated but got %+v", updated)
+	}
+}
+
+func TestStartGameIncrementsPlayCount(t *testing.T) {
+	games, _ := newTestGames()
+	quiz := testQuiz()
+
+	for i := 1; i <= 2; i++ {
+		pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+		games.processStartGameMessage(common.StartGameMessage{Sessionid: "host", Pin: pin})
+
+		stats := games.stats.GetAll()
+		if len(stats) != 1 {
+			t.Fatalf("expected stats for 1 quiz but got %d", len(stats))
+		}
+		expected := common.QuizStats{Quizid: quiz.Id, Plays: i, Players: 2 * i}
+		if stats[0] != expected {
+			t.Errorf("expected %+v after %d game(s) but got %+v", expected, i, stats[0])
+		}
+	}
+}
+
+func TestStatsGoThroughBreaker(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	games := InitGames(newFakeMessageHub(), store, GamesOptions{SlowWriteThreshold: time.Second})
+	games.stats.RecordGameStarted(1, 2)
+
+	// increments are held while the store is degraded
+	games.writer.trip("test")
+	games.stats.RecordGameStarted(1, 3)
+	if data, err := store.Get(statsKey(1, playsCounter)); err != nil || string(data) != "1" {
+		t.Errorf("expected the held increment not to reach the store but got %q, %v", data, err)
+	}
+
+	msg := &common.GetStatsMessage{Result: make(chan []common.QuizStats, 1)}
+	games.processGetStatsMessage(msg)
+	expected := common.QuizStats{Quizid: 1, Plays: 2, Players: 5}
+	if stats := <-msg.Result; len(stats) != 1 || stats[0] != expected {
+		t.Errorf("expected stats %+v including the held increments but got %+v", expected, stats)
+	}
+	if _, ok := <-msg.Result; ok {
+		t.Error("expected the result to be closed")
+	}
+
+	games.FlushHeldWrites()
+	if data, err := store.Get(statsKey(1, playersCounter)); err != nil || string(data) != "5" {
+		t.Errorf("expected the held increments to be flushed but got %q, %v", data, err)
+	}
+}
+
+func TestHostChosenQuestionDuration(t *testing.T) {
+	games, mh := newTestGames()
+	games.processHostGameLobbyMessage(common.HostGameLobbyMessage{
+		Sessionid:        "host",
+		Quizid:           1,
+		QuestionDuration: 60,
+	})
+
+	pin := 0
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.SetSessionGamePinMessage); ok && m.Sessionid == "host" {
+			pin = m.Pin
+		}
+	}
+	if pin == 0 {
+		t.Fatal("expected game pin to be set for host")
+	}
+
+	quiz := testQuiz()
+	games.setGameQuiz(pin, quiz)
+	if _, err := games.addPlayerToGame(common.AddPlayerToGameMessage{Sessionid: "player1", Name: "player1", Pin: pin}); err != nil {
+		t.Fatalf("error adding player: %v", err)
+	}
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	game, _ := games.get(pin)
+	if game.Quiz.QuestionDuration != quiz.QuestionDuration {
+		t.Errorf("expected quiz duration to remain %d but got %d", quiz.QuestionDuration, game.Quiz.QuestionDuration)
+	}
+	if timeLeft := time.Until(game.QuestionDeadline); timeLeft < 55*time.Second || timeLeft > 60*time.Second {
+		t.Errorf("expected deadline to be about 60 seconds away but got %v", timeLeft)
+	}
+
+	if _, err := games.registerAnswer(pin, "player1", nil, 1); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	game, _ = games.get(pin)
+	if score := game.Players["player1"]; score < 190 || score > 200 {
+		t.Errorf("expected score to be calculated against the 60 second duration but got %d", score)
+	}
+}
+
+// returns the screens that a session was sent to
+func sessionScreens(msgs []interface{}, sessionid string) []string {
+	screens := []string{}
+	for _, msg := range msgs {
+		if m, ok := msg.(common.SessionToScreenMessage); ok && m.Sessionid == sessionid {
+			screens = append(screens, m.Nextscreen)
+		}
+	}
+	return screens
+}
+
+func TestAnsweredPlayerReconnects(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Clientid: 1, Sessionid: "player1", Pin: pin, Answer: 1})
+	game, _ := games.get(pin)
+	score := game.Players["player1"]
+
+	// player reconnects and the client re-sends the answer
+	mh.drain(messaging.SessionsTopic)
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Clientid: 2, Sessionid: "player1", Pin: pin, Answer: 1})
+	game, _ = games.get(pin)
+	if game.Players["player1"] != score || len(game.PlayersAnswered) != 1 {
+		t.Errorf("expected re-sent answer to be ignored but score is %d and %d players have answered", game.Players["player1"], len(game.PlayersAnswered))
+	}
+	if screens := sessionScreens(mh.drain(messaging.SessionsTopic), "player1"); len(screens) != 1 || screens[0] != "wait-for-question-end" {
+		t.Errorf("expected player to wait for question end after re-sending answer but got %v", screens)
+	}
+
+	tests := []struct {
+		sessionid string
+		query     func(sessionid string)
+		expected  string
+	}{
+		{"player1", func(sessionid string) {
+			games.processQueryDisplayChoicesMessage(common.QueryDisplayChoicesMessage{Clientid: 2, Sessionid: sessionid, Pin: pin})
+		}, "wait-for-question-end"},
+		{"player1", func(sessionid string) {
+			games.processQueryPlayerResultsMessage(common.QueryPlayerResultsMessage{Clientid: 2, Sessionid: sessionid, Pin: pin})
+		}, "wait-for-question-end"},
+		{"player2", func(sessionid string) {
+			games.processQueryPlayerResultsMessage(common.QueryPlayerResultsMessage{Clientid: 3, Sessionid: sessionid, Pin: pin})
+		}, "answer-question"},
+	}
+
+	for testIndex, test := range tests {
+		test.query(test.sessionid)
+		screens := sessionScreens(mh.drain(messaging.SessionsTopic), test.sessionid)
+		if len(screens) != 1 || screens[0] != test.expected {
+			t.Errorf("expected %s to be sent to %s but got %v for test index %d", test.sessionid, test.expected, screens, testIndex)
+		}
+		if results := mh.drain(messaging.ClientHubTopic); len(results) != 0 {
+			t.Errorf("expected no client messages but got %v for test index %d", results, testIndex)
+		}
+	}
+
+	// unanswered players still get their choices followed by the question
+	// context
+	games.processQueryDisplayChoicesMessage(common.QueryDisplayChoicesMessage{Clientid: 3, Sessionid: "player2", Pin: pin})
+	msgs := mh.drain(messaging.ClientHubTopic)
+	if len(msgs) != 2 || msgs[0].(common.ClientMessage).Message != "display-choices 4" {
+		t.Errorf("expected player2 to be sent display-choices but got %v", msgs)
+	}
+}
+
+func TestLiveCorrectCount(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		games, mh := newTestGames()
+		quiz := testQuiz()
+		quiz.LiveCorrectCount = enabled
+		pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error starting game: %v", err)
+		}
+		mh.drain(messaging.SessionsTopic)
+
+		games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 1})
+
+		updates := sessionMessages(mh.drain(messaging.SessionsTopic), "host", "players-answered ")
+		if len(updates) != 1 {
+			t.Fatalf("expected host to receive 1 update but got %d", len(updates))
+		}
+		var update map[string]interface{}
+		if err := json.Unmarshal([]byte(updates[0][len("players-answered "):]), &update); err != nil {
+			t.Fatalf("error decoding update: %v", err)
+		}
+		correct, ok := update["correct"]
+		if ok != enabled {
+			t.Errorf("expected correct count to be present only when enabled (enabled=%v) but got %s", enabled, updates[0])
+		}
+		if enabled && correct != float64(1) {
+			t.Errorf("expected a correct count of 1 but got %v", correct)
+		}
+	}
+}
+
+func TestStartEmptyQuiz(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.Questions = nil
+	pin := addTestGame(t, games, "host", []string{"player1"}, quiz)
+
+	games.processStartGameMessage(common.StartGameMessage{Sessionid: "host", Pin: pin})
+
+	game, err := games.get(pin)
+	if err != nil {
+		t.Fatalf("error getting game: %v", err)
+	}
+	if game.GameState != common.GameNotStarted {
+		t.Errorf("expected game to remain in the lobby but got state %d", game.GameState)
+	}
+
+	msgs := mh.drain(messaging.SessionsTopic)
+	errorCount := 0
+	for _, msg := range msgs {
+		if m, ok := msg.(common.ErrorToSessionMessage); ok && m.Sessionid == "host" {
+			errorCount++
+			if m.Nextscreen != "" {
+				t.Errorf("expected host to stay on the lobby screen but got %s", m.Nextscreen)
+			}
+		}
+	}
+	if errorCount != 1 {
+		t.Errorf("expected host to receive 1 error but got %d", errorCount)
+	}
+	if screens := sessionScreens(msgs, "player1"); len(screens) != 0 {
+		t.Errorf("expected player to stay on the waiting screen but got %v", screens)
+	}
+}
+
+func TestGameStatusMidQuestion(t *testing.T) {
+	games, _ := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2", "player3"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	if _, err := games.registerAnswer(pin, "player2", nil, 0); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+
+	msg := &common.GetGameStatusMessage{Pin: pin, Result: make(chan common.GetGameStatusResult, 1)}
+	games.processGetGameStatusMessage(msg)
+	result := <-msg.Result
+	if result.Error != nil {
+		t.Fatalf("error getting game status: %v", result.Error)
+	}
+	expected := common.GameStatus{
+		Pin:            pin,
+		State:          common.QuestionInProgress,
+		QuestionIndex:  0,
+		TotalQuestions: 2,
+		Players:        3,
+		Answered:       1,
+	}
+	if result.Status != expected {
+		t.Errorf("expected status %+v but got %+v", expected, result.Status)
+	}
+
+	msg = &common.GetGameStatusMessage{Pin: -1, Result: make(chan common.GetGameStatusResult, 1)}
+	games.processGetGameStatusMessage(msg)
+	if result := <-msg.Result; result.Error == nil {
+		t.Error("expected an error getting the status of a nonexistent game")
+	}
+}
+
+func TestOneJoinPerDevice(t *testing.T) {
+	tests := []struct {
+		enforce     bool
+		expectError []bool
+	}{
+		{false, []bool{false, false, false, false}},
+		{true, []bool{false, true, false, true}},
+	}
+
+	joins := []struct {
+		sessionid   string
+		fingerprint string
+	}{
+		{"player1", "device-a"},
+		{"player2", "device-a"}, // second join from the same device
+		{"player3", "device-b"},
+		{"player4", ""}, // device could not be identified
+	}
+
+	for testIndex, test := range tests {
+		games, _ := newTestGames()
+		games.oneJoinPerDevice = test.enforce
+		pin, err := games.add("host")
+		if err != nil {
+			t.Fatalf("error adding game: %v", err)
+		}
+
+		for i, join := range joins {
+			_, err := games.addPlayerToGame(common.AddPlayerToGameMessage{
+				Sessionid:   join.sessionid,
+				Name:        join.sessionid,
+				Pin:         pin,
+				Fingerprint: join.fingerprint,
+			})
+			if (err != nil) != test.expectError[i] {
+				t.Errorf("unexpected error value %v for join %d in test index %d", err, i, testIndex)
+			}
+		}
+	}
+}
+
+func TestPrintableQuestion(t *testing.T) {
+	games, _ := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+
+	getQuestion := func() common.GetPrintableQuestionResult {
+		msg := &common.GetPrintableQuestionMessage{Pin: pin, Result: make(chan common.GetPrintableQuestionResult, 1)}
+		games.processGetPrintableQuestionMessage(msg)
+		return <-msg.Result
+	}
+
+	if result := getQuestion(); result.Error == nil {
+		t.Error("expected an error before the game has started")
+	}
+
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	result := getQuestion()
+	if result.Error != nil {
+		t.Fatalf("error getting live question: %v", result.Error)
+	}
+	q := result.Question
+	if q.Pin != pin || q.QuestionIndex != 0 || q.TotalQuestions != 2 || q.Question != "question 0" || strings.Join(q.Answers, ",") != "zero,one,two,three" {
+		t.Errorf("unexpected printable question %+v", q)
+	}
+
+	// printing a question whose time is up does not end it
+	game, _ := games.getGamePointer(pin)
+	game.QuestionDeadline = time.Now().Add(-time.Second)
+	if result := getQuestion(); result.Error != nil {
+		t.Errorf("error getting expired question: %v", result.Error)
+	}
+	if game, _ := games.get(pin); game.GameState != common.QuestionInProgress {
+		t.Errorf("expected the question to be left in progress but got state %d", game.GameState)
+	}
+
+	if err := games.showResults(pin); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	if result := getQuestion(); result.Error == nil {
+		t.Error("expected an error while showing results")
+	}
+
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error moving to next question: %v", err)
+	}
+	if result := getQuestion(); result.Error != nil || result.Question.QuestionIndex != 1 {
+		t.Errorf("expected second question to be live but got %+v", result)
+	}
+	games.nextState(pin)
+	games.nextState(pin)
+	if result := getQuestion(); result.Error == nil {
+		t.Error("expected an error after the game has ended")
+	}
+}
+
+func TestAutoStart(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.AutoStartPlayers = 3
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+
+	autoStartMessages := func() []common.AutoStartGameMessage {
+		found := []common.AutoStartGameMessage{}
+		for _, msg := range mh.drain(messaging.GamesTopic) {
+			if m, ok := msg.(common.AutoStartGameMessage); ok {
+				found = append(found, m)
+			}
+		}
+		return found
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if msgs := autoStartMessages(); len(msgs) != 0 {
+		t.Fatalf("expected no auto-start before the minimum players join but got %v", msgs)
+	}
+
+	if _, err := games.addPlayerToGame(common.AddPlayerToGameMessage{Sessionid: "player3", Name: "player3", Pin: pin}); err != nil {
+		t.Fatalf("error adding player: %v", err)
+	}
+	var msgs []common.AutoStartGameMessage
+	for i := 0; i < 100 && len(msgs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		msgs = autoStartMessages()
+	}
+	if len(msgs) != 1 || msgs[0].Pin != pin {
+		t.Fatalf("expected game to auto-start after the third player joined but got %v", msgs)
+	}
+
+	// the countdown elapsing starts the game once
+	games.processAutoStartGameMessage(msgs[0])
+	games.processAutoStartGameMessage(msgs[0])
+	game, _ := games.get(pin)
+	if game.GameState != common.QuestionInProgress || game.QuestionIndex != 0 {
+		t.Errorf("expected the first question to be live but got state %d and question %d", game.GameState, game.QuestionIndex)
+	}
+}
+
+func TestAutoStartSurvivesRestart(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	games := InitGames(newFakeMessageHub(), store, GamesOptions{SlowWriteThreshold: time.Second})
+	quiz := testQuiz()
+	quiz.AutoStartPlayers = 2
+	quiz.AutoStartDelay = 60
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	if game, _ := games.get(pin); !game.AutoStarting {
+		t.Fatal("expected the auto-start countdown to have begun")
+	}
+
+	// the countdown is restarted when the game is loaded by a new instance
+	quiz.AutoStartDelay = 0
+	games.setGameQuiz(pin, quiz)
+	mh := newFakeMessageHub()
+	InitGames(mh, store, GamesOptions{SlowWriteThreshold: time.Second})
+	var found bool
+	for i := 0; i < 100 && !found; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, msg := range mh.drain(messaging.GamesTopic) {
+			if m, ok := msg.(common.AutoStartGameMessage); ok && m.Pin == pin {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("expected the reloaded game to auto-start")
+	}
+}
+
+func TestHostWaitingStatus(t *testing.T) {
+	mh := newFakeMessageHub()
+	games := InitGames(mh, nil, GamesOptions{HostWaitInterval: 10 * time.Millisecond})
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	hostWaiting := func() []common.HostWaitingMessage {
+		found := []common.HostWaitingMessage{}
+		var msgs []interface{}
+		for i := 0; i < 100 && len(found) == 0; i++ {
+			time.Sleep(5 * time.Millisecond)
+			msgs = mh.drain(messaging.GamesTopic)
+			for _, msg := range msgs {
+				if m, ok := msg.(common.HostWaitingMessage); ok {
+					found = append(found, m)
+				}
+			}
+		}
+		return found
+	}
+
+	games.processShowResultsMessage(common.ShowResultsMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	// showing the results again must not start a second series of updates
+	games.processShowResultsMessage(common.ShowResultsMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	mh.drain(messaging.SessionsTopic)
+
+	msgs := hostWaiting()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 host waiting message but got %v", msgs)
+	}
+	games.processHostWaitingMessage(msgs[0])
+	sent := mh.drain(messaging.SessionsTopic)
+	for _, player := range []string{"player1", "player2"} {
+		status := sessionMessages(sent, player, "host-status ")
+		if len(status) != 1 || !strings.Contains(status[0], `"hostconnected":true`) {
+			t.Errorf("expected %s to receive a host-status with the host connected but got %v", player, status)
+		}
+	}
+
+	games.setPlayerConnected(pin, "host", false)
+	msgs = hostWaiting()
+	if len(msgs) != 1 {
+		t.Fatalf("expected the host waiting updates to continue but got %v", msgs)
+	}
+	games.processHostWaitingMessage(msgs[0])
+	status := sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "host-status ")
+	if len(status) != 1 || !strings.Contains(status[0], `"hostconnected":false`) {
+		t.Errorf("expected a host-status with the host disconnected but got %v", status)
+	}
+
+	// the updates stop once the host moves on
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error moving to the next question: %v", err)
+	}
+	msgs = hostWaiting()
+	if len(msgs) != 1 {
+		t.Fatalf("expected a pending host waiting message but got %v", msgs)
+	}
+	games.processHostWaitingMessage(msgs[0])
+	if status := sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "host-status "); len(status) != 0 {
+		t.Errorf("expected no host-status once the next question is live but got %v", status)
+	}
+	if msgs := hostWaiting(); len(msgs) != 0 {
+		t.Errorf("expected the host waiting updates to stop but got %v", msgs)
+	}
+}
+
+func TestChoiceExplanations(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.Questions[0].ChoiceExplanations = []string{"zero is too small", "", "two is too big"}
+	players := []string{"player0", "player1", "player2", "player3"}
+	pin := addTestGame(t, games, "host", players, quiz)
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	for i, player := range players {
+		if _, err := games.registerAnswer(pin, player, nil, i); err != nil {
+			t.Fatalf("error registering answer for %s: %v", player, err)
+		}
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	games.processShowResultsMessage(common.ShowResultsMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	sent := mh.drain(messaging.SessionsTopic)
+
+	// player1 answered correctly and player3 chose an answer without an
+	// explanation
+	expected := []string{"zero is too small", "", "two is too big", ""}
+	for i, player := range players {
+		results := sessionMessages(sent, player, "player-results ")
+		if len(results) != 1 {
+			t.Fatalf("expected 1 player-results message for %s but got %v", player, results)
+		}
+		var payload struct {
+			Explanation string `json:"explanation"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(results[0], "player-results ")), &payload); err != nil {
+			t.Fatalf("error decoding player-results for %s: %v", player, err)
+		}
+		if payload.Explanation != expected[i] {
+			t.Errorf("expected %s to get explanation %q but got %q", player, expected[i], payload.Explanation)
+		}
+	}
+}
+
+func TestPushQuizAppliesAtNextQuestion(t *testing.T) {
+	games, _ := newTestGames()
+	running := addTestGame(t, games, "host", []string{"player1"}, testQuiz())
+	lobbyQuiz := testQuiz()
+	lobbyQuiz.AcceptSubmissions = true
+	lobby := addTestGame(t, games, "host2", []string{"player2"}, lobbyQuiz)
+	shuffledQuiz := testQuiz()
+	shuffledQuiz.ShuffleQuestions = true
+	shuffled := addTestGame(t, games, "host3", []string{"player3"}, shuffledQuiz)
+	pickedQuiz := testQuiz()
+	pickedQuiz.PickQuestions = 1
+	picked := addTestGame(t, games, "host5", []string{"player5"}, pickedQuiz)
+	pickedLobby := addTestGame(t, games, "host6", []string{"player6"}, pickedQuiz)
+	draw, _ := games.get(pickedLobby)
+	recent := games.recentQuestions.Get(pickedQuiz.Id)
+	other := testQuiz()
+	other.Id = 2
+	unrelated := addTestGame(t, games, "host4", []string{"player4"}, other)
+	for _, pin := range []int{running, shuffled, picked, unrelated} {
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error starting game %d: %v", pin, err)
+		}
+	}
+
+	// the host approved a player's question before the update
+	submitted := common.QuizQuestion{Question: "submitted", Answers: []string{"yes", "no"}, Correct: 0}
+	if _, err := games.submitQuestion(lobby, "player2", submitted); err != nil {
+		t.Fatalf("error submitting question: %v", err)
+	}
+	if _, err := games.moderateSubmission(lobby, 1, true); err != nil {
+		t.Fatalf("error approving submission: %v", err)
+	}
+
+	updated := testQuiz()
+	updated.Questions[0].Question = "fixed question 0"
+	updated.Questions[1].Question = "fixed question 1"
+	pins := games.pushQuiz(updated)
+	expectedPins := []int{running, lobby}
+	sort.Ints(expectedPins)
+	if len(pins) != 2 || pins[0] != expectedPins[0] || pins[1] != expectedPins[1] {
+		t.Errorf("expected the update to be pushed to games %v but got %v", expectedPins, pins)
+	}
+
+	game, _ := games.get(lobby)
+	if game.Quiz.Questions[0].Question != "fixed question 0" {
+		t.Errorf("expected the game that has not started to use the update immediately but got %q", game.Quiz.Questions[0].Question)
+	}
+	if game.Quiz.NumQuestions() != 3 || game.Quiz.Questions[2].Question != "submitted" {
+		t.Errorf("expected the approved question to be kept after the update but got %v", game.Quiz.Questions)
+	}
+
+	// the game that has not started keeps its draw
+	game, _ = games.get(pickedLobby)
+	if fmt.Sprint(game.Quiz.Questions) != fmt.Sprint(draw.Quiz.Questions) {
+		t.Errorf("expected the picked questions to be kept but got %v", game.Quiz.Questions)
+	}
+	if fmt.Sprint(games.recentQuestions.Get(pickedQuiz.Id)) != fmt.Sprint(recent) {
+		t.Error("expected the recent questions not to be recorded again")
+	}
+
+	// the live question must not change
+	game, _ = games.get(running)
+	if game.Quiz.Questions[0].Question != "question 0" || game.Quiz.Questions[1].Question != "question 1" {
+		t.Errorf("expected the update to be held until the next question but got %v", game.Quiz.Questions)
+	}
+	if _, err := games.nextState(running); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	game, _ = games.get(running)
+	if game.Quiz.Questions[1].Question != "question 1" {
+		t.Errorf("expected the update to be held while the results are shown but got %q", game.Quiz.Questions[1].Question)
+	}
+
+	if _, err := games.nextState(running); err != nil {
+		t.Fatalf("error moving to the next question: %v", err)
+	}
+	game, _ = games.get(running)
+	if game.PendingQuiz != nil {
+		t.Error("expected the pending update to be cleared once applied")
+	}
+	if game.Quiz.Questions[0].Question != "question 0" {
+		t.Errorf("expected the asked question to be kept but got %q", game.Quiz.Questions[0].Question)
+	}
+	if _, current, _ := game.GetCurrentQuestion(); current.Question != "fixed question 1" {
+		t.Errorf("expected the next question to come from the update but got %q", current.Question)
+	}
+
+	for _, pin := range []int{shuffled, unrelated} {
+		game, _ = games.get(pin)
+		if game.PendingQuiz != nil {
+			t.Errorf("expected game %d not to receive the update", pin)
+		}
+	}
+}
+
+func TestPushQuizKeepsApprovedQuestions(t *testing.T) {
+	games, _ := newTestGames()
+	quiz := testQuiz()
+	quiz.AcceptSubmissions = true
+	pin := addTestGame(t, games, "host", []string{"player1"}, quiz)
+	submitted := common.QuizQuestion{Question: "submitted", Answers: []string{"yes", "no"}, Correct: 0}
+	if _, err := games.submitQuestion(pin, "player1", submitted); err != nil {
+		t.Fatalf("error submitting question: %v", err)
+	}
+	if _, err := games.moderateSubmission(pin, 1, true); err != nil {
+		t.Fatalf("error approving submission: %v", err)
+	}
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	updated := testQuiz()
+	updated.Questions[1].Question = "fixed question 1"
+	games.pushQuiz(updated)
+	// show the results of question 0 and move on to question 1
+	for i := 0; i < 2; i++ {
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error advancing game: %v", err)
+		}
+	}
+
+	game, _ := games.get(pin)
+	questions := []string{}
+	for _, question := range game.Quiz.Questions {
+		questions = append(questions, question.Question)
+	}
+	if expected := []string{"question 0", "fixed question 1", "submitted"}; fmt.Sprint(questions) != fmt.Sprint(expected) {
+		t.Errorf("expected questions %v after the update but got %v", expected, questions)
+	}
+}
+
+func TestCompressedGamePersistence(t *testing.T) {
+	store := newSlowStore()
+	games := InitGames(newFakeMessageHub(), nil, GamesOptions{Compress: true})
+	games.writer = NewPersistenceBreaker(store, time.Second)
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+
+	data, ok := store.get(fmt.Sprintf("game:%d", pin))
+	if !ok {
+		t.Fatal("expected game to have been persisted")
+	}
+	if strings.HasPrefix(string(data), "{") {
+		t.Fatal("expected persisted game to be compressed")
+	}
+	game, err := common.UnmarshalGame(data)
+	if err != nil {
+		t.Fatalf("error unmarshaling compressed game: %v", err)
+	}
+	if game.Pin != pin || len(game.Players) != 2 {
+		t.Errorf("expected game %d with 2 players but got game %d with %d players", pin, game.Pin, len(game.Players))
+	}
+}
+
+func TestPlayerResultsSerializationError(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	// fail to serialize the first player's results
+	calls := 0
+	convertToJSON = func(input interface{}) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", errors.New("injected serialization error")
+		}
+		return common.ConvertToJSON(input)
+	}
+	defer func() { convertToJSON = common.ConvertToJSON }()
+
+	mh.drain(messaging.SessionsTopic)
+	games.processShowResultsMessage(common.ShowResultsMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	sent := mh.drain(messaging.SessionsTopic)
+
+	notified, delivered := 0, 0
+	for _, player := range []string{"player1", "player2"} {
+		if len(sessionMessages(sent, player, "player-results ")) == 1 {
+			delivered++
+		}
+		for _, msg := range sent {
+			if m, ok := msg.(common.ErrorToSessionMessage); ok && m.Sessionid == player {
+				if m.Nextscreen != "display-player-results" {
+					t.Errorf("expected %s to be sent to the results screen to recover but got %q", player, m.Nextscreen)
+				}
+				notified++
+			}
+		}
+	}
+	if notified != 1 || delivered != 1 {
+		t.Errorf("expected 1 player to be notified of the error and 1 to get results but got %d and %d", notified, delivered)
+	}
+}
+
+func TestCaseSensitiveNames(t *testing.T) {
+	tests := []struct {
+		caseSensitive bool
+		expectError   bool
+	}{
+		{false, true},
+		{true, false},
+	}
+
+	for _, test := range tests {
+		games := InitGames(newFakeMessageHub(), nil, GamesOptions{CaseSensitiveNames: test.caseSensitive})
+		pin := addTestGame(t, games, "host", nil, testQuiz())
+		if _, err := games.addPlayerToGame(common.AddPlayerToGameMessage{Sessionid: "player1", Name: "Bob", Pin: pin}); err != nil {
+			t.Fatalf("error adding Bob: %v", err)
+		}
+		_, err := games.addPlayerToGame(common.AddPlayerToGameMessage{Sessionid: "player2", Name: "bob", Pin: pin})
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error value %v adding bob with case-sensitive names %v", err, test.caseSensitive)
+		}
+	}
+}
+
+func TestEmphasizeAnswer(t *testing.T) {
+	games, mh := newTestGames()
+	players := []string{"player1", "player2", "player3"}
+	pin := addTestGame(t, games, "host", players, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	// the answer cannot be revealed while the question is live
+	games.processEmphasizeAnswerMessage(common.EmphasizeAnswerMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	if msgs := sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "emphasize-answer "); len(msgs) != 0 {
+		t.Fatalf("expected no emphasized answer while the question is live but got %v", msgs)
+	}
+
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	games.processEmphasizeAnswerMessage(common.EmphasizeAnswerMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	sent := mh.drain(messaging.SessionsTopic)
+	for _, player := range players {
+		msgs := sessionMessages(sent, player, "emphasize-answer ")
+		if len(msgs) != 1 {
+			t.Fatalf("expected %s to receive 1 emphasized answer but got %v", player, msgs)
+		}
+		var emphasis struct {
+			Index  int    `json:"index"`
+			Answer string `json:"answer"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(msgs[0], "emphasize-answer ")), &emphasis); err != nil {
+			t.Fatalf("error decoding emphasized answer: %v", err)
+		}
+		if emphasis.Index != 1 || emphasis.Answer != "one" {
+			t.Errorf("expected %s to receive answer 1 (one) but got %d (%s)", player, emphasis.Index, emphasis.Answer)
+		}
+	}
+	if msgs := sessionMessages(sent, "host", "emphasize-answer "); len(msgs) != 0 {
+		t.Errorf("expected the host not to receive the emphasized answer but got %v", msgs)
+	}
+}
+
+func TestPracticeModeMaxAttempts(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.PracticeMode = true
+	quiz.MaxAttempts = 3
+	// player2 keeps the question open
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	// wrong answers below the cap let the player try again
+	for attempt := 1; attempt < quiz.MaxAttempts; attempt++ {
+		games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 0})
+		sent := mh.drain(messaging.SessionsTopic)
+		if msgs := sessionMessages(sent, "player1", "display-choices "); len(msgs) != 1 {
+			t.Fatalf("expected the choices to be displayed again after attempt %d but got %v", attempt, msgs)
+		}
+		if msgs := sessionMessages(sent, "player1", "emphasize-answer "); len(msgs) != 0 {
+			t.Fatalf("expected the answer not to be revealed after attempt %d but got %v", attempt, msgs)
+		}
+		game, _ := games.get(pin)
+		if _, answered := game.PlayersAnswered["player1"]; answered {
+			t.Fatalf("expected attempt %d not to be recorded as an answer", attempt)
+		}
+	}
+
+	// the last attempt is recorded and reveals the correct answer
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 0})
+	msgs := sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "emphasize-answer ")
+	if len(msgs) != 1 {
+		t.Fatalf("expected the correct answer to be revealed when the cap is reached but got %v", msgs)
+	}
+	var emphasis struct {
+		Index  int    `json:"index"`
+		Answer string `json:"answer"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msgs[0], "emphasize-answer ")), &emphasis); err != nil {
+		t.Fatalf("error decoding emphasized answer: %v", err)
+	}
+	if emphasis.Index != 1 || emphasis.Answer != "one" {
+		t.Errorf("expected answer 1 (one) to be revealed but got %d (%s)", emphasis.Index, emphasis.Answer)
+	}
+	game, _ := games.get(pin)
+	if _, answered := game.PlayersAnswered["player1"]; !answered {
+		t.Fatal("expected the last attempt to be recorded")
+	}
+	if game.Players["player1"] != 0 {
+		t.Errorf("expected no score for a wrong answer but got %d", game.Players["player1"])
+	}
+
+	// further attempts are rejected
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 1})
+	rejected := false
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.ErrorToSessionMessage); ok && m.Sessionid == "player1" && m.Nextscreen == "wait-for-question-end" {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("expected attempt beyond the cap to be rejected")
+	}
+	game, _ = games.get(pin)
+	if game.Players["player1"] != 0 || game.Attempts["player1"] != quiz.MaxAttempts {
+		t.Errorf("expected attempt beyond the cap to be ignored but score is %d after %d attempts", game.Players["player1"], game.Attempts["player1"])
+	}
+}
+
+func TestGameLabel(t *testing.T) {
+	games, mh := newTestGames()
+	games.processHostGameLobbyMessage(common.HostGameLobbyMessage{
+		Sessionid: "host",
+		Quizid:    1,
+		Label:     "Room A",
+	})
+
+	pin := 0
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.SetSessionGamePinMessage); ok && m.Sessionid == "host" {
+			pin = m.Pin
+		}
+	}
+	if pin == 0 {
+		t.Fatal("expected game pin to be set for host")
+	}
+
+	game, err := games.get(pin)
+	if err != nil {
+		t.Fatalf("error getting game: %v", err)
+	}
+	if game.Label != "Room A" {
+		t.Errorf("expected label \"Room A\" but got \"%s\"", game.Label)
+	}
+}
+
+func TestSubmittedQuestionLimits(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.AcceptSubmissions = true
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	mh.drain(messaging.SessionsTopic)
+
+	submit := func(sessionid, text string) {
+		games.processSubmitQuestionMessage(common.SubmitQuestionMessage{
+			Sessionid: sessionid,
+			Pin:       pin,
+			Question: common.QuizQuestion{
+				Question: text,
+				Answers:  []string{"yes", "no"},
+				Correct:  1,
+			},
+		})
+	}
+	submit("player1", strings.Repeat("x", 281))
+	for i := 0; i < 4; i++ {
+		submit("player1", fmt.Sprintf("question %d", i))
+	}
+	submit("player2", "question from player2")
+
+	rejected := 0
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.ErrorToSessionMessage); ok && m.Sessionid == "player1" {
+			rejected++
+		}
+	}
+	if rejected != 2 {
+		t.Errorf("expected the long question and the fourth question to be rejected but got %d errors", rejected)
+	}
+	game, _ := games.get(pin)
+	if len(game.Submissions) != 4 {
+		t.Errorf("expected 3 submissions from player1 and 1 from player2 but got %+v", game.Submissions)
+	}
+}
+
+func TestSubmittedQuestions(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.AcceptSubmissions = true
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	mh.drain(messaging.SessionsTopic)
+
+	submit := func(sessionid, text string) {
+		games.processSubmitQuestionMessage(common.SubmitQuestionMessage{
+			Sessionid: sessionid,
+			Pin:       pin,
+			Question: common.QuizQuestion{
+				Question: text,
+				Answers:  []string{"yes", "no"},
+				Correct:  1,
+			},
+		})
+	}
+	submit("player1", "approved question")
+	submit("player2", "rejected question")
+	submit("player2", "")
+
+	sent := mh.drain(messaging.SessionsTopic)
+	if msgs := sessionMessages(sent, "player1", "question-submitted"); len(msgs) != 1 {
+		t.Errorf("expected player1 to be told that their question was submitted but got %v", msgs)
+	}
+	updates := sessionMessages(sent, "host", "submitted-questions ")
+	if len(updates) != 2 {
+		t.Fatalf("expected host to receive 2 updates but got %d", len(updates))
+	}
+	game, _ := games.get(pin)
+	if len(game.Submissions) != 2 {
+		t.Fatalf("expected 2 submissions but got %d", len(game.Submissions))
+	}
+	if game.Submissions[0].Name != "player1" || game.Submissions[1].Question.Question != "rejected question" {
+		t.Errorf("unexpected submissions %+v", game.Submissions)
+	}
+
+	// only the host can approve questions
+	games.processModerateSubmissionMessage(common.ModerateSubmissionMessage{Sessionid: "player1", Pin: pin, Submission: 1, Approve: true})
+	games.processModerateSubmissionMessage(common.ModerateSubmissionMessage{Sessionid: "host", Pin: pin, Submission: 1, Approve: true})
+	games.processModerateSubmissionMessage(common.ModerateSubmissionMessage{Sessionid: "host", Pin: pin, Submission: 2, Approve: false})
+	sent = mh.drain(messaging.SessionsTopic)
+	if msgs := sessionMessages(sent, "player1", "question-approved"); len(msgs) != 1 {
+		t.Errorf("expected player1 to be told that their question was approved but got %v", msgs)
+	}
+	if msgs := sessionMessages(sent, "player2", "question-rejected"); len(msgs) != 1 {
+		t.Errorf("expected player2 to be told that their question was rejected but got %v", msgs)
+	}
+
+	game, _ = games.get(pin)
+	if len(game.Submissions) != 0 {
+		t.Errorf("expected no submissions to be left but got %d", len(game.Submissions))
+	}
+	if game.Quiz.NumQuestions() != len(quiz.Questions)+1 {
+		t.Fatalf("expected %d questions after approval but got %d", len(quiz.Questions)+1, game.Quiz.NumQuestions())
+	}
+
+	// the approved question is asked after the quiz's questions
+	for i := 0; i < 2*len(quiz.Questions)+1; i++ {
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error advancing game: %v", err)
+		}
+	}
+	game, _ = games.get(pin)
+	question, err := game.Quiz.GetQuestion(game.QuestionIndex)
+	if err != nil {
+		t.Fatalf("error getting current question: %v", err)
+	}
+	if game.GameState != common.QuestionInProgress || question.Question != "approved question" {
+		t.Errorf("expected the approved question to be in progress but got \"%s\" in state %d", question.Question, game.GameState)
+	}
+
+	// questions cannot be submitted once the game has started
+	submit("player1", "late question")
+	game, _ = games.get(pin)
+	if len(game.Submissions) != 0 {
+		t.Errorf("expected submission to be rejected after the game started")
+	}
+}
+
+func TestMergeRejoins(t *testing.T) {
+	tests := []struct {
+		name         string
+		mergeRejoins bool
+		disconnected bool
+		expectMerged bool
+	}{
+		{"disabled by default", false, true, false},
+		{"enabled", true, true, true},
+		{"previous session still connected", true, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mh := newFakeMessageHub()
+			games := InitGames(mh, nil, GamesOptions{MergeRejoins: test.mergeRejoins})
+			pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+			if test.disconnected {
+				games.setPlayerConnected(pin, "player1", false)
+			}
+			mh.drain(messaging.SessionsTopic)
+
+			// player1 lost their cookie and rejoins with a new session
+			_, err := games.addPlayerToGame(common.AddPlayerToGameMessage{Sessionid: "newsession", Name: "PLAYER1", Pin: pin})
+			game, _ := games.get(pin)
+			if !test.expectMerged {
+				if err == nil {
+					t.Fatal("expected rejoin under the same name to be rejected")
+				}
+				if _, ok := game.Players["player1"]; !ok || len(game.Players) != 2 {
+					t.Errorf("expected players to be unchanged but got %v", game.PlayerNames)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("error rejoining game: %v", err)
+			}
+			if len(game.Players) != 2 {
+				t.Errorf("expected 2 players after the rejoin but got %d", len(game.Players))
+			}
+			if _, ok := game.Players["player1"]; ok {
+				t.Error("expected the previous session to be removed from the game")
+			}
+			if score, ok := game.Players["newsession"]; !ok || score != 0 {
+				t.Errorf("expected the new session to take over the slot with a score of 0 but got %d", score)
+			}
+			if game.PlayerNames["newsession"] != "player1" {
+				t.Errorf("expected the player to keep the name player1 but got %s", game.PlayerNames["newsession"])
+			}
+			deregistered := false
+			for _, msg := range mh.drain(messaging.SessionsTopic) {
+				if m, ok := msg.(common.DeregisterGameFromSessionsMessage); ok && len(m.Sessions) == 1 && m.Sessions[0] == "player1" {
+					deregistered = true
+				}
+			}
+			if !deregistered {
+				t.Error("expected the previous session to be deregistered from the game")
+			}
+		})
+	}
+}
+
+func TestPickedQuestionsAvoidRepeats(t *testing.T) {
+	games, _ := newTestGames()
+	quiz := common.Quiz{Id: 1, Name: "pool", QuestionDuration: 20, PickQuestions: 3}
+	for i := 0; i < 6; i++ {
+		quiz.Questions = append(quiz.Questions, common.QuizQuestion{
+			Question: fmt.Sprintf("question %d", i),
+			Answers:  []string{"a", "b"},
+		})
+	}
+
+	asked := func() map[string]struct{} {
+		pin := addTestGame(t, games, "host", []string{"player1"}, quiz)
+		game, _ := games.get(pin)
+		if game.Quiz.NumQuestions() != quiz.PickQuestions {
+			t.Fatalf("expected %d questions to be picked but got %d", quiz.PickQuestions, game.Quiz.NumQuestions())
+		}
+		questions := make(map[string]struct{})
+		for _, question := range game.Quiz.Questions {
+			questions[question.Question] = struct{}{}
+		}
+		return questions
+	}
+
+	for i := 0; i < 5; i++ {
+		first := asked()
+		second := asked()
+		for question := range second {
+			if _, ok := first[question]; ok {
+				t.Fatalf("expected consecutive games to ask different questions but both asked %s", question)
+			}
+		}
+	}
+}
+
+func TestJoinTeam(t *testing.T) {
+	games, _ := newTestGames()
+	pin, err := games.add("host")
+	if err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+	games.setGameQuiz(pin, testQuiz())
+	for _, player := range []struct{ name, team string }{{"player1", "Reds"}, {"player2", " reds "}, {"player3", ""}} {
+		if _, err := games.addPlayerToGame(common.AddPlayerToGameMessage{Sessionid: player.name, Name: player.name, Pin: pin, Team: player.team}); err != nil {
+			t.Fatalf("error adding player: %v", err)
+		}
+	}
+
+	game, _ := games.get(pin)
+	if game.Teams["player1"] != "Reds" || game.Teams["player2"] != "Reds" {
+		t.Errorf("expected player1 and player2 to be on Reds but got %v", game.Teams)
+	}
+	if _, ok := game.Teams["player3"]; ok {
+		t.Errorf("expected player3 not to be on a team but got %s", game.Teams["player3"])
+	}
+}
+
+func TestAdvanceWhenIdle(t *testing.T) {
+	mh := newFakeMessageHub()
+	games := InitGames(mh, nil, GamesOptions{AdvanceWhenIdle: 100 * time.Millisecond})
+	pin := addTestGame(t, games, "host", []string{"player1", "player2", "player3"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	questionIdle := func() []common.QuestionIdleMessage {
+		found := []common.QuestionIdleMessage{}
+		for _, msg := range mh.drain(messaging.GamesTopic) {
+			if m, ok := msg.(common.QuestionIdleMessage); ok {
+				found = append(found, m)
+			}
+		}
+		return found
+	}
+
+	// answers that keep arriving within the idle window keep the question
+	// open
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 1})
+	time.Sleep(60 * time.Millisecond)
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player2", Pin: pin, Answer: 0})
+	time.Sleep(60 * time.Millisecond)
+	if msgs := questionIdle(); len(msgs) != 0 {
+		t.Fatalf("expected no idle messages while answers are arriving but got %v", msgs)
+	}
+
+	// a timer that fired before the next answer arrived is ignored
+	games.processQuestionIdleMessage(common.QuestionIdleMessage{Pin: pin, QuestionIndex: 0, Generation: 0})
+	if game, _ := games.get(pin); game.GameState != common.QuestionInProgress {
+		t.Fatalf("expected a stale idle message not to end the question but got state %d", game.GameState)
+	}
+
+	var msgs []common.QuestionIdleMessage
+	for i := 0; i < 100 && len(msgs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		msgs = questionIdle()
+	}
+	if len(msgs) != 1 || msgs[0].Pin != pin || msgs[0].QuestionIndex != 0 {
+		t.Fatalf("expected 1 idle message for question 0 but got %v", msgs)
+	}
+	mh.drain(messaging.SessionsTopic)
+	games.processQuestionIdleMessage(msgs[0])
+
+	game, _ := games.get(pin)
+	if game.GameState != common.ShowResults {
+		t.Errorf("expected the idle gap to show the results but got state %d", game.GameState)
+	}
+	sent := mh.drain(messaging.SessionsTopic)
+	if screens := sessionScreens(sent, "host"); len(screens) != 1 || screens[0] != "host-show-results" {
+		t.Errorf("expected host to be sent to host-show-results but got %v", screens)
+	}
+	if results := sessionMessages(sent, "host", "question-results "); len(results) != 1 {
+		t.Errorf("expected the host's session to receive the question results but got %v", results)
+	}
+	if results := sessionMessages(sent, "player3", "player-results "); len(results) != 1 {
+		t.Errorf("expected player3 to receive their results but got %v", results)
+	}
+}
+
+func TestPauseAndResumeGame(t *testing.T) {
+	mh := newFakeMessageHub()
+	games := InitGames(mh, nil, GamesOptions{AdvanceWhenIdle: time.Hour})
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	pausedContext := func() []bool {
+		paused := []bool{}
+		for _, message := range sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "question-context ") {
+			var questionContext common.PlayerQuestionContext
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(message, "question-context ")), &questionContext); err != nil {
+				t.Fatalf("error parsing question-context payload: %v", err)
+			}
+			paused = append(paused, questionContext.Paused)
+		}
+		return paused
+	}
+
+	games.processPauseGameMessage(common.PauseGameMessage{Sessionid: "host", Pin: pin})
+	if paused := pausedContext(); len(paused) != 1 || !paused[0] {
+		t.Errorf("expected player1 to be told that the game is paused but got %v", paused)
+	}
+
+	// the idle timer is consumed while the game is paused
+	games.mutex.Lock()
+	delete(games.idleTimers, pin)
+	games.mutex.Unlock()
+
+	games.processResumeGameMessage(common.ResumeGameMessage{Sessionid: "host", Pin: pin})
+	if paused := pausedContext(); len(paused) != 1 || paused[0] {
+		t.Errorf("expected player1 to be told that the game has resumed but got %v", paused)
+	}
+	games.mutex.RLock()
+	_, ok := games.idleTimers[pin]
+	games.mutex.RUnlock()
+	if !ok {
+		t.Error("expected the idle timer to be restarted when the game resumes")
+	}
+}
+
+func TestGameEndPostsResults(t *testing.T) {
+	handler := &testWebhookServer{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	games := InitGames(newFakeMessageHub(), nil, GamesOptions{Webhook: newTestWebhook(server.URL)})
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	games.setGameLabel(pin, "Room A")
+	for question := 0; question < 2; question++ {
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error showing question %d: %v", question, err)
+		}
+		// player2 doesn't answer so that the question stays open
+		if _, err := games.registerAnswer(pin, "player1", nil, question+1); err != nil {
+			t.Fatalf("error registering answer: %v", err)
+		}
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error showing results of question %d: %v", question, err)
+		}
+	}
+
+	handler.mux.Lock()
+	early := len(handler.received)
+	handler.mux.Unlock()
+	if early != 0 {
+		t.Fatalf("expected no results before the game ended but got %d", early)
+	}
+
+	if state, _ := games.nextState(pin); state != common.GameEnded {
+		t.Fatalf("expected the game to end but got state %d", state)
+	}
+	received, _ := handler.wait()
+	if len(received) != 1 {
+		t.Fatalf("expected the webhook to receive the results once but got %v", received)
+	}
+	results := received[0]
+	if results.Pin != pin || results.QuizId != 1 || results.Label != "Room A" || len(results.Players) != 2 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	winner, loser := results.Players[0], results.Players[1]
+	if winner.Name != "player1" || winner.Score == 0 || winner.Correct != 2 || winner.Answered != 2 {
+		t.Errorf("expected player1 to win with 2 correct answers but got %+v", winner)
+	}
+	if loser.Name != "player2" || loser.Score != 0 || loser.Correct != 0 || loser.Answered != 0 {
+		t.Errorf("expected player2 to come last without answering but got %+v", loser)
+	}
+
+	// advancing an ended game doesn't post the results again
+	games.nextState(pin)
+	time.Sleep(20 * time.Millisecond)
+	handler.mux.Lock()
+	defer handler.mux.Unlock()
+	if len(handler.received) != 1 {
+		t.Errorf("expected the results to be posted once but got %d", len(handler.received))
+	}
+}
+
+func TestGeneratePin(t *testing.T) {
+	for length := 1; length <= maxPinLength; length++ {
+		for i := 0; i < 100; i++ {
+			pin, err := generatePin(length)
+			if err != nil {
+				t.Fatalf("error generating pin: %v", err)
+			}
+			if digits := len(strconv.Itoa(pin)); digits != length {
+				t.Fatalf("expected a pin with %d digits but got %d", length, pin)
+			}
+		}
+	}
+
+	// every single-digit pin should come up about as often as the others
+	const rounds = 9000
+	counts := make(map[int]int)
+	for i := 0; i < rounds; i++ {
+		pin, _ := generatePin(1)
+		counts[pin]++
+	}
+	for pin := 1; pin <= 9; pin++ {
+		if counts[pin] < rounds/9*8/10 || counts[pin] > rounds/9*12/10 {
+			t.Errorf("expected pin %d to come up about %d times but got %d", pin, rounds/9, counts[pin])
+		}
+	}
+}
+
+func TestUniquePins(t *testing.T) {
+	games := InitGames(newFakeMessageHub(), nil, GamesOptions{PinLength: 1})
+	pins := make(map[int]struct{})
+	for i := 0; i < 9; i++ {
+		pin, err := games.add("host")
+		if err != nil {
+			t.Fatalf("error adding game %d: %v", i, err)
+		}
+		if _, ok := pins[pin]; ok {
+			t.Fatalf("pin %d was used by more than one game", pin)
+		}
+		pins[pin] = struct{}{}
+	}
+
+	// all single-digit pins are taken
+	if pin, err := games.add("host"); err == nil {
+		t.Errorf("expected an error once all pins are taken but got pin %d", pin)
+	}
+
+	games.delete(1)
+	pin, err := games.add("host")
+	if err != nil || pin != 1 {
+		t.Errorf("expected the freed pin 1 to be reused but got %d and %v", pin, err)
+	}
+
+	if games := InitGames(newFakeMessageHub(), nil, GamesOptions{}); games.pinLength != DefaultPinLength {
+		t.Errorf("expected the default pin length of %d but got %d", DefaultPinLength, games.pinLength)
+	}
+}
+
+func TestLobbyDisplayCap(t *testing.T) {
+	mh := newFakeMessageHub()
+	games := InitGames(mh, nil, GamesOptions{LobbyDisplayCap: 3})
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+
+	join := func(player string) []interface{} {
+		mh.drain(messaging.SessionsTopic)
+		games.processAddPlayerToGameMessage(common.AddPlayerToGameMessage{Sessionid: player, Name: player, Pin: pin})
+		return mh.drain(messaging.SessionsTopic)
+	}
+
+	// the full list is sent up to the threshold
+	sent := join("player3")
+	if list := sessionMessages(sent, "host", "participants-list "); len(list) != 1 || list[0] != "participants-list [\"player1\",\"player2\",\"player3\"]\n" {
+		t.Fatalf("expected the host to receive the full list but got %v", list)
+	}
+
+	for _, player := range []string{"player4", "player5"} {
+		sent = join(player)
+		if list := sessionMessages(sent, "host", "participants-list "); len(list) != 0 {
+			t.Errorf("expected no full list beyond the threshold but got %v", list)
+		}
+		summaries := sessionMessages(sent, "host", "participants-summary ")
+		if len(summaries) != 1 {
+			t.Fatalf("expected the host to receive 1 summary but got %v", summaries)
+		}
+		var summary struct {
+			Count  int      `json:"count"`
+			Sample []string `json:"sample"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(summaries[0], "participants-summary ")), &summary); err != nil {
+			t.Fatalf("error parsing summary: %v", err)
+		}
+		game, _ := games.get(pin)
+		if summary.Count != len(game.Players) {
+			t.Errorf("expected a count of %d but got %d", len(game.Players), summary.Count)
+		}
+		if len(summary.Sample) != 3 || summary.Sample[0] != player {
+			t.Errorf("expected a sample of 3 names starting with %s but got %v", player, summary.Sample)
+		}
+	}
+}
+
+func TestShuffleParticipantsRespectsCap(t *testing.T) {
+	mh := newFakeMessageHub()
+	games := InitGames(mh, nil, GamesOptions{LobbyDisplayCap: 3})
+	pin := addTestGame(t, games, "host", []string{"player1", "player2", "player3", "player4", "player5"}, testQuiz())
+	mh.drain(messaging.SessionsTopic)
+
+	games.processShuffleParticipantsMessage(common.ShuffleParticipantsMessage{Sessionid: "host", Pin: pin})
+	sent := mh.drain(messaging.SessionsTopic)
+	if list := sessionMessages(sent, "host", "participants-list "); len(list) != 0 {
+		t.Errorf("expected no full list beyond the threshold but got %v", list)
+	}
+	summaries := sessionMessages(sent, "host", "participants-summary ")
+	if len(summaries) != 1 {
+		t.Fatalf("expected the host to receive 1 summary but got %v", summaries)
+	}
+	var summary struct {
+		Count  int      `json:"count"`
+		Sample []string `json:"sample"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(summaries[0], "participants-summary ")), &summary); err != nil {
+		t.Fatalf("error parsing summary: %v", err)
+	}
+	if summary.Count != 5 || len(summary.Sample) != 3 {
+		t.Errorf("expected a count of 5 and a sample of 3 names but got %+v", summary)
+	}
+}
+
+func TestHostReconnectsMidQuestion(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	// the host reconnects 5 seconds into the 20 second question
+	game, _ := games.getGamePointer(pin)
+	games.mutex.Lock()
+	game.QuestionDeadline = time.Now().Add(15 * time.Second)
+	deadline := game.QuestionDeadline
+	games.mutex.Unlock()
+	mh.drain(messaging.ClientHubTopic)
+
+	games.processHostShowQuestionMessage(common.HostShowQuestionMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+
+	var current common.GameCurrentQuestion
+	found := false
+	for _, msg := range mh.drain(messaging.ClientHubTopic) {
+		m, ok := msg.(common.ClientMessage)
+		if !ok || m.Clientid != 1 || !strings.HasPrefix(m.Message, "host-show-question ") {
+			continue
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(m.Message, "host-show-question ")), &current); err != nil {
+			t.Fatalf("error parsing host-show-question payload: %v", err)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("expected the host to be sent the question")
+	}
+	if current.TimeLeft != 15 {
+		t.Errorf("expected 15 seconds left but got %d", current.TimeLeft)
+	}
+	if game, _ := games.get(pin); !game.QuestionDeadline.Equal(deadline) {
+		t.Errorf("expected reconnecting not to move the deadline but it moved by %v", game.QuestionDeadline.Sub(deadline))
+	}
+}
+
+func TestPlayerQuestionContext(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	// start the second question 5 seconds ago
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error showing results: %v", err)
+	}
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error moving to the next question: %v", err)
+	}
+	game, _ := games.getGamePointer(pin)
+	games.mutex.Lock()
+	game.QuestionDeadline = time.Now().Add(15 * time.Second)
+	games.mutex.Unlock()
+
+	parseContext := func(message string) common.PlayerQuestionContext {
+		var questionContext common.PlayerQuestionContext
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(message, "question-context ")), &questionContext); err != nil {
+			t.Fatalf("error parsing question-context payload: %v", err)
+		}
+		return questionContext
+	}
+	expected := common.PlayerQuestionContext{QuestionIndex: 1, TotalQuestions: 2, TimeLeft: 15}
+
+	mh.drain(messaging.SessionsTopic)
+	current, _ := games.get(pin)
+	games.sendGamePlayersToAnswerQuestionScreen("host", current)
+	sent := mh.drain(messaging.SessionsTopic)
+	for _, player := range []string{"player1", "player2"} {
+		contexts := sessionMessages(sent, player, "question-context ")
+		if len(contexts) != 1 {
+			t.Fatalf("expected %s to be sent the question context but got %v", player, contexts)
+		}
+		if questionContext := parseContext(contexts[0]); questionContext != expected {
+			t.Errorf("expected %s to be sent %+v but got %+v", player, expected, questionContext)
+		}
+	}
+
+	// reconnecting players get the same context
+	mh.drain(messaging.ClientHubTopic)
+	games.processQueryDisplayChoicesMessage(common.QueryDisplayChoicesMessage{Clientid: 3, Sessionid: "player1", Pin: pin})
+	found := false
+	for _, msg := range mh.drain(messaging.ClientHubTopic) {
+		m, ok := msg.(common.ClientMessage)
+		if !ok || !strings.HasPrefix(m.Message, "question-context ") {
+			continue
+		}
+		found = true
+		if questionContext := parseContext(m.Message); questionContext != expected {
+			t.Errorf("expected the reconnecting player to be sent %+v but got %+v", expected, questionContext)
+		}
+	}
+	if !found {
+		t.Error("expected the reconnecting player to be sent the question context")
+	}
+}
+
+func TestAnswerRecorded(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.ShufflePerPlayer = true
+	// player2 keeps the question open
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 2})
+	msgs := sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "answer-recorded ")
+	if len(msgs) != 1 {
+		t.Fatalf("expected player1 to be sent answer-recorded but got %v", msgs)
+	}
+	var ack common.AnswerAcknowledgement
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msgs[0], "answer-recorded ")), &ack); err != nil {
+		t.Fatalf("error decoding answer-recorded payload: %v", err)
+	}
+	// the choice is echoed in the order the answers were displayed to the
+	// player
+	if ack.Choice != 2 || !ack.Final {
+		t.Errorf("expected final choice 2 to be recorded but got %+v", ack)
+	}
+
+	// answering again echoes the choice that was recorded the first time
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 3})
+	msgs = sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "answer-recorded ")
+	if len(msgs) != 1 {
+		t.Fatalf("expected player1 to be sent answer-recorded but got %v", msgs)
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msgs[0], "answer-recorded ")), &ack); err != nil {
+		t.Fatalf("error decoding answer-recorded payload: %v", err)
+	}
+	if ack.Choice != 2 {
+		t.Errorf("expected the original choice 2 to be echoed but got %d", ack.Choice)
+	}
+}
+
+func TestAnswerRecordedNotFinalWhenAnswerChangeAllowed(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.AllowAnswerChange = true
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 2})
+	msgs := sessionMessages(mh.drain(messaging.SessionsTopic), "player1", "answer-recorded ")
+	if len(msgs) != 1 {
+		t.Fatalf("expected player1 to be sent answer-recorded but got %v", msgs)
+	}
+	var ack common.AnswerAcknowledgement
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msgs[0], "answer-recorded ")), &ack); err != nil {
+		t.Fatalf("error decoding answer-recorded payload: %v", err)
+	}
+	if ack.Final {
+		t.Errorf("expected the answer to be changeable but got %+v", ack)
+	}
+}
+
+func TestGetGameResultsLeavesStateAlone(t *testing.T) {
+	games, _ := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+
+	c := make(chan common.GetGameResultsResult, 1)
+	games.processGetGameResultsMessage(&common.GetGameResultsMessage{Pin: pin, Result: c})
+	if result := <-c; result.Error == nil {
+		t.Error("expected an error for a game that has not started")
+	}
+	if _, ok := <-c; ok {
+		t.Error("expected the result to be closed")
+	}
+
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	c = make(chan common.GetGameResultsResult, 1)
+	games.processGetGameResultsMessage(&common.GetGameResultsMessage{Pin: pin, Result: c})
+	result := <-c
+	if result.Error != nil {
+		t.Fatalf("error getting results: %v", result.Error)
+	}
+	if result.Results.QuestionIndex != 0 {
+		t.Errorf("expected the results of question 0 but got %d", result.Results.QuestionIndex)
+	}
+	if game, _ := games.get(pin); game.GameState != common.QuestionInProgress {
+		t.Errorf("expected the question to still be in progress but got state %d", game.GameState)
+	}
+}
+
+func TestOneGamePerHost(t *testing.T) {
+	games, mh := newTestGames()
+	hostedPin := func() int {
+		pin := 0
+		for _, msg := range mh.drain(messaging.SessionsTopic) {
+			if m, ok := msg.(common.SetSessionGamePinMessage); ok && m.Sessionid == "host" {
+				pin = m.Pin
+			}
+		}
+		return pin
+	}
+
+	games.processHostGameLobbyMessage(common.HostGameLobbyMessage{Sessionid: "host", Quizid: 1})
+	first := hostedPin()
+	if first == 0 {
+		t.Fatal("expected the first game to be created")
+	}
+
+	// a second lobby is not created while the first game is live and the
+	// host is sent back to the lobby of the first game
+	games.processHostGameLobbyMessage(common.HostGameLobbyMessage{Sessionid: "host", Quizid: 1})
+	sent := mh.drain(messaging.SessionsTopic)
+	redirected := false
+	for _, msg := range sent {
+		switch m := msg.(type) {
+		case common.ErrorToSessionMessage:
+			t.Errorf("expected the host to be sent back to the lobby without an error but got %q", m.Message)
+		case common.SessionToScreenMessage:
+			redirected = m.Sessionid == "host" && m.Nextscreen == "host-game-lobby"
+		case common.SetSessionGamePinMessage:
+			if m.Pin != first {
+				t.Errorf("expected the host to be pointed at game %d but got %d", first, m.Pin)
+			}
+		}
+	}
+	if !redirected {
+		t.Errorf("expected the host to be sent back to the lobby but got %v", sent)
+	}
+	games.mutex.RLock()
+	count := len(games.all)
+	games.mutex.RUnlock()
+	if count != 1 {
+		t.Errorf("expected only 1 game but got %d", count)
+	}
+
+	// another session can still host
+	games.processHostGameLobbyMessage(common.HostGameLobbyMessage{Sessionid: "host2", Quizid: 1})
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.ErrorToSessionMessage); ok {
+			t.Errorf("expected host2 to be allowed to host but got %q", m.Message)
+		}
+	}
+
+	// once the first game is cancelled the host can start another
+	games.processCancelGameMessage(common.CancelGameMessage{Sessionid: "host", Pin: first})
+	mh.drain(messaging.SessionsTopic)
+	games.processHostGameLobbyMessage(common.HostGameLobbyMessage{Sessionid: "host", Quizid: 1})
+	if second := hostedPin(); second == 0 || second == first {
+		t.Errorf("expected a new game to be created after cancelling but got pin %d", second)
+	}
+}
+
+func TestSetShuffleInLobby(t *testing.T) {
+	games, mh := newTestGames()
+	stored := testQuiz()
+	stored.Questions = nil
+	for i := 0; i < 8; i++ {
+		stored.Questions = append(stored.Questions, common.QuizQuestion{
+			Question: fmt.Sprintf("question %d", i),
+			Answers:  []string{"zero", "one", "two", "three", "four", "five"},
+			Correct:  1,
+		})
+	}
+	stored.AcceptSubmissions = true
+	original := fmt.Sprint(stored.Questions)
+	shuffled := addTestGame(t, games, "host1", []string{"player1"}, stored)
+	unshuffled := addTestGame(t, games, "host2", []string{"player2"}, stored)
+
+	// only the host may change the settings
+	games.processSetShuffleMessage(common.SetShuffleMessage{Sessionid: "player1", Pin: shuffled, Shuffle: common.ShuffleOptions{Questions: true, Answers: true}})
+	if game, _ := games.get(shuffled); game.ShuffleOverride != nil {
+		t.Fatal("expected a player to be prevented from changing the shuffle settings")
+	}
+
+	// an approved submission is kept when the quiz is reshuffled
+	submitted := common.QuizQuestion{Question: "submitted", Answers: []string{"yes", "no"}, Correct: 0}
+	if _, err := games.submitQuestion(shuffled, "player1", submitted); err != nil {
+		t.Fatalf("error submitting question: %v", err)
+	}
+	if _, err := games.moderateSubmission(shuffled, 1, true); err != nil {
+		t.Fatalf("error approving submission: %v", err)
+	}
+	approved := fmt.Sprint(append(append([]common.QuizQuestion{}, stored.Questions...), submitted))
+
+	games.processSetShuffleMessage(common.SetShuffleMessage{Sessionid: "host1", Pin: shuffled, Shuffle: common.ShuffleOptions{Questions: true, Answers: true}})
+	if msgs := mh.drain(messaging.QuizzesTopic); len(msgs) != 0 {
+		t.Errorf("expected the game's own quiz to be reshuffled without looking up the stored quiz but got %v", msgs)
+	}
+
+	game, _ := games.get(shuffled)
+	if game.Quiz.NumQuestions() != len(stored.Questions)+1 {
+		t.Errorf("expected the approved submission to be kept but got %d questions", game.Quiz.NumQuestions())
+	}
+	if !game.Quiz.ShuffleQuestions || !game.Quiz.ShuffleAnswers {
+		t.Errorf("expected the game's quiz to shuffle but got %v and %v", game.Quiz.ShuffleQuestions, game.Quiz.ShuffleAnswers)
+	}
+	if fmt.Sprint(game.Quiz.Questions) == approved {
+		t.Error("expected the game's questions to be shuffled")
+	}
+	other, _ := games.get(unshuffled)
+	if other.Quiz.ShuffleQuestions || fmt.Sprint(other.Quiz.Questions) != original {
+		t.Error("expected the other game to keep the quiz's order")
+	}
+	if stored.ShuffleQuestions || fmt.Sprint(stored.Questions) != original {
+		t.Error("expected the stored quiz to be left alone")
+	}
+
+	// the settings cannot change once the game has started
+	if _, err := games.nextState(shuffled); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+	games.processSetShuffleMessage(common.SetShuffleMessage{Sessionid: "host1", Pin: shuffled})
+	if game, _ := games.get(shuffled); !game.ShuffleOverride.Questions {
+		t.Error("expected the shuffle settings to be locked once the game started")
+	}
+	locked := mh.drain(messaging.SessionsTopic)
+	if len(locked) != 1 {
+		t.Errorf("expected the host to be told the settings are locked but got %v", locked)
+	}
+}
+
+func TestStaleAnswerRejected(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	// start question 0, show its results and move on to question 1
+	for i := 0; i < 3; i++ {
+		if _, err := games.nextState(pin); err != nil {
+			t.Fatalf("error advancing game: %v", err)
+		}
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	// player1's answer to question 0 arrives after question 1 started
+	previous := 0
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 2, QuestionIndex: &previous})
+	game, _ := games.get(pin)
+	if game.QuestionIndex != 1 || game.GameState != common.QuestionInProgress {
+		t.Fatalf("expected question 1 to be live but got question %d in state %d", game.QuestionIndex, game.GameState)
+	}
+	if _, answered := game.PlayersAnswered["player1"]; answered || game.Votes[2] != 0 {
+		t.Errorf("expected the late answer not to be counted but got votes %v", game.Votes)
+	}
+	rejected := false
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.ErrorToSessionMessage); ok && m.Sessionid == "player1" && m.Nextscreen == "" {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("expected player1 to be told the answer was not counted")
+	}
+
+	// an answer to the current question is counted
+	current := 1
+	games.processRegisterAnswerMessage(common.RegisterAnswerMessage{Sessionid: "player1", Pin: pin, Answer: 2, QuestionIndex: &current})
+	game, _ = games.get(pin)
+	if _, answered := game.PlayersAnswered["player1"]; !answered || game.Votes[2] != 1 {
+		t.Errorf("expected the answer to the current question to be counted but got votes %v", game.Votes)
+	}
+}
+
+func TestConcurrentGameDeletion(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		games, mh := newTestGames()
+		pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+		mh.drain(messaging.SessionsTopic)
+
+		// the REST API and the host delete the game at the same time
+		result := make(chan error, 1)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			games.processDeleteGameByPin(&common.DeleteGameByPin{Pin: pin, Result: result})
+		}()
+		go func() {
+			defer wg.Done()
+			games.processDeleteGameMessage(common.DeleteGameMessage{Sessionid: "host", Pin: pin})
+		}()
+		wg.Wait()
+
+		if _, err := games.get(pin); err == nil {
+			t.Fatal("expected the game to be deleted")
+		}
+		if err := <-result; err != nil {
+			if _, ok := err.(*common.NoSuchGameError); !ok {
+				t.Errorf("expected the REST deletion to succeed or find the game gone but got %v", err)
+			}
+		}
+
+		deregistered := 0
+		screens := map[string]int{}
+		for _, msg := range mh.drain(messaging.SessionsTopic) {
+			switch m := msg.(type) {
+			case common.DeregisterGameFromSessionsMessage:
+				deregistered++
+			case common.SessionToScreenMessage:
+				screens[m.Sessionid]++
+			case common.ErrorToSessionMessage:
+				t.Errorf("expected a clean teardown but %s was sent an error: %s", m.Sessionid, m.Message)
+			}
+		}
+		if deregistered != 1 {
+			t.Errorf("expected the sessions to be released once but got %d releases", deregistered)
+		}
+		for _, sessionid := range []string{"host", "player1", "player2"} {
+			if screens[sessionid] != 1 {
+				t.Errorf("expected %s to be sent to a new screen once but got %d", sessionid, screens[sessionid])
+			}
+		}
+	}
+}
+
+func TestKickLastUnansweredPlayerEndsQuestion(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2", "player3"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	for _, player := range []string{"player1", "player2"} {
+		if _, err := games.registerAnswer(pin, player, nil, 1); err != nil {
+			t.Fatalf("error registering answer for %s: %v", player, err)
+		}
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	games.processKickPlayerMessage(common.KickPlayerMessage{Sessionid: "host", Pin: pin, Player: "player3"})
+	if game, _ := games.get(pin); game.GameState != common.ShowResults {
+		t.Errorf("expected the question to end once the remaining players had answered but got state %d", game.GameState)
+	}
+	updates := sessionMessages(mh.drain(messaging.SessionsTopic), "host", "players-answered ")
+	if len(updates) != 1 {
+		t.Fatalf("expected the host to be sent players-answered but got %v", updates)
+	}
+	var update common.AnswersUpdate
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(updates[0], "players-answered ")), &update); err != nil {
+		t.Fatalf("error decoding players-answered payload: %v", err)
+	}
+	if !update.AllAnswered || update.Answered != 2 || update.TotalPlayers != 2 {
+		t.Errorf("expected all 2 remaining players to have answered but got %+v", update)
+	}
+}
+
+func TestKickPlayer(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2", "player3"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	if _, err := games.registerAnswer(pin, "player1", nil, 1); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	// only the host may kick players
+	games.processKickPlayerMessage(common.KickPlayerMessage{Sessionid: "player2", Pin: pin, Player: "player1"})
+	if game, _ := games.get(pin); len(game.Players) != 3 {
+		t.Fatalf("expected a player to be prevented from kicking but got players %v", game.Players)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	// players can be identified by name
+	games.processKickPlayerMessage(common.KickPlayerMessage{Sessionid: "host", Pin: pin, Player: "PLAYER1"})
+	game, _ := games.get(pin)
+	if _, ok := game.Players["player1"]; ok {
+		t.Error("expected player1 to be removed from Players")
+	}
+	if _, ok := game.PlayerNames["player1"]; ok {
+		t.Error("expected player1 to be removed from PlayerNames")
+	}
+	if _, ok := game.PlayersAnswered["player1"]; ok {
+		t.Error("expected player1 to be removed from PlayersAnswered")
+	}
+	if _, ok := game.CorrectPlayers["player1"]; ok {
+		t.Error("expected player1 to be removed from CorrectPlayers")
+	}
+
+	msgs := mh.drain(messaging.SessionsTopic)
+	deregistered, toEntrance := false, false
+	for _, msg := range msgs {
+		switch m := msg.(type) {
+		case common.DeregisterGameFromSessionsMessage:
+			deregistered = len(m.Sessions) == 1 && m.Sessions[0] == "player1"
+		case common.SessionToScreenMessage:
+			if m.Sessionid == "player1" && m.Nextscreen == "entrance" {
+				toEntrance = true
+			}
+		}
+	}
+	if !deregistered || !toEntrance {
+		t.Errorf("expected player1 to be deregistered and sent to the entrance but got %v", msgs)
+	}
+	lists := sessionMessages(msgs, "host", "participants-list ")
+	if len(lists) != 1 || strings.TrimSpace(lists[0]) != `participants-list ["player2","player3"]` {
+		t.Errorf("expected the host to get the remaining players but got %v", lists)
+	}
+
+	// players can also be identified by session ID
+	games.processKickPlayerMessage(common.KickPlayerMessage{Sessionid: "host", Pin: pin, Player: "player3"})
+	if game, _ := games.get(pin); len(game.Players) != 1 || len(game.PlayerNames) != 1 {
+		t.Errorf("expected only player2 to remain but got %v", game.PlayerNames)
+	}
+
+	// the host is told if there is no such player
+	mh.drain(messaging.SessionsTopic)
+	games.processKickPlayerMessage(common.KickPlayerMessage{Sessionid: "host", Pin: pin, Player: "nobody"})
+	msgs = mh.drain(messaging.SessionsTopic)
+	if len(msgs) != 1 {
+		t.Fatalf("expected a single error but got %v", msgs)
+	}
+	if m, ok := msgs[0].(common.ErrorToSessionMessage); !ok || m.Sessionid != "host" || m.Nextscreen != "" {
+		t.Errorf("expected the host to be told the player does not exist but got %+v", msgs[0])
+	}
+}
+
+func TestAbandonedGameCancelled(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	cancel := common.CancelAbandonedGamesMessage{Grace: time.Minute}
+
+	// the host drops and reconnects within the grace period
+	games.setPlayerConnected(pin, "host", false)
+	games.processCancelAbandonedGamesMessage(cancel)
+	if _, err := games.get(pin); err != nil {
+		t.Fatalf("expected the game to survive within the grace period: %v", err)
+	}
+	games.setPlayerConnected(pin, "host", true)
+	game, _ := games.get(pin)
+	if game.HostDisconnected || !game.HostDisconnectedAt.IsZero() {
+		t.Errorf("expected the host to be connected again but got %v at %v", game.HostDisconnected, game.HostDisconnectedAt)
+	}
+	games.processCancelAbandonedGamesMessage(cancel)
+	if _, err := games.get(pin); err != nil {
+		t.Fatalf("expected the game to survive after the host reconnected: %v", err)
+	}
+	mh.drain(messaging.SessionsTopic)
+
+	// the host drops and does not come back
+	games.setPlayerConnected(pin, "host", false)
+	pointer, err := games.getGamePointer(pin)
+	if err != nil {
+		t.Fatalf("error getting game: %v", err)
+	}
+	games.mutex.Lock()
+	pointer.HostDisconnectedAt = time.Now().Add(-2 * time.Minute)
+	games.mutex.Unlock()
+	games.processCancelAbandonedGamesMessage(cancel)
+	if _, err := games.get(pin); err == nil {
+		t.Fatal("expected the game to be cancelled once the grace period expired")
+	}
+	toEntrance := map[string]bool{}
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if m, ok := msg.(common.SessionToScreenMessage); ok && m.Nextscreen == "entrance" {
+			toEntrance[m.Sessionid] = true
+		}
+	}
+	if !toEntrance["player1"] || !toEntrance["player2"] {
+		t.Errorf("expected the players to be sent to the entrance but got %v", toEntrance)
+	}
+}
+
+func TestConcurrentGameCreation(t *testing.T) {
+	// two instances sharing a store with so few pins that collisions are
+	// all but certain
+	store := newTestSQLiteStore(t)
+	instances := []*Games{
+		InitGames(newFakeMessageHub(), store, GamesOptions{SlowWriteThreshold: time.Second, PinLength: 2}),
+		InitGames(newFakeMessageHub(), store, GamesOptions{SlowWriteThreshold: time.Second, PinLength: 2}),
+	}
+
+	const perInstance = 40
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	pins := map[int]int{}
+	for i, games := range instances {
+		for j := 0; j < perInstance; j++ {
+			wg.Add(1)
+			go func(games *Games, host string) {
+				defer wg.Done()
+				pin, err := games.add(host)
+				if err != nil {
+					t.Errorf("error adding game for %s: %v", host, err)
+					return
+				}
+				mux.Lock()
+				pins[pin]++
+				mux.Unlock()
+			}(games, fmt.Sprintf("host%d-%d", i, j))
+		}
+	}
+	wg.Wait()
+
+	if len(pins) != len(instances)*perInstance {
+		t.Errorf("expected %d unique pins but got %d", len(instances)*perInstance, len(pins))
+	}
+	for pin, count := range pins {
+		if count > 1 {
+			t.Errorf("pin %d was given to %d games", pin, count)
+		}
+	}
+}
+
+func TestGameSummarySentToHost(t *testing.T) {
+	games, mh := newTestGames()
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	for state := common.GameNotStarted; state != common.GameEnded; {
+		var err error
+		if state, err = games.nextState(pin); err != nil {
+			t.Fatalf("error advancing game: %v", err)
+		}
+	}
+
+	games.processHostShowGameResultsMessage(common.HostShowGameResultsMessage{Clientid: 1, Sessionid: "host", Pin: pin})
+	var summary common.GameSummary
+	found := false
+	for _, msg := range mh.drain(messaging.ClientHubTopic) {
+		m, ok := msg.(common.ClientMessage)
+		if !ok || m.Clientid != 1 || !strings.HasPrefix(m.Message, "game-summary ") {
+			continue
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(m.Message, "game-summary ")), &summary); err != nil {
+			t.Fatalf("error decoding game summary: %v", err)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("expected the host to be sent the game summary")
+	}
+	if len(summary.Players) != 2 || summary.TotalQuestions != 2 || len(summary.Questions) != 2 {
+		t.Errorf("expected every player and question in the summary but got %+v", summary)
+	}
+}
+
+func TestFiftyFiftySendsRemovedAnswers(t *testing.T) {
+	games, mh := newTestGames()
+	quiz := testQuiz()
+	quiz.FiftyFifty = true
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, quiz)
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+	mh.drain(messaging.ClientHubTopic)
+
+	games.processFiftyFiftyMessage(common.FiftyFiftyMessage{Clientid: 1, Sessionid: "player1", Pin: pin})
+	msgs := mh.drain(messaging.ClientHubTopic)
+	if len(msgs) != 1 {
+		t.Fatalf("expected the removed answers to be sent but got %v", msgs)
+	}
+	m, ok := msgs[0].(common.ClientMessage)
+	if !ok || m.Clientid != 1 || !strings.HasPrefix(m.Message, "fifty-fifty-removed ") {
+		t.Fatalf("unexpected message %+v", msgs[0])
+	}
+	var removed []int
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(m.Message, "fifty-fifty-removed ")), &removed); err != nil {
+		t.Fatalf("error decoding fifty-fifty-removed payload: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected two answers to be removed but got %v", removed)
+	}
+	game, _ := games.get(pin)
+	if game.FiftyFiftyAvailable("player1") {
+		t.Error("expected the lifeline to be spent")
+	}
+
+	// the lifeline cannot be used again
+	mh.drain(messaging.SessionsTopic)
+	games.processFiftyFiftyMessage(common.FiftyFiftyMessage{Clientid: 1, Sessionid: "player1", Pin: pin})
+	if msgs := mh.drain(messaging.ClientHubTopic); len(msgs) != 0 {
+		t.Errorf("expected no choices to be sent for a spent lifeline but got %v", msgs)
+	}
+	rejected := false
+	for _, msg := range mh.drain(messaging.SessionsTopic) {
+		if e, ok := msg.(common.ErrorToSessionMessage); ok && e.Sessionid == "player1" && e.Nextscreen == "" {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("expected player1 to be told the lifeline was already used")
+	}
+}
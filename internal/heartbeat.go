@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// how often the hub handlers record a heartbeat when they are idle
+const HeartbeatInterval = 5 * time.Second
+
+// Records the last time a handler loop was alive - a handler that dies or
+// is wedged stops beating.
+type Heartbeat struct {
+	name string
+	last int64 // unix nanoseconds, accessed atomically
+}
+
+func NewHeartbeat(name string) *Heartbeat {
+	return &Heartbeat{name: name}
+}
+
+func (h *Heartbeat) Beat() {
+	h.beatAt(time.Now())
+}
+
+func (h *Heartbeat) beatAt(t time.Time) {
+	atomic.StoreInt64(&h.last, t.UnixNano())
+}
+
+// Returns the zero time if the handler has never beaten
+func (h *Heartbeat) Last() time.Time {
+	last := atomic.LoadInt64(&h.last)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// Serves /readyz - the server is ready if all handlers have beaten within
+// maxAge and all dependencies are reachable.
+type Readiness struct {
+	maxAge       time.Duration
+	heartbeats   []*Heartbeat
+	dependencies []dependency
+}
+
+// A service that the server cannot run without
+type dependency struct {
+	name string
+	ping func() error
+}
+
+func NewReadiness(maxAge time.Duration, heartbeats ...*Heartbeat) *Readiness {
+	return &Readiness{
+		maxAge:     maxAge,
+		heartbeats: heartbeats,
+	}
+}
+
+// Fails readiness whenever ping returns an error
+func (r *Readiness) AddDependency(name string, ping func() error) {
+	r.dependencies = append(r.dependencies, dependency{name: name, ping: ping})
+}
+
+// Returns the names of the handlers that have not beaten recently
+func (r *Readiness) stale(now time.Time) []string {
+	stale := []string{}
+	for _, h := range r.heartbeats {
+		last := h.Last()
+		if last.IsZero() || now.Sub(last) > r.maxAge {
+			stale = append(stale, h.name)
+		}
+	}
+	return stale
+}
+
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if stale := r.stale(time.Now()); len(stale) > 0 {
+		http.Error(w, fmt.Sprintf("handlers not responding: %s", strings.Join(stale, ", ")), http.StatusServiceUnavailable)
+		return
+	}
+	for _, d := range r.dependencies {
+		if err := d.ping(); err != nil {
+			http.Error(w, fmt.Sprintf("%s not reachable: %v", d.name, err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadinessFailsForStalledHandler(t *testing.T) {
+	games := NewHeartbeat("games")
+	sessions := NewHeartbeat("sessions")
+	readiness := NewReadiness(15*time.Second, games, sessions)
+
+	tests := []struct {
+		gamesLast     time.Time
+		sessionsLast  time.Time
+		expectedCode  int
+		expectedStale string
+	}{
+		{time.Now(), time.Time{}, http.StatusServiceUnavailable, "sessions"}, // sessions never started
+		{time.Now(), time.Now(), http.StatusOK, ""},
+		{time.Now().Add(-time.Minute), time.Now(), http.StatusServiceUnavailable, "games"}, // games stalled
+	}
+
+	for testIndex, test := range tests {
+		games.beatAt(test.gamesLast)
+		sessions.last = 0
+		if !test.sessionsLast.IsZero() {
+			sessions.beatAt(test.sessionsLast)
+		}
+
+		w := httptest.NewRecorder()
+		readiness.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != test.expectedCode {
+			t.Errorf("expected status %d but got %d for test index %d", test.expectedCode, w.Code, testIndex)
+		}
+		if test.expectedStale != "" && !strings.Contains(w.Body.String(), test.expectedStale) {
+			t.Errorf("expected %s to be reported as stalled but got %q for test index %d", test.expectedStale, w.Body.String(), testIndex)
+		}
+	}
+}
+
+func TestReadinessGatedOnStore(t *testing.T) {
+	games := NewHeartbeat("games")
+	games.Beat()
+	readiness := NewReadiness(15*time.Second, games)
+	pingErr := errors.New("connection refused")
+	readiness.AddDependency("store", func() error { return pingErr })
+
+	w := httptest.NewRecorder()
+	readiness.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "store not reachable") {
+		t.Errorf("expected readiness to fail while the store is down but got %d %q", w.Code, w.Body.String())
+	}
+
+	pingErr = nil
+	w = httptest.NewRecorder()
+	readiness.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected readiness to pass once the store is up but got %d %q", w.Code, w.Body.String())
+	}
+}
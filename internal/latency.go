@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+// Number of round-trip times kept for each client - the average only covers
+// the most recent pings so that it follows changes in the venue's network
+const rttSamples = 10
+
+// Accumulates the round-trip times of the pings sent to a websocket client
+type rttAccumulator struct {
+	mutex   sync.Mutex
+	samples [rttSamples]time.Duration
+	count   int // number of samples added - older samples are overwritten
+}
+
+func (a *rttAccumulator) Add(rtt time.Duration) {
+	a.mutex.Lock()
+	a.samples[a.count%rttSamples] = rtt
+	a.count++
+	a.mutex.Unlock()
+}
+
+// Returns false if no pongs have been received
+func (a *rttAccumulator) Average() (time.Duration, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	n := a.count
+	if n > rttSamples {
+		n = rttSamples
+	}
+	if n == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		total += a.samples[i]
+	}
+	return total / time.Duration(n), true
+}
+
+// Pings carry the time they were sent so that the round-trip time can be
+// worked out from the pong, which echoes the payload
+func pingPayload(now time.Time) []byte {
+	return []byte(strconv.FormatInt(now.UnixNano(), 10))
+}
+
+func rttFromPong(payload string, now time.Time) (time.Duration, bool) {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	rtt := now.Sub(time.Unix(0, sent))
+	if rtt < 0 {
+		return 0, false
+	}
+	return rtt, true
+}
+
+// rtts holds the average round-trip time of each client that has answered a
+// ping
+func connectionStats(clients int, rtts []time.Duration) common.ConnectionStats {
+	stats := common.ConnectionStats{
+		Clients:  clients,
+		Measured: len(rtts),
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+	sorted := append([]time.Duration{}, rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, rtt := range sorted {
+		total += rtt
+	}
+	stats.AverageRTT = milliseconds(total / time.Duration(len(sorted)))
+	// nearest-rank percentile
+	rank := int(math.Ceil(0.95 * float64(len(sorted))))
+	stats.P95RTT = milliseconds(sorted[rank-1])
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
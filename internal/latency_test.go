@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTAccumulator(t *testing.T) {
+	var acc rttAccumulator
+	if _, ok := acc.Average(); ok {
+		t.Error("expected no average before any pongs")
+	}
+
+	acc.Add(10 * time.Millisecond)
+	acc.Add(30 * time.Millisecond)
+	if avg, ok := acc.Average(); !ok || avg != 20*time.Millisecond {
+		t.Errorf("expected an average of 20ms but got %v", avg)
+	}
+
+	// only the most recent samples are averaged
+	for i := 0; i < rttSamples; i++ {
+		acc.Add(100 * time.Millisecond)
+	}
+	if avg, _ := acc.Average(); avg != 100*time.Millisecond {
+		t.Errorf("expected the old samples to be dropped but got an average of %v", avg)
+	}
+}
+
+func TestRTTFromPong(t *testing.T) {
+	sent := time.Now()
+	if rtt, ok := rttFromPong(string(pingPayload(sent)), sent.Add(42*time.Millisecond)); !ok || rtt != 42*time.Millisecond {
+		t.Errorf("expected an rtt of 42ms but got %v (%v)", rtt, ok)
+	}
+	// pongs that do not echo a timestamp are ignored
+	if _, ok := rttFromPong("", sent); ok {
+		t.Error("expected an empty pong to be ignored")
+	}
+	if _, ok := rttFromPong(string(pingPayload(sent)), sent.Add(-time.Second)); ok {
+		t.Error("expected a pong from the future to be ignored")
+	}
+}
+
+func TestConnectionStats(t *testing.T) {
+	stats := connectionStats(3, nil)
+	if stats.Clients != 3 || stats.Measured != 0 || stats.AverageRTT != 0 {
+		t.Errorf("unexpected stats without measurements %+v", stats)
+	}
+
+	rtts := []time.Duration{}
+	for i := 20; i >= 1; i-- {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+	stats = connectionStats(25, rtts)
+	if stats.Clients != 25 || stats.Measured != 20 {
+		t.Errorf("unexpected client counts %+v", stats)
+	}
+	if stats.AverageRTT != 10.5 {
+		t.Errorf("expected an average of 10.5ms but got %v", stats.AverageRTT)
+	}
+	if stats.P95RTT != 19 {
+		t.Errorf("expected a p95 of 19ms but got %v", stats.P95RTT)
+	}
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	LogLevelDebug = iota
+	LogLevelInfo
+)
+
+// messages below this level are not logged - accessed atomically
+var logLevel int32 = LogLevelInfo
+
+// Sets the minimum level of the messages that are logged - name is either
+// "debug" or "info"
+func SetLogLevel(name string) error {
+	switch strings.ToLower(name) {
+	case "debug":
+		atomic.StoreInt32(&logLevel, LogLevelDebug)
+	case "info", "":
+		atomic.StoreInt32(&logLevel, LogLevelInfo)
+	default:
+		return fmt.Errorf("unknown log level %s", name)
+	}
+	return nil
+}
+
+// Logs chatty messages such as every incoming command - only logged if the
+// log level is debug
+func debugf(format string, v ...interface{}) {
+	if atomic.LoadInt32(&logLevel) > LogLevelDebug {
+		return
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCommandLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		SetLogLevel("info")
+	}()
+
+	hub := NewHub(newFakeMessageHub(), nil)
+	if err := SetLogLevel("info"); err != nil {
+		t.Fatalf("error setting log level: %v", err)
+	}
+	hub.processMessage(NewClientCommand(1, []byte("join-game {}")))
+	if strings.Contains(buf.String(), "join-game") {
+		t.Errorf("expected no command logs at info level but got %q", buf.String())
+	}
+
+	if err := SetLogLevel("debug"); err != nil {
+		t.Fatalf("error setting log level: %v", err)
+	}
+	hub.processMessage(NewClientCommand(1, []byte("join-game {}")))
+	if logged := buf.String(); strings.Count(logged, "join-game") != 1 {
+		t.Errorf("expected the command to be logged once at debug level but got %q", logged)
+	}
+
+	if err := SetLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown log level")
+	}
+}
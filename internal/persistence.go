@@ -7,8 +7,24 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/kwkoo/go-quiz/internal/common"
 )
 
+// Store is the persistent store that games, sessions and quizzes are kept
+// in - keys are namespaced by a prefix followed by a colon (e.g. game:1234)
+type Store interface {
+	GetKeys(prefix string) ([]string, error)
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, expiry int) error           // expiry is in seconds - 0 never expires
+	SetNX(key string, value []byte, expiry int) (bool, error) // only sets the key if it does not exist - returns false if it does
+	Delete(key string)
+	Incr(counterKey string) (int, error)
+	IncrBy(counterKey string, n int) (int, error)
+	Ping() error
+	Close()
+}
+
+// The Redis implementation of Store
 type PersistenceEngine struct {
 	pool *redis.Pool
 }
@@ -48,20 +64,34 @@ func InitRedis(redisHost, redisPassword string) *PersistenceEngine {
 	return &PersistenceEngine{pool: &pool}
 }
 
-// wait for Redis to come up
-func (engine *PersistenceEngine) WaitForRedis() {
+// how often WaitForRedis retries
+const redisRetryInterval = 5 * time.Second
+
+// wait for Redis to come up - gives up after timeout unless timeout is 0
+func (engine *PersistenceEngine) WaitForRedis(timeout time.Duration) error {
 	if engine == nil {
-		return
+		return nil
 	}
+	return waitUntilReachable(engine.Ping, timeout, redisRetryInterval)
+}
 
+// Calls ping every interval until it succeeds - returns the last error if
+// ping is still failing after timeout, a timeout of 0 waits forever
+func waitUntilReachable(ping func() error, timeout, interval time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
 	for {
-		conn := engine.pool.Get()
-		if conn.Err() == nil {
-			conn.Close()
-			return
+		err := ping()
+		if err == nil {
+			return nil
+		}
+		if !deadline.IsZero() && !time.Now().Add(interval).Before(deadline) {
+			return fmt.Errorf("store not reachable after %v: %v", timeout, err)
 		}
-		log.Print("could not get connection to Redis, sleeping...")
-		time.Sleep(5 * time.Second)
+		log.Printf("could not get connection to Redis, sleeping...: %v", err)
+		time.Sleep(interval)
 	}
 }
 
@@ -73,6 +103,17 @@ func (engine *PersistenceEngine) Close() {
 	log.Print("persistence engine shutdown")
 }
 
+func (engine *PersistenceEngine) Ping() error {
+	if engine == nil {
+		return errors.New("redis not configured")
+	}
+	conn := engine.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	return err
+}
+
 func (engine *PersistenceEngine) GetKeys(prefix string) ([]string, error) {
 	if engine == nil {
 		return []string{}, nil
@@ -133,6 +174,27 @@ func (engine *PersistenceEngine) Set(key string, value []byte, expiry int) error
 	return nil
 }
 
+func (engine *PersistenceEngine) SetNX(key string, value []byte, expiry int) (bool, error) {
+	if engine == nil {
+		return true, nil
+	}
+	conn := engine.pool.Get()
+	defer conn.Close()
+
+	var reply interface{}
+	var err error
+	if expiry == 0 {
+		reply, err = conn.Do("SET", key, value, "NX")
+	} else {
+		reply, err = conn.Do("SET", key, value, "NX", "EX", expiry)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error setting key %s in redis: %v", key, err)
+	}
+	// redis replies with nil if the key already exists
+	return reply != nil, nil
+}
+
 func (engine *PersistenceEngine) Delete(key string) {
 	if engine == nil {
 		return
@@ -152,3 +214,48 @@ func (engine *PersistenceEngine) Incr(counterKey string) (int, error) {
 
 	return redis.Int(conn.Do("INCR", counterKey))
 }
+
+func (engine *PersistenceEngine) IncrBy(counterKey string, n int) (int, error) {
+	if engine == nil {
+		return 0, errors.New("redis not configured")
+	}
+	conn := engine.pool.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("INCRBY", counterKey, n))
+}
+
+// the subset of Store used to report on the persistent store
+type storeInspector interface {
+	Ping() error
+	GetKeys(prefix string) ([]string, error)
+}
+
+// prefixes of the keys that are counted when reporting on the store
+var storeKeyPrefixes = []string{"game", "session", "quiz"}
+
+func inspectStore(store storeInspector) common.StoreStatus {
+	status := common.StoreStatus{
+		Persistent: true,
+		Mode:       "redis",
+		Ping:       "PONG",
+		Keys:       make(map[string]int),
+	}
+	if _, ok := store.(*SQLiteStore); ok {
+		status.Mode = "sqlite"
+	}
+	if err := store.Ping(); err != nil {
+		status.Ping = err.Error()
+		return status
+	}
+	for _, prefix := range storeKeyPrefixes {
+		keys, err := store.GetKeys(prefix)
+		if err != nil {
+			log.Printf("error counting %s keys: %v", prefix, err)
+			status.Keys[prefix] = -1
+			continue
+		}
+		status.Keys[prefix] = len(keys)
+	}
+	return status
+}
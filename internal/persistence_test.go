@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+// returns canned keys for each prefix
+type fakeStore struct {
+	pingErr error
+	keys    []string
+}
+
+func (s fakeStore) Ping() error {
+	return s.pingErr
+}
+
+func (s fakeStore) GetKeys(prefix string) ([]string, error) {
+	matched := []string{}
+	for _, key := range s.keys {
+		if strings.HasPrefix(key, prefix+":") {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
+
+func TestInspectStore(t *testing.T) {
+	store := fakeStore{
+		keys: []string{"game:1", "game:2", "session:a", "quiz:1", "quiz:2", "quiz:3", "quizid", "stats:quiz:1:plays"},
+	}
+	status := inspectStore(store)
+	if !status.Persistent || status.Mode != "redis" || status.Ping != "PONG" {
+		t.Errorf("unexpected store status %+v", status)
+	}
+	expected := map[string]int{"game": 2, "session": 1, "quiz": 3}
+	for prefix, count := range expected {
+		if status.Keys[prefix] != count {
+			t.Errorf("expected %d %s keys but got %d", count, prefix, status.Keys[prefix])
+		}
+	}
+
+	store.pingErr = errors.New("connection refused")
+	status = inspectStore(store)
+	if status.Ping != "connection refused" || len(status.Keys) != 0 {
+		t.Errorf("expected ping failure to be reported without key counts but got %+v", status)
+	}
+}
+
+func TestStoreStatusInMemory(t *testing.T) {
+	sessions, _, _ := newTestSessions()
+	msg := &common.GetStoreStatusMessage{Result: make(chan common.StoreStatus, 1)}
+	sessions.processGetStoreStatusMessage(msg)
+
+	status := <-msg.Result
+	if status.Persistent || status.Mode != "in-memory" {
+		t.Errorf("expected in-memory mode but got %+v", status)
+	}
+}
+
+func TestWaitUntilReachable(t *testing.T) {
+	// store comes up on the third attempt
+	attempts := 0
+	ping := func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitUntilReachable(ping, 0, time.Millisecond); err != nil || attempts != 3 {
+		t.Errorf("expected to wait until the store is reachable but got %v after %d attempts", err, attempts)
+	}
+
+	// store never comes up
+	down := func() error { return errors.New("connection refused") }
+	start := time.Now()
+	err := waitUntilReachable(down, 20*time.Millisecond, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected an error once the timeout expires but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to give up after the timeout but waited %v", elapsed)
+	}
+}
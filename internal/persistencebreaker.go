@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often held writes are retried while the persistent store is degraded
+const breakerRetryInterval = 5 * time.Second
+
+type kvWriter interface {
+	Set(key string, value []byte, expiry int) error
+	SetNX(key string, value []byte, expiry int) (bool, error)
+	IncrBy(counterKey string, n int) (int, error)
+	Delete(key string)
+}
+
+type heldWrite struct {
+	value     []byte
+	expiry    int
+	delete    bool
+	increment bool // held increments of a counter are added together
+	by        int
+}
+
+// PersistenceBreaker sits in front of the persistent store. When writes
+// become slow or fail, the breaker opens and subsequent writes are held in
+// memory (only the latest value for each key is kept) so that game
+// processing isn't blocked by the store. Held writes are flushed when the
+// store recovers and on shutdown.
+type PersistenceBreaker struct {
+	store         kvWriter
+	slowThreshold time.Duration
+	retryInterval time.Duration
+	mux           sync.Mutex
+	open          bool
+	held          map[string]heldWrite
+	inflight      sync.WaitGroup // slow writes that are still being written in the background
+}
+
+func NewPersistenceBreaker(store kvWriter, slowThreshold time.Duration) *PersistenceBreaker {
+	return &PersistenceBreaker{
+		store:         store,
+		slowThreshold: slowThreshold,
+		retryInterval: breakerRetryInterval,
+		held:          make(map[string]heldWrite),
+	}
+}
+
+func (b *PersistenceBreaker) Run(ctx context.Context, shutdownComplete func()) {
+	if b == nil {
+		<-ctx.Done()
+		shutdownComplete()
+		return
+	}
+
+	ticker := time.NewTicker(b.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.Flush()
+			log.Print("shutting down persistence breaker")
+			shutdownComplete()
+			return
+		case <-ticker.C:
+			b.flush(false)
+		}
+	}
+}
+
+func (b *PersistenceBreaker) Set(key string, value []byte, expiry int) error {
+	if b == nil {
+		return nil
+	}
+	if b.hold(key, heldWrite{value: value, expiry: expiry}) {
+		return nil
+	}
+
+	// the write is made in the background so that the caller only waits
+	// for up to the slow threshold
+	done := make(chan error, 1)
+	b.inflight.Add(1)
+	go func() {
+		defer b.inflight.Done()
+		done <- b.store.Set(key, value, expiry)
+	}()
+	timer := time.NewTimer(b.slowThreshold)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			b.trip(err.Error())
+			b.hold(key, heldWrite{value: value, expiry: expiry})
+		}
+	case <-timer.C:
+		// also held so that the write is retried if the background write
+		// fails
+		b.trip(fmt.Sprintf("write to %s took longer than %v", key, b.slowThreshold))
+		b.hold(key, heldWrite{value: value, expiry: expiry})
+	}
+	return nil
+}
+
+// Only sets the key if it does not exist - returns false if it does. The
+// store cannot be checked while it is degraded so the key is only checked
+// against the held writes - if it is not held, the write is held and the key
+// is treated as set.
+func (b *PersistenceBreaker) SetNX(key string, value []byte, expiry int) (bool, error) {
+	if b == nil {
+		return true, nil
+	}
+	if set, held := b.holdNX(key, heldWrite{value: value, expiry: expiry}); held {
+		return set, nil
+	}
+
+	// bounded by the slow threshold in the same way as Set
+	type result struct {
+		set bool
+		err error
+	}
+	done := make(chan result, 1)
+	b.inflight.Add(1)
+	go func() {
+		defer b.inflight.Done()
+		set, err := b.store.SetNX(key, value, expiry)
+		done <- result{set: set, err: err}
+	}()
+	timer := time.NewTimer(b.slowThreshold)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			b.trip(r.err.Error())
+			b.hold(key, heldWrite{value: value, expiry: expiry})
+			return true, nil
+		}
+		return r.set, nil
+	case <-timer.C:
+		b.trip(fmt.Sprintf("write to %s took longer than %v", key, b.slowThreshold))
+		b.hold(key, heldWrite{value: value, expiry: expiry})
+		return true, nil
+	}
+}
+
+// Drops the held write for key if it still holds value - used when a
+// reservation made with SetNX is abandoned so that the held value cannot
+// overwrite the key later
+func (b *PersistenceBreaker) Release(key string, value []byte) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if w, ok := b.held[key]; ok && !w.delete && bytes.Equal(w.value, value) {
+		delete(b.held, key)
+	}
+}
+
+// Increments the counter - while the store is degraded the increments are
+// added together in memory and applied when the held writes are flushed
+func (b *PersistenceBreaker) IncrBy(key string, n int) {
+	if b == nil {
+		return
+	}
+	if b.holdIncrement(key, n) {
+		return
+	}
+
+	done := make(chan error, 1)
+	b.inflight.Add(1)
+	go func() {
+		defer b.inflight.Done()
+		_, err := b.store.IncrBy(key, n)
+		if err != nil {
+			log.Printf("error incrementing counter %s: %v", key, err)
+		}
+		done <- err
+	}()
+	timer := time.NewTimer(b.slowThreshold)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			b.trip(err.Error())
+			b.holdIncrement(key, n)
+		}
+	case <-timer.C:
+		// unlike Set the increment is not held as well because it would be
+		// counted twice if the background increment succeeds
+		b.trip(fmt.Sprintf("increment of %s took longer than %v", key, b.slowThreshold))
+	}
+}
+
+func (b *PersistenceBreaker) Delete(key string) {
+	if b == nil {
+		return
+	}
+	if b.hold(key, heldWrite{delete: true}) {
+		return
+	}
+	b.store.Delete(key)
+}
+
+// Returns the number of writes currently held in memory
+func (b *PersistenceBreaker) Held() int {
+	if b == nil {
+		return 0
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.held)
+}
+
+// Returns the keys starting with prefix that have held writes - the value is
+// true if the key is waiting to be deleted
+func (b *PersistenceBreaker) HeldKeys(prefix string) map[string]bool {
+	keys := make(map[string]bool)
+	if b == nil {
+		return keys
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for key, w := range b.held {
+		if strings.HasPrefix(key, prefix) {
+			keys[key] = w.delete
+		}
+	}
+	return keys
+}
+
+// Returns the held increments of the counters starting with prefix
+func (b *PersistenceBreaker) HeldIncrements(prefix string) map[string]int {
+	increments := make(map[string]int)
+	if b == nil {
+		return increments
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for key, w := range b.held {
+		if w.increment && strings.HasPrefix(key, prefix) {
+			increments[key] = w.by
+		}
+	}
+	return increments
+}
+
+// Writes all held writes to the store whether or not it is slow - called on
+// shutdown before the store is closed
+func (b *PersistenceBreaker) Flush() {
+	if b == nil {
+		return
+	}
+	if remaining := b.flush(true); remaining > 0 {
+		log.Printf("could not flush %d held write(s) to the persistent store", remaining)
+	}
+}
+
+// Holds the write only if the key does not have a held value - held is false
+// if the breaker is closed
+func (b *PersistenceBreaker) holdNX(key string, w heldWrite) (set bool, held bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if !b.open {
+		return false, false
+	}
+	if existing, ok := b.held[key]; ok && !existing.delete {
+		return false, true
+	}
+	b.held[key] = w
+	return true, true
+}
+
+// Returns true if the increment was held because the breaker is open
+func (b *PersistenceBreaker) holdIncrement(key string, n int) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if !b.open {
+		return false
+	}
+	w := b.held[key]
+	b.held[key] = heldWrite{increment: true, by: w.by + n}
+	return true
+}
+
+// Returns true if the write was held because the breaker is open
+func (b *PersistenceBreaker) hold(key string, w heldWrite) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if !b.open {
+		return false
+	}
+	b.held[key] = w
+	return true
+}
+
+func (b *PersistenceBreaker) trip(reason string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.open {
+		return
+	}
+	log.Printf("persistent store degraded (%s) - holding writes in memory", reason)
+	b.open = true
+}
+
+// Writes all held writes to the store. The breaker closes if all writes
+// succeed without being slow. If force is false, flushing stops at the first
+// slow or failed write. Returns the number of writes still held.
+func (b *PersistenceBreaker) flush(force bool) int {
+	b.mux.Lock()
+	if !b.open {
+		b.mux.Unlock()
+		return 0
+	}
+	held := b.held
+	b.held = make(map[string]heldWrite)
+	b.mux.Unlock()
+
+	// a slow write that finishes after the held writes would overwrite them
+	b.inflight.Wait()
+
+	remaining := make(map[string]heldWrite)
+	healthy := true
+	for key, w := range held {
+		if !healthy && !force {
+			remaining[key] = w
+			continue
+		}
+		start := time.Now()
+		if w.delete {
+			b.store.Delete(key)
+		} else if w.increment {
+			if _, err := b.store.IncrBy(key, w.by); err != nil {
+				log.Printf("error flushing held increment to the persistent store: %v", err)
+				remaining[key] = w
+				healthy = false
+				continue
+			}
+		} else if err := b.store.Set(key, w.value, w.expiry); err != nil {
+			log.Printf("error flushing held write to the persistent store: %v", err)
+			remaining[key] = w
+			healthy = false
+			continue
+		}
+		if time.Since(start) > b.slowThreshold {
+			healthy = false
+		}
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for key, w := range remaining {
+		existing, ok := b.held[key]
+		if ok && w.increment && existing.increment {
+			// increments that were held during the flush are added on
+			b.held[key] = heldWrite{increment: true, by: existing.by + w.by}
+			continue
+		}
+		// writes that were held during the flush are newer
+		if !ok {
+			b.held[key] = w
+		}
+	}
+	if healthy && len(b.held) == 0 {
+		b.open = false
+		log.Printf("persistent store recovered - flushed %d held write(s)", len(held))
+	}
+	return len(b.held)
+}
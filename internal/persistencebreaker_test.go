@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+// a persistent store that can be made to respond slowly
+type slowStore struct {
+	mux   sync.Mutex
+	delay time.Duration
+	data  map[string][]byte
+}
+
+func newSlowStore() *slowStore {
+	return &slowStore{data: make(map[string][]byte)}
+}
+
+func (s *slowStore) setDelay(delay time.Duration) {
+	s.mux.Lock()
+	s.delay = delay
+	s.mux.Unlock()
+}
+
+func (s *slowStore) Set(key string, value []byte, expiry int) error {
+	s.mux.Lock()
+	delay := s.delay
+	s.mux.Unlock()
+	time.Sleep(delay)
+
+	s.mux.Lock()
+	s.data[key] = value
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *slowStore) SetNX(key string, value []byte, expiry int) (bool, error) {
+	s.mux.Lock()
+	delay := s.delay
+	s.mux.Unlock()
+	time.Sleep(delay)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.data[key]; ok {
+		return false, nil
+	}
+	s.data[key] = value
+	return true, nil
+}
+
+func (s *slowStore) IncrBy(key string, n int) (int, error) {
+	s.mux.Lock()
+	delay := s.delay
+	s.mux.Unlock()
+	time.Sleep(delay)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	value, _ := strconv.Atoi(string(s.data[key]))
+	value += n
+	s.data[key] = []byte(strconv.Itoa(value))
+	return value, nil
+}
+
+func (s *slowStore) Delete(key string) {
+	s.mux.Lock()
+	delete(s.data, key)
+	s.mux.Unlock()
+}
+
+func (s *slowStore) get(key string) ([]byte, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func TestPersistenceBreakerHoldsWritesWhileStoreIsSlow(t *testing.T) {
+	store := newSlowStore()
+	games, _ := newTestGames()
+	games.writer = NewPersistenceBreaker(store, 20*time.Millisecond)
+
+	pin := addTestGame(t, games, "host", []string{"player1", "player2"}, testQuiz())
+	if _, err := games.nextState(pin); err != nil {
+		t.Fatalf("error starting game: %v", err)
+	}
+
+	// the first slow write trips the breaker without waiting for the store
+	store.setDelay(100 * time.Millisecond)
+	start := time.Now()
+	games.persist(games.all[pin])
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the first slow write to carry on in the background but it took %v", elapsed)
+	}
+
+	// gameplay should not be blocked by the slow store
+	start = time.Now()
+	if _, err := games.registerAnswer(pin, "player1", nil, 1); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	if _, err := games.registerAnswer(pin, "player2", nil, 0); err != nil {
+		t.Fatalf("error registering answer: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected answers to be processed without waiting for the store but took %v", elapsed)
+	}
+	if games.writer.Held() != 1 {
+		t.Fatalf("expected 1 held write but got %d", games.writer.Held())
+	}
+
+	// the store recovers
+	store.setDelay(0)
+	if remaining := games.writer.flush(false); remaining != 0 {
+		t.Fatalf("expected all held writes to be flushed but %d remain", remaining)
+	}
+
+	data, ok := store.get(fmt.Sprintf("game:%d", pin))
+	if !ok {
+		t.Fatal("expected game to have been flushed to the store")
+	}
+	game, err := common.UnmarshalGame(data)
+	if err != nil {
+		t.Fatalf("error unmarshaling flushed game: %v", err)
+	}
+	if len(game.PlayersAnswered) != 2 {
+		t.Errorf("expected flushed game to have 2 answers but got %d", len(game.PlayersAnswered))
+	}
+
+	// writes go straight to the store once the breaker closes
+	games.delete(pin)
+	if _, ok := store.get(fmt.Sprintf("game:%d", pin)); ok {
+		t.Error("expected game to have been deleted from the store")
+	}
+}
+
+func TestReservePinGoesThroughBreaker(t *testing.T) {
+	store := newSlowStore()
+	games, _ := newTestGames()
+	games.writer = NewPersistenceBreaker(store, 20*time.Millisecond)
+
+	pin, err := games.add("host1")
+	if err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+	if _, ok := store.get(fmt.Sprintf("game:%d", pin)); !ok {
+		t.Error("expected the pin to be reserved in the store")
+	}
+
+	// the first slow reservation trips the breaker without waiting for the
+	// store
+	store.setDelay(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := games.add("host2"); err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the slow reservation to be bounded by the threshold but took %v", elapsed)
+	}
+	games.writer.flush(true)
+	games.writer.trip("test")
+
+	start = time.Now()
+	pin, err = games.add("host3")
+	if err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the game to be added without waiting for the store but took %v", elapsed)
+	}
+	if games.writer.Held() != 1 {
+		t.Errorf("expected the reservation to be held but got %d held write(s)", games.writer.Held())
+	}
+	if _, err := games.get(pin); err != nil {
+		t.Errorf("expected the game to be available while the store is degraded: %v", err)
+	}
+}
+
+func TestGetAllIncludesHeldGames(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	games := InitGames(newFakeMessageHub(), store, GamesOptions{SlowWriteThreshold: time.Second})
+
+	deleted, err := games.add("host1")
+	if err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+
+	games.writer.trip("test")
+	added, err := games.add("host2")
+	if err != nil {
+		t.Fatalf("error adding game: %v", err)
+	}
+	games.delete(deleted)
+
+	pins := []int{}
+	for _, game := range games.getAll() {
+		pins = append(pins, game.Pin)
+	}
+	if len(pins) != 1 || pins[0] != added {
+		t.Errorf("expected only game %d to be listed while its write is held but got %v", added, pins)
+	}
+
+	// the held writes are flushed on shutdown
+	games.FlushHeldWrites()
+	if _, err := store.Get(fmt.Sprintf("game:%d", added)); err != nil {
+		t.Errorf("expected game %d to have been flushed to the store: %v", added, err)
+	}
+	if _, err := store.Get(fmt.Sprintf("game:%d", deleted)); err == nil {
+		t.Errorf("expected game %d to have been deleted from the store", deleted)
+	}
+}
+
+func TestAbandonedReservationIsReleased(t *testing.T) {
+	breaker := NewPersistenceBreaker(newSlowStore(), time.Second)
+	breaker.trip("test")
+
+	if set, _ := breaker.SetNX("game:1", []byte("winner"), 0); !set {
+		t.Fatal("expected the first reservation to succeed while the breaker is open")
+	}
+	if set, _ := breaker.SetNX("game:1", []byte("loser"), 0); set {
+		t.Error("expected a second reservation of a held key to fail")
+	}
+
+	// releasing someone else's reservation leaves it alone
+	breaker.Release("game:1", []byte("loser"))
+	if breaker.Held() != 1 {
+		t.Fatalf("expected the winning reservation to be held but got %d held write(s)", breaker.Held())
+	}
+	breaker.Release("game:1", []byte("winner"))
+	if breaker.Held() != 0 {
+		t.Errorf("expected the abandoned reservation to be dropped but got %d held write(s)", breaker.Held())
+	}
+}
+
+func TestRecentQuestionsGoThroughBreaker(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	breaker := NewPersistenceBreaker(store, time.Second)
+	breaker.trip("test")
+
+	recent := NewRecentQuestions(store, breaker)
+	recent.Set(1, []string{"a", "b"})
+	if breaker.Held() != 1 {
+		t.Fatalf("expected the recent questions to be held but got %d held write(s)", breaker.Held())
+	}
+	if hashes := recent.Get(1); len(hashes) != 2 {
+		t.Errorf("expected 2 recent questions while the write is held but got %d", len(hashes))
+	}
+
+	if remaining := breaker.flush(false); remaining != 0 {
+		t.Fatalf("expected all held writes to be flushed but %d remain", remaining)
+	}
+
+	// the recent questions survive a restart
+	hashes := NewRecentQuestions(store, nil).Get(1)
+	if _, ok := hashes["a"]; !ok || len(hashes) != 2 {
+		t.Errorf("expected recent questions to be loaded from the store but got %v", hashes)
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+const (
+	questionFlagsPrefix = "flags"
+	maxFlagsPerQuiz     = 100 // older flags are dropped once a quiz has this many
+)
+
+// Keeps the questions that players flagged so that admins can review them
+// after the games have been deleted. Flags are stored per quiz in the
+// persistent store when one is configured and in memory otherwise.
+type QuestionFlags struct {
+	mutex  sync.Mutex
+	engine Store
+	flags  map[int][]common.QuestionFlag // map key is the quiz id
+}
+
+func NewQuestionFlags(engine Store) *QuestionFlags {
+	return &QuestionFlags{
+		engine: engine,
+		flags:  make(map[int][]common.QuestionFlag),
+	}
+}
+
+func questionFlagsKey(quizid int) string {
+	return fmt.Sprintf("%s:quiz:%d", questionFlagsPrefix, quizid)
+}
+
+// Records a flag against the quiz
+func (f *QuestionFlags) Add(flag common.QuestionFlag) {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	flags := append(f.get(flag.Quizid), flag)
+	if len(flags) > maxFlagsPerQuiz {
+		flags = flags[len(flags)-maxFlagsPerQuiz:]
+	}
+	if f.engine == nil {
+		f.flags[flag.Quizid] = flags
+		return
+	}
+	data, err := json.Marshal(flags)
+	if err != nil {
+		log.Printf("error converting flags for quiz %d to JSON: %v", flag.Quizid, err)
+		return
+	}
+	if err := f.engine.Set(questionFlagsKey(flag.Quizid), data, 0); err != nil {
+		log.Printf("error persisting flags for quiz %d: %v", flag.Quizid, err)
+	}
+}
+
+func (f *QuestionFlags) get(quizid int) []common.QuestionFlag {
+	if f.engine == nil {
+		return append([]common.QuestionFlag{}, f.flags[quizid]...)
+	}
+	var flags []common.QuestionFlag
+	data, err := f.engine.Get(questionFlagsKey(quizid))
+	if err != nil {
+		return flags
+	}
+	if err := json.Unmarshal(data, &flags); err != nil {
+		log.Printf("error parsing flags for quiz %d: %v", quizid, err)
+	}
+	return flags
+}
+
+// Returns the flags for all quizzes, sorted by quiz id and then by time
+func (f *QuestionFlags) GetAll() []common.QuestionFlag {
+	all := []common.QuestionFlag{}
+	if f == nil {
+		return all
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.engine == nil {
+		for _, flags := range f.flags {
+			all = append(all, flags...)
+		}
+	} else {
+		keys, err := f.engine.GetKeys(questionFlagsPrefix)
+		if err != nil {
+			log.Printf("error retrieving flag keys from persistent store: %v", err)
+			return all
+		}
+		for _, key := range keys {
+			var quizid int
+			if _, err := fmt.Sscanf(key, questionFlagsPrefix+":quiz:%d", &quizid); err != nil {
+				continue
+			}
+			all = append(all, f.get(quizid)...)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Quizid != all[j].Quizid {
+			return all[i].Quizid < all[j].Quizid
+		}
+		return all[i].Time.Before(all[j].Time)
+	})
+	return all
+}
@@ -8,22 +8,28 @@ import (
 	"log"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/kwkoo/go-quiz/internal/common"
 	"github.com/kwkoo/go-quiz/internal/messaging"
 )
 
 type Quizzes struct {
-	all    map[int]common.Quiz
-	mutex  sync.RWMutex
-	engine *PersistenceEngine
-	msghub messaging.MessageHub
+	all       map[int]common.Quiz
+	mutex     sync.RWMutex
+	engine    Store
+	msghub    messaging.MessageHub
+	heartbeat *Heartbeat
 }
 
-func InitQuizzes(msghub messaging.MessageHub, engine *PersistenceEngine) (*Quizzes, error) {
-	keys, err := engine.GetKeys("quiz")
-	if err != nil {
-		return nil, fmt.Errorf("could not retrieve keys from redis: %v", err)
+func InitQuizzes(msghub messaging.MessageHub, engine Store) (*Quizzes, error) {
+	keys := []string{}
+	if engine != nil {
+		var err error
+		keys, err = engine.GetKeys("quiz")
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve keys from the persistent store: %v", err)
+		}
 	}
 
 	all := make(map[int]common.Quiz)
@@ -37,7 +43,7 @@ func InitQuizzes(msghub messaging.MessageHub, engine *PersistenceEngine) (*Quizz
 		dec := json.NewDecoder(bytes.NewReader(data))
 		var quiz common.Quiz
 		if err := dec.Decode(&quiz); err != nil {
-			log.Printf("error parsing JSON from redis for key %s: %v", key, err)
+			log.Printf("error parsing JSON from the persistent store for key %s: %v", key, err)
 			continue
 		}
 		all[quiz.Id] = quiz
@@ -45,16 +51,22 @@ func InitQuizzes(msghub messaging.MessageHub, engine *PersistenceEngine) (*Quizz
 
 	log.Printf("ingested %d quizzes", len(all))
 	return &Quizzes{
-		all:    all,
-		engine: engine,
-		msghub: msghub,
+		all:       all,
+		engine:    engine,
+		msghub:    msghub,
+		heartbeat: NewHeartbeat("quizzes"),
 	}, nil
 }
 
 func (q *Quizzes) Run(ctx context.Context, shutdownComplete func()) {
 	topic := q.msghub.GetTopic(messaging.QuizzesTopic)
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	q.heartbeat.Beat()
 	for {
 		select {
+		case <-ticker.C:
+			q.heartbeat.Beat()
 		case <-ctx.Done():
 			log.Print("shutting down quiz handler")
 			shutdownComplete()
@@ -79,6 +91,8 @@ func (q *Quizzes) Run(ctx context.Context, shutdownComplete func()) {
 				q.processAddQuizMessage(m)
 			case *common.UpdateQuizMessage:
 				q.processUpdateQuizMessage(m)
+			case *common.RestoreQuizzesMessage:
+				q.processRestoreQuizzesMessage(m)
 			default:
 				log.Printf("unrecognized message type %T received on %s topic", msg, messaging.QuizzesTopic)
 			}
@@ -86,13 +100,22 @@ func (q *Quizzes) Run(ctx context.Context, shutdownComplete func()) {
 	}
 }
 
+func (q *Quizzes) Heartbeat() *Heartbeat {
+	return q.heartbeat
+}
+
 func (q *Quizzes) processUpdateQuizMessage(msg *common.UpdateQuizMessage) {
 	msg.Result <- q.update(msg.Quiz)
 	close(msg.Result)
 }
 
+func (q *Quizzes) processRestoreQuizzesMessage(msg *common.RestoreQuizzesMessage) {
+	msg.Result <- q.restore(msg.Quizzes, msg.Replace)
+	close(msg.Result)
+}
+
 func (q *Quizzes) processAddQuizMessage(msg *common.AddQuizMessage) {
-	msg.Result <- q.add(msg.Quiz)
+	msg.Result <- q.add(msg.Quiz, msg.Author)
 	close(msg.Result)
 }
 
@@ -138,14 +161,18 @@ func (q *Quizzes) processLookupQuizForGameMessage(msg common.LookupQuizForGameMe
 
 func (q *Quizzes) processSendQuizzesToClientMessage(msg common.SendQuizzesToClientMessage) {
 	type quizMeta struct {
-		Id   int    `json:"id"`
-		Name string `json:"name"`
+		Id        int    `json:"id"`
+		Name      string `json:"name"`
+		Questions int    `json:"questions"`
+		Duration  int    `json:"duration"` // estimated number of seconds to play the quiz
 	}
 	ml := []quizMeta{}
 	for _, quiz := range q.getQuizzes() {
 		ml = append(ml, quizMeta{
-			Id:   quiz.Id,
-			Name: quiz.Name,
+			Id:        quiz.Id,
+			Name:      quiz.Name,
+			Questions: quiz.NumQuestions(),
+			Duration:  quiz.EstimatedDuration(),
 		})
 	}
 
@@ -206,12 +233,17 @@ func (q *Quizzes) delete(id int) {
 }
 
 // called by REST API
-func (q *Quizzes) add(quiz common.Quiz) error {
+func (q *Quizzes) add(quiz common.Quiz, author string) error {
 	var err error
 	quiz.Id, err = q.nextID()
 	if err != nil {
 		return err
 	}
+	if len(author) > 0 {
+		quiz.CreatedBy = author
+	}
+	quiz.CreatedAt = time.Now()
+	quiz.UpdatedAt = quiz.CreatedAt
 
 	if q.engine != nil {
 		encoded, err := quiz.Marshal()
@@ -232,6 +264,12 @@ func (q *Quizzes) add(quiz common.Quiz) error {
 // called by REST API
 func (q *Quizzes) update(quiz common.Quiz) error {
 	q.mutex.Lock()
+	if existing, ok := q.all[quiz.Id]; ok {
+		// authorship is fixed when the quiz is added
+		quiz.CreatedBy = existing.CreatedBy
+		quiz.CreatedAt = existing.CreatedAt
+	}
+	quiz.UpdatedAt = time.Now()
 	q.all[quiz.Id] = quiz
 	q.mutex.Unlock()
 
@@ -247,6 +285,57 @@ func (q *Quizzes) update(quiz common.Quiz) error {
 	return nil
 }
 
+// Stores the quizzes from a backup as they are - quizzes with the same id are
+// overwritten. If replace is true, the quizzes that are not in the backup are
+// deleted.
+func (q *Quizzes) restore(quizzes []common.Quiz, replace bool) error {
+	if replace {
+		restored := make(map[int]struct{})
+		for _, quiz := range quizzes {
+			restored[quiz.Id] = struct{}{}
+		}
+		for _, existing := range q.getQuizzes() {
+			if _, ok := restored[existing.Id]; !ok {
+				q.delete(existing.Id)
+			}
+		}
+	}
+
+	highest := 0
+	for _, quiz := range quizzes {
+		if quiz.Id > highest {
+			highest = quiz.Id
+		}
+		if q.engine != nil {
+			encoded, err := quiz.Marshal()
+			if err != nil {
+				return fmt.Errorf("error converting quiz to JSON: %v", err)
+			}
+			if err := q.engine.Set(fmt.Sprintf("quiz:%d", quiz.Id), encoded, 0); err != nil {
+				return fmt.Errorf("error persisting quiz to redis: %v", err)
+			}
+		}
+		q.mutex.Lock()
+		q.all[quiz.Id] = quiz
+		q.mutex.Unlock()
+	}
+
+	// make sure that quizzes added later do not reuse the restored ids
+	if q.engine != nil {
+		current, err := q.engine.IncrBy("quizid", 0)
+		if err != nil {
+			return fmt.Errorf("error retrieving quiz ID from persistent store: %v", err)
+		}
+		if current < highest {
+			if _, err := q.engine.IncrBy("quizid", highest-current); err != nil {
+				return fmt.Errorf("error updating quiz ID in persistent store: %v", err)
+			}
+		}
+	}
+	log.Printf("restored %d quizzes", len(quizzes))
+	return nil
+}
+
 func (q *Quizzes) nextID() (int, error) {
 	if q.engine == nil {
 		q.mutex.RLock()
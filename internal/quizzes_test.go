@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+	"github.com/kwkoo/go-quiz/internal/messaging"
+)
+
+func TestSendQuizzesIncludesQuestionCounts(t *testing.T) {
+	mh := newFakeMessageHub()
+	quizzes, err := InitQuizzes(mh, nil)
+	if err != nil {
+		t.Fatalf("error initializing quizzes: %v", err)
+	}
+	empty := common.Quiz{Id: 2, Name: "empty quiz", QuestionDuration: 10}
+	quizzes.all[1] = testQuiz()
+	quizzes.all[2] = empty
+
+	quizzes.processSendQuizzesToClientMessage(common.SendQuizzesToClientMessage{Clientid: 1, Sessionid: "host"})
+
+	msgs := mh.drain(messaging.ClientHubTopic)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 client message but got %d", len(msgs))
+	}
+	payload := strings.TrimPrefix(msgs[0].(common.ClientMessage).Message, "all-quizzes ")
+	var meta []struct {
+		Id        int `json:"id"`
+		Questions int `json:"questions"`
+		Duration  int `json:"duration"`
+	}
+	if err := json.Unmarshal([]byte(payload), &meta); err != nil {
+		t.Fatalf("error decoding all-quizzes payload: %v", err)
+	}
+
+	expected := map[int][2]int{
+		1: {2, 40},
+		2: {0, 0},
+	}
+	if len(meta) != len(expected) {
+		t.Fatalf("expected %d quizzes but got %d", len(expected), len(meta))
+	}
+	for _, m := range meta {
+		if e := expected[m.Id]; m.Questions != e[0] || m.Duration != e[1] {
+			t.Errorf("expected quiz %d to have %d questions and duration %d but got %d and %d", m.Id, e[0], e[1], m.Questions, m.Duration)
+		}
+	}
+}
+
+func TestQuizAuthorshipAndTimestamps(t *testing.T) {
+	quizzes, err := InitQuizzes(newFakeMessageHub(), nil)
+	if err != nil {
+		t.Fatalf("error initializing quizzes: %v", err)
+	}
+
+	before := time.Now()
+	if err := quizzes.add(testQuiz(), "alice"); err != nil {
+		t.Fatalf("error adding quiz: %v", err)
+	}
+	added, err := quizzes.get(1)
+	if err != nil {
+		t.Fatalf("error retrieving added quiz: %v", err)
+	}
+	if added.CreatedBy != "alice" {
+		t.Errorf("expected quiz to be created by alice but got %q", added.CreatedBy)
+	}
+	if added.CreatedAt.Before(before) {
+		t.Errorf("expected created-at to be set when the quiz is added but got %v", added.CreatedAt)
+	}
+	if !added.UpdatedAt.Equal(added.CreatedAt) {
+		t.Errorf("expected updated-at %v to equal created-at %v after add", added.UpdatedAt, added.CreatedAt)
+	}
+
+	time.Sleep(time.Millisecond)
+	edited := added
+	edited.Name = "edited"
+	edited.CreatedBy = "mallory"
+	edited.CreatedAt = time.Time{}
+	if err := quizzes.update(edited); err != nil {
+		t.Fatalf("error updating quiz: %v", err)
+	}
+	updated, err := quizzes.get(1)
+	if err != nil {
+		t.Fatalf("error retrieving updated quiz: %v", err)
+	}
+	if updated.CreatedBy != "alice" || !updated.CreatedAt.Equal(added.CreatedAt) {
+		t.Errorf("expected update to keep authorship alice/%v but got %s/%v", added.CreatedAt, updated.CreatedBy, updated.CreatedAt)
+	}
+	if !updated.UpdatedAt.After(added.UpdatedAt) {
+		t.Errorf("expected update to refresh updated-at but got %v (was %v)", updated.UpdatedAt, added.UpdatedAt)
+	}
+}
+
+func TestRestoreQuizzes(t *testing.T) {
+	quizzes, err := InitQuizzes(newFakeMessageHub(), nil)
+	if err != nil {
+		t.Fatalf("error initializing quizzes: %v", err)
+	}
+
+	// restore into an empty store
+	backup := []common.Quiz{testQuiz(), {Id: 5, Name: "fifth"}}
+	if err := quizzes.restore(backup, false); err != nil {
+		t.Fatalf("error restoring quizzes: %v", err)
+	}
+	if all := quizzes.getQuizzes(); len(all) != 2 || all[0].Id != 1 || all[1].Id != 5 {
+		t.Fatalf("expected quizzes 1 and 5 to be restored but got %v", all)
+	}
+	if err := quizzes.add(common.Quiz{Name: "added"}, ""); err != nil {
+		t.Fatalf("error adding quiz: %v", err)
+	}
+	if _, err := quizzes.get(6); err != nil {
+		t.Errorf("expected added quiz to get an id after the restored quizzes: %v", err)
+	}
+
+	// merge keeps the quizzes that are not in the backup
+	if err := quizzes.restore([]common.Quiz{{Id: 5, Name: "restored fifth"}}, false); err != nil {
+		t.Fatalf("error merging quizzes: %v", err)
+	}
+	if all := quizzes.getQuizzes(); len(all) != 3 {
+		t.Errorf("expected 3 quizzes after merge but got %d", len(all))
+	}
+	if quiz, _ := quizzes.get(5); quiz.Name != "restored fifth" {
+		t.Errorf("expected quiz 5 to be overwritten but got %s", quiz.Name)
+	}
+
+	// replace deletes the quizzes that are not in the backup
+	if err := quizzes.restore([]common.Quiz{{Id: 5, Name: "fifth"}}, true); err != nil {
+		t.Fatalf("error replacing quizzes: %v", err)
+	}
+	if all := quizzes.getQuizzes(); len(all) != 1 || all[0].Id != 5 {
+		t.Errorf("expected only quiz 5 after replace but got %v", all)
+	}
+}
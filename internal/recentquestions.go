@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+const recentQuestionsPrefix = "recentquestions"
+
+// Remembers the questions picked for the last game that used each quiz so
+// that the next game can avoid them. The picks are read from the persistent
+// store once at startup and served from memory after that - updates are
+// written through the breaker so that picking questions does not wait on a
+// slow store.
+type RecentQuestions struct {
+	mutex  sync.Mutex
+	writer *PersistenceBreaker
+	recent map[int][]string // map key is the quiz id
+}
+
+func NewRecentQuestions(engine Store, writer *PersistenceBreaker) *RecentQuestions {
+	r := RecentQuestions{
+		writer: writer,
+		recent: make(map[int][]string),
+	}
+	if engine == nil {
+		return &r
+	}
+
+	keys, err := engine.GetKeys(recentQuestionsPrefix)
+	if err != nil {
+		log.Printf("error retrieving recent question keys from persistent store: %v", err)
+		return &r
+	}
+	for _, key := range keys {
+		var quizid int
+		if _, err := fmt.Sscanf(key, recentQuestionsPrefix+":quiz:%d", &quizid); err != nil {
+			continue
+		}
+		data, err := engine.Get(key)
+		if err != nil {
+			log.Printf("error retrieving recent questions for quiz %d: %v", quizid, err)
+			continue
+		}
+		var recent []string
+		if err := json.Unmarshal(data, &recent); err != nil {
+			log.Printf("error parsing recent questions for quiz %d: %v", quizid, err)
+			continue
+		}
+		r.recent[quizid] = recent
+	}
+	return &r
+}
+
+func recentQuestionsKey(quizid int) string {
+	return fmt.Sprintf("%s:quiz:%d", recentQuestionsPrefix, quizid)
+}
+
+// Returns the hashes of the questions picked for the last game that used the
+// quiz
+func (r *RecentQuestions) Get(quizid int) map[string]struct{} {
+	hashes := make(map[string]struct{})
+	if r == nil {
+		return hashes
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, hash := range r.recent[quizid] {
+		hashes[hash] = struct{}{}
+	}
+	return hashes
+}
+
+// Records the hashes of the questions picked for a game
+func (r *RecentQuestions) Set(quizid int, hashes []string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	r.recent[quizid] = hashes
+	r.mutex.Unlock()
+
+	if r.writer == nil {
+		return
+	}
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		log.Printf("error converting recent questions for quiz %d to JSON: %v", quizid, err)
+		return
+	}
+	if err := r.writer.Set(recentQuestionsKey(quizid), data, 0); err != nil {
+		log.Printf("error persisting recent questions for quiz %d: %v", quizid, err)
+	}
+}
@@ -16,33 +16,56 @@ import (
 )
 
 type webSocketRegistry interface {
+	ClientIDs() []uint64
 	DeregisterClientID([]uint64)
 }
 
 type Sessions struct {
-	msghub         messaging.MessageHub
-	wsRegistry     webSocketRegistry
-	mutex          sync.RWMutex
-	all            map[string]*common.Session
-	clientids      map[uint64]*common.Session
-	engine         *PersistenceEngine
-	auth           *api.Auth
-	sessionTimeout int
-	reaperInterval int
+	msghub             messaging.MessageHub
+	wsRegistry         webSocketRegistry
+	mutex              sync.RWMutex
+	all                map[string]*common.Session
+	clientids          map[uint64]*common.Session
+	engine             Store
+	auth               *api.Auth
+	sessionTimeout     int
+	reaperInterval     int
+	maxSessionIDLength int
+	heartbeat          *Heartbeat
+	reconnectGrace     time.Duration // time allowed for a session's previous client to be deregistered
+	hostGrace          time.Duration // time a disconnected host has to reconnect before the reaper cancels the game
 }
 
-func InitSessions(msghub messaging.MessageHub, engine *PersistenceEngine, wsRegistry webSocketRegistry, auth *api.Auth, sessionTimeout int, reaperInterval int) *Sessions {
-	log.Printf("session timeout set to %d seconds", sessionTimeout)
+// Settings for InitSessions
+type SessionsOptions struct {
+	SessionTimeout     int           // seconds - both for in-memory sessions and sessions in the persistent store
+	ReaperInterval     int           // seconds between invocations of the session reaper
+	MaxSessionIDLength int           // maximum length of session IDs sent by clients
+	ReconnectGrace     time.Duration // time allowed for a session's previous client to be deregistered
+	HostGrace          time.Duration // time a disconnected host has to reconnect before the reaper cancels the game
+}
+
+func InitSessions(msghub messaging.MessageHub, engine Store, wsRegistry webSocketRegistry, auth *api.Auth, options SessionsOptions) *Sessions {
+	log.Printf("session timeout set to %d seconds", options.SessionTimeout)
 
 	sessions := Sessions{
-		msghub:         msghub,
-		wsRegistry:     wsRegistry,
-		all:            make(map[string]*common.Session),
-		clientids:      make(map[uint64]*common.Session),
-		engine:         engine,
-		auth:           auth,
-		sessionTimeout: sessionTimeout,
-		reaperInterval: reaperInterval,
+		msghub:             msghub,
+		wsRegistry:         wsRegistry,
+		all:                make(map[string]*common.Session),
+		clientids:          make(map[uint64]*common.Session),
+		engine:             engine,
+		auth:               auth,
+		sessionTimeout:     options.SessionTimeout,
+		reaperInterval:     options.ReaperInterval,
+		maxSessionIDLength: options.MaxSessionIDLength,
+		heartbeat:          NewHeartbeat("sessions"),
+		reconnectGrace:     options.ReconnectGrace,
+		hostGrace:          options.HostGrace,
+	}
+
+	if engine == nil {
+		log.Print("no persistent store configured - sessions will only be kept in memory")
+		return &sessions
 	}
 
 	keys, err := engine.GetKeys("session")
@@ -77,12 +100,21 @@ func (s *Sessions) RunSessionReaper(ctx context.Context, shutdownComplete func()
 	}
 }
 
+func (s *Sessions) Heartbeat() *Heartbeat {
+	return s.heartbeat
+}
+
 func (s *Sessions) Run(ctx context.Context, shutdownComplete func()) {
 	fromClients := s.msghub.GetTopic(messaging.IncomingMessageTopic)
 	sessionsHub := s.msghub.GetTopic(messaging.SessionsTopic)
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	s.heartbeat.Beat()
 
 	for {
 		select {
+		case <-ticker.C:
+			s.heartbeat.Beat()
 		case msg, ok := <-fromClients:
 			if !ok {
 				log.Printf("received empty message from %s", messaging.IncomingMessageTopic)
@@ -122,6 +154,16 @@ func (s *Sessions) Run(ctx context.Context, shutdownComplete func()) {
 				s.processDeregisterClientMessage(m)
 			case *common.GetSessionsMessage:
 				s.processGetSessionsMessage(m)
+			case *common.GetSessionMessage:
+				s.processGetSessionMessage(m)
+			case *common.ReapSessionsMessage:
+				s.processReapSessionsMessage(m)
+			case *common.GetStoreStatusMessage:
+				s.processGetStoreStatusMessage(m)
+			case *common.GetClientsMessage:
+				s.processGetClientsMessage(m)
+			case *common.DisconnectClientMessage:
+				s.processDisconnectClientMessage(m)
 			default:
 				log.Printf("unrecognized message type %T received on %s topic", msg, messaging.SessionsTopic)
 			}
@@ -138,6 +180,96 @@ func (s *Sessions) processGetSessionsMessage(msg *common.GetSessionsMessage) {
 	close(msg.Result)
 }
 
+// Result is nil if the session does not exist
+func (s *Sessions) processGetSessionMessage(msg *common.GetSessionMessage) {
+	var result *common.Session
+	if session := s.getSession(msg.Sessionid); session != nil {
+		s.mutex.RLock()
+		copied := session.Copy()
+		s.mutex.RUnlock()
+		result = &copied
+	}
+	msg.Result <- result
+	close(msg.Result)
+}
+
+// Runs the session reaper on demand. The reaper sends messages to this
+// goroutine so it has to run in a separate goroutine.
+func (s *Sessions) processReapSessionsMessage(msg *common.ReapSessionsMessage) {
+	go func() {
+		msg.Result <- s.expireSessions()
+		close(msg.Result)
+	}()
+}
+
+// Lists the connected clients along with their sessions and screens
+func (s *Sessions) processGetClientsMessage(msg *common.GetClientsMessage) {
+	ids := s.wsRegistry.ClientIDs()
+	clients := make([]common.ConnectedClient, 0, len(ids))
+	s.mutex.RLock()
+	for _, id := range ids {
+		client := common.ConnectedClient{Clientid: id}
+		if session, ok := s.clientids[id]; ok {
+			client.Sessionid = session.Id
+			client.Screen = session.Screen
+		}
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+	msg.Result <- clients
+	close(msg.Result)
+}
+
+func (s *Sessions) processDisconnectClientMessage(msg *common.DisconnectClientMessage) {
+	connected := false
+	for _, id := range s.wsRegistry.ClientIDs() {
+		if id == msg.Clientid {
+			connected = true
+			break
+		}
+	}
+	if !connected {
+		msg.Result <- false
+		close(msg.Result)
+		return
+	}
+
+	// the hub notifies us when the client is deregistered so this cannot
+	// block the sessions handler
+	go func() {
+		log.Printf("force-disconnecting client %d", msg.Clientid)
+		s.wsRegistry.DeregisterClientID([]uint64{msg.Clientid})
+		msg.Result <- true
+		close(msg.Result)
+	}()
+}
+
+// Scanning the persistent store may take a while so it is done in a
+// separate goroutine.
+func (s *Sessions) processGetStoreStatusMessage(msg *common.GetStoreStatusMessage) {
+	if s.engine == nil {
+		msg.Result <- common.StoreStatus{
+			Mode: "in-memory",
+			Keys: map[string]int{},
+		}
+		close(msg.Result)
+		return
+	}
+	go func() {
+		msg.Result <- inspectStore(s.engine)
+		close(msg.Result)
+	}()
+}
+
+func (s *Sessions) clientConnected(clientid uint64) bool {
+	for _, id := range s.wsRegistry.ClientIDs() {
+		if id == clientid {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Sessions) processDeregisterClientMessage(msg common.DeregisterClientMessage) {
 	log.Printf("session deregister client %d", msg.Clientid)
 	s.mutex.RLock()
@@ -145,6 +277,7 @@ func (s *Sessions) processDeregisterClientMessage(msg common.DeregisterClientMes
 	s.mutex.RUnlock()
 	if ok {
 		s.updateClientIDForSession(session.Id, 0)
+		s.sendPlayerConnection(session, false)
 	}
 
 	s.mutex.Lock()
@@ -246,7 +379,7 @@ func (s *Sessions) processSessionToScreenMessage(msg common.SessionToScreenMessa
 }
 
 func (s *Sessions) processBindGameToSessionMessage(msg common.BindGameToSessionMessage) {
-	s.registerSessionInGame(msg.Sessionid, msg.Name, msg.Pin)
+	s.registerSessionInGame(msg.Sessionid, msg.Name, msg.Pin, msg.Fingerprint)
 }
 
 func (s *Sessions) processErrorToSessionMessage(msg common.ErrorToSessionMessage) {
@@ -280,7 +413,7 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 	if !ok {
 		// client hasn't identified themselves yet
 		if m.cmd == "session" {
-			if len(m.arg) == 0 || len(m.arg) > 64 {
+			if !validSessionID(m.arg, s.maxSessionIDLength) {
 				s.msghub.Send(messaging.ClientHubTopic, common.ClientErrorMessage{
 					Clientid:   m.client,
 					Sessionid:  "",
@@ -290,6 +423,11 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 				return
 			}
 
+			if m.retried && !s.clientConnected(m.client) {
+				// the client went away while it was waiting
+				return
+			}
+
 			clientid := m.client
 			sessionid := m.arg
 
@@ -298,6 +436,16 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 				session = s.newSession(sessionid, m.client, "entrance")
 			} else {
 				if session.ClientId != 0 {
+					if s.reconnectGrace > 0 && !m.retried {
+						// the previous client's deregistration may be in
+						// flight if the page was refreshed - try again once
+						// it has had a chance to be processed
+						retry := &ClientCommand{client: m.client, cmd: m.cmd, arg: m.arg, retried: true}
+						time.AfterFunc(s.reconnectGrace, func() {
+							s.msghub.Send(messaging.IncomingMessageTopic, retry)
+						})
+						return
+					}
 					s.msghub.Send(messaging.ClientHubTopic, common.ClientErrorMessage{
 						Clientid:   m.client,
 						Sessionid:  "",
@@ -308,6 +456,7 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 					return
 				}
 				s.updateClientIDForSession(session.Id, clientid)
+				s.sendPlayerConnection(session, true)
 			}
 			s.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
 				Sessionid:  sessionid,
@@ -363,8 +512,10 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 
 	case "join-game":
 		pinfo := struct {
-			Pin  int    `json:"pin"`
-			Name string `json:"name"`
+			Pin         int    `json:"pin"`
+			Name        string `json:"name"`
+			Fingerprint string `json:"fingerprint"`
+			Team        string `json:"team"`
 		}{}
 		dec := json.NewDecoder(strings.NewReader(m.arg))
 		if err := dec.Decode(&pinfo); err != nil {
@@ -385,9 +536,11 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 		}
 
 		s.msghub.Send(messaging.GamesTopic, common.AddPlayerToGameMessage{
-			Sessionid: sessionid,
-			Name:      pinfo.Name,
-			Pin:       pinfo.Pin,
+			Sessionid:   sessionid,
+			Name:        pinfo.Name,
+			Pin:         pinfo.Pin,
+			Fingerprint: pinfo.Fingerprint,
+			Team:        pinfo.Team,
 		})
 
 		return
@@ -429,8 +582,50 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 		})
 		return
 
+	case "fifty-fifty":
+		if session.Gamepin < 0 {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "could not get game pin for this session",
+				Nextscreen: "entrance",
+			})
+			return
+		}
+
+		s.msghub.Send(messaging.GamesTopic, common.FiftyFiftyMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
 	case "answer":
-		playerAnswer, err := strconv.Atoi(m.arg)
+		// orderings and multi-select answers are sent as comma-separated
+		// indices - the answer may be followed by the index of the question
+		// the player is answering (e.g. "2 4")
+		args := strings.Fields(m.arg)
+		if len(args) == 0 || len(args) > 2 {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "could not parse answer",
+				Nextscreen: "",
+			})
+			return
+		}
+		var questionIndex *int
+		if len(args) == 2 {
+			index, err := strconv.Atoi(args[1])
+			if err != nil {
+				s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+					Sessionid:  sessionid,
+					Message:    "could not parse question index",
+					Nextscreen: "",
+				})
+				return
+			}
+			questionIndex = &index
+		}
+		playerAnswer, choices, err := parseAnswer(args[0])
 		if err != nil {
 			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 				Sessionid:  sessionid,
@@ -450,10 +645,79 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 		}
 
 		s.msghub.Send(messaging.GamesTopic, common.RegisterAnswerMessage{
+			Clientid:      clientid,
+			Sessionid:     sessionid,
+			Pin:           session.Gamepin,
+			Answer:        playerAnswer,
+			Choices:       choices,
+			QuestionIndex: questionIndex,
+		})
+		return
+
+	case "flag-question":
+		if session.Gamepin < 0 {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "could not get game pin for this session",
+				Nextscreen: "entrance",
+			})
+			return
+		}
+
+		s.msghub.Send(messaging.GamesTopic, common.FlagQuestionMessage{
 			Clientid:  clientid,
 			Sessionid: sessionid,
 			Pin:       session.Gamepin,
-			Answer:    playerAnswer,
+			Reason:    m.arg,
+		})
+		return
+
+	case "submit-question":
+		// the argument is the question in JSON
+		if session.Gamepin < 0 {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "could not get game pin for this session",
+				Nextscreen: "entrance",
+			})
+			return
+		}
+		var question common.QuizQuestion
+		if err := json.Unmarshal([]byte(m.arg), &question); err != nil {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "could not parse question: " + err.Error(),
+				Nextscreen: "",
+			})
+			return
+		}
+
+		s.msghub.Send(messaging.GamesTopic, common.SubmitQuestionMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+			Question:  question,
+		})
+		return
+
+	case "approve-question", "reject-question":
+		// the argument is the id of the submitted question
+		submission, err := strconv.Atoi(strings.TrimSpace(m.arg))
+		if err != nil {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "expected int argument",
+				Nextscreen: "",
+			})
+			return
+		}
+
+		s.msghub.Send(messaging.GamesTopic, common.ModerateSubmissionMessage{
+			Clientid:   clientid,
+			Sessionid:  sessionid,
+			Pin:        session.Gamepin,
+			Submission: submission,
+			Approve:    m.cmd == "approve-question",
 		})
 		return
 
@@ -480,7 +744,22 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 		return
 
 	case "host-game-lobby":
-		quizid, err := strconv.Atoi(m.arg)
+		// the argument is the quiz id optionally followed by the number of
+		// seconds for each question and a label - e.g. "3 20 label=Room A"
+		arg, label := m.arg, ""
+		if i := strings.Index(arg, "label="); i >= 0 {
+			arg, label = arg[:i], strings.TrimSpace(arg[i+len("label="):])
+		}
+		args := strings.Fields(arg)
+		if len(args) == 0 || len(args) > 2 {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "expected int argument",
+				Nextscreen: "host-select-quiz",
+			})
+			return
+		}
+		quizid, err := strconv.Atoi(args[0])
 		if err != nil {
 			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
 				Sessionid:  sessionid,
@@ -489,11 +768,25 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 			})
 			return
 		}
+		duration := 0
+		if len(args) == 2 {
+			duration, err = strconv.Atoi(args[1])
+			if err != nil || duration <= 0 {
+				s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+					Sessionid:  sessionid,
+					Message:    "question duration must be a positive number of seconds",
+					Nextscreen: "host-select-quiz",
+				})
+				return
+			}
+		}
 
 		s.msghub.Send(messaging.GamesTopic, common.HostGameLobbyMessage{
-			Clientid:  clientid,
-			Sessionid: sessionid,
-			Quizid:    quizid,
+			Clientid:         clientid,
+			Sessionid:        sessionid,
+			Quizid:           quizid,
+			QuestionDuration: duration,
+			Label:            label,
 		})
 		return
 
@@ -529,6 +822,106 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 		})
 		return
 
+	case "shuffle-participants":
+		s.msghub.Send(messaging.GamesTopic, common.ShuffleParticipantsMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "kick-player":
+		s.msghub.Send(messaging.GamesTopic, common.KickPlayerMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+			Player:    m.arg,
+		})
+		return
+
+	case "set-shuffle":
+		// the argument is JSON - e.g. {"questions":true,"answers":false}
+		var shuffle common.ShuffleOptions
+		if err := json.Unmarshal([]byte(m.arg), &shuffle); err != nil {
+			s.msghub.Send(messaging.SessionsTopic, common.ErrorToSessionMessage{
+				Sessionid:  sessionid,
+				Message:    "could not parse shuffle settings: " + err.Error(),
+				Nextscreen: "",
+			})
+			return
+		}
+
+		s.msghub.Send(messaging.GamesTopic, common.SetShuffleMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+			Shuffle:   shuffle,
+		})
+		return
+
+	case "preview-quiz":
+		if !session.Admin {
+			s.msghub.Send(messaging.SessionsTopic, common.SessionToScreenMessage{
+				Sessionid:  sessionid,
+				Nextscreen: "authenticate-user",
+			})
+			return
+		}
+		s.msghub.Send(messaging.GamesTopic, common.PreviewQuizMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "begin-answers":
+		s.msghub.Send(messaging.GamesTopic, common.BeginAnswersMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "skip-question":
+		s.msghub.Send(messaging.GamesTopic, common.SkipQuestionMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "pause-game":
+		s.msghub.Send(messaging.GamesTopic, common.PauseGameMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "resume-game":
+		s.msghub.Send(messaging.GamesTopic, common.ResumeGameMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "reveal-next-bar":
+		s.msghub.Send(messaging.GamesTopic, common.RevealNextBarMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
+	case "emphasize-answer":
+		s.msghub.Send(messaging.GamesTopic, common.EmphasizeAnswerMessage{
+			Clientid:  clientid,
+			Sessionid: sessionid,
+			Pin:       session.Gamepin,
+		})
+		return
+
 	case "delete-game":
 		s.msghub.Send(messaging.GamesTopic, common.DeleteGameMessage{
 			Clientid:  clientid,
@@ -547,6 +940,39 @@ func (s *Sessions) processClientCommand(m *ClientCommand) {
 	}
 }
 
+// Parses the argument to the answer command - either a single answer index
+// or comma-separated indices for orderings and multi-select answers
+func parseAnswer(arg string) (int, []int, error) {
+	if !strings.Contains(arg, ",") {
+		answer, err := strconv.Atoi(arg)
+		return answer, nil, err
+	}
+	parts := strings.Split(arg, ",")
+	order := make([]int, len(parts))
+	for i, part := range parts {
+		index, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0, nil, err
+		}
+		order[i] = index
+	}
+	return -1, order, nil
+}
+
+// Session IDs are used in persistent store keys (session:<id>) so they are
+// restricted to UUID-like characters.
+func validSessionID(id string, maxLength int) bool {
+	if len(id) == 0 || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Sessions) newSession(id string, clientid uint64, screen string) *common.Session {
 	session := &common.Session{
 		Id:       id,
@@ -575,7 +1001,8 @@ func (s *Sessions) extendSessionExpiry(id string) {
 	s.persist(session)
 }
 
-func (s *Sessions) expireSessions() {
+// Returns the number of sessions that were expired
+func (s *Sessions) expireSessions() int {
 	clientids := []uint64{}
 	now := time.Now()
 	s.mutex.RLock()
@@ -594,6 +1021,13 @@ func (s *Sessions) expireSessions() {
 		log.Printf("expiring %d session(s)", len(clientids))
 		s.wsRegistry.DeregisterClientID(clientids)
 	}
+
+	if s.hostGrace > 0 {
+		s.msghub.Send(messaging.GamesTopic, common.CancelAbandonedGamesMessage{
+			Grace: s.hostGrace,
+		})
+	}
+	return len(clientids)
 }
 
 func (s *Sessions) persist(session *common.Session) {
@@ -629,9 +1063,15 @@ func (s *Sessions) getAll() []common.Session {
 
 func (s *Sessions) deleteSession(id string) {
 	s.mutex.Lock()
+	if session, ok := s.all[id]; ok && session.ClientId != 0 && s.clientids[session.ClientId] == session {
+		delete(s.clientids, session.ClientId)
+	}
 	delete(s.all, id)
 	s.mutex.Unlock()
 
+	if s.engine == nil {
+		return
+	}
 	s.engine.Delete(fmt.Sprintf("session:%s", id))
 }
 
@@ -645,6 +1085,21 @@ func (s *Sessions) getClientIDForSession(id string) uint64 {
 	return session.ClientId
 }
 
+// lets the game know that a player has disconnected or reconnected
+func (s *Sessions) sendPlayerConnection(session *common.Session, connected bool) {
+	s.mutex.RLock()
+	sessionid, pin := session.Id, session.Gamepin
+	s.mutex.RUnlock()
+	if pin <= 0 {
+		return
+	}
+	s.msghub.Send(messaging.GamesTopic, common.PlayerConnectionMessage{
+		Sessionid: sessionid,
+		Pin:       pin,
+		Connected: connected,
+	})
+}
+
 func (s *Sessions) updateClientIDForSession(id string, newclientid uint64) {
 	if id == "" {
 		return
@@ -692,7 +1147,7 @@ func (s *Sessions) getSession(id string) *common.Session {
 
 	decoded, err := common.UnmarshalSession(data)
 	if err != nil {
-		log.Printf("error decoding session from redis: %v", err)
+		log.Printf("error decoding session from the persistent store: %v", err)
 		return nil
 	}
 
@@ -705,7 +1160,7 @@ func (s *Sessions) getSession(id string) *common.Session {
 	return decoded
 }
 
-func (s *Sessions) registerSessionInGame(id, name string, pin int) {
+func (s *Sessions) registerSessionInGame(id, name string, pin int, fingerprint string) {
 	session := s.getSession(id)
 
 	if session == nil {
@@ -713,8 +1168,14 @@ func (s *Sessions) registerSessionInGame(id, name string, pin int) {
 	}
 
 	s.mutex.Lock()
+	now := time.Now()
+	if session.Gamepin > 0 && session.Gamepin != pin {
+		session.LeftGame(session.Gamepin, now)
+	}
+	session.JoinedGame(pin, name, false, now)
 	session.Name = name
 	session.Gamepin = pin
+	session.Fingerprint = fingerprint
 	s.mutex.Unlock()
 	s.persist(session)
 }
@@ -727,6 +1188,7 @@ func (s *Sessions) deregisterGameFromSession(id string) {
 	}
 
 	s.mutex.Lock()
+	session.LeftGame(session.Gamepin, time.Now())
 	session.Gamepin = -1
 	session.Screen = "entrance"
 	s.mutex.Unlock()
@@ -754,6 +1216,17 @@ func (s *Sessions) setSessionGamePin(id string, pin int) {
 	}
 
 	s.mutex.Lock()
+	if pin != session.Gamepin {
+		now := time.Now()
+		if session.Gamepin > 0 {
+			session.LeftGame(session.Gamepin, now)
+		}
+		if pin > 0 {
+			// players are bound to games with their name - only hosts
+			// have their pin set directly
+			session.JoinedGame(pin, "", true, now)
+		}
+	}
 	session.Gamepin = pin
 	s.mutex.Unlock()
 	s.persist(session)
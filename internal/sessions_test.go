@@ -0,0 +1,315 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/api"
+	"github.com/kwkoo/go-quiz/internal/common"
+	"github.com/kwkoo/go-quiz/internal/messaging"
+)
+
+func TestValidSessionID(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected bool
+	}{
+		{"0b6b8b0e-6c1f-4a3e-9d6c-8f3f0c7c2a11", true},
+		{"abc_DEF-123", true},
+		{"", false},
+		{"abc:def", false},
+		{"session:abc", false},
+		{"abc\ndef", false},
+		{"abc\x00", false},
+		{"abc def", false},
+		{"0123456789012345678901234567890123456789012345678901234567890123456789", false}, // too long
+	}
+
+	for testIndex, test := range tests {
+		if valid := validSessionID(test.id, 64); valid != test.expected {
+			t.Errorf("expected %v but got %v for test index %d", test.expected, valid, testIndex)
+		}
+	}
+}
+
+type fakeWebSocketRegistry struct {
+	connected    []uint64
+	deregistered []uint64
+}
+
+func (r *fakeWebSocketRegistry) ClientIDs() []uint64 {
+	return r.connected
+}
+
+func (r *fakeWebSocketRegistry) DeregisterClientID(ids []uint64) {
+	r.deregistered = append(r.deregistered, ids...)
+}
+
+func newTestSessions() (*Sessions, *fakeMessageHub, *fakeWebSocketRegistry) {
+	mh := newFakeMessageHub()
+	registry := &fakeWebSocketRegistry{}
+	auth := api.InitAuth("admin", "password", "test")
+	return InitSessions(mh, nil, registry, auth, SessionsOptions{SessionTimeout: 900, ReaperInterval: 60, MaxSessionIDLength: 64}), mh, registry
+}
+
+func TestReapSessionsOnDemand(t *testing.T) {
+	sessions, mh, registry := newTestSessions()
+	sessions.newSession("expired", 1, "entrance")
+	sessions.newSession("active", 2, "entrance")
+	sessions.getSession("expired").Expiry = time.Now().Add(-time.Second)
+
+	msg := &common.ReapSessionsMessage{Result: make(chan int)}
+	sessions.processReapSessionsMessage(msg)
+
+	select {
+	case reaped := <-msg.Result:
+		if reaped != 1 {
+			t.Errorf("expected 1 session to be reaped but got %d", reaped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reaper")
+	}
+
+	deleted := []string{}
+	for _, m := range mh.drain(messaging.SessionsTopic) {
+		if d, ok := m.(common.DeleteSessionMessage); ok {
+			deleted = append(deleted, d.Sessionid)
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "expired" {
+		t.Errorf("expected expired session to be deleted but got %v", deleted)
+	}
+	if len(registry.deregistered) != 1 || registry.deregistered[0] != 1 {
+		t.Errorf("expected client 1 to be deregistered but got %v", registry.deregistered)
+	}
+}
+
+func TestSessionsWithoutPersistentStore(t *testing.T) {
+	sessions, _, _ := newTestSessions()
+	if sessions.engine != nil {
+		t.Fatal("expected sessions to be constructed without a persistent store")
+	}
+
+	sessions.newSession("session1", 1, "entrance")
+	if session := sessions.getSession("session1"); session == nil || session.ClientId != 1 {
+		t.Fatalf("expected to get session1 with client 1 but got %v", session)
+	}
+	sessions.extendSessionExpiry("session1")
+
+	sessions.deleteSession("session1")
+	if session := sessions.getSession("session1"); session != nil {
+		t.Errorf("expected session1 to be deleted but got %v", session)
+	}
+	if _, ok := sessions.clientids[1]; ok {
+		t.Error("expected client 1 to be removed when its session is deleted")
+	}
+	if reaped := sessions.expireSessions(); reaped != 0 {
+		t.Errorf("expected no sessions to be reaped but got %d", reaped)
+	}
+}
+
+func TestListAndDisconnectClients(t *testing.T) {
+	sessions, _, registry := newTestSessions()
+	registry.connected = []uint64{1, 2}
+	sessions.newSession("session1", 1, "entrance")
+
+	list := &common.GetClientsMessage{Result: make(chan []common.ConnectedClient, 1)}
+	sessions.processGetClientsMessage(list)
+	clients := <-list.Result
+	expected := []common.ConnectedClient{
+		{Clientid: 1, Sessionid: "session1", Screen: "entrance"},
+		{Clientid: 2},
+	}
+	if len(clients) != len(expected) {
+		t.Fatalf("expected %d clients but got %v", len(expected), clients)
+	}
+	for i := range expected {
+		if clients[i] != expected[i] {
+			t.Errorf("expected client %+v but got %+v", expected[i], clients[i])
+		}
+	}
+
+	tests := []struct {
+		clientid  uint64
+		connected bool
+	}{
+		{2, true},
+		{3, false},
+	}
+	for _, test := range tests {
+		msg := &common.DisconnectClientMessage{Clientid: test.clientid, Result: make(chan bool, 1)}
+		sessions.processDisconnectClientMessage(msg)
+		select {
+		case connected := <-msg.Result:
+			if connected != test.connected {
+				t.Errorf("expected disconnecting client %d to return %v but got %v", test.clientid, test.connected, connected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting to disconnect client %d", test.clientid)
+		}
+	}
+	if len(registry.deregistered) != 1 || registry.deregistered[0] != 2 {
+		t.Errorf("expected only client 2 to be deregistered but got %v", registry.deregistered)
+	}
+}
+
+func TestParseAnswer(t *testing.T) {
+	tests := []struct {
+		arg          string
+		expectAnswer int
+		expectOrder  []int
+		expectError  bool
+	}{
+		{"2", 2, nil, false},
+		{"2,0,1", -1, []int{2, 0, 1}, false},
+		{"2, 0, 1", -1, []int{2, 0, 1}, false},
+		{"a", 0, nil, true},
+		{"1,a", 0, nil, true},
+	}
+
+	for testIndex, test := range tests {
+		answer, order, err := parseAnswer(test.arg)
+		if (err != nil) != test.expectError {
+			t.Errorf("unexpected error value %v for test index %d", err, testIndex)
+			continue
+		}
+		if test.expectError {
+			continue
+		}
+		if answer != test.expectAnswer || len(order) != len(test.expectOrder) {
+			t.Errorf("expected %d %v but got %d %v for test index %d", test.expectAnswer, test.expectOrder, answer, order, testIndex)
+			continue
+		}
+		for i := range order {
+			if order[i] != test.expectOrder[i] {
+				t.Errorf("expected order %v but got %v for test index %d", test.expectOrder, order, testIndex)
+				break
+			}
+		}
+	}
+}
+
+func TestSessionGameHistory(t *testing.T) {
+	sessions, _, _ := newTestSessions()
+	sessions.newSession("player", 1, "entrance")
+
+	getSession := func() *common.Session {
+		msg := &common.GetSessionMessage{Sessionid: "player", Result: make(chan *common.Session, 1)}
+		sessions.processGetSessionMessage(msg)
+		return <-msg.Result
+	}
+
+	sessions.registerSessionInGame("player", "alice", 100, "")
+	session := getSession()
+	if len(session.Games) != 1 || session.Games[0].Pin != 100 || session.Games[0].Name != "alice" || session.Games[0].LeftAt != nil {
+		t.Fatalf("expected the session to be in game 100 but got %+v", session.Games)
+	}
+
+	// leaving the game is recorded
+	sessions.deregisterGameFromSession("player")
+	sessions.registerSessionInGame("player", "bob", 200, "")
+	session = getSession()
+	if len(session.Games) != 2 {
+		t.Fatalf("expected 2 games in the history but got %+v", session.Games)
+	}
+	if session.Games[0].LeftAt == nil {
+		t.Error("expected the session to have left game 100")
+	}
+	if session.Games[1].Pin != 200 || session.Games[1].Name != "bob" || session.Games[1].LeftAt != nil {
+		t.Errorf("expected the session to be in game 200 but got %+v", session.Games[1])
+	}
+
+	// hosting a game leaves the previous game
+	sessions.setSessionGamePin("player", 300)
+	session = getSession()
+	if len(session.Games) != 3 || session.Games[1].LeftAt == nil || session.Games[2].Pin != 300 || !session.Games[2].Host {
+		t.Errorf("expected the session to have left game 200 and to host game 300 but got %+v", session.Games)
+	}
+
+	// the history is bounded
+	for pin := 1000; pin < 1020; pin++ {
+		sessions.registerSessionInGame("player", "alice", pin, "")
+	}
+	session = getSession()
+	if len(session.Games) != 10 || session.Games[9].Pin != 1019 || session.Games[0].Pin != 1010 {
+		t.Errorf("expected the 10 most recent games but got %+v", session.Games)
+	}
+
+	msg := &common.GetSessionMessage{Sessionid: "missing", Result: make(chan *common.Session, 1)}
+	sessions.processGetSessionMessage(msg)
+	if missing := <-msg.Result; missing != nil {
+		t.Errorf("expected no session but got %+v", missing)
+	}
+}
+
+func TestReconnectGrace(t *testing.T) {
+	sessions, mh, registry := newTestSessions()
+	sessions.reconnectGrace = 10 * time.Millisecond
+	registry.connected = []uint64{2}
+	// client 1 is the page before it was refreshed
+	sessions.newSession("session1", 1, "entrance")
+
+	sessions.processClientCommand(&ClientCommand{client: 2, cmd: "session", arg: "session1"})
+	for _, msg := range mh.drain(messaging.ClientHubTopic) {
+		if m, ok := msg.(common.ClientErrorMessage); ok {
+			t.Fatalf("expected the new client to wait for the old client but got %q", m.Message)
+		}
+	}
+
+	// the old client's deregistration completes within the grace
+	sessions.processDeregisterClientMessage(common.DeregisterClientMessage{Clientid: 1})
+
+	var retry *ClientCommand
+	deadline := time.After(time.Second)
+	for retry == nil {
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for the session command to be retried")
+		case <-time.After(5 * time.Millisecond):
+		}
+		for _, msg := range mh.drain(messaging.IncomingMessageTopic) {
+			retry = msg.(*ClientCommand)
+		}
+	}
+	sessions.processClientCommand(retry)
+	for _, msg := range mh.drain(messaging.ClientHubTopic) {
+		if m, ok := msg.(common.ClientErrorMessage); ok {
+			t.Fatalf("expected the new client to be bound but got %q", m.Message)
+		}
+	}
+	if clientid := sessions.getClientIDForSession("session1"); clientid != 2 {
+		t.Errorf("expected session1 to be bound to client 2 but got %d", clientid)
+	}
+
+	// a client that is still blocked after the grace is rejected
+	registry.connected = []uint64{2, 3}
+	retry = &ClientCommand{client: 3, cmd: "session", arg: "session1", retried: true}
+	sessions.processClientCommand(retry)
+	rejected := false
+	for _, msg := range mh.drain(messaging.ClientHubTopic) {
+		if _, ok := msg.(common.ClientErrorMessage); ok {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("expected client 3 to be rejected once the grace has passed")
+	}
+}
+
+func TestReaperChecksForAbandonedGames(t *testing.T) {
+	sessions, mh, _ := newTestSessions()
+	sessions.expireSessions()
+	if msgs := mh.drain(messaging.GamesTopic); len(msgs) != 0 {
+		t.Errorf("expected no games to be checked without a host grace period but got %v", msgs)
+	}
+
+	sessions.hostGrace = time.Minute
+	sessions.expireSessions()
+	msgs := mh.drain(messaging.GamesTopic)
+	if len(msgs) != 1 {
+		t.Fatalf("expected the games to be checked but got %v", msgs)
+	}
+	if m, ok := msgs[0].(common.CancelAbandonedGamesMessage); !ok || m.Grace != time.Minute {
+		t.Errorf("unexpected message %+v", msgs[0])
+	}
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	// registers the pure Go sqlite driver so that builds do not need cgo
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS kv (
+	key     TEXT PRIMARY KEY,
+	value   BLOB NOT NULL,
+	expires INTEGER NOT NULL DEFAULT 0
+)`
+
+// A Store kept in a single SQLite table - for deployments that want
+// persistence without running Redis. Expired keys are treated as missing
+// and removed when keys are next scanned.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func InitSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database %s: %v", path, err)
+	}
+	// sqlite only allows a single writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sqlite schema in %s: %v", path, err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (store *SQLiteStore) Close() {
+	if store == nil {
+		return
+	}
+	store.db.Close()
+	log.Print("persistence engine shutdown")
+}
+
+func (store *SQLiteStore) Ping() error {
+	if store == nil {
+		return errors.New("sqlite not configured")
+	}
+	return store.db.Ping()
+}
+
+func (store *SQLiteStore) GetKeys(prefix string) ([]string, error) {
+	if store == nil {
+		return []string{}, nil
+	}
+	pattern := prefix + ":"
+	now := time.Now().Unix()
+	if _, err := store.db.Exec(`DELETE FROM kv WHERE expires != 0 AND expires <= ?`, now); err != nil {
+		log.Printf("error removing expired keys from sqlite: %v", err)
+	}
+	rows, err := store.db.Query(`SELECT key FROM kv WHERE substr(key, 1, ?) = ? AND (expires = 0 OR expires > ?) ORDER BY key`, len(pattern), pattern, now)
+	if err != nil {
+		return []string{}, fmt.Errorf("error retrieving %s* keys: %v", pattern, err)
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return keys, fmt.Errorf("error retrieving %s* keys: %v", pattern, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (store *SQLiteStore) Get(key string) ([]byte, error) {
+	if store == nil {
+		return nil, nil
+	}
+	var data []byte
+	err := store.db.QueryRow(`SELECT value FROM kv WHERE key = ? AND (expires = 0 OR expires > ?)`, key, time.Now().Unix()).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("error getting value for key %s: %v", key, err)
+	}
+	return data, nil
+}
+
+func (store *SQLiteStore) Set(key string, value []byte, expiry int) error {
+	if store == nil {
+		return nil
+	}
+	var expires int64
+	if expiry > 0 {
+		expires = time.Now().Add(time.Duration(expiry) * time.Second).Unix()
+	}
+	if _, err := store.db.Exec(`INSERT INTO kv (key, value, expires) VALUES (?, ?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires = excluded.expires`, key, value, expires); err != nil {
+		return fmt.Errorf("error setting key %s in sqlite: %v", key, err)
+	}
+	return nil
+}
+
+// Expired keys are treated as missing and replaced
+func (store *SQLiteStore) SetNX(key string, value []byte, expiry int) (bool, error) {
+	if store == nil {
+		return true, nil
+	}
+	now := time.Now()
+	var expires int64
+	if expiry > 0 {
+		expires = now.Add(time.Duration(expiry) * time.Second).Unix()
+	}
+	result, err := store.db.Exec(`INSERT INTO kv (key, value, expires) VALUES (?, ?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires = excluded.expires WHERE kv.expires != 0 AND kv.expires <= ?`, key, value, expires, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("error setting key %s in sqlite: %v", key, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error setting key %s in sqlite: %v", key, err)
+	}
+	return n > 0, nil
+}
+
+func (store *SQLiteStore) Delete(key string) {
+	if store == nil {
+		return
+	}
+	if _, err := store.db.Exec(`DELETE FROM kv WHERE key = ?`, key); err != nil {
+		log.Printf("error deleting key %s from sqlite: %v", key, err)
+	}
+}
+
+func (store *SQLiteStore) Incr(counterKey string) (int, error) {
+	return store.IncrBy(counterKey, 1)
+}
+
+// Follows the semantics of Redis' INCRBY - a missing or expired counter
+// starts at 0, the expiry of an existing counter is kept and values that
+// are not integers are an error
+func (store *SQLiteStore) IncrBy(counterKey string, n int) (int, error) {
+	if store == nil {
+		return 0, errors.New("sqlite not configured")
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var data []byte
+	var expires int64
+	current := 0
+	err = tx.QueryRow(`SELECT value, expires FROM kv WHERE key = ?`, counterKey).Scan(&data, &expires)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return 0, err
+	case expires != 0 && expires <= time.Now().Unix():
+		expires = 0
+	default:
+		if current, err = strconv.Atoi(string(data)); err != nil {
+			return 0, fmt.Errorf("value for key %s is not an integer", counterKey)
+		}
+	}
+
+	current += n
+	if _, err := tx.Exec(`INSERT INTO kv (key, value, expires) VALUES (?, ?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires = excluded.expires`, counterKey, []byte(strconv.Itoa(current)), expires); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	store, err := InitSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("error initializing sqlite store: %v", err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+func TestSQLiteGetKeys(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	for _, key := range []string{"game:1", "game:2", "gameid", "games:1", "session:a", "quiz:1"} {
+		if err := store.Set(key, []byte("value"), 0); err != nil {
+			t.Fatalf("error setting %s: %v", key, err)
+		}
+	}
+	// expired keys are not returned
+	if err := store.Set("game:3", []byte("value"), 60); err != nil {
+		t.Fatalf("error setting game:3: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE kv SET expires = 1 WHERE key = 'game:3'`); err != nil {
+		t.Fatalf("error expiring game:3: %v", err)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"game", []string{"game:1", "game:2"}},
+		{"session", []string{"session:a"}},
+		{"quiz", []string{"quiz:1"}},
+		{"stats", []string{}},
+	}
+	for _, test := range tests {
+		keys, err := store.GetKeys(test.prefix)
+		if err != nil {
+			t.Fatalf("error getting %s keys: %v", test.prefix, err)
+		}
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("expected %s keys %v but got %v", test.prefix, test.expected, keys)
+		}
+	}
+
+	if _, err := store.Get("game:3"); err == nil {
+		t.Error("expected an error getting an expired key")
+	}
+	data, err := store.Get("game:1")
+	if err != nil || string(data) != "value" {
+		t.Errorf("expected game:1 to be value but got %q (%v)", data, err)
+	}
+	store.Delete("game:1")
+	if _, err := store.Get("game:1"); err == nil {
+		t.Error("expected an error getting a deleted key")
+	}
+}
+
+func TestSQLiteIncr(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	for expected := 1; expected <= 3; expected++ {
+		n, err := store.Incr("quizid")
+		if err != nil {
+			t.Fatalf("error incrementing quizid: %v", err)
+		}
+		if n != expected {
+			t.Errorf("expected quizid to be %d but got %d", expected, n)
+		}
+	}
+	if n, err := store.IncrBy("quizid", 0); err != nil || n != 3 {
+		t.Errorf("expected incrementing by 0 to return 3 but got %d (%v)", n, err)
+	}
+	if n, err := store.IncrBy("quizid", 7); err != nil || n != 10 {
+		t.Errorf("expected quizid to be 10 but got %d (%v)", n, err)
+	}
+	// counters are stored as decimal strings like Redis
+	if data, err := store.Get("quizid"); err != nil || string(data) != "10" {
+		t.Errorf("expected quizid to be stored as 10 but got %q (%v)", data, err)
+	}
+
+	if err := store.Set("quiz:1", []byte("{}"), 0); err != nil {
+		t.Fatalf("error setting quiz:1: %v", err)
+	}
+	if _, err := store.Incr("quiz:1"); err == nil {
+		t.Error("expected an error incrementing a value that is not an integer")
+	}
+}
+
+func TestSQLiteSetNX(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	if set, err := store.SetNX("game:1", []byte("first"), 0); err != nil || !set {
+		t.Fatalf("expected a missing key to be set but got %v (%v)", set, err)
+	}
+	if set, err := store.SetNX("game:1", []byte("second"), 0); err != nil || set {
+		t.Errorf("expected an existing key to be left alone but got %v (%v)", set, err)
+	}
+	if data, _ := store.Get("game:1"); string(data) != "first" {
+		t.Errorf("expected game:1 to keep its first value but got %q", data)
+	}
+
+	// expired keys are treated as missing
+	if err := store.Set("game:2", []byte("old"), 60); err != nil {
+		t.Fatalf("error setting game:2: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE kv SET expires = 1 WHERE key = 'game:2'`); err != nil {
+		t.Fatalf("error expiring game:2: %v", err)
+	}
+	if set, err := store.SetNX("game:2", []byte("new"), 0); err != nil || !set {
+		t.Errorf("expected an expired key to be replaced but got %v (%v)", set, err)
+	}
+	if data, _ := store.Get("game:2"); string(data) != "new" {
+		t.Errorf("expected game:2 to be replaced but got %q", data)
+	}
+}
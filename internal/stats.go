@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+const (
+	statsPrefix    = "stats"
+	playsCounter   = "plays"
+	playersCounter = "players"
+)
+
+// Counters for quiz play statistics - increments are applied atomically by the
+// persistent store, or to in-memory counters when there is no store.
+type PlayStats struct {
+	mutex    sync.Mutex
+	engine   Store
+	writer   *PersistenceBreaker // increments go through the breaker so that a slow store does not hold up the games
+	counters map[string]int      // map key is the counter key
+}
+
+func NewPlayStats(engine Store, writer *PersistenceBreaker) *PlayStats {
+	return &PlayStats{
+		engine:   engine,
+		writer:   writer,
+		counters: make(map[string]int),
+	}
+}
+
+func statsKey(quizid int, counter string) string {
+	return fmt.Sprintf("%s:quiz:%d:%s", statsPrefix, quizid, counter)
+}
+
+// Records a game that was started with the given number of players
+func (s *PlayStats) RecordGameStarted(quizid, players int) {
+	if s == nil {
+		return
+	}
+	s.incrBy(statsKey(quizid, playsCounter), 1)
+	if players > 0 {
+		s.incrBy(statsKey(quizid, playersCounter), players)
+	}
+}
+
+func (s *PlayStats) incrBy(key string, n int) {
+	if s.engine == nil {
+		s.mutex.Lock()
+		s.counters[key] += n
+		s.mutex.Unlock()
+		return
+	}
+	s.writer.IncrBy(key, n)
+}
+
+func (s *PlayStats) snapshot() map[string]int {
+	if s.engine == nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		counters := make(map[string]int, len(s.counters))
+		for k, v := range s.counters {
+			counters[k] = v
+		}
+		return counters
+	}
+
+	counters := make(map[string]int)
+	keys, err := s.engine.GetKeys(statsPrefix)
+	if err != nil {
+		log.Printf("error retrieving stats keys from persistent store: %v", err)
+		return counters
+	}
+	for _, key := range keys {
+		data, err := s.engine.Get(key)
+		if err != nil {
+			log.Printf("error retrieving counter %s: %v", key, err)
+			continue
+		}
+		value, err := strconv.Atoi(string(data))
+		if err != nil {
+			log.Printf("counter %s is not an integer: %v", key, err)
+			continue
+		}
+		counters[key] = value
+	}
+	// increments held while the store is degraded have not reached the store
+	for key, n := range s.writer.HeldIncrements(statsPrefix) {
+		counters[key] += n
+	}
+	return counters
+}
+
+// Returns the statistics for all quizzes that have been played, sorted by
+// quiz id
+func (s *PlayStats) GetAll() []common.QuizStats {
+	all := []common.QuizStats{}
+	if s == nil {
+		return all
+	}
+
+	byQuiz := make(map[int]*common.QuizStats)
+	for key, value := range s.snapshot() {
+		// stats:quiz:<id>:<counter>
+		parts := strings.Split(key, ":")
+		if len(parts) != 4 || parts[1] != "quiz" {
+			continue
+		}
+		quizid, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+		stats, ok := byQuiz[quizid]
+		if !ok {
+			stats = &common.QuizStats{Quizid: quizid}
+			byQuiz[quizid] = stats
+		}
+		switch parts[3] {
+		case playsCounter:
+			stats.Plays = value
+		case playersCounter:
+			stats.Players = value
+		}
+	}
+
+	for _, stats := range byQuiz {
+		all = append(all, *stats)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Quizid < all[j].Quizid })
+	return all
+}
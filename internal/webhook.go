@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second // doubled after each failed attempt
+	webhookTimeout  = 10 * time.Second
+)
+
+// Posts the final results of each game to an external service such as a
+// gradebook
+type ResultsWebhook struct {
+	url      string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+// Returns nil if url is empty - results are not posted anywhere
+func NewResultsWebhook(url string) *ResultsWebhook {
+	if url == "" {
+		return nil
+	}
+	return &ResultsWebhook{
+		url:      url,
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: webhookAttempts,
+		backoff:  webhookBackoff,
+	}
+}
+
+// Posts the results in the background so that the game isn't held up by a
+// slow or unavailable webhook
+func (w *ResultsWebhook) Send(results common.GameResults) {
+	if w == nil {
+		return
+	}
+	encoded, err := common.ConvertToJSON(&results)
+	if err != nil {
+		log.Printf("error converting results of game %d to JSON: %v", results.Pin, err)
+		return
+	}
+	go func() {
+		backoff := w.backoff
+		for attempt := 1; ; attempt++ {
+			err := w.post([]byte(encoded))
+			if err == nil {
+				return
+			}
+			if attempt >= w.attempts {
+				log.Printf("giving up posting results of game %d to webhook after %d attempts: %v", results.Pin, attempt, err)
+				return
+			}
+			log.Printf("error posting results of game %d to webhook - retrying in %v: %v", results.Pin, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+func (w *ResultsWebhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
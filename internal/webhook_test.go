@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kwkoo/go-quiz/internal/common"
+)
+
+// records the results posted to it - the first few requests fail with a 500
+// if failures is set
+type testWebhookServer struct {
+	mux      sync.Mutex
+	failures int
+	attempts int
+	received []common.GameResults
+}
+
+func (s *testWebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	}
+	var results common.GameResults
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.received = append(s.received, results)
+}
+
+// waits for the server to receive a payload
+func (s *testWebhookServer) wait() ([]common.GameResults, int) {
+	for i := 0; i < 200; i++ {
+		s.mux.Lock()
+		received, attempts := s.received, s.attempts
+		s.mux.Unlock()
+		if len(received) > 0 {
+			return received, attempts
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.received, s.attempts
+}
+
+func newTestWebhook(url string) *ResultsWebhook {
+	webhook := NewResultsWebhook(url)
+	webhook.backoff = time.Millisecond
+	return webhook
+}
+
+func TestResultsWebhookRetries(t *testing.T) {
+	handler := &testWebhookServer{failures: 2}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	newTestWebhook(server.URL).Send(common.GameResults{
+		Pin:     1234,
+		Players: []common.GameResultsPlayer{{Name: "player1", Score: 100}},
+	})
+	received, attempts := handler.wait()
+	if len(received) != 1 {
+		t.Fatalf("expected the webhook to receive the results once but got %v", received)
+	}
+	if attempts != 3 {
+		t.Errorf("expected the results to be posted after 3 attempts but got %d", attempts)
+	}
+	if received[0].Pin != 1234 || len(received[0].Players) != 1 || received[0].Players[0].Score != 100 {
+		t.Errorf("unexpected results %+v", received[0])
+	}
+}
+
+func TestResultsWebhookGivesUp(t *testing.T) {
+	handler := &testWebhookServer{failures: 100}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	newTestWebhook(server.URL).Send(common.GameResults{Pin: 1234})
+	time.Sleep(100 * time.Millisecond)
+	handler.mux.Lock()
+	defer handler.mux.Unlock()
+	if handler.attempts != webhookAttempts {
+		t.Errorf("expected %d attempts but got %d", webhookAttempts, handler.attempts)
+	}
+}
+
+func TestNoResultsWebhook(t *testing.T) {
+	if webhook := NewResultsWebhook(""); webhook != nil {
+		t.Errorf("expected no webhook without a URL but got %+v", webhook)
+	}
+	// sending to a missing webhook is a no-op
+	var webhook *ResultsWebhook
+	webhook.Send(common.GameResults{})
+}
@@ -47,6 +47,9 @@ type Client struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// Round-trip times of pings.
+	rtt rttAccumulator
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -61,7 +64,14 @@ func (c *Client) readPump(unregister chan *Client, incomingcommands chan *Client
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.conn.SetPongHandler(func(payload string) error {
+		now := time.Now()
+		if rtt, ok := rttFromPong(payload, now); ok {
+			c.rtt.Add(rtt)
+		}
+		c.conn.SetReadDeadline(now.Add(pongWait))
+		return nil
+	})
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
@@ -115,7 +125,7 @@ func (c *Client) writePump() {
 			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.conn.WriteMessage(websocket.PingMessage, pingPayload(time.Now())); err != nil {
 				return
 			}
 		}
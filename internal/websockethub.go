@@ -9,7 +9,9 @@ import (
 	"context"
 	"log"
 	"math"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/kwkoo/go-quiz/internal/common"
 	"github.com/kwkoo/go-quiz/internal/messaging"
@@ -38,10 +40,10 @@ type Hub struct {
 
 	msghub messaging.MessageHub
 
-	persistenceengine *PersistenceEngine
+	persistenceengine Store
 }
 
-func NewHub(msghub messaging.MessageHub, persistenceEngine *PersistenceEngine) *Hub {
+func NewHub(msghub messaging.MessageHub, persistenceEngine Store) *Hub {
 	return &Hub{
 		incomingcommands:  make(chan *ClientCommand),
 		register:          make(chan *Client),
@@ -54,6 +56,9 @@ func NewHub(msghub messaging.MessageHub, persistenceEngine *PersistenceEngine) *
 }
 
 func (h *Hub) ClosePersistenceEngine() {
+	if h.persistenceengine == nil {
+		return
+	}
 	h.persistenceengine.Close()
 }
 
@@ -79,7 +84,6 @@ func (h *Hub) Run(ctx context.Context, shutdownComplete func()) {
 			h.deregisterClient(client)
 
 		case message := <-h.incomingcommands:
-			log.Printf("incoming command: %s, arg: %s", message.cmd, message.arg)
 			h.processMessage(message)
 
 		case msg, ok := <-clientHub:
@@ -92,6 +96,8 @@ func (h *Hub) Run(ctx context.Context, shutdownComplete func()) {
 				h.processClientMessage(m)
 			case common.ClientErrorMessage:
 				h.processClientErrorMessage(m)
+			case *common.GetConnectionStatsMessage:
+				h.processGetConnectionStatsMessage(m)
 			default:
 				log.Printf("unrecognized message type %T received on %s topic", msg, messaging.ClientHubTopic)
 			}
@@ -99,6 +105,18 @@ func (h *Hub) Run(ctx context.Context, shutdownComplete func()) {
 	}
 }
 
+// Returns the IDs of all connected clients in ascending order
+func (h *Hub) ClientIDs() []uint64 {
+	h.clientmux.RLock()
+	ids := make([]uint64, 0, len(h.clientids))
+	for id := range h.clientids {
+		ids = append(ids, id)
+	}
+	h.clientmux.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 // called by session reaper
 func (h *Hub) DeregisterClientID(ids []uint64) {
 	clients := []*Client{}
@@ -159,8 +177,22 @@ func (h *Hub) processClientErrorMessage(msg common.ClientErrorMessage) {
 	h.errorMessageToClient(c, msg.Message, msg.Nextscreen)
 }
 
+func (h *Hub) processGetConnectionStatsMessage(msg *common.GetConnectionStatsMessage) {
+	h.clientmux.RLock()
+	clients := len(h.clientids)
+	rtts := []time.Duration{}
+	for _, client := range h.clientids {
+		if rtt, ok := client.rtt.Average(); ok {
+			rtts = append(rtts, rtt)
+		}
+	}
+	h.clientmux.RUnlock()
+	msg.Result <- connectionStats(clients, rtts)
+	close(msg.Result)
+}
+
 func (h *Hub) processMessage(m *ClientCommand) {
-	log.Printf("cmd=%s, arg=%s", m.cmd, m.arg)
+	debugf("incoming command: %s, arg: %s", m.cmd, m.arg)
 
 	h.msghub.Send(messaging.IncomingMessageTopic, m)
 }
@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"time"
 	_ "time/tzdata"
@@ -27,29 +28,131 @@ func health(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "OK")
 }
 
+// Builds the HTTP server - websocket connections are hijacked from the server
+// and set their own deadlines in their read and write pumps, so the timeouts
+// only bound the HTTP requests. A timeout of 0 means no timeout.
+func newServer(port int, readTimeout, writeTimeout, idleTimeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// Returns the muxes for the public and admin listeners - the admin mux is nil
+// if the admin routes are served by the public listener
+func newMuxes(separateAdmin bool, publicRoutes, adminRoutes map[string]http.Handler) (*http.ServeMux, *http.ServeMux) {
+	publicMux := http.NewServeMux()
+	for pattern, handler := range publicRoutes {
+		publicMux.Handle(pattern, handler)
+	}
+	if !separateAdmin {
+		for pattern, handler := range adminRoutes {
+			publicMux.Handle(pattern, handler)
+		}
+		return publicMux, nil
+	}
+	adminMux := http.NewServeMux()
+	for pattern, handler := range adminRoutes {
+		adminMux.Handle(pattern, handler)
+	}
+	return publicMux, adminMux
+}
+
+// Serves requests on the listener until ctx is done and then shuts the
+// server down gracefully - shutdownComplete is called once the server has
+// shut down
+func serve(ctx context.Context, name string, server *http.Server, listener net.Listener, shutdownComplete func()) {
+	go func() {
+		log.Printf("%s listening on %v", name, listener.Addr())
+		if err := server.Serve(listener); err != nil {
+			if err == http.ErrServerClosed {
+				log.Printf("%s graceful shutdown", name)
+				shutdownComplete()
+				return
+			}
+			log.Fatal(err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		log.Printf("interrupt signal received, initiating %s shutdown...", name)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+}
+
 func main() {
 	config := struct {
-		Port           int    `default:"8080" usage:"HTTP listener port"`
-		Docroot        string `usage:"HTML document root - will use the embedded docroot if not specified"`
-		RedisHost      string `usage:"Redis host and port - will not connect to Redis if blank"`
-		RedisPassword  string `usage:"Redis password"`
-		AdminUser      string `default:"admin" usage:"Admin username"`
-		AdminPassword  string `usage:"Admin password"`
-		SessionTimeout int    `default:"900" usage:"Timeout in seconds both for in-memory sessions and sessions in the persistent store"`
-		ReaperInterval int    `default:"60" usage:"Number of seconds between invocations of session reaper"`
+		Port               int    `default:"8080" usage:"HTTP listener port"`
+		Docroot            string `usage:"HTML document root - will use the embedded docroot if not specified"`
+		RedisHost          string `usage:"Redis host and port - will not connect to Redis if blank"`
+		RedisPassword      string `usage:"Redis password"`
+		RedisWaitTimeout   int    `default:"0" usage:"Number of seconds to wait for Redis at startup before exiting - 0 waits forever"`
+		Store              string `default:"redis" usage:"Persistent store - redis or sqlite - the redis store is only used if a Redis host is set"`
+		SqlitePath         string `default:"quiz.db" usage:"Path to the SQLite database used when the store is sqlite"`
+		AdminUser          string `default:"admin" usage:"Admin username"`
+		AdminPassword      string `usage:"Admin password"`
+		SessionTimeout     int    `default:"900" usage:"Timeout in seconds both for in-memory sessions and sessions in the persistent store"`
+		ReaperInterval     int    `default:"60" usage:"Number of seconds between invocations of session reaper"`
+		MaxSessionIdLength int    `default:"64" usage:"Maximum length of session IDs sent by clients"`
+		DisambiguateNames  bool   `usage:"Append a numeric suffix to duplicate player names instead of rejecting them"`
+		SlowWriteThreshold int    `default:"500" usage:"Number of milliseconds after which a game write to the persistent store is considered slow - game writes are held in memory while the store is slow"`
+		MaxImportSize      int    `default:"1048576" usage:"Maximum number of bytes in a quiz import request"`
+		OneJoinPerDevice   bool   `usage:"Reject players joining a game from a device that has already joined it - players whose device cannot be identified are also rejected"`
+		HostWaitInterval   int    `usage:"Number of seconds between status updates sent to players while the host lingers on the results of a question - 0 disables the updates"`
+		CompressGames      bool   `usage:"Gzip games before writing them to the persistent store"`
+		CaseSensitiveNames bool   `usage:"Treat player names that differ only in case as different names - names are case-insensitive by default"`
+		MergeRejoins       bool   `usage:"Let a player that rejoins a game lobby with a new session take over the slot of the disconnected player with the same name"`
+		AdvanceWhenIdle    int    `usage:"Number of seconds without new answers after which a question ends early and the results are shown - 0 disables early advancement"`
+		ResultsWebhook     string `usage:"URL that the final results of each game are posted to as JSON when the game ends"`
+		PinLength          int    `default:"6" usage:"Number of digits in game pins"`
+		LobbyDisplayCap    int    `usage:"Maximum number of player names sent to the host's lobby - the host is sent the player count and a sample of names once a game has more players - 0 always sends every name"`
+		LogLevel           string `default:"info" usage:"Minimum level of the messages that are logged - debug also logs every incoming command"`
+		AnswerGrace        int    `default:"500" usage:"Number of milliseconds after a question's deadline that answers are still counted - late answers earn the minimum score"`
+		MaxAnswerLog       int    `default:"500" usage:"Maximum number of answers kept in each player's answer log - older answers are folded into totals so that results stay accurate - 0 keeps every answer"`
+		HostGrace          int    `default:"300" usage:"Number of seconds a disconnected host has to reconnect before the session reaper cancels the game - 0 never cancels the game"`
+		ReconnectGrace     int    `usage:"Number of milliseconds to wait for a session's previous client to disconnect before rejecting a new client for the session - 0 rejects the new client immediately"`
+		AdminPort          int    `usage:"Port for a separate listener serving only the admin UI and REST API - the admin routes are served on the main port if this is 0"`
+		ReadTimeout        int    `default:"15" usage:"Number of seconds allowed for reading an HTTP request - 0 disables the timeout"`
+		WriteTimeout       int    `default:"30" usage:"Number of seconds allowed for writing an HTTP response - 0 disables the timeout"`
+		IdleTimeout        int    `default:"120" usage:"Number of seconds an idle keep-alive HTTP connection is kept open - 0 disables the timeout"`
 	}{}
 	if err := configparser.Parse(&config); err != nil {
 		log.Fatal(err)
 	}
+	if err := internal.SetLogLevel(config.LogLevel); err != nil {
+		log.Fatal(err)
+	}
 
 	// initialize random number generator - used for shuffling answers
 	rand.Seed(time.Now().UnixNano())
 
-	var persistenceEngine *internal.PersistenceEngine
-	if len(config.RedisHost) > 0 {
-		log.Printf("will use Redis at %s as the persistent store", config.RedisHost)
-		persistenceEngine = internal.InitRedis(config.RedisHost, config.RedisPassword)
-		persistenceEngine.WaitForRedis()
+	// left nil when there is no persistent store
+	var persistenceEngine internal.Store
+	switch config.Store {
+	case "redis":
+		if len(config.RedisHost) > 0 {
+			log.Printf("will use Redis at %s as the persistent store", config.RedisHost)
+			redisEngine := internal.InitRedis(config.RedisHost, config.RedisPassword)
+			if err := redisEngine.WaitForRedis(time.Duration(config.RedisWaitTimeout) * time.Second); err != nil {
+				log.Fatal(err)
+			}
+			persistenceEngine = redisEngine
+		}
+	case "sqlite":
+		log.Printf("will use SQLite at %s as the persistent store", config.SqlitePath)
+		sqliteStore, err := internal.InitSQLite(config.SqlitePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		persistenceEngine = sqliteStore
+	default:
+		log.Fatalf("unsupported store %s - must be redis or sqlite", config.Store)
 	}
 
 	shutdown.InitShutdownHandler()
@@ -71,13 +174,7 @@ func main() {
 	auth := api.InitAuth(config.AdminUser, config.AdminPassword, authRealm)
 
 	fileServer := http.FileServer(filesystem).ServeHTTP
-
-	http.HandleFunc("/admin/", auth.BasicAuth(fileServer))
-
-	http.HandleFunc("/healthz", health)
-
 	cookieGen := api.InitCookieGenerator(fileServer)
-	http.HandleFunc("/", cookieGen.ServeHTTP)
 
 	mh := messaging.InitMessageHub()
 	quizzes, err := internal.InitQuizzes(mh, persistenceEngine)
@@ -94,7 +191,13 @@ func main() {
 		quizzes.Run(ctx, shutdown.NotifyShutdownComplete)
 	}(shutdown.Context())
 
-	sessions := internal.InitSessions(mh, persistenceEngine, hub, auth, config.SessionTimeout, config.ReaperInterval)
+	sessions := internal.InitSessions(mh, persistenceEngine, hub, auth, internal.SessionsOptions{
+		SessionTimeout:     config.SessionTimeout,
+		ReaperInterval:     config.ReaperInterval,
+		MaxSessionIDLength: config.MaxSessionIdLength,
+		ReconnectGrace:     time.Duration(config.ReconnectGrace) * time.Millisecond,
+		HostGrace:          time.Duration(config.HostGrace) * time.Second,
+	})
 	go func(ctx context.Context) {
 		sessions.Run(ctx, shutdown.NotifyShutdownComplete)
 	}(shutdown.Context())
@@ -102,43 +205,80 @@ func main() {
 		sessions.RunSessionReaper(ctx, shutdown.NotifyShutdownComplete)
 	}(shutdown.Context())
 
-	games := internal.InitGames(mh, persistenceEngine)
+	games := internal.InitGames(mh, persistenceEngine, internal.GamesOptions{
+		SlowWriteThreshold: time.Duration(config.SlowWriteThreshold) * time.Millisecond,
+		DisambiguateNames:  config.DisambiguateNames,
+		OneJoinPerDevice:   config.OneJoinPerDevice,
+		HostWaitInterval:   time.Duration(config.HostWaitInterval) * time.Second,
+		Compress:           config.CompressGames,
+		CaseSensitiveNames: config.CaseSensitiveNames,
+		MergeRejoins:       config.MergeRejoins,
+		AdvanceWhenIdle:    time.Duration(config.AdvanceWhenIdle) * time.Second,
+		Webhook:            internal.NewResultsWebhook(config.ResultsWebhook),
+		PinLength:          config.PinLength,
+		LobbyDisplayCap:    config.LobbyDisplayCap,
+		AnswerGrace:        time.Duration(config.AnswerGrace) * time.Millisecond,
+		AnswerLogCap:       config.MaxAnswerLog,
+	})
 	go func(ctx context.Context) {
 		games.Run(ctx, shutdown.NotifyShutdownComplete)
 	}(shutdown.Context())
+	go func(ctx context.Context) {
+		games.RunPersistenceBreaker(ctx, shutdown.NotifyShutdownComplete)
+	}(shutdown.Context())
+
+	// handlers are considered stalled if they miss a few heartbeats
+	readiness := internal.NewReadiness(3*internal.HeartbeatInterval, quizzes.Heartbeat(), sessions.Heartbeat(), games.Heartbeat())
+	if persistenceEngine != nil {
+		// do not accept traffic while the store is down
+		readiness.AddDependency("store", persistenceEngine.Ping)
+	}
 
-	api := api.InitRestApi(mh)
-	http.HandleFunc("/api/", auth.BasicAuth(api.ServeHTTP))
+	api := api.InitRestApi(mh, int64(config.MaxImportSize))
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		internal.ServeWs(hub, w, r)
-	})
+	publicMux, adminMux := newMuxes(config.AdminPort > 0,
+		map[string]http.Handler{
+			"/healthz": http.HandlerFunc(health),
+			"/readyz":  readiness,
+			"/ws": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				internal.ServeWs(hub, w, r)
+			}),
+			"/public/game/": http.HandlerFunc(api.PublicGame),
+			"/":             http.HandlerFunc(cookieGen.ServeHTTP),
+		},
+		map[string]http.Handler{
+			"/admin/": auth.BasicAuth(fileServer),
+			"/api/":   auth.BasicAuth(api.ServeHTTP),
+			// the admin UI uses the shared images so they have to be served
+			// by the admin listener as well
+			"/images/": http.HandlerFunc(fileServer),
+		},
+	)
 
-	server := &http.Server{
-		Addr: fmt.Sprintf(":%d", config.Port),
+	readTimeout := time.Duration(config.ReadTimeout) * time.Second
+	writeTimeout := time.Duration(config.WriteTimeout) * time.Second
+	idleTimeout := time.Duration(config.IdleTimeout) * time.Second
+
+	server := newServer(config.Port, readTimeout, writeTimeout, idleTimeout)
+	server.Handler = publicMux
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
 	}
+	serve(shutdown.Context(), "web server", server, listener, shutdown.NotifyShutdownComplete)
 
-	go func() {
-		log.Printf("listening on port %v", config.Port)
-		if err := server.ListenAndServe(); err != nil {
-			if err == http.ErrServerClosed {
-				log.Print("web server graceful shutdown")
-				shutdown.NotifyShutdownComplete()
-				return
-			}
+	if adminMux != nil {
+		adminServer := newServer(config.AdminPort, readTimeout, writeTimeout, idleTimeout)
+		adminServer.Handler = adminMux
+		adminListener, err := net.Listen("tcp", adminServer.Addr)
+		if err != nil {
 			log.Fatal(err)
 		}
-	}()
-
-	go func(ctx context.Context) {
-		<-ctx.Done()
-		log.Print("interrupt signal received, initiating web server shutdown...")
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		server.Shutdown(ctx)
-	}(shutdown.Context())
+		serve(shutdown.Context(), "admin server", adminServer, adminListener, shutdown.NotifyShutdownComplete)
+	}
 
 	shutdown.WaitForShutdown()
 	mh.Close()
+	games.FlushHeldWrites()
 	hub.ClosePersistenceEngine()
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewServerTimeouts(t *testing.T) {
+	server := newServer(8080, 15*time.Second, 30*time.Second, 120*time.Second)
+	if server.Addr != ":8080" {
+		t.Errorf("expected server to listen on :8080 but got %s", server.Addr)
+	}
+	if server.ReadHeaderTimeout != 15*time.Second || server.ReadTimeout != 15*time.Second {
+		t.Errorf("expected read timeouts of 15s but got %v and %v", server.ReadHeaderTimeout, server.ReadTimeout)
+	}
+	if server.WriteTimeout != 30*time.Second {
+		t.Errorf("expected write timeout of 30s but got %v", server.WriteTimeout)
+	}
+	if server.IdleTimeout != 120*time.Second {
+		t.Errorf("expected idle timeout of 120s but got %v", server.IdleTimeout)
+	}
+}
+
+func TestSeparateAdminListener(t *testing.T) {
+	respond := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		})
+	}
+	publicMux, adminMux := newMuxes(true,
+		map[string]http.Handler{"/ws": respond("public")},
+		map[string]http.Handler{"/api/": respond("admin")},
+	)
+	if adminMux == nil {
+		t.Fatal("expected a separate admin mux")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var shutdownComplete sync.WaitGroup
+	addrs := map[string]string{}
+	for name, mux := range map[string]*http.ServeMux{"public": publicMux, "admin": adminMux} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error creating %s listener: %v", name, err)
+		}
+		addrs[name] = listener.Addr().String()
+		server := newServer(0, time.Second, time.Second, time.Second)
+		server.Handler = mux
+		shutdownComplete.Add(1)
+		serve(ctx, name, server, listener, shutdownComplete.Done)
+	}
+
+	tests := []struct {
+		listener     string
+		path         string
+		expectStatus int
+		expectBody   string
+	}{
+		{"public", "/ws", http.StatusOK, "public"},
+		{"public", "/api/quiz", http.StatusNotFound, ""},
+		{"admin", "/api/quiz", http.StatusOK, "admin"},
+		{"admin", "/ws", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		resp, err := http.Get("http://" + addrs[test.listener] + test.path)
+		if err != nil {
+			t.Fatalf("error requesting %s from %s listener: %v", test.path, test.listener, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.expectStatus {
+			t.Errorf("expected status %d for %s on %s listener but got %d", test.expectStatus, test.path, test.listener, resp.StatusCode)
+			continue
+		}
+		if test.expectBody != "" && string(body) != test.expectBody {
+			t.Errorf("expected %q for %s on %s listener but got %q", test.expectBody, test.path, test.listener, string(body))
+		}
+	}
+
+	// both listeners shut down gracefully
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		shutdownComplete.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the listeners to shut down")
+	}
+}
+
+func TestAdminRoutesOnPublicListener(t *testing.T) {
+	publicMux, adminMux := newMuxes(false,
+		map[string]http.Handler{"/ws": http.NotFoundHandler()},
+		map[string]http.Handler{"/api/": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+	)
+	if adminMux != nil {
+		t.Error("expected no admin mux without a separate admin listener")
+	}
+	if _, pattern := publicMux.Handler(httptest.NewRequest(http.MethodGet, "/api/quiz", nil)); pattern != "/api/" {
+		t.Errorf("expected the public mux to serve the admin routes but got pattern %q", pattern)
+	}
+}